@@ -0,0 +1,72 @@
+// Command acl-migrate flips the ACL of derived media objects (HLS output,
+// posters, storyboards) after AWS_S3_PRIVATE_OUTPUTS is changed.
+//
+//	acl-migrate -acl private [-media media_x] [-dry-run]
+package main
+
+import (
+	"context"
+	"flag"
+	"os/signal"
+	"syscall"
+
+	"calixio/internal/config"
+	"calixio/internal/service"
+
+	"go.uber.org/zap"
+)
+
+func main() {
+	acl := flag.String("acl", "private", `target ACL: "private" or "public-read"`)
+	mediaID := flag.String("media", "", "only migrate this media id")
+	dryRun := flag.Bool("dry-run", false, "list the objects without changing them")
+	flag.Parse()
+
+	cfg, err := config.Load()
+	if err != nil {
+		panic(err)
+	}
+
+	var logger *zap.Logger
+	if cfg.Env == "prod" {
+		logger, err = zap.NewProduction()
+	} else {
+		logger, err = zap.NewDevelopment()
+	}
+	if err != nil {
+		panic(err)
+	}
+
+	defer func() { _ = logger.Sync() }()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if cfg.Storage.Backend != "" && cfg.Storage.Backend != "s3" {
+		logger.Fatal("acl migration only applies to the s3 storage backend", zap.String("backend", cfg.Storage.Backend))
+	}
+	storageSvc, err := service.NewS3Storage(ctx, cfg)
+	if err != nil {
+		logger.Fatal("storage init", zap.Error(err))
+	}
+
+	result, err := service.MigrateOutputACLs(ctx, storageSvc, service.MigrateOutputACLInput{
+		ACL:     *acl,
+		MediaID: *mediaID,
+		DryRun:  *dryRun,
+		Logger:  logger,
+	})
+	logger.Info("acl migration finished",
+		zap.String("acl", *acl),
+		zap.Bool("dry_run", *dryRun),
+		zap.Int("media", result.Prefixes),
+		zap.Int("objects", result.Objects),
+		zap.Int("failed", result.Failed),
+	)
+	if err != nil {
+		logger.Fatal("acl migration", zap.Error(err))
+	}
+	if result.Failed > 0 {
+		logger.Fatal("acl migration incomplete", zap.Int("failed", result.Failed))
+	}
+}
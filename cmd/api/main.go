@@ -6,6 +6,7 @@ import (
 	authhandlers "calixio/internal/http/handlers/auth"
 	filehandlers "calixio/internal/http/handlers/files"
 	roomhandlers "calixio/internal/http/handlers/rooms"
+	storagehandlers "calixio/internal/http/handlers/storage"
 	webhookhandlers "calixio/internal/http/handlers/webhook"
 	"context"
 	"net/http"
@@ -24,6 +25,10 @@ import (
 	"go.uber.org/zap"
 )
 
+// workerShutdownGrace bounds how long in-flight encodes of the embedded
+// workers may keep running after a stop signal, as in cmd/worker.
+const workerShutdownGrace = 30 * time.Second
+
 func main() {
 	cfg, err := config.Load()
 	if err != nil {
@@ -76,44 +81,101 @@ func main() {
 
 	roomRepo := repository.NewPostgresRoomRepository(pool)
 	mediaRepo := repository.NewPostgresMediaRepository(pool)
+	mediaUploadRepo := repository.NewPostgresMediaUploadRepository(pool)
+	mediaSubtitleRepo := repository.NewPostgresMediaSubtitleRepository(pool)
+	mediaKeyRepo := repository.NewPostgresMediaKeyRepository(pool)
+	tagRepo := repository.NewPostgresTagRepository(pool)
+	collectionRepo := repository.NewPostgresCollectionRepository(pool)
+	transcodeJobRepo := repository.NewPostgresTranscodeJobRepository(pool)
 	userRepo := repository.NewPostgresUserRepository(pool)
+	usageRepo := repository.NewPostgresUsageRepository(pool)
 	sessionRepo := repository.NewPostgresSessionRepository(pool)
 	roomSvc := service.NewRoomService(roomRepo, mediaRepo, lkClient)
 	playbackSvc := service.NewRoomPlaybackService(roomRepo, redisClient)
 	webhookSvc := service.NewWebhookService(redisClient)
 
-	storageSvc, err := service.NewStorageService(ctx, cfg)
+	storageSvc, err := service.NewStorage(ctx, cfg)
 	if err != nil {
 		logger.Fatal("storage init", zap.Error(err))
 	}
 
+	// workerCtx stops the embedded transcoding workers on shutdown.
+	workerCtx, stopWorkers := context.WithCancel(ctx)
+	defer stopWorkers()
+
 	var transcoderSvc *service.MediaTranscoderService
 	if cfg.Transcoding.Enabled {
+		malwareScanner, err := service.NewMalwareScanner(cfg)
+		if err != nil {
+			logger.Fatal("malware scanner init", zap.Error(err))
+		}
 		transcoderSvc, err = service.NewMediaTranscoderService(service.NewMediaTranscoderServiceInput{
-			MediaRepo:       mediaRepo,
-			Storage:         storageSvc,
-			FFmpegPath:      cfg.Transcoding.FFmpegPath,
-			FFprobePath:     cfg.Transcoding.FFprobePath,
-			WorkDir:         cfg.Transcoding.WorkDir,
-			SegmentDuration: cfg.Transcoding.HLSSegmentSec,
-			QueueSize:       cfg.Transcoding.QueueSize,
-			JobTimeout:      cfg.Transcoding.JobTimeout,
-			Logger:          logger,
+			MediaRepo:             mediaRepo,
+			JobRepo:               transcodeJobRepo,
+			SubtitleRepo:          mediaSubtitleRepo,
+			KeyRepo:               mediaKeyRepo,
+			Storage:               storageSvc,
+			FFmpegPath:            cfg.Transcoding.FFmpegPath,
+			FFprobePath:           cfg.Transcoding.FFprobePath,
+			WorkDir:               cfg.Transcoding.WorkDir,
+			SegmentDuration:       cfg.Transcoding.HLSSegmentSec,
+			Renditions:            cfg.Transcoding.HLSRenditions,
+			ProfilesFile:          cfg.Transcoding.ProfilesFile,
+			Passthrough:           cfg.Transcoding.Passthrough,
+			PassthroughMaxKbps:    cfg.Transcoding.PassthroughMaxKbps,
+			StoryboardIntervalSec: cfg.Transcoding.StoryboardIntervalSec,
+			StoryboardThumbWidth:  cfg.Transcoding.StoryboardThumbWidth,
+			KeyRotationSegments:   cfg.Transcoding.KeyRotationSegments,
+			Workers:               cfg.Transcoding.Workers,
+			JobTimeout:            cfg.Transcoding.JobTimeout,
+			JobLeaseTTL:           cfg.Transcoding.JobLeaseTTL,
+			JobPollInterval:       cfg.Transcoding.JobPoll,
+			MaxAttempts:           cfg.Transcoding.MaxAttempts,
+			Logger:                logger,
+			Scanner:               malwareScanner,
+			QuarantinePrefix:      cfg.MalwareScan.QuarantinePrefix,
 		})
 		if err != nil {
 			logger.Fatal("transcoder init", zap.Error(err))
 		}
+		// With TRANSCODER_RUN_IN_API=false the API only enqueues and
+		// cmd/worker does the encoding.
+		if cfg.Transcoding.RunInAPI {
+			if err := transcoderSvc.Start(workerCtx); err != nil {
+				logger.Fatal("transcoder start", zap.Error(err))
+			}
+		}
 	}
 
+	usageSvc := service.NewUsageService(service.NewUsageServiceInput{
+		UsageRepo: usageRepo,
+		MediaRepo: mediaRepo,
+		Storage:   storageSvc,
+		Logger:    logger,
+	})
 	mediaUploadSvc := service.NewMediaUploadService(service.NewMediaUploadServiceInput{
 		MediaRepo:         mediaRepo,
+		UploadRepo:        mediaUploadRepo,
+		SubtitleRepo:      mediaSubtitleRepo,
+		KeyRepo:           mediaKeyRepo,
+		TagRepo:           tagRepo,
+		CollectionRepo:    collectionRepo,
 		Storage:           storageSvc,
 		Transcoder:        transcoderSvc,
+		Usage:             usageSvc,
 		Cache:             redisClient,
 		MaxSizeBytes:      cfg.AWS.MaxUploadBytes,
 		AllowedMimeTypes:  cfg.AWS.AllowedMIMEs,
 		PresignURLTTL:     cfg.AWS.PresignTTL,
 		PlaybackSignedTTL: cfg.MediaPlayback.SignedTTL,
+		ProxySegments:     cfg.MediaPlayback.ProxySegments,
+		ProbeUploads:      cfg.MediaUpload.Probe,
+		FFprobePath:       cfg.Transcoding.FFprobePath,
+
+		ImportAllowedHosts: cfg.MediaImport.AllowedHosts,
+		ImportMaxRedirects: cfg.MediaImport.MaxRedirects,
+		ImportTimeout:      cfg.MediaImport.Timeout,
+		Logger:             logger,
 	})
 	mediaCleanupSvc := service.NewMediaCleanupService(service.NewMediaCleanupServiceInput{
 		MediaRepo: mediaRepo,
@@ -124,10 +186,14 @@ func main() {
 	jwtSvc := authn.NewJWTService(cfg.JWTSecret, cfg.AccessTTL)
 	authSvc := service.NewAuthService(userRepo, sessionRepo, jwtSvc, cfg.AccessTTL, cfg.RefreshTTL)
 	authHandler := authhandlers.NewHandler(authSvc, jwtSvc, logger)
-	fileHandler := filehandlers.NewHandler(mediaUploadSvc, logger)
+	fileHandler := filehandlers.NewHandler(mediaUploadSvc, usageSvc, logger)
 	roomHandler := roomhandlers.NewHandler(roomSvc, mediaUploadSvc, playbackSvc, jwtSvc, logger)
 	webhookHandler := webhookhandlers.NewHandler(webhookSvc, lkClient, logger)
-	router := httpserver.NewRouter(authHandler, roomHandler, fileHandler, webhookHandler, jwtSvc, sessionRepo, logger, cfg.CORSOrigins)
+	var storageHandler *storagehandlers.Handler
+	if localStorage, ok := storageSvc.(*service.LocalStorage); ok {
+		storageHandler = storagehandlers.NewHandler(localStorage, cfg.AWS.MaxUploadBytes, logger)
+	}
+	router := httpserver.NewRouter(authHandler, roomHandler, fileHandler, webhookHandler, storageHandler, jwtSvc, sessionRepo, logger, cfg.CORSOrigins)
 
 	srv := &http.Server{
 		Addr:         cfg.HTTPAddr,
@@ -145,9 +211,15 @@ func main() {
 	}()
 
 	startStaleActiveRoomsCloser(roomSvc, logger, 24*time.Hour, time.Hour)
+	startStaleImportsReaper(mediaUploadSvc, logger, time.Hour)
 	mediaCleanupSvc.RunDaily()
+	usageSvc.RunDaily()
 
-	waitForShutdown(logger, srv)
+	var workers *service.MediaTranscoderService
+	if cfg.Transcoding.RunInAPI {
+		workers = transcoderSvc
+	}
+	waitForShutdown(logger, srv, workers, stopWorkers)
 }
 
 func startStaleActiveRoomsCloser(roomSvc *service.RoomService, logger *zap.Logger, minActiveAge, runEvery time.Duration) {
@@ -180,16 +252,51 @@ func startStaleActiveRoomsCloser(roomSvc *service.RoomService, logger *zap.Logge
 	}()
 }
 
-func waitForShutdown(logger *zap.Logger, srv *http.Server) {
+// startStaleImportsReaper fails imports left behind by an API process that
+// stopped while downloading them.
+func startStaleImportsReaper(mediaSvc *service.MediaUploadService, logger *zap.Logger, runEvery time.Duration) {
+	if runEvery <= 0 {
+		runEvery = time.Hour
+	}
+
+	go func() {
+		ticker := time.NewTicker(runEvery)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+			failedCount, err := mediaSvc.FailStaleImports(ctx)
+			cancel()
+			if err != nil {
+				logger.Error("stale import reaper failed", zap.Error(err))
+			} else if failedCount > 0 {
+				logger.Info("stale import reaper finished", zap.Int("failed_imports", failedCount))
+			}
+		}
+	}()
+}
+
+// waitForShutdown stops the HTTP server and the embedded transcoding
+// workers, if any, once a stop signal arrives. Jobs still running after
+// workerShutdownGrace are cancelled and re-queued.
+func waitForShutdown(logger *zap.Logger, srv *http.Server, workers *service.MediaTranscoderService, stopWorkers context.CancelFunc) {
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
 	<-stop
 
+	logger.Info("shutting down")
+	stopWorkers()
+
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-
-	logger.Info("shutting down")
 	if err := srv.Shutdown(ctx); err != nil {
 		logger.Error("shutdown error", zap.Error(err))
 	}
+
+	if workers == nil {
+		return
+	}
+	if workers.Stop(workerShutdownGrace) {
+		logger.Warn("transcoding jobs still running at shutdown were cancelled and re-queued", zap.Duration("grace", workerShutdownGrace))
+	}
 }
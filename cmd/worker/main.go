@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"calixio/internal/config"
+	"calixio/internal/repository"
+	"calixio/internal/service"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+// shutdownGrace bounds how long in-flight encodes may keep running after a
+// stop signal. Jobs still running afterwards are cancelled and re-queued.
+const shutdownGrace = 30 * time.Second
+
+func main() {
+	cfg, err := config.Load()
+	if err != nil {
+		panic(err)
+	}
+
+	var logger *zap.Logger
+	if cfg.Env == "prod" {
+		logger, err = zap.NewProduction()
+	} else {
+		logger, err = zap.NewDevelopment()
+	}
+	if err != nil {
+		panic(err)
+	}
+
+	defer func() { _ = logger.Sync() }()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	pool, err := pgxpool.New(ctx, cfg.DatabaseURL)
+	if err != nil {
+		logger.Fatal("postgres connect", zap.Error(err))
+	}
+	defer pool.Close()
+
+	if err := pool.Ping(ctx); err != nil {
+		logger.Fatal("postgres ping", zap.Error(err))
+	}
+
+	mediaRepo := repository.NewPostgresMediaRepository(pool)
+	transcodeJobRepo := repository.NewPostgresTranscodeJobRepository(pool)
+	mediaSubtitleRepo := repository.NewPostgresMediaSubtitleRepository(pool)
+	mediaKeyRepo := repository.NewPostgresMediaKeyRepository(pool)
+
+	storageSvc, err := service.NewStorage(ctx, cfg)
+	if err != nil {
+		logger.Fatal("storage init", zap.Error(err))
+	}
+
+	malwareScanner, err := service.NewMalwareScanner(cfg)
+	if err != nil {
+		logger.Fatal("malware scanner init", zap.Error(err))
+	}
+	transcoderSvc, err := service.NewMediaTranscoderService(service.NewMediaTranscoderServiceInput{
+		MediaRepo:             mediaRepo,
+		JobRepo:               transcodeJobRepo,
+		SubtitleRepo:          mediaSubtitleRepo,
+		KeyRepo:               mediaKeyRepo,
+		Storage:               storageSvc,
+		FFmpegPath:            cfg.Transcoding.FFmpegPath,
+		FFprobePath:           cfg.Transcoding.FFprobePath,
+		WorkDir:               cfg.Transcoding.WorkDir,
+		SegmentDuration:       cfg.Transcoding.HLSSegmentSec,
+		Renditions:            cfg.Transcoding.HLSRenditions,
+		ProfilesFile:          cfg.Transcoding.ProfilesFile,
+		Passthrough:           cfg.Transcoding.Passthrough,
+		PassthroughMaxKbps:    cfg.Transcoding.PassthroughMaxKbps,
+		StoryboardIntervalSec: cfg.Transcoding.StoryboardIntervalSec,
+		StoryboardThumbWidth:  cfg.Transcoding.StoryboardThumbWidth,
+		KeyRotationSegments:   cfg.Transcoding.KeyRotationSegments,
+		Workers:               cfg.Transcoding.Workers,
+		JobTimeout:            cfg.Transcoding.JobTimeout,
+		JobLeaseTTL:           cfg.Transcoding.JobLeaseTTL,
+		JobPollInterval:       cfg.Transcoding.JobPoll,
+		MaxAttempts:           cfg.Transcoding.MaxAttempts,
+		Logger:                logger,
+		Scanner:               malwareScanner,
+		QuarantinePrefix:      cfg.MalwareScan.QuarantinePrefix,
+	})
+	if err != nil {
+		logger.Fatal("transcoder init", zap.Error(err))
+	}
+	if err := transcoderSvc.Start(ctx); err != nil {
+		logger.Fatal("transcoder start", zap.Error(err))
+	}
+
+	<-ctx.Done()
+	logger.Info("shutting down")
+
+	if transcoderSvc.Stop(shutdownGrace) {
+		logger.Warn("transcoding jobs still running at shutdown were cancelled and re-queued", zap.Duration("grace", shutdownGrace))
+	}
+}
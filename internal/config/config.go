@@ -28,6 +28,23 @@ type Config struct {
 		RoomAutoTimeout time.Duration
 		TokenTTL        time.Duration
 	}
+	Storage struct {
+		Backend      string
+		LocalDir     string
+		LocalBaseURL string
+		LocalSecret  string
+	}
+	GCS struct {
+		Bucket          string
+		CredentialsFile string
+		PublicURL       string
+	}
+	Azure struct {
+		Account   string
+		Key       string
+		Container string
+		Endpoint  string
+	}
 	AWS struct {
 		Bucket          string
 		Region          string
@@ -36,21 +53,51 @@ type Config struct {
 		Endpoint        string
 		PathStyle       bool
 		PublicURL       string
+		PrivateOutputs  bool
 		PresignTTL      time.Duration
 		MaxUploadBytes  int64
 		AllowedMIMEs    []string
 	}
 	Transcoding struct {
-		Enabled       bool
-		FFmpegPath    string
-		FFprobePath   string
-		WorkDir       string
-		HLSSegmentSec int
-		QueueSize     int
-		JobTimeout    time.Duration
+		Enabled               bool
+		RunInAPI              bool
+		FFmpegPath            string
+		FFprobePath           string
+		WorkDir               string
+		HLSSegmentSec         int
+		HLSRenditions         []string
+		ProfilesFile          string
+		Passthrough           bool
+		PassthroughMaxKbps    int
+		StoryboardIntervalSec int
+		StoryboardThumbWidth  int
+		KeyRotationSegments   int
+		Workers               int
+		JobTimeout            time.Duration
+		JobLeaseTTL           time.Duration
+		JobPoll               time.Duration
+		MaxAttempts           int
 	}
 	MediaPlayback struct {
-		SignedTTL time.Duration
+		SignedTTL     time.Duration
+		ProxySegments bool
+	}
+	MediaUpload struct {
+		Probe bool
+	}
+	MediaImport struct {
+		AllowedHosts []string
+		MaxRedirects int
+		Timeout      time.Duration
+	}
+	MalwareScan struct {
+		Scanner          string
+		ClamdAddress     string
+		Timeout          time.Duration
+		QuarantinePrefix string
+		// MaxStreamBytes mirrors StreamMaxLength in clamd.conf; 0 means
+		// clamd's own ceiling of 4 GiB.
+		MaxStreamBytes int64
 	}
 }
 
@@ -78,6 +125,20 @@ func Load() (*Config, error) {
 	cfg.LiveKit.RoomAutoTimeout = getenvDuration("LIVEKIT_ROOM_AUTO_TIMEOUT", 2*time.Hour)
 	cfg.LiveKit.TokenTTL = getenvDuration("LIVEKIT_TOKEN_TTL", 2*time.Hour)
 
+	cfg.Storage.Backend = strings.ToLower(getenv("STORAGE_BACKEND", "s3"))
+	cfg.Storage.LocalDir = getenv("STORAGE_LOCAL_DIR", "./data/storage")
+	cfg.Storage.LocalBaseURL = getenv("STORAGE_LOCAL_BASE_URL", "http://localhost:8080")
+	cfg.Storage.LocalSecret = getenv("STORAGE_LOCAL_SECRET", "")
+
+	cfg.GCS.Bucket = getenv("GCS_BUCKET", "")
+	cfg.GCS.CredentialsFile = getenv("GCS_CREDENTIALS_FILE", "")
+	cfg.GCS.PublicURL = getenv("GCS_PUBLIC_URL", "")
+
+	cfg.Azure.Account = getenv("AZURE_STORAGE_ACCOUNT", "")
+	cfg.Azure.Key = getenv("AZURE_STORAGE_KEY", "")
+	cfg.Azure.Container = getenv("AZURE_STORAGE_CONTAINER", "")
+	cfg.Azure.Endpoint = getenv("AZURE_STORAGE_ENDPOINT", "")
+
 	cfg.AWS.Bucket = getenv("AWS_S3_BUCKET", "")
 	cfg.AWS.Region = getenv("AWS_REGION", "us-east-1")
 	cfg.AWS.AccessKeyID = getenv("AWS_ACCESS_KEY_ID", "")
@@ -85,6 +146,7 @@ func Load() (*Config, error) {
 	cfg.AWS.Endpoint = getenv("AWS_S3_ENDPOINT", "")
 	cfg.AWS.PathStyle = getenv("AWS_S3_PATH_STYLE", "false") == "true"
 	cfg.AWS.PublicURL = getenv("AWS_S3_PUBLIC_URL", "")
+	cfg.AWS.PrivateOutputs = getenv("AWS_S3_PRIVATE_OUTPUTS", "false") == "true"
 	cfg.AWS.PresignTTL = getenvDuration("AWS_S3_PRESIGN_TTL", 15*time.Minute)
 	cfg.AWS.MaxUploadBytes = getenvInt64("AWS_S3_MAX_UPLOAD_BYTES", 10*1024*1024*1024)
 	cfg.AWS.AllowedMIMEs = getenvCSV("AWS_S3_ALLOWED_MIME_TYPES", []string{
@@ -97,17 +159,46 @@ func Load() (*Config, error) {
 	})
 
 	cfg.Transcoding.Enabled = getenv("TRANSCODER_ENABLED", "false") == "true"
+	cfg.Transcoding.RunInAPI = getenv("TRANSCODER_RUN_IN_API", "true") == "true"
 	cfg.Transcoding.FFmpegPath = getenv("TRANSCODER_FFMPEG_PATH", "ffmpeg")
 	cfg.Transcoding.FFprobePath = getenv("TRANSCODER_FFPROBE_PATH", "ffprobe")
 	cfg.Transcoding.WorkDir = getenv("TRANSCODER_WORK_DIR", "")
 	cfg.Transcoding.HLSSegmentSec = getenvInt("TRANSCODER_HLS_SEGMENT_SEC", 6)
-	cfg.Transcoding.QueueSize = getenvInt("TRANSCODER_QUEUE_SIZE", 32)
+	cfg.Transcoding.HLSRenditions = getenvCSV("TRANSCODER_HLS_RENDITIONS", []string{
+		"1080p:5000k",
+		"720p:2800k",
+		"480p:1400k",
+		"360p:800k",
+	})
+	cfg.Transcoding.ProfilesFile = getenv("TRANSCODER_PROFILES_FILE", "")
+	cfg.Transcoding.Passthrough = getenv("TRANSCODER_PASSTHROUGH", "true") == "true"
+	cfg.Transcoding.PassthroughMaxKbps = getenvInt("TRANSCODER_PASSTHROUGH_MAX_KBPS", 8000)
+	cfg.Transcoding.StoryboardIntervalSec = getenvInt("TRANSCODER_STORYBOARD_INTERVAL_SEC", 10)
+	cfg.Transcoding.StoryboardThumbWidth = getenvInt("TRANSCODER_STORYBOARD_THUMB_WIDTH", 160)
+	cfg.Transcoding.KeyRotationSegments = getenvInt("TRANSCODER_KEY_ROTATION_SEGMENTS", 10)
+	cfg.Transcoding.Workers = getenvInt("TRANSCODER_WORKERS", 1)
 	cfg.Transcoding.JobTimeout = 4 * time.Hour
+	cfg.Transcoding.JobLeaseTTL = getenvDuration("TRANSCODER_JOB_LEASE_TTL", 2*time.Minute)
+	cfg.Transcoding.JobPoll = getenvDuration("TRANSCODER_JOB_POLL_INTERVAL", 5*time.Second)
+	cfg.Transcoding.MaxAttempts = getenvInt("TRANSCODER_JOB_MAX_ATTEMPTS", 3)
 	cfg.MediaPlayback.SignedTTL = getenvDuration("MEDIA_PLAYBACK_SIGNED_TTL", 3*time.Hour)
+	cfg.MediaPlayback.ProxySegments = getenv("MEDIA_PLAYBACK_PROXY_SEGMENTS", "false") == "true"
+	cfg.MediaUpload.Probe = getenv("MEDIA_UPLOAD_PROBE", "true") == "true"
+	cfg.MediaImport.AllowedHosts = getenvCSV("MEDIA_IMPORT_ALLOWED_HOSTS", nil)
+	cfg.MediaImport.MaxRedirects = getenvInt("MEDIA_IMPORT_MAX_REDIRECTS", 5)
+	cfg.MediaImport.Timeout = getenvDuration("MEDIA_IMPORT_TIMEOUT", 6*time.Hour)
+	cfg.MalwareScan.Scanner = strings.ToLower(getenv("MALWARE_SCANNER", ""))
+	cfg.MalwareScan.ClamdAddress = getenv("CLAMD_ADDRESS", "tcp://127.0.0.1:3310")
+	cfg.MalwareScan.Timeout = getenvDuration("CLAMD_TIMEOUT", 10*time.Minute)
+	cfg.MalwareScan.QuarantinePrefix = getenv("MALWARE_QUARANTINE_PREFIX", "quarantine/")
+	cfg.MalwareScan.MaxStreamBytes = getenvInt64("CLAMD_MAX_STREAM_BYTES", 0)
 
 	if cfg.JWTSecret == "change-me" {
 		return nil, fmt.Errorf("JWT_SECRET must be set")
 	}
+	if cfg.Storage.Backend == "local" && cfg.Storage.LocalSecret == "" {
+		return nil, fmt.Errorf("STORAGE_LOCAL_SECRET must be set for the local storage backend")
+	}
 
 	return cfg, nil
 }
@@ -4,6 +4,10 @@ type InitMediaUploadRequest struct {
 	FileName    string `json:"fileName" validate:"required"`
 	ContentType string `json:"contentType" validate:"required"`
 	SizeBytes   int64  `json:"sizeBytes" validate:"required,gt=0"`
+	Format      string `json:"format,omitempty" validate:"omitempty,oneof=hls_ts cmaf"`
+	Encrypted   bool   `json:"encrypted,omitempty"`
+	// ContentSHA256 lets the server reuse the outputs of an identical file.
+	ContentSHA256 string `json:"contentSha256,omitempty" validate:"omitempty,len=64,hexadecimal"`
 }
 
 type InitMediaUploadResponse struct {
@@ -12,6 +16,50 @@ type InitMediaUploadResponse struct {
 	StorageKey string `json:"storageKey"`
 }
 
+type InitMultipartMediaUploadResponse struct {
+	MediaID       string `json:"mediaId"`
+	UploadID      string `json:"uploadId"`
+	StorageKey    string `json:"storageKey"`
+	PartSizeBytes int64  `json:"partSizeBytes"`
+	PartCount     int    `json:"partCount"`
+}
+
+type SignMultipartMediaUploadRequest struct {
+	MediaID     string  `json:"mediaId" validate:"required"`
+	PartNumbers []int32 `json:"partNumbers" validate:"required,min=1,max=1000,dive,gt=0"`
+}
+
+type MultipartUploadPartURLResponse struct {
+	PartNumber int32  `json:"partNumber"`
+	UploadURL  string `json:"uploadUrl"`
+}
+
+type SignMultipartMediaUploadResponse struct {
+	MediaID string                           `json:"mediaId"`
+	Parts   []MultipartUploadPartURLResponse `json:"parts"`
+}
+
+// MultipartUploadPartRequest carries the ETag the storage returned for the
+// part; backends that check parts by presence (Azure) accept it empty.
+type MultipartUploadPartRequest struct {
+	PartNumber int32  `json:"partNumber" validate:"required,gt=0"`
+	ETag       string `json:"etag"`
+}
+
+type CompleteMultipartMediaUploadRequest struct {
+	MediaID string                       `json:"mediaId" validate:"required"`
+	Parts   []MultipartUploadPartRequest `json:"parts" validate:"required,min=1,dive"`
+}
+
+type AbortMultipartMediaUploadRequest struct {
+	MediaID string `json:"mediaId" validate:"required"`
+}
+
+type AbortMultipartMediaUploadResponse struct {
+	MediaID string `json:"mediaId"`
+	Status  string `json:"status"`
+}
+
 type CompleteMediaUploadRequest struct {
 	MediaID string `json:"mediaId" validate:"required"`
 }
@@ -24,6 +72,7 @@ type CompleteMediaUploadResponse struct {
 type MediaListItemResponse struct {
 	ID            string  `json:"id"`
 	Title         string  `json:"title"`
+	Description   string  `json:"description"`
 	OriginalName  string  `json:"originalName"`
 	PlaybackURL   string  `json:"playbackUrl"`
 	PreviewURL    *string `json:"previewUrl,omitempty"`
@@ -31,19 +80,142 @@ type MediaListItemResponse struct {
 	FileSizeBytes int64   `json:"fileSizeBytes"`
 	MimeType      string  `json:"mimeType"`
 	Status        string  `json:"status"`
+	RejectReason  *string `json:"rejectReason,omitempty"`
 	CreatedAt     string  `json:"createdAt"`
+
+	EncodingProfile *string                    `json:"encodingProfile,omitempty"`
+	Progress        *MediaProgressResponse     `json:"progress,omitempty"`
+	Width           *int                       `json:"width,omitempty"`
+	Height          *int                       `json:"height,omitempty"`
+	TechMetadata    *MediaTechMetadataResponse `json:"techMetadata,omitempty"`
+	Encrypted       bool                       `json:"encrypted"`
+	Scan            *MediaScanResponse         `json:"scan,omitempty"`
+	ContentSHA256   *string                    `json:"contentSha256,omitempty"`
+	Tags            []string                   `json:"tags"`
+	CollectionIDs   []string                   `json:"collectionIds"`
+}
+
+// MediaListResponse is one page of GET /media; nextCursor is null on the
+// last page.
+type MediaListResponse struct {
+	Items      []MediaListItemResponse `json:"items"`
+	NextCursor *string                 `json:"nextCursor"`
+}
+
+// UpdateMediaRequest changes only the fields present; an empty tags or
+// collectionIds array clears them.
+type UpdateMediaRequest struct {
+	Title         *string   `json:"title,omitempty" validate:"omitempty,max=200"`
+	Description   *string   `json:"description,omitempty" validate:"omitempty,max=5000"`
+	Tags          *[]string `json:"tags,omitempty"`
+	CollectionIDs *[]string `json:"collectionIds,omitempty"`
+}
+
+type MediaTechMetadataResponse struct {
+	Container      string  `json:"container"`
+	DurationSec    float64 `json:"durationSec"`
+	BitRate        int64   `json:"bitRate"`
+	Width          int     `json:"width"`
+	Height         int     `json:"height"`
+	FrameRate      float64 `json:"frameRate"`
+	VideoCodec     string  `json:"videoCodec"`
+	VideoProfile   string  `json:"videoProfile,omitempty"`
+	PixelFormat    string  `json:"pixelFormat,omitempty"`
+	VideoBitRate   int64   `json:"videoBitRate,omitempty"`
+	AudioCodec     string  `json:"audioCodec,omitempty"`
+	AudioChannels  int     `json:"audioChannels,omitempty"`
+	ChannelLayout  string  `json:"channelLayout,omitempty"`
+	SampleRate     int     `json:"sampleRate,omitempty"`
+	AudioBitRate   int64   `json:"audioBitRate,omitempty"`
+	VideoTracks    int     `json:"videoTracks"`
+	AudioTracks    int     `json:"audioTracks"`
+	SubtitleTracks int     `json:"subtitleTracks"`
+}
+
+type MediaProgressResponse struct {
+	Stage     string  `json:"stage"`
+	Percent   float64 `json:"percent"`
+	ETASec    *int    `json:"etaSec,omitempty"`
+	UpdatedAt string  `json:"updatedAt"`
+}
+
+type MediaScanResponse struct {
+	Status    string  `json:"status"`
+	Signature *string `json:"signature,omitempty"`
+	ScannedAt string  `json:"scannedAt"`
 }
 
 type PlaybackMediaResponse struct {
-	MediaID     string  `json:"mediaId"`
-	Status      string  `json:"status"`
-	Manifest    string  `json:"manifest"`
-	ManifestURL *string `json:"manifestUrl,omitempty"`
-	PreviewURL  *string `json:"previewUrl,omitempty"`
-	ExpiresAt   string  `json:"expiresAt"`
+	MediaID         string  `json:"mediaId"`
+	Status          string  `json:"status"`
+	Format          string  `json:"format"`
+	ManifestType    string  `json:"manifestType"`
+	Manifest        string  `json:"manifest"`
+	ManifestURL     *string `json:"manifestUrl,omitempty"`
+	DashManifestURL *string `json:"dashManifestUrl,omitempty"`
+	PreviewURL      *string `json:"previewUrl,omitempty"`
+	StoryboardURL   *string `json:"storyboardUrl,omitempty"`
+	ExpiresAt       string  `json:"expiresAt"`
 }
 
 type DeleteMediaResponse struct {
 	MediaID string `json:"mediaId"`
 	Status  string `json:"status"`
 }
+
+type SetMediaPosterRequest struct {
+	TimestampSec *float64 `json:"timestampSec" validate:"required,gte=0"`
+}
+
+type MediaPosterResponse struct {
+	MediaID    string  `json:"mediaId"`
+	PreviewURL *string `json:"previewUrl,omitempty"`
+}
+
+type MediaSubtitleResponse struct {
+	ID        string `json:"id"`
+	Language  string `json:"language"`
+	Label     string `json:"label"`
+	Source    string `json:"source"`
+	IsDefault bool   `json:"isDefault"`
+	CreatedAt string `json:"createdAt"`
+}
+
+// UsageResponse reports a user's consumption; limits are null when unlimited.
+type UsageResponse struct {
+	Plan            string `json:"plan"`
+	StorageBytes    int64  `json:"storageBytes"`
+	MediaCount      int    `json:"mediaCount"`
+	DurationSec     int64  `json:"durationSec"`
+	MaxStorageBytes *int64 `json:"maxStorageBytes"`
+	MaxMediaCount   *int   `json:"maxMediaCount"`
+	MaxDurationSec  *int64 `json:"maxDurationSec"`
+}
+
+type ImportMediaRequest struct {
+	URL       string `json:"url" validate:"required,url"`
+	Format    string `json:"format,omitempty" validate:"omitempty,oneof=hls_ts cmaf"`
+	Encrypted bool   `json:"encrypted,omitempty"`
+}
+
+type ImportMediaResponse struct {
+	MediaID   string `json:"mediaId"`
+	Status    string `json:"status"`
+	SizeBytes int64  `json:"sizeBytes"`
+}
+
+type CollectionRequest struct {
+	Name string `json:"name" validate:"required,max=100"`
+}
+
+type CollectionResponse struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	MediaCount int    `json:"mediaCount"`
+	CreatedAt  string `json:"createdAt"`
+}
+
+type TagResponse struct {
+	Name       string `json:"name"`
+	MediaCount int    `json:"mediaCount"`
+}
@@ -41,14 +41,16 @@ type UpdateRoomStateResponse struct {
 }
 
 type RoomPlaybackStateResponse struct {
-	RoomID       string  `json:"roomId"`
-	MediaID      string  `json:"mediaId"`
-	Status       string  `json:"status"`
-	PositionMs   int64   `json:"positionMs"`
-	PlaybackRate float64 `json:"playbackRate"`
-	UpdatedAt    int64   `json:"updatedAt"`
-	Version      int64   `json:"version"`
-	HostID       string  `json:"hostId"`
+	RoomID        string  `json:"roomId"`
+	MediaID       string  `json:"mediaId"`
+	Status        string  `json:"status"`
+	PositionMs    int64   `json:"positionMs"`
+	PlaybackRate  float64 `json:"playbackRate"`
+	UpdatedAt     int64   `json:"updatedAt"`
+	Version       int64   `json:"version"`
+	HostID        string  `json:"hostId"`
+	AudioTrack    string  `json:"audioTrack,omitempty"`
+	SubtitleTrack string  `json:"subtitleTrack,omitempty"`
 }
 
 type UpdateRoomPlaybackRequest struct {
@@ -56,4 +58,7 @@ type UpdateRoomPlaybackRequest struct {
 	Status       string  `json:"status" validate:"required,oneof=playing paused seeking"`
 	PositionMs   int64   `json:"positionMs"`
 	PlaybackRate float64 `json:"playbackRate" validate:"gt=0"`
+	// Omit a track field to keep the current selection; send "" to clear it.
+	AudioTrack    *string `json:"audioTrack" validate:"omitempty,max=128"`
+	SubtitleTrack *string `json:"subtitleTrack" validate:"omitempty,max=128"`
 }
@@ -0,0 +1,132 @@
+package files
+
+import (
+	"errors"
+	"net/http"
+
+	"calixio/internal/http/authn"
+	"calixio/internal/http/dto"
+	httputil "calixio/internal/http/httputil"
+	"calixio/internal/repository"
+	"calixio/internal/service"
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+func (h *Handler) ListCollections(w http.ResponseWriter, r *http.Request) {
+	userID := authn.UserIDFromContext(r.Context())
+	if userID == "" {
+		httputil.RespondError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	collections, err := h.media.ListCollections(r.Context(), userID)
+	if err != nil {
+		h.logger.Error("list collections", zap.Error(err), zap.String("user_id", userID))
+		httputil.RespondError(w, http.StatusInternalServerError, "collection_list_failed")
+		return
+	}
+
+	resp := make([]dto.CollectionResponse, 0, len(collections))
+	for _, collection := range collections {
+		resp = append(resp, collectionResponse(collection))
+	}
+
+	httputil.RespondJSON(w, http.StatusOK, resp)
+}
+
+func (h *Handler) CreateCollection(w http.ResponseWriter, r *http.Request) {
+	userID := authn.UserIDFromContext(r.Context())
+	if userID == "" {
+		httputil.RespondError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var req dto.CollectionRequest
+	if err := httputil.DecodeJSON(r, &req); err != nil {
+		httputil.RespondError(w, http.StatusBadRequest, "invalid_json")
+		return
+	}
+	if err := httputil.ValidateStruct(req); err != nil {
+		httputil.RespondValidationError(w, err)
+		return
+	}
+
+	collection, err := h.media.CreateCollection(r.Context(), userID, req.Name)
+	if err != nil {
+		h.respondCollectionError(w, err, "create collection", userID, "")
+		return
+	}
+
+	httputil.RespondJSON(w, http.StatusCreated, collectionResponse(collection))
+}
+
+func (h *Handler) UpdateCollection(w http.ResponseWriter, r *http.Request) {
+	userID := authn.UserIDFromContext(r.Context())
+	if userID == "" {
+		httputil.RespondError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var req dto.CollectionRequest
+	if err := httputil.DecodeJSON(r, &req); err != nil {
+		httputil.RespondError(w, http.StatusBadRequest, "invalid_json")
+		return
+	}
+	if err := httputil.ValidateStruct(req); err != nil {
+		httputil.RespondValidationError(w, err)
+		return
+	}
+
+	collectionID := chi.URLParam(r, "id")
+	collection, err := h.media.RenameCollection(r.Context(), userID, collectionID, req.Name)
+	if err != nil {
+		h.respondCollectionError(w, err, "rename collection", userID, collectionID)
+		return
+	}
+
+	httputil.RespondJSON(w, http.StatusOK, collectionResponse(collection))
+}
+
+func (h *Handler) DeleteCollection(w http.ResponseWriter, r *http.Request) {
+	userID := authn.UserIDFromContext(r.Context())
+	if userID == "" {
+		httputil.RespondError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	collectionID := chi.URLParam(r, "id")
+	if err := h.media.DeleteCollection(r.Context(), userID, collectionID); err != nil {
+		h.respondCollectionError(w, err, "delete collection", userID, collectionID)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) respondCollectionError(w http.ResponseWriter, err error, action, userID, collectionID string) {
+	switch {
+	case errors.Is(err, service.ErrCollectionNotFound):
+		httputil.RespondError(w, http.StatusNotFound, "collection_not_found")
+	case errors.Is(err, service.ErrForbiddenCollection):
+		httputil.RespondError(w, http.StatusForbidden, "collection_forbidden")
+	case errors.Is(err, service.ErrCollectionExists):
+		httputil.RespondError(w, http.StatusConflict, "collection_exists")
+	case errors.Is(err, service.ErrInvalidCollection):
+		httputil.RespondError(w, http.StatusBadRequest, "invalid_collection")
+	case errors.Is(err, service.ErrInvalidUploadInput):
+		httputil.RespondError(w, http.StatusBadRequest, "invalid_collection_request")
+	default:
+		h.logger.Error(action, zap.Error(err), zap.String("user_id", userID), zap.String("collection_id", collectionID))
+		httputil.RespondError(w, http.StatusInternalServerError, "collection_update_failed")
+	}
+}
+
+func collectionResponse(collection repository.Collection) dto.CollectionResponse {
+	return dto.CollectionResponse{
+		ID:         collection.ID,
+		Name:       collection.Name,
+		MediaCount: collection.MediaCount,
+		CreatedAt:  collection.CreatedAt.Format(httputil.TimeLayout),
+	}
+}
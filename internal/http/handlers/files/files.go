@@ -3,6 +3,8 @@ package files
 import (
 	"errors"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"calixio/internal/http/authn"
 	"calixio/internal/http/dto"
@@ -15,49 +17,113 @@ import (
 
 type Handler struct {
 	media  *service.MediaUploadService
+	usage  *service.UsageService
 	logger *zap.Logger
 }
 
-func NewHandler(media *service.MediaUploadService, logger *zap.Logger) *Handler {
-	return &Handler{media: media, logger: logger}
+func NewHandler(media *service.MediaUploadService, usage *service.UsageService, logger *zap.Logger) *Handler {
+	return &Handler{media: media, usage: usage, logger: logger}
 }
 
-func (h *Handler) ListMedia(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) GetMedia(w http.ResponseWriter, r *http.Request) {
 	userID := authn.UserIDFromContext(r.Context())
 	if userID == "" {
 		httputil.RespondError(w, http.StatusUnauthorized, "unauthorized")
 		return
 	}
 
-	items, err := h.media.ListByOwner(r.Context(), userID)
+	mediaID := chi.URLParam(r, "id")
+	if mediaID == "" {
+		httputil.RespondError(w, http.StatusBadRequest, "media_id_required")
+		return
+	}
+
+	item, err := h.media.GetMedia(r.Context(), userID, mediaID)
 	if err != nil {
 		switch {
+		case errors.Is(err, repository.ErrNotFound):
+			httputil.RespondError(w, http.StatusNotFound, "media_not_found")
+		case errors.Is(err, service.ErrForbiddenMedia):
+			httputil.RespondError(w, http.StatusForbidden, "media_forbidden")
 		case errors.Is(err, service.ErrInvalidUploadInput):
-			httputil.RespondError(w, http.StatusBadRequest, "invalid_upload_input")
+			httputil.RespondError(w, http.StatusBadRequest, "invalid_media_request")
 		default:
-			h.logger.Error("list media", zap.Error(err), zap.String("user_id", userID))
-			httputil.RespondError(w, http.StatusInternalServerError, "media_list_failed")
+			h.logger.Error("get media", zap.Error(err), zap.String("user_id", userID), zap.String("media_id", mediaID))
+			httputil.RespondError(w, http.StatusInternalServerError, "media_get_failed")
 		}
 		return
 	}
 
-	resp := make([]dto.MediaListItemResponse, 0, len(items))
-	for _, item := range items {
-		resp = append(resp, dto.MediaListItemResponse{
-			ID:            item.ID,
-			Title:         item.Title,
-			OriginalName:  item.OriginalName,
-			PlaybackURL:   item.PlaybackURL,
-			PreviewURL:    item.PreviewURL,
-			DurationSec:   item.DurationSec,
-			FileSizeBytes: item.FileSizeBytes,
-			MimeType:      item.MimeType,
-			Status:        string(item.Status),
-			CreatedAt:     item.CreatedAt.Format(httputil.TimeLayout),
-		})
-	}
+	httputil.RespondJSON(w, http.StatusOK, mediaListItemResponse(item))
+}
 
-	httputil.RespondJSON(w, http.StatusOK, resp)
+func mediaListItemResponse(item repository.Media) dto.MediaListItemResponse {
+	resp := dto.MediaListItemResponse{
+		ID:            item.ID,
+		Title:         item.Title,
+		Description:   item.Description,
+		OriginalName:  item.OriginalName,
+		PlaybackURL:   item.PlaybackURL,
+		PreviewURL:    item.PreviewURL,
+		DurationSec:   item.DurationSec,
+		FileSizeBytes: item.FileSizeBytes,
+		MimeType:      item.MimeType,
+		Status:        string(item.Status),
+		RejectReason:  item.RejectReason,
+		CreatedAt:     item.CreatedAt.Format(httputil.TimeLayout),
+
+		EncodingProfile: item.EncodingProfile,
+		Width:           item.Width,
+		Height:          item.Height,
+		Encrypted:       item.Encrypted,
+		ContentSHA256:   item.ContentSHA256,
+		Tags:            item.Tags,
+		CollectionIDs:   item.CollectionIDs,
+	}
+	if resp.Tags == nil {
+		resp.Tags = []string{}
+	}
+	if resp.CollectionIDs == nil {
+		resp.CollectionIDs = []string{}
+	}
+	if meta := item.TechMetadata; meta != nil {
+		resp.TechMetadata = &dto.MediaTechMetadataResponse{
+			Container:      meta.Container,
+			DurationSec:    meta.DurationSec,
+			BitRate:        meta.BitRate,
+			Width:          meta.Width,
+			Height:         meta.Height,
+			FrameRate:      meta.FrameRate,
+			VideoCodec:     meta.VideoCodec,
+			VideoProfile:   meta.VideoProfile,
+			PixelFormat:    meta.PixelFormat,
+			VideoBitRate:   meta.VideoBitRate,
+			AudioCodec:     meta.AudioCodec,
+			AudioChannels:  meta.AudioChannels,
+			ChannelLayout:  meta.ChannelLayout,
+			SampleRate:     meta.SampleRate,
+			AudioBitRate:   meta.AudioBitRate,
+			VideoTracks:    meta.VideoTracks,
+			AudioTracks:    meta.AudioTracks,
+			SubtitleTracks: meta.SubtitleTracks,
+		}
+	}
+	if item.Progress != nil {
+		resp.Progress = &dto.MediaProgressResponse{
+			Stage:     string(item.Progress.Stage),
+			Percent:   item.Progress.Percent,
+			ETASec:    item.Progress.ETASec,
+			UpdatedAt: item.Progress.UpdatedAt.UTC().Format(httputil.TimeLayout),
+		}
+	}
+	if item.Scan != nil {
+		resp.Scan = &dto.MediaScanResponse{
+			Status:    string(item.Scan.Status),
+			Signature: item.Scan.Signature,
+			ScannedAt: item.Scan.ScannedAt.UTC().Format(httputil.TimeLayout),
+		}
+	}
+	return resp
 }
 
 func (h *Handler) InitMediaUpload(w http.ResponseWriter, r *http.Request) {
@@ -78,13 +144,20 @@ func (h *Handler) InitMediaUpload(w http.ResponseWriter, r *http.Request) {
 	}
 
 	out, err := h.media.InitUpload(r.Context(), service.InitUploadInput{
-		OwnerUserID: userID,
-		FileName:    req.FileName,
-		ContentType: req.ContentType,
-		SizeBytes:   req.SizeBytes,
+		OwnerUserID:   userID,
+		FileName:      req.FileName,
+		ContentType:   req.ContentType,
+		SizeBytes:     req.SizeBytes,
+		Format:        req.Format,
+		Encrypted:     req.Encrypted,
+		ContentSHA256: req.ContentSHA256,
 	})
 	if err != nil {
 		switch {
+		case errors.Is(err, service.ErrMultipartUploadRequired):
+			httputil.RespondError(w, http.StatusBadRequest, "multipart_upload_required")
+		case quotaErrorCode(err) != "":
+			httputil.RespondError(w, http.StatusForbidden, quotaErrorCode(err))
 		case errors.Is(err, service.ErrInvalidUploadInput):
 			httputil.RespondError(w, http.StatusBadRequest, "invalid_upload_input")
 		default:
@@ -101,6 +174,188 @@ func (h *Handler) InitMediaUpload(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+func (h *Handler) InitMultipartMediaUpload(w http.ResponseWriter, r *http.Request) {
+	userID := authn.UserIDFromContext(r.Context())
+	if userID == "" {
+		httputil.RespondError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var req dto.InitMediaUploadRequest
+	if err := httputil.DecodeJSON(r, &req); err != nil {
+		httputil.RespondError(w, http.StatusBadRequest, "invalid_json")
+		return
+	}
+	if err := httputil.ValidateStruct(req); err != nil {
+		httputil.RespondValidationError(w, err)
+		return
+	}
+
+	out, err := h.media.InitMultipartUpload(r.Context(), service.InitUploadInput{
+		OwnerUserID:   userID,
+		FileName:      req.FileName,
+		ContentType:   req.ContentType,
+		SizeBytes:     req.SizeBytes,
+		Format:        req.Format,
+		Encrypted:     req.Encrypted,
+		ContentSHA256: req.ContentSHA256,
+	})
+	if err != nil {
+		switch {
+		case quotaErrorCode(err) != "":
+			httputil.RespondError(w, http.StatusForbidden, quotaErrorCode(err))
+		case errors.Is(err, service.ErrInvalidUploadInput):
+			httputil.RespondError(w, http.StatusBadRequest, "invalid_upload_input")
+		default:
+			h.logger.Error("init multipart media upload", zap.Error(err), zap.String("user_id", userID))
+			httputil.RespondError(w, http.StatusInternalServerError, "media_upload_init_failed")
+		}
+		return
+	}
+
+	httputil.RespondJSON(w, http.StatusCreated, dto.InitMultipartMediaUploadResponse{
+		MediaID:       out.MediaID,
+		UploadID:      out.UploadID,
+		StorageKey:    out.StorageKey,
+		PartSizeBytes: out.PartSizeBytes,
+		PartCount:     out.PartCount,
+	})
+}
+
+func (h *Handler) SignMultipartMediaUpload(w http.ResponseWriter, r *http.Request) {
+	userID := authn.UserIDFromContext(r.Context())
+	if userID == "" {
+		httputil.RespondError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var req dto.SignMultipartMediaUploadRequest
+	if err := httputil.DecodeJSON(r, &req); err != nil {
+		httputil.RespondError(w, http.StatusBadRequest, "invalid_json")
+		return
+	}
+	if err := httputil.ValidateStruct(req); err != nil {
+		httputil.RespondValidationError(w, err)
+		return
+	}
+
+	parts, err := h.media.SignUploadParts(r.Context(), service.SignUploadPartsInput{
+		OwnerUserID: userID,
+		MediaID:     req.MediaID,
+		PartNumbers: req.PartNumbers,
+	})
+	if err != nil {
+		h.respondMultipartError(w, err, "sign multipart media upload", userID, req.MediaID, "media_upload_sign_failed")
+		return
+	}
+
+	resp := dto.SignMultipartMediaUploadResponse{
+		MediaID: req.MediaID,
+		Parts:   make([]dto.MultipartUploadPartURLResponse, 0, len(parts)),
+	}
+	for _, part := range parts {
+		resp.Parts = append(resp.Parts, dto.MultipartUploadPartURLResponse{
+			PartNumber: part.PartNumber,
+			UploadURL:  part.UploadURL,
+		})
+	}
+
+	httputil.RespondJSON(w, http.StatusOK, resp)
+}
+
+func (h *Handler) CompleteMultipartMediaUpload(w http.ResponseWriter, r *http.Request) {
+	userID := authn.UserIDFromContext(r.Context())
+	if userID == "" {
+		httputil.RespondError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var req dto.CompleteMultipartMediaUploadRequest
+	if err := httputil.DecodeJSON(r, &req); err != nil {
+		httputil.RespondError(w, http.StatusBadRequest, "invalid_json")
+		return
+	}
+	if err := httputil.ValidateStruct(req); err != nil {
+		httputil.RespondValidationError(w, err)
+		return
+	}
+
+	parts := make([]service.CompletedPart, 0, len(req.Parts))
+	for _, part := range req.Parts {
+		parts = append(parts, service.CompletedPart{PartNumber: part.PartNumber, ETag: part.ETag})
+	}
+
+	out, err := h.media.CompleteMultipartUpload(r.Context(), service.CompleteMultipartUploadInput{
+		OwnerUserID: userID,
+		MediaID:     req.MediaID,
+		Parts:       parts,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrUploadedObjectNotFound):
+			httputil.RespondError(w, http.StatusNotFound, "uploaded_object_not_found")
+		default:
+			h.respondMultipartError(w, err, "complete multipart media upload", userID, req.MediaID, "media_upload_complete_failed")
+		}
+		return
+	}
+
+	httputil.RespondJSON(w, http.StatusOK, dto.CompleteMediaUploadResponse{
+		MediaID: out.MediaID,
+		Status:  string(out.Status),
+	})
+}
+
+func (h *Handler) AbortMultipartMediaUpload(w http.ResponseWriter, r *http.Request) {
+	userID := authn.UserIDFromContext(r.Context())
+	if userID == "" {
+		httputil.RespondError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var req dto.AbortMultipartMediaUploadRequest
+	if err := httputil.DecodeJSON(r, &req); err != nil {
+		httputil.RespondError(w, http.StatusBadRequest, "invalid_json")
+		return
+	}
+	if err := httputil.ValidateStruct(req); err != nil {
+		httputil.RespondValidationError(w, err)
+		return
+	}
+
+	if err := h.media.AbortMultipartUpload(r.Context(), userID, req.MediaID); err != nil {
+		h.respondMultipartError(w, err, "abort multipart media upload", userID, req.MediaID, "media_upload_abort_failed")
+		return
+	}
+
+	httputil.RespondJSON(w, http.StatusOK, dto.AbortMultipartMediaUploadResponse{
+		MediaID: req.MediaID,
+		Status:  "aborted",
+	})
+}
+
+func (h *Handler) respondMultipartError(w http.ResponseWriter, err error, logMsg, userID, mediaID, failureCode string) {
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		httputil.RespondError(w, http.StatusNotFound, "media_not_found")
+	case errors.Is(err, service.ErrForbiddenMedia):
+		httputil.RespondError(w, http.StatusForbidden, "media_forbidden")
+	case errors.Is(err, service.ErrMultipartUploadNotFound):
+		httputil.RespondError(w, http.StatusNotFound, "multipart_upload_not_found")
+	case errors.Is(err, service.ErrInvalidMultipartParts):
+		httputil.RespondError(w, http.StatusBadRequest, "invalid_multipart_parts")
+	case quotaErrorCode(err) != "":
+		httputil.RespondError(w, http.StatusForbidden, quotaErrorCode(err))
+	case rejectReason(err) != "":
+		respondMediaRejected(w, rejectReason(err))
+	case errors.Is(err, service.ErrInvalidUploadInput):
+		httputil.RespondError(w, http.StatusBadRequest, "invalid_upload_input")
+	default:
+		h.logger.Error(logMsg, zap.Error(err), zap.String("user_id", userID), zap.String("media_id", mediaID))
+		httputil.RespondError(w, http.StatusInternalServerError, failureCode)
+	}
+}
+
 func (h *Handler) CompleteMediaUpload(w http.ResponseWriter, r *http.Request) {
 	userID := authn.UserIDFromContext(r.Context())
 	if userID == "" {
@@ -130,6 +385,10 @@ func (h *Handler) CompleteMediaUpload(w http.ResponseWriter, r *http.Request) {
 			httputil.RespondError(w, http.StatusForbidden, "media_forbidden")
 		case errors.Is(err, service.ErrUploadedObjectNotFound):
 			httputil.RespondError(w, http.StatusNotFound, "uploaded_object_not_found")
+		case quotaErrorCode(err) != "":
+			httputil.RespondError(w, http.StatusForbidden, quotaErrorCode(err))
+		case rejectReason(err) != "":
+			respondMediaRejected(w, rejectReason(err))
 		case errors.Is(err, service.ErrInvalidUploadInput):
 			httputil.RespondError(w, http.StatusBadRequest, "invalid_uploaded_object")
 		default:
@@ -177,23 +436,33 @@ func (h *Handler) GetPlayback(w http.ResponseWriter, r *http.Request) {
 	}
 
 	httputil.RespondJSON(w, http.StatusOK, dto.PlaybackMediaResponse{
-		MediaID:     out.MediaID,
-		Status:      string(out.Status),
-		Manifest:    out.Manifest,
-		ManifestURL: out.ManifestURL,
-		PreviewURL:  out.PreviewURL,
-		ExpiresAt:   out.ExpiresAt.UTC().Format(httputil.TimeLayout),
+		MediaID:         out.MediaID,
+		Status:          string(out.Status),
+		Format:          string(out.Format),
+		ManifestType:    "hls",
+		Manifest:        out.Manifest,
+		ManifestURL:     out.ManifestURL,
+		DashManifestURL: out.DashManifestURL,
+		PreviewURL:      out.PreviewURL,
+		StoryboardURL:   out.StoryboardURL,
+		ExpiresAt:       out.ExpiresAt.UTC().Format(httputil.TimeLayout),
 	})
 }
 
+// GetPlaybackManifest serves everything below /media/playback/{token}/:
+// playlists are rendered here, other names are streamed as segments.
 func (h *Handler) GetPlaybackManifest(w http.ResponseWriter, r *http.Request) {
 	token := chi.URLParam(r, "token")
 	if token == "" {
 		httputil.RespondError(w, http.StatusBadRequest, "playback_token_required")
 		return
 	}
+	if !service.IsPlaybackPlaylist(chi.URLParam(r, "*")) {
+		h.streamPlaybackSegment(w, r, token)
+		return
+	}
 
-	manifest, err := h.media.ResolvePlaybackManifest(r.Context(), token)
+	manifest, err := h.media.ResolvePlaybackManifest(r.Context(), token, chi.URLParam(r, "*"))
 	if err != nil {
 		switch {
 		case errors.Is(err, service.ErrInvalidManifestKey), errors.Is(err, repository.ErrNotFound), errors.Is(err, service.ErrMediaNotReady):
@@ -205,12 +474,49 @@ func (h *Handler) GetPlaybackManifest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
-	w.Header().Set("Cache-Control", "private, no-store")
+	contentType := "application/vnd.apple.mpegurl"
+	switch name := chi.URLParam(r, "*"); {
+	case strings.HasSuffix(name, ".mpd"):
+		contentType = "application/dash+xml"
+	case strings.HasSuffix(name, ".vtt"):
+		contentType = "text/vtt"
+	}
+	w.Header().Set("Content-Type", contentType)
+	if h.media.ProxiesPlaybackSegments() {
+		// No presigned URLs inside, so the playlist is stable for its token.
+		w.Header().Set("Cache-Control", "private, max-age="+strconv.Itoa(manifestMaxAgeSec))
+	} else {
+		w.Header().Set("Cache-Control", "private, no-store")
+	}
 	w.WriteHeader(http.StatusOK)
 	_, _ = w.Write([]byte(manifest))
 }
 
+func (h *Handler) GetPlaybackKey(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+	if token == "" {
+		httputil.RespondError(w, http.StatusBadRequest, "playback_token_required")
+		return
+	}
+
+	key, err := h.media.ResolvePlaybackKey(r.Context(), token, chi.URLParam(r, "key"))
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidManifestKey), errors.Is(err, repository.ErrNotFound), errors.Is(err, service.ErrMediaNotReady):
+			httputil.RespondError(w, http.StatusNotFound, "media_playback_key_not_found")
+		default:
+			h.logger.Error("resolve media playback key", zap.Error(err), zap.String("token", token))
+			httputil.RespondError(w, http.StatusInternalServerError, "media_playback_failed")
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(key)
+}
+
 func (h *Handler) DeleteMedia(w http.ResponseWriter, r *http.Request) {
 	userID := authn.UserIDFromContext(r.Context())
 	if userID == "" {
@@ -0,0 +1,73 @@
+package files
+
+import (
+	"errors"
+	"net/http"
+
+	"calixio/internal/http/authn"
+	"calixio/internal/http/dto"
+	httputil "calixio/internal/http/httputil"
+	"calixio/internal/service"
+
+	"go.uber.org/zap"
+)
+
+// ImportMedia starts fetching a remote file; the response comes as soon as
+// the source has answered, and the media reports the transfer as progress.
+func (h *Handler) ImportMedia(w http.ResponseWriter, r *http.Request) {
+	userID := authn.UserIDFromContext(r.Context())
+	if userID == "" {
+		httputil.RespondError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var req dto.ImportMediaRequest
+	if err := httputil.DecodeJSON(r, &req); err != nil {
+		httputil.RespondError(w, http.StatusBadRequest, "invalid_json")
+		return
+	}
+	if err := httputil.ValidateStruct(req); err != nil {
+		httputil.RespondValidationError(w, err)
+		return
+	}
+
+	out, err := h.media.ImportFromURL(r.Context(), service.ImportMediaInput{
+		OwnerUserID: userID,
+		URL:         req.URL,
+		Format:      req.Format,
+		Encrypted:   req.Encrypted,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidImportURL):
+			httputil.RespondError(w, http.StatusBadRequest, "invalid_import_url")
+		case errors.Is(err, service.ErrImportHostNotAllowed):
+			httputil.RespondError(w, http.StatusForbidden, "import_host_not_allowed")
+		case errors.Is(err, service.ErrImportAddressForbidden):
+			httputil.RespondError(w, http.StatusForbidden, "import_address_forbidden")
+		case errors.Is(err, service.ErrImportLengthRequired):
+			httputil.RespondError(w, http.StatusUnprocessableEntity, "import_length_unknown")
+		case errors.Is(err, service.ErrImportTooLarge):
+			httputil.RespondError(w, http.StatusRequestEntityTooLarge, "upload_too_large")
+		case errors.Is(err, service.ErrImportMimeNotAllowed):
+			httputil.RespondError(w, http.StatusUnsupportedMediaType, "import_type_not_allowed")
+		case errors.Is(err, service.ErrImportSourceFailed):
+			h.logger.Warn("import source failed", zap.Error(err), zap.String("user_id", userID))
+			httputil.RespondError(w, http.StatusBadGateway, "import_source_unavailable")
+		case quotaErrorCode(err) != "":
+			httputil.RespondError(w, http.StatusForbidden, quotaErrorCode(err))
+		case errors.Is(err, service.ErrInvalidUploadInput):
+			httputil.RespondError(w, http.StatusBadRequest, "invalid_upload_input")
+		default:
+			h.logger.Error("import media", zap.Error(err), zap.String("user_id", userID))
+			httputil.RespondError(w, http.StatusInternalServerError, "media_import_failed")
+		}
+		return
+	}
+
+	httputil.RespondJSON(w, http.StatusAccepted, dto.ImportMediaResponse{
+		MediaID:   out.MediaID,
+		Status:    string(out.Status),
+		SizeBytes: out.SizeBytes,
+	})
+}
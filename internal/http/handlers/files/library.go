@@ -0,0 +1,143 @@
+package files
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"calixio/internal/http/authn"
+	"calixio/internal/http/dto"
+	httputil "calixio/internal/http/httputil"
+	"calixio/internal/repository"
+	"calixio/internal/service"
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+// ListMedia accepts the optional query parameters status, tag, collection,
+// q, sort, limit and cursor.
+func (h *Handler) ListMedia(w http.ResponseWriter, r *http.Request) {
+	userID := authn.UserIDFromContext(r.Context())
+	if userID == "" {
+		httputil.RespondError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	query := r.URL.Query()
+	limit := 0
+	if raw := query.Get("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil {
+			httputil.RespondError(w, http.StatusBadRequest, "invalid_media_list_query")
+			return
+		}
+		limit = parsed
+	}
+
+	page, err := h.media.ListMedia(r.Context(), service.ListMediaInput{
+		OwnerUserID:  userID,
+		Status:       query.Get("status"),
+		Tag:          query.Get("tag"),
+		CollectionID: query.Get("collection"),
+		Query:        query.Get("q"),
+		Sort:         query.Get("sort"),
+		Cursor:       query.Get("cursor"),
+		Limit:        limit,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidMediaListQuery):
+			httputil.RespondError(w, http.StatusBadRequest, "invalid_media_list_query")
+		case errors.Is(err, service.ErrInvalidMediaCursor):
+			httputil.RespondError(w, http.StatusBadRequest, "invalid_cursor")
+		case errors.Is(err, service.ErrInvalidUploadInput):
+			httputil.RespondError(w, http.StatusBadRequest, "invalid_upload_input")
+		default:
+			h.logger.Error("list media", zap.Error(err), zap.String("user_id", userID))
+			httputil.RespondError(w, http.StatusInternalServerError, "media_list_failed")
+		}
+		return
+	}
+
+	resp := dto.MediaListResponse{
+		Items: make([]dto.MediaListItemResponse, 0, len(page.Items)),
+	}
+	for _, item := range page.Items {
+		resp.Items = append(resp.Items, mediaListItemResponse(item))
+	}
+	if page.NextCursor != "" {
+		resp.NextCursor = &page.NextCursor
+	}
+
+	httputil.RespondJSON(w, http.StatusOK, resp)
+}
+
+func (h *Handler) UpdateMedia(w http.ResponseWriter, r *http.Request) {
+	userID := authn.UserIDFromContext(r.Context())
+	if userID == "" {
+		httputil.RespondError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var req dto.UpdateMediaRequest
+	if err := httputil.DecodeJSON(r, &req); err != nil {
+		httputil.RespondError(w, http.StatusBadRequest, "invalid_json")
+		return
+	}
+	if err := httputil.ValidateStruct(req); err != nil {
+		httputil.RespondValidationError(w, err)
+		return
+	}
+
+	mediaID := chi.URLParam(r, "id")
+	item, err := h.media.UpdateMedia(r.Context(), service.UpdateMediaInput{
+		OwnerUserID:   userID,
+		MediaID:       mediaID,
+		Title:         req.Title,
+		Description:   req.Description,
+		Tags:          req.Tags,
+		CollectionIDs: req.CollectionIDs,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrNotFound):
+			httputil.RespondError(w, http.StatusNotFound, "media_not_found")
+		case errors.Is(err, service.ErrForbiddenMedia):
+			httputil.RespondError(w, http.StatusForbidden, "media_forbidden")
+		case errors.Is(err, service.ErrCollectionNotFound):
+			httputil.RespondError(w, http.StatusUnprocessableEntity, "collection_not_found")
+		case errors.Is(err, service.ErrInvalidMediaDetails):
+			httputil.RespondError(w, http.StatusBadRequest, "invalid_media_details")
+		case errors.Is(err, service.ErrInvalidUploadInput):
+			httputil.RespondError(w, http.StatusBadRequest, "invalid_media_request")
+		default:
+			h.logger.Error("update media", zap.Error(err), zap.String("user_id", userID), zap.String("media_id", mediaID))
+			httputil.RespondError(w, http.StatusInternalServerError, "media_update_failed")
+		}
+		return
+	}
+
+	httputil.RespondJSON(w, http.StatusOK, mediaListItemResponse(item))
+}
+
+func (h *Handler) ListTags(w http.ResponseWriter, r *http.Request) {
+	userID := authn.UserIDFromContext(r.Context())
+	if userID == "" {
+		httputil.RespondError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	tags, err := h.media.ListTags(r.Context(), userID)
+	if err != nil {
+		h.logger.Error("list tags", zap.Error(err), zap.String("user_id", userID))
+		httputil.RespondError(w, http.StatusInternalServerError, "tag_list_failed")
+		return
+	}
+
+	resp := make([]dto.TagResponse, 0, len(tags))
+	for _, tag := range tags {
+		resp = append(resp, dto.TagResponse{Name: tag.Name, MediaCount: tag.MediaCount})
+	}
+
+	httputil.RespondJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,90 @@
+package files
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	httputil "calixio/internal/http/httputil"
+	"calixio/internal/repository"
+	"calixio/internal/service"
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+const (
+	// manifestMaxAgeSec keeps proxied playlists short-lived in caches so
+	// newly added subtitle tracks show up quickly.
+	manifestMaxAgeSec = 60
+	// segmentStreamTimeout matches the route timeout of
+	// /media/playback/{token}/*: a large segment on a slow connection takes
+	// far longer than the server-wide write timeout.
+	segmentStreamTimeout = 10 * time.Minute
+)
+
+func (h *Handler) streamPlaybackSegment(w http.ResponseWriter, r *http.Request, token string) {
+	segment, err := h.media.OpenPlaybackSegment(r.Context(), token, chi.URLParam(r, "*"), singleByteRange(r.Header.Get("Range")))
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidRange):
+			httputil.RespondError(w, http.StatusRequestedRangeNotSatisfiable, "media_playback_invalid_range")
+		case errors.Is(err, service.ErrInvalidManifestKey), errors.Is(err, repository.ErrNotFound), errors.Is(err, service.ErrMediaNotReady):
+			httputil.RespondError(w, http.StatusNotFound, "media_playback_not_found")
+		default:
+			h.logger.Error("open media playback segment", zap.Error(err), zap.String("token", token))
+			httputil.RespondError(w, http.StatusInternalServerError, "media_playback_failed")
+		}
+		return
+	}
+	defer segment.Body.Close()
+
+	header := w.Header()
+	header.Set("Accept-Ranges", "bytes")
+	header.Set("Cache-Control", "private, max-age="+strconv.Itoa(int(segment.MaxAge.Seconds())))
+	if segment.ETag != "" {
+		header.Set("ETag", segment.ETag)
+		if match := r.Header.Get("If-None-Match"); match != "" && etagMatches(match, segment.ETag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	if !segment.LastModified.IsZero() {
+		header.Set("Last-Modified", segment.LastModified.UTC().Format(http.TimeFormat))
+	}
+	header.Set("Content-Type", segment.ContentType)
+	header.Set("Content-Length", strconv.FormatInt(segment.ContentLength, 10))
+
+	status := http.StatusOK
+	if segment.ContentRange != "" {
+		header.Set("Content-Range", segment.ContentRange)
+		status = http.StatusPartialContent
+	}
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(segmentStreamTimeout))
+	w.WriteHeader(status)
+	if _, err := io.Copy(w, segment.Body); err != nil {
+		h.logger.Debug("stream media playback segment", zap.Error(err), zap.String("token", token))
+	}
+}
+
+// singleByteRange passes a single "bytes=" range through to storage. Multi
+// ranges are ignored, which RFC 9110 allows, and the whole object is sent.
+func singleByteRange(raw string) string {
+	raw = strings.TrimSpace(raw)
+	if !strings.HasPrefix(raw, "bytes=") || strings.Contains(raw, ",") {
+		return ""
+	}
+	return raw
+}
+
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,118 @@
+package files
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"calixio/internal/repository"
+	"calixio/internal/service"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-chi/chi/v5"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+type segmentTestMediaRepo struct {
+	repository.MediaRepository
+	media repository.Media
+}
+
+func (r *segmentTestMediaRepo) GetByID(_ context.Context, id string) (repository.Media, error) {
+	if id != r.media.ID {
+		return repository.Media{}, repository.ErrNotFound
+	}
+	return r.media, nil
+}
+
+type segmentTestStorage struct {
+	service.Storage
+	body func() io.Reader
+	size int64
+}
+
+func (s *segmentTestStorage) OpenObject(context.Context, string, string) (service.ObjectStream, error) {
+	return service.ObjectStream{
+		ObjectHead: service.ObjectHead{ContentLength: s.size},
+		Body:       io.NopCloser(s.body()),
+	}, nil
+}
+
+// slowReader hands out at most chunkSize bytes per tick.
+type slowReader struct {
+	remaining int
+	chunkSize int
+	tick      time.Duration
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	if r.remaining == 0 {
+		return 0, io.EOF
+	}
+	time.Sleep(r.tick)
+	n := min(len(p), r.chunkSize, r.remaining)
+	r.remaining -= n
+	return n, nil
+}
+
+func TestStreamPlaybackSegmentOutlivesServerWriteTimeout(t *testing.T) {
+	const (
+		token        = "0123456789abcdef0123456789abcdef"
+		chunks       = 8
+		chunkSize    = 1024
+		writeTimeout = 100 * time.Millisecond
+		tick         = 50 * time.Millisecond
+	)
+
+	cache := miniredis.RunT(t)
+	cache.Set("media:playback:manifest:v1:"+token, "media-1")
+	cache.SetTTL("media:playback:manifest:v1:"+token, time.Hour)
+
+	media := repository.Media{ID: "media-1", OwnerUserID: "user-1", Status: repository.MediaReady}
+	mediaSvc := service.NewMediaUploadService(service.NewMediaUploadServiceInput{
+		MediaRepo: &segmentTestMediaRepo{media: media},
+		Storage: &segmentTestStorage{
+			size: chunks * chunkSize,
+			body: func() io.Reader {
+				return &slowReader{remaining: chunks * chunkSize, chunkSize: chunkSize, tick: tick}
+			},
+		},
+		Cache:         redis.NewClient(&redis.Options{Addr: cache.Addr()}),
+		ProxySegments: true,
+	})
+	h := NewHandler(mediaSvc, nil, zap.NewNop())
+
+	router := chi.NewRouter()
+	router.Get("/media/playback/{token}/*", h.GetPlaybackManifest)
+	srv := httptest.NewUnstartedServer(router)
+	srv.Config.WriteTimeout = writeTimeout
+	srv.Start()
+	defer srv.Close()
+
+	startedAt := time.Now()
+	resp, err := http.Get(srv.URL + "/media/playback/" + token + "/720p/segment_000.ts")
+	if err != nil {
+		t.Fatalf("GET segment: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("segment body cut off after %d bytes: %v", len(body), err)
+	}
+	if len(body) != chunks*chunkSize {
+		t.Fatalf("got %d bytes, want %d", len(body), chunks*chunkSize)
+	}
+	if elapsed := time.Since(startedAt); elapsed <= writeTimeout {
+		t.Fatalf("segment took %v, the test needs it to outlast the %v write timeout", elapsed, writeTimeout)
+	}
+	if got := resp.Header.Get("Content-Type"); got != "video/mp2t" {
+		t.Fatalf("content type = %q, want video/mp2t", got)
+	}
+}
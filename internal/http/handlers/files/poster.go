@@ -0,0 +1,115 @@
+package files
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"calixio/internal/http/authn"
+	"calixio/internal/http/dto"
+	httputil "calixio/internal/http/httputil"
+	"calixio/internal/repository"
+	"calixio/internal/service"
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+// posterCaptureTimeout matches the route timeout of POST /media/{id}/poster.
+const posterCaptureTimeout = 5 * time.Minute
+
+func (h *Handler) SetMediaPoster(w http.ResponseWriter, r *http.Request) {
+	userID := authn.UserIDFromContext(r.Context())
+	if userID == "" {
+		httputil.RespondError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var req dto.SetMediaPosterRequest
+	if err := httputil.DecodeJSON(r, &req); err != nil {
+		httputil.RespondError(w, http.StatusBadRequest, "invalid_json")
+		return
+	}
+	if err := httputil.ValidateStruct(req); err != nil {
+		httputil.RespondValidationError(w, err)
+		return
+	}
+
+	// ffmpeg seeks in the original over the network, which can outlive the
+	// server-wide timeouts on large remote files.
+	rc := http.NewResponseController(w)
+	_ = rc.SetReadDeadline(time.Now().Add(posterCaptureTimeout))
+	_ = rc.SetWriteDeadline(time.Now().Add(posterCaptureTimeout))
+
+	mediaID := chi.URLParam(r, "id")
+	out, err := h.media.SetPosterAtTimestamp(r.Context(), userID, mediaID, *req.TimestampSec)
+	if err != nil {
+		h.respondPosterError(w, err, "set media poster", userID, mediaID)
+		return
+	}
+
+	httputil.RespondJSON(w, http.StatusOK, dto.MediaPosterResponse{
+		MediaID:    out.MediaID,
+		PreviewURL: out.PreviewURL,
+	})
+}
+
+func (h *Handler) UploadMediaPoster(w http.ResponseWriter, r *http.Request) {
+	userID := authn.UserIDFromContext(r.Context())
+	if userID == "" {
+		httputil.RespondError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	contentType := strings.ToLower(strings.TrimSpace(strings.Split(r.Header.Get("Content-Type"), ";")[0]))
+	if contentType != "image/jpeg" && contentType != "image/png" {
+		httputil.RespondError(w, http.StatusUnsupportedMediaType, "invalid_poster_content_type")
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, service.MaxPosterImageBytes))
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			httputil.RespondError(w, http.StatusRequestEntityTooLarge, "poster_too_large")
+			return
+		}
+		httputil.RespondError(w, http.StatusBadRequest, "invalid_poster_image")
+		return
+	}
+
+	mediaID := chi.URLParam(r, "id")
+	out, err := h.media.UploadPosterImage(r.Context(), userID, mediaID, data)
+	if err != nil {
+		h.respondPosterError(w, err, "upload media poster", userID, mediaID)
+		return
+	}
+
+	httputil.RespondJSON(w, http.StatusOK, dto.MediaPosterResponse{
+		MediaID:    out.MediaID,
+		PreviewURL: out.PreviewURL,
+	})
+}
+
+func (h *Handler) respondPosterError(w http.ResponseWriter, err error, logMsg, userID, mediaID string) {
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		httputil.RespondError(w, http.StatusNotFound, "media_not_found")
+	case errors.Is(err, service.ErrForbiddenMedia):
+		httputil.RespondError(w, http.StatusForbidden, "media_forbidden")
+	case errors.Is(err, service.ErrMediaNotReady):
+		httputil.RespondError(w, http.StatusConflict, "media_not_ready")
+	case errors.Is(err, service.ErrInvalidPosterTimestamp):
+		httputil.RespondError(w, http.StatusBadRequest, "invalid_poster_timestamp")
+	case errors.Is(err, service.ErrInvalidPosterImage):
+		httputil.RespondError(w, http.StatusBadRequest, "invalid_poster_image")
+	case errors.Is(err, service.ErrInvalidUploadInput):
+		httputil.RespondError(w, http.StatusBadRequest, "invalid_media_request")
+	case errors.Is(err, service.ErrPosterCaptureUnavailable):
+		httputil.RespondError(w, http.StatusServiceUnavailable, "poster_capture_unavailable")
+	default:
+		h.logger.Error(logMsg, zap.Error(err), zap.String("user_id", userID), zap.String("media_id", mediaID))
+		httputil.RespondError(w, http.StatusInternalServerError, "media_poster_failed")
+	}
+}
@@ -0,0 +1,26 @@
+package files
+
+import (
+	"errors"
+	"net/http"
+
+	httputil "calixio/internal/http/httputil"
+	"calixio/internal/service"
+)
+
+// rejectReason returns the reason of a failed content check, or "" for any
+// other error.
+func rejectReason(err error) string {
+	var rejected *service.MediaRejectedError
+	if errors.As(err, &rejected) {
+		return rejected.Reason
+	}
+	return ""
+}
+
+func respondMediaRejected(w http.ResponseWriter, reason string) {
+	httputil.RespondJSON(w, http.StatusUnprocessableEntity, map[string]string{
+		"error":  "media_rejected",
+		"reason": reason,
+	})
+}
@@ -0,0 +1,150 @@
+package files
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"calixio/internal/http/authn"
+	"calixio/internal/http/dto"
+	httputil "calixio/internal/http/httputil"
+	"calixio/internal/repository"
+	"calixio/internal/service"
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+// subtitleFormOverhead leaves room for the non-file multipart fields.
+const subtitleFormOverhead = 64 << 10
+
+// AddMediaSubtitle accepts multipart/form-data with a "file" part and
+// "language", "label", optional "format" and "default" fields.
+func (h *Handler) AddMediaSubtitle(w http.ResponseWriter, r *http.Request) {
+	userID := authn.UserIDFromContext(r.Context())
+	if userID == "" {
+		httputil.RespondError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, service.MaxSubtitleFileBytes+subtitleFormOverhead)
+	if err := r.ParseMultipartForm(service.MaxSubtitleFileBytes + subtitleFormOverhead); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			httputil.RespondError(w, http.StatusRequestEntityTooLarge, "subtitle_too_large")
+			return
+		}
+		httputil.RespondError(w, http.StatusBadRequest, "invalid_subtitle_form")
+		return
+	}
+	defer func() { _ = r.MultipartForm.RemoveAll() }()
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		httputil.RespondError(w, http.StatusBadRequest, "subtitle_file_required")
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		httputil.RespondError(w, http.StatusBadRequest, "invalid_subtitle_form")
+		return
+	}
+
+	isDefault := false
+	if raw := r.FormValue("default"); raw != "" {
+		isDefault, err = strconv.ParseBool(raw)
+		if err != nil {
+			httputil.RespondError(w, http.StatusBadRequest, "invalid_subtitle_form")
+			return
+		}
+	}
+
+	mediaID := chi.URLParam(r, "id")
+	subtitle, err := h.media.AddSubtitle(r.Context(), service.AddSubtitleInput{
+		OwnerUserID: userID,
+		MediaID:     mediaID,
+		Language:    r.FormValue("language"),
+		Label:       r.FormValue("label"),
+		Format:      r.FormValue("format"),
+		FileName:    header.Filename,
+		IsDefault:   isDefault,
+		Data:        data,
+	})
+	if err != nil {
+		h.respondSubtitleError(w, err, "add media subtitle", userID, mediaID)
+		return
+	}
+
+	httputil.RespondJSON(w, http.StatusCreated, mediaSubtitleResponse(subtitle))
+}
+
+func (h *Handler) ListMediaSubtitles(w http.ResponseWriter, r *http.Request) {
+	userID := authn.UserIDFromContext(r.Context())
+	if userID == "" {
+		httputil.RespondError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	mediaID := chi.URLParam(r, "id")
+	subtitles, err := h.media.ListSubtitles(r.Context(), userID, mediaID)
+	if err != nil {
+		h.respondSubtitleError(w, err, "list media subtitles", userID, mediaID)
+		return
+	}
+
+	resp := make([]dto.MediaSubtitleResponse, 0, len(subtitles))
+	for _, subtitle := range subtitles {
+		resp = append(resp, mediaSubtitleResponse(subtitle))
+	}
+	httputil.RespondJSON(w, http.StatusOK, resp)
+}
+
+func (h *Handler) DeleteMediaSubtitle(w http.ResponseWriter, r *http.Request) {
+	userID := authn.UserIDFromContext(r.Context())
+	if userID == "" {
+		httputil.RespondError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	mediaID := chi.URLParam(r, "id")
+	if err := h.media.DeleteSubtitle(r.Context(), userID, mediaID, chi.URLParam(r, "subtitleId")); err != nil {
+		h.respondSubtitleError(w, err, "delete media subtitle", userID, mediaID)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) respondSubtitleError(w http.ResponseWriter, err error, logMsg, userID, mediaID string) {
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		httputil.RespondError(w, http.StatusNotFound, "subtitle_not_found")
+	case errors.Is(err, service.ErrForbiddenMedia):
+		httputil.RespondError(w, http.StatusForbidden, "media_forbidden")
+	case errors.Is(err, service.ErrMediaNotReady):
+		httputil.RespondError(w, http.StatusConflict, "media_not_ready")
+	case errors.Is(err, service.ErrSubtitleExists):
+		httputil.RespondError(w, http.StatusConflict, "subtitle_label_taken")
+	case errors.Is(err, service.ErrInvalidSubtitle):
+		httputil.RespondError(w, http.StatusBadRequest, "invalid_subtitle")
+	case errors.Is(err, service.ErrInvalidUploadInput):
+		httputil.RespondError(w, http.StatusBadRequest, "invalid_media_request")
+	case errors.Is(err, service.ErrSubtitlesUnavailable):
+		httputil.RespondError(w, http.StatusServiceUnavailable, "subtitles_unavailable")
+	default:
+		h.logger.Error(logMsg, zap.Error(err), zap.String("user_id", userID), zap.String("media_id", mediaID))
+		httputil.RespondError(w, http.StatusInternalServerError, "media_subtitle_failed")
+	}
+}
+
+func mediaSubtitleResponse(subtitle repository.MediaSubtitle) dto.MediaSubtitleResponse {
+	return dto.MediaSubtitleResponse{
+		ID:        subtitle.ID,
+		Language:  subtitle.Language,
+		Label:     subtitle.Label,
+		Source:    string(subtitle.Source),
+		IsDefault: subtitle.IsDefault,
+		CreatedAt: subtitle.CreatedAt.Format(httputil.TimeLayout),
+	}
+}
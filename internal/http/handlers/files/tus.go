@@ -0,0 +1,266 @@
+package files
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"calixio/internal/http/authn"
+	httputil "calixio/internal/http/httputil"
+	"calixio/internal/repository"
+	"calixio/internal/service"
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+const (
+	tusVersion         = "1.0.0"
+	tusExtensions      = "creation,termination"
+	tusUploadsBasePath = "/media/tus"
+	tusChunkTimeout    = 10 * time.Minute
+)
+
+func (h *Handler) TusOptions(w http.ResponseWriter, r *http.Request) {
+	setTusHeaders(w)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(h.media.MaxUploadBytes(), 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) TusCreateUpload(w http.ResponseWriter, r *http.Request) {
+	userID := authn.UserIDFromContext(r.Context())
+	if userID == "" {
+		httputil.RespondError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	if !checkTusResumable(w, r) {
+		return
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		setTusHeaders(w)
+		httputil.RespondError(w, http.StatusBadRequest, "invalid_upload_length")
+		return
+	}
+	if length > h.media.MaxUploadBytes() {
+		setTusHeaders(w)
+		httputil.RespondError(w, http.StatusRequestEntityTooLarge, "upload_too_large")
+		return
+	}
+
+	metadata, err := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		setTusHeaders(w)
+		httputil.RespondError(w, http.StatusBadRequest, "invalid_upload_metadata")
+		return
+	}
+
+	info, err := h.media.CreateTusUpload(r.Context(), service.InitUploadInput{
+		OwnerUserID:   userID,
+		FileName:      metadata["filename"],
+		ContentType:   metadata["filetype"],
+		SizeBytes:     length,
+		Format:        metadata["format"],
+		Encrypted:     metadata["encrypted"] == "true",
+		ContentSHA256: metadata["sha256"],
+	})
+	if err != nil {
+		setTusHeaders(w)
+		switch {
+		case quotaErrorCode(err) != "":
+			httputil.RespondError(w, http.StatusForbidden, quotaErrorCode(err))
+		case errors.Is(err, service.ErrInvalidUploadInput):
+			httputil.RespondError(w, http.StatusBadRequest, "invalid_upload_input")
+		default:
+			h.logger.Error("create tus upload", zap.Error(err), zap.String("user_id", userID))
+			httputil.RespondError(w, http.StatusInternalServerError, "media_upload_init_failed")
+		}
+		return
+	}
+
+	setTusHeaders(w)
+	w.Header().Set("Location", path.Join(tusUploadsBasePath, info.MediaID))
+	w.Header().Set("Upload-Offset", "0")
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (h *Handler) TusGetUpload(w http.ResponseWriter, r *http.Request) {
+	userID := authn.UserIDFromContext(r.Context())
+	if userID == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if !checkTusResumable(w, r) {
+		return
+	}
+
+	mediaID := chi.URLParam(r, "id")
+	info, err := h.media.GetTusUpload(r.Context(), userID, mediaID)
+	setTusHeaders(w)
+	w.Header().Set("Cache-Control", "no-store")
+	if err != nil {
+		w.WriteHeader(h.tusErrorStatus(err, "get tus upload", userID, mediaID))
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(info.OffsetBytes, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(info.LengthBytes, 10))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) TusPatchUpload(w http.ResponseWriter, r *http.Request) {
+	userID := authn.UserIDFromContext(r.Context())
+	if userID == "" {
+		httputil.RespondError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	if !checkTusResumable(w, r) {
+		return
+	}
+
+	setTusHeaders(w)
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		httputil.RespondError(w, http.StatusUnsupportedMediaType, "invalid_content_type")
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		httputil.RespondError(w, http.StatusBadRequest, "invalid_upload_offset")
+		return
+	}
+
+	// Chunks from slow mobile links easily outlive the server-wide timeouts.
+	rc := http.NewResponseController(w)
+	_ = rc.SetReadDeadline(time.Now().Add(tusChunkTimeout))
+	_ = rc.SetWriteDeadline(time.Now().Add(tusChunkTimeout))
+
+	mediaID := chi.URLParam(r, "id")
+	info, err := h.media.WriteTusChunk(r.Context(), service.WriteTusChunkInput{
+		OwnerUserID: userID,
+		MediaID:     mediaID,
+		OffsetBytes: offset,
+		Body:        r.Body,
+	})
+	if code := quotaErrorCode(err); code != "" {
+		httputil.RespondError(w, http.StatusForbidden, code)
+		return
+	}
+	if reason := rejectReason(err); reason != "" {
+		respondMediaRejected(w, reason)
+		return
+	}
+	if err != nil && info.MediaID == "" {
+		status := h.tusErrorStatus(err, "patch tus upload", userID, mediaID)
+		httputil.RespondError(w, status, tusErrorCode(status))
+		return
+	}
+	if err != nil {
+		h.logger.Warn("tus chunk interrupted",
+			zap.Error(err),
+			zap.String("user_id", userID),
+			zap.String("media_id", mediaID),
+			zap.Int64("offset", info.OffsetBytes),
+		)
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(info.OffsetBytes, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) TusTerminateUpload(w http.ResponseWriter, r *http.Request) {
+	userID := authn.UserIDFromContext(r.Context())
+	if userID == "" {
+		httputil.RespondError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	if !checkTusResumable(w, r) {
+		return
+	}
+
+	setTusHeaders(w)
+	mediaID := chi.URLParam(r, "id")
+	if err := h.media.TerminateTusUpload(r.Context(), userID, mediaID); err != nil {
+		status := h.tusErrorStatus(err, "terminate tus upload", userID, mediaID)
+		httputil.RespondError(w, status, tusErrorCode(status))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) tusErrorStatus(err error, logMsg, userID, mediaID string) int {
+	switch {
+	case errors.Is(err, repository.ErrNotFound), errors.Is(err, service.ErrMultipartUploadNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrForbiddenMedia):
+		return http.StatusForbidden
+	case errors.Is(err, service.ErrTusOffsetMismatch):
+		return http.StatusConflict
+	case errors.Is(err, service.ErrInvalidUploadInput), errors.Is(err, service.ErrInvalidMultipartParts):
+		return http.StatusBadRequest
+	case errors.Is(err, service.ErrUploadedObjectNotFound):
+		return http.StatusNotFound
+	default:
+		h.logger.Error(logMsg, zap.Error(err), zap.String("user_id", userID), zap.String("media_id", mediaID))
+		return http.StatusInternalServerError
+	}
+}
+
+func tusErrorCode(status int) string {
+	switch status {
+	case http.StatusNotFound:
+		return "tus_upload_not_found"
+	case http.StatusForbidden:
+		return "media_forbidden"
+	case http.StatusConflict:
+		return "upload_offset_mismatch"
+	case http.StatusBadRequest:
+		return "invalid_upload_input"
+	default:
+		return "tus_upload_failed"
+	}
+}
+
+func checkTusResumable(w http.ResponseWriter, r *http.Request) bool {
+	if r.Header.Get("Tus-Resumable") == tusVersion {
+		return true
+	}
+	setTusHeaders(w)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.WriteHeader(http.StatusPreconditionFailed)
+	return false
+}
+
+func setTusHeaders(w http.ResponseWriter) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+}
+
+// parseTusMetadata decodes the Upload-Metadata header: comma separated
+// "key base64value" pairs where the value may be omitted.
+func parseTusMetadata(header string) (map[string]string, error) {
+	out := map[string]string{}
+	if strings.TrimSpace(header) == "" {
+		return out, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+		switch len(fields) {
+		case 1:
+			out[fields[0]] = ""
+		case 2:
+			val, err := base64.StdEncoding.DecodeString(fields[1])
+			if err != nil {
+				return nil, err
+			}
+			out[fields[0]] = string(val)
+		default:
+			return nil, errors.New("invalid metadata pair")
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,52 @@
+package files
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTusMetadata(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		want    map[string]string
+		wantErr bool
+	}{
+		{name: "empty", header: "", want: map[string]string{}},
+		{name: "blank", header: "   ", want: map[string]string{}},
+		{
+			name:   "pairs",
+			header: "filename bW92aWUubXA0,filetype dmlkZW8vbXA0",
+			want:   map[string]string{"filename": "movie.mp4", "filetype": "video/mp4"},
+		},
+		{
+			name:   "spaces around pairs",
+			header: " filename bW92aWUubXA0 , filetype dmlkZW8vbXA0 ",
+			want:   map[string]string{"filename": "movie.mp4", "filetype": "video/mp4"},
+		},
+		{
+			name:   "key without value",
+			header: "encrypted,filename bW92aWUubXA0",
+			want:   map[string]string{"encrypted": "", "filename": "movie.mp4"},
+		},
+		{name: "invalid base64", header: "filename !!!", wantErr: true},
+		{name: "too many fields", header: "filename bW92aWUubXA0 extra", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTusMetadata(tt.header)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseTusMetadata(%q) = %v, want error", tt.header, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("parseTusMetadata(%q) = %v, want %v", tt.header, got, tt.want)
+			}
+		})
+	}
+}
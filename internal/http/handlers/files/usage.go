@@ -0,0 +1,59 @@
+package files
+
+import (
+	"errors"
+	"net/http"
+
+	"calixio/internal/http/authn"
+	"calixio/internal/http/dto"
+	httputil "calixio/internal/http/httputil"
+	"calixio/internal/repository"
+	"calixio/internal/service"
+
+	"go.uber.org/zap"
+)
+
+func (h *Handler) GetUsage(w http.ResponseWriter, r *http.Request) {
+	userID := authn.UserIDFromContext(r.Context())
+	if userID == "" {
+		httputil.RespondError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	report, err := h.usage.GetUsage(r.Context(), userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrNotFound):
+			httputil.RespondError(w, http.StatusNotFound, "user_not_found")
+		default:
+			h.logger.Error("get usage", zap.Error(err), zap.String("user_id", userID))
+			httputil.RespondError(w, http.StatusInternalServerError, "usage_get_failed")
+		}
+		return
+	}
+
+	httputil.RespondJSON(w, http.StatusOK, dto.UsageResponse{
+		Plan:            report.Quota.Plan,
+		StorageBytes:    report.Usage.StorageBytes,
+		MediaCount:      report.Usage.MediaCount,
+		DurationSec:     report.Usage.DurationSec,
+		MaxStorageBytes: report.Quota.MaxStorageBytes,
+		MaxMediaCount:   report.Quota.MaxMediaCount,
+		MaxDurationSec:  report.Quota.MaxDurationSec,
+	})
+}
+
+// quotaErrorCode returns the response code of a quota error, or "" for any
+// other error.
+func quotaErrorCode(err error) string {
+	switch {
+	case errors.Is(err, service.ErrStorageQuotaExceeded):
+		return "storage_quota_exceeded"
+	case errors.Is(err, service.ErrMediaCountQuotaExceeded):
+		return "media_count_quota_exceeded"
+	case errors.Is(err, service.ErrDurationQuotaExceeded):
+		return "duration_quota_exceeded"
+	default:
+		return ""
+	}
+}
@@ -222,12 +222,16 @@ func (h *Handler) buildRoomStateResponse(ctx context.Context, room repository.Ro
 		return resp
 	}
 	resp.Playback = &dto.PlaybackMediaResponse{
-		MediaID:     playback.MediaID,
-		Status:      string(playback.Status),
-		Manifest:    playback.Manifest,
-		ManifestURL: playback.ManifestURL,
-		PreviewURL:  playback.PreviewURL,
-		ExpiresAt:   playback.ExpiresAt.UTC().Format(httputil.TimeLayout),
+		MediaID:         playback.MediaID,
+		Status:          string(playback.Status),
+		Format:          string(playback.Format),
+		ManifestType:    "hls",
+		Manifest:        playback.Manifest,
+		ManifestURL:     playback.ManifestURL,
+		DashManifestURL: playback.DashManifestURL,
+		PreviewURL:      playback.PreviewURL,
+		StoryboardURL:   playback.StoryboardURL,
+		ExpiresAt:       playback.ExpiresAt.UTC().Format(httputil.TimeLayout),
 	}
 
 	return resp
@@ -255,14 +259,16 @@ func (h *Handler) GetRoomPlaybackState(w http.ResponseWriter, r *http.Request) {
 	}
 
 	httputil.RespondJSON(w, http.StatusOK, dto.RoomPlaybackStateResponse{
-		RoomID:       state.RoomID,
-		MediaID:      state.MediaID,
-		Status:       string(state.Status),
-		PositionMs:   state.PositionMs,
-		PlaybackRate: state.PlaybackRate,
-		UpdatedAt:    state.UpdatedAt,
-		Version:      state.Version,
-		HostID:       state.HostID,
+		RoomID:        state.RoomID,
+		MediaID:       state.MediaID,
+		Status:        string(state.Status),
+		PositionMs:    state.PositionMs,
+		PlaybackRate:  state.PlaybackRate,
+		UpdatedAt:     state.UpdatedAt,
+		Version:       state.Version,
+		HostID:        state.HostID,
+		AudioTrack:    state.AudioTrack,
+		SubtitleTrack: state.SubtitleTrack,
 	})
 }
 
@@ -289,10 +295,12 @@ func (h *Handler) UpdateRoomPlaybackState(w http.ResponseWriter, r *http.Request
 	}
 
 	state, err := h.playback.SaveByHost(r.Context(), roomID, userID, service.UpdateRoomPlaybackInput{
-		MediaID:      req.MediaID,
-		Status:       service.PlaybackStatus(req.Status),
-		PositionMs:   req.PositionMs,
-		PlaybackRate: req.PlaybackRate,
+		MediaID:       req.MediaID,
+		Status:        service.PlaybackStatus(req.Status),
+		PositionMs:    req.PositionMs,
+		PlaybackRate:  req.PlaybackRate,
+		AudioTrack:    req.AudioTrack,
+		SubtitleTrack: req.SubtitleTrack,
 	})
 	if err != nil {
 		switch {
@@ -310,14 +318,16 @@ func (h *Handler) UpdateRoomPlaybackState(w http.ResponseWriter, r *http.Request
 	}
 
 	httputil.RespondJSON(w, http.StatusOK, dto.RoomPlaybackStateResponse{
-		RoomID:       state.RoomID,
-		MediaID:      state.MediaID,
-		Status:       string(state.Status),
-		PositionMs:   state.PositionMs,
-		PlaybackRate: state.PlaybackRate,
-		UpdatedAt:    state.UpdatedAt,
-		Version:      state.Version,
-		HostID:       state.HostID,
+		RoomID:        state.RoomID,
+		MediaID:       state.MediaID,
+		Status:        string(state.Status),
+		PositionMs:    state.PositionMs,
+		PlaybackRate:  state.PlaybackRate,
+		UpdatedAt:     state.UpdatedAt,
+		Version:       state.Version,
+		HostID:        state.HostID,
+		AudioTrack:    state.AudioTrack,
+		SubtitleTrack: state.SubtitleTrack,
 	})
 }
 
@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	httputil "calixio/internal/http/httputil"
+	"calixio/internal/repository"
+	"calixio/internal/service"
+
+	"go.uber.org/zap"
+)
+
+// transferTimeout matches the route timeout of /storage/*: whole originals
+// pass through here, far beyond the server-wide read and write timeouts.
+const transferTimeout = 15 * time.Minute
+
+// Handler serves the local storage backend: the presigned URLs it hands out
+// point here instead of at S3.
+type Handler struct {
+	storage        *service.LocalStorage
+	maxUploadBytes int64
+	logger         *zap.Logger
+}
+
+func NewHandler(storage *service.LocalStorage, maxUploadBytes int64, logger *zap.Logger) *Handler {
+	return &Handler{storage: storage, maxUploadBytes: maxUploadBytes, logger: logger}
+}
+
+// GetObject serves presigned GETs and unsigned reads of public outputs.
+// Range and conditional requests are handled by http.ServeContent.
+func (h *Handler) GetObject(w http.ResponseWriter, r *http.Request) {
+	key, ok := objectKey(r)
+	if !ok {
+		httputil.RespondError(w, http.StatusBadRequest, "storage_key_invalid")
+		return
+	}
+	query := r.URL.Query()
+	if query.Get("sig") != "" || !h.storage.PublicReadable(key) {
+		if err := h.storage.VerifyRequest(http.MethodGet, key, query, ""); err != nil {
+			httputil.RespondError(w, http.StatusForbidden, "storage_signature_invalid")
+			return
+		}
+	}
+
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(transferTimeout))
+
+	file, head, err := h.storage.OpenFile(key)
+	if err != nil {
+		h.respondError(w, err, "open local storage object", key)
+		return
+	}
+	defer file.Close()
+
+	if head.ContentType != "" {
+		w.Header().Set("Content-Type", head.ContentType)
+	}
+	w.Header().Set("ETag", head.ETag)
+	http.ServeContent(w, r, key, head.LastModified, file)
+}
+
+// PutObject accepts presigned single-object uploads and, with uploadId and
+// partNumber in the query, multipart parts. The ETag header carries the
+// value clients pass back when completing a multipart upload.
+func (h *Handler) PutObject(w http.ResponseWriter, r *http.Request) {
+	key, ok := objectKey(r)
+	if !ok {
+		httputil.RespondError(w, http.StatusBadRequest, "storage_key_invalid")
+		return
+	}
+	query := r.URL.Query()
+	if err := h.storage.VerifyRequest(http.MethodPut, key, query, r.Header.Get("Content-Type")); err != nil {
+		httputil.RespondError(w, http.StatusForbidden, "storage_signature_invalid")
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	_ = rc.SetReadDeadline(time.Now().Add(transferTimeout))
+	_ = rc.SetWriteDeadline(time.Now().Add(transferTimeout))
+
+	body := http.MaxBytesReader(w, r.Body, h.maxUploadBytes)
+	var (
+		etag string
+		err  error
+	)
+	if uploadID := query.Get("uploadId"); uploadID != "" {
+		partNumber, parseErr := strconv.ParseInt(query.Get("partNumber"), 10, 32)
+		if parseErr != nil {
+			httputil.RespondError(w, http.StatusBadRequest, "storage_part_number_invalid")
+			return
+		}
+		etag, err = h.storage.WritePart(r.Context(), key, uploadID, int32(partNumber), body)
+	} else {
+		// The signature pins the type when the URL was presigned with one.
+		contentType := query.Get("contentType")
+		if contentType == "" {
+			contentType = r.Header.Get("Content-Type")
+		}
+		etag, err = h.storage.WriteObject(r.Context(), key, contentType, body)
+	}
+	if err != nil {
+		h.respondError(w, err, "write local storage object", key)
+		return
+	}
+
+	w.Header().Set("ETag", etag)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) respondError(w http.ResponseWriter, err error, logMsg, key string) {
+	var maxErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxErr):
+		httputil.RespondError(w, http.StatusRequestEntityTooLarge, "storage_object_too_large")
+	case errors.Is(err, service.ErrInvalidStorageKey):
+		httputil.RespondError(w, http.StatusBadRequest, "storage_key_invalid")
+	case errors.Is(err, service.ErrInvalidMultipartParts):
+		httputil.RespondError(w, http.StatusBadRequest, "storage_part_number_invalid")
+	case errors.Is(err, repository.ErrNotFound):
+		httputil.RespondError(w, http.StatusNotFound, "storage_object_not_found")
+	default:
+		h.logger.Error(logMsg, zap.Error(err), zap.String("key", key))
+		httputil.RespondError(w, http.StatusInternalServerError, "storage_failed")
+	}
+}
+
+// objectKey reads the key from the decoded path; the chi wildcard would hold
+// the escaped form for some keys.
+func objectKey(r *http.Request) (string, bool) {
+	key, ok := strings.CutPrefix(r.URL.Path, service.LocalStorageRoute)
+	if !ok || key == "" {
+		return "", false
+	}
+	return key, true
+}
@@ -8,6 +8,7 @@ import (
 	authhandlers "calixio/internal/http/handlers/auth"
 	filehandlers "calixio/internal/http/handlers/files"
 	roomhandlers "calixio/internal/http/handlers/rooms"
+	storagehandlers "calixio/internal/http/handlers/storage"
 	webhookhandlers "calixio/internal/http/handlers/webhook"
 	httpmiddleware "calixio/internal/http/middleware"
 	"calixio/internal/repository"
@@ -23,6 +24,7 @@ func NewRouter(
 	roomHandler *roomhandlers.Handler,
 	fileHandler *filehandlers.Handler,
 	webhookHandler *webhookhandlers.Handler,
+	storageHandler *storagehandlers.Handler,
 	jwt *authn.JWTService,
 	tokens repository.SessionRepository,
 	logger *zap.Logger,
@@ -33,7 +35,6 @@ func NewRouter(
 	r.Use(middleware.RequestID)
 	r.Use(middleware.RealIP)
 	r.Use(middleware.Recoverer)
-	r.Use(middleware.Timeout(30 * time.Second))
 	allowedOrigins := append([]string{}, corsOrigins...)
 	allowedOrigins = append(allowedOrigins, "https://calixio.managetlg.com")
 	hasWildcardOrigin := false
@@ -47,10 +48,16 @@ func NewRouter(
 	}
 
 	corsOptions := cors.Options{
-		AllowedOrigins:   filtered,
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-Requested-With"},
-		ExposedHeaders:   []string{"Link", "Set-Cookie"},
+		AllowedOrigins: filtered,
+		AllowedMethods: []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders: []string{
+			"Accept", "Authorization", "Content-Type", "X-Requested-With",
+			"Tus-Resumable", "Upload-Length", "Upload-Metadata", "Upload-Offset",
+		},
+		ExposedHeaders: []string{
+			"Link", "Set-Cookie", "ETag",
+			"Location", "Tus-Resumable", "Tus-Version", "Tus-Extension", "Tus-Max-Size", "Upload-Offset", "Upload-Length",
+		},
 		AllowCredentials: true,
 		MaxAge:           300,
 	}
@@ -62,34 +69,89 @@ func NewRouter(
 	r.Use(cors.Handler(corsOptions))
 	r.Use(httpmiddleware.LoggingMiddleware(logger))
 
-	r.Post("/auth/login", authHandler.Login)
-	r.Post("/auth/register", authHandler.Register)
-	r.Post("/auth/refresh", authHandler.Refresh)
-	r.Get("/media/playback/{token}/index.m3u8", fileHandler.GetPlaybackManifest)
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.Timeout(30 * time.Second))
+
+		r.Post("/auth/login", authHandler.Login)
+		r.Post("/auth/register", authHandler.Register)
+		r.Post("/auth/refresh", authHandler.Refresh)
+		r.Get("/media/playback/{token}/keys/{key}", fileHandler.GetPlaybackKey)
+
+		r.Route("/rooms", func(r chi.Router) {
+			r.Post("/{id}/join", roomHandler.JoinRoom)
+			r.Get("/{id}/playback", roomHandler.GetRoomPlaybackState)
+			r.Group(func(r chi.Router) {
+				r.Use(httpmiddleware.AuthMiddleware(jwt, tokens))
+				r.Get("/", roomHandler.ListRooms)
+				r.Post("/", roomHandler.CreateRoom)
+				r.Post("/{id}/state", roomHandler.UpdateRoomState)
+				r.Post("/{id}/playback", roomHandler.UpdateRoomPlaybackState)
+				r.Post("/{id}/end", roomHandler.EndRoom)
+			})
+		})
 
-	r.Route("/rooms", func(r chi.Router) {
-		r.Post("/{id}/join", roomHandler.JoinRoom)
-		r.Get("/{id}/playback", roomHandler.GetRoomPlaybackState)
 		r.Group(func(r chi.Router) {
 			r.Use(httpmiddleware.AuthMiddleware(jwt, tokens))
-			r.Get("/", roomHandler.ListRooms)
-			r.Post("/", roomHandler.CreateRoom)
-			r.Post("/{id}/state", roomHandler.UpdateRoomState)
-			r.Post("/{id}/playback", roomHandler.UpdateRoomPlaybackState)
-			r.Post("/{id}/end", roomHandler.EndRoom)
+			r.Get("/me/usage", fileHandler.GetUsage)
+			r.Get("/media", fileHandler.ListMedia)
+			r.Get("/media/{id}", fileHandler.GetMedia)
+			r.Patch("/media/{id}", fileHandler.UpdateMedia)
+			r.Get("/media/{id}/playback", fileHandler.GetPlayback)
+			r.Put("/media/{id}/poster", fileHandler.UploadMediaPoster)
+			r.Get("/media/{id}/subtitles", fileHandler.ListMediaSubtitles)
+			r.Post("/media/{id}/subtitles", fileHandler.AddMediaSubtitle)
+			r.Delete("/media/{id}/subtitles/{subtitleId}", fileHandler.DeleteMediaSubtitle)
+			r.Delete("/media/{id}", fileHandler.DeleteMedia)
+			r.Post("/media/import", fileHandler.ImportMedia)
+			r.Get("/tags", fileHandler.ListTags)
+			r.Get("/collections", fileHandler.ListCollections)
+			r.Post("/collections", fileHandler.CreateCollection)
+			r.Patch("/collections/{id}", fileHandler.UpdateCollection)
+			r.Delete("/collections/{id}", fileHandler.DeleteCollection)
+			r.Post("/media/upload/init", fileHandler.InitMediaUpload)
+			r.Post("/media/upload/complete", fileHandler.CompleteMediaUpload)
+			r.Post("/media/upload/multipart/init", fileHandler.InitMultipartMediaUpload)
+			r.Post("/media/upload/multipart/sign", fileHandler.SignMultipartMediaUpload)
+			r.Post("/media/upload/multipart/complete", fileHandler.CompleteMultipartMediaUpload)
+			r.Post("/media/upload/multipart/abort", fileHandler.AbortMultipartMediaUpload)
 		})
+
+		r.Post("/livekit/webhook", webhookHandler.LiveKitWebhook)
 	})
 
+	// Capturing a poster runs ffmpeg against the original in the request.
 	r.Group(func(r chi.Router) {
+		r.Use(middleware.Timeout(5 * time.Minute))
 		r.Use(httpmiddleware.AuthMiddleware(jwt, tokens))
-		r.Get("/media", fileHandler.ListMedia)
-		r.Get("/media/{id}/playback", fileHandler.GetPlayback)
-		r.Delete("/media/{id}", fileHandler.DeleteMedia)
-		r.Post("/media/upload/init", fileHandler.InitMediaUpload)
-		r.Post("/media/upload/complete", fileHandler.CompleteMediaUpload)
+		r.Post("/media/{id}/poster", fileHandler.SetMediaPoster)
+	})
+
+	// Playback segments are streamed through the API when presigning is off.
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.Timeout(10 * time.Minute))
+		r.Get("/media/playback/{token}/*", fileHandler.GetPlaybackManifest)
 	})
 
-	r.Post("/livekit/webhook", webhookHandler.LiveKitWebhook)
+	// tus chunks stream large bodies, so they get a longer deadline than the rest of the API.
+	r.Options("/media/tus", fileHandler.TusOptions)
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.Timeout(15 * time.Minute))
+		r.Use(httpmiddleware.AuthMiddleware(jwt, tokens))
+		r.Post("/media/tus", fileHandler.TusCreateUpload)
+		r.Head("/media/tus/{id}", fileHandler.TusGetUpload)
+		r.Patch("/media/tus/{id}", fileHandler.TusPatchUpload)
+		r.Delete("/media/tus/{id}", fileHandler.TusTerminateUpload)
+	})
+
+	// Only the local storage backend is served by the API; its URLs carry
+	// their own signatures instead of a session.
+	if storageHandler != nil {
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.Timeout(15 * time.Minute))
+			r.Get("/storage/*", storageHandler.GetObject)
+			r.Put("/storage/*", storageHandler.PutObject)
+		})
+	}
 
 	return r
 }
@@ -0,0 +1,175 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var ErrCollectionNotFound = errors.New("collection not found")
+
+// Collection is a user-defined folder of media. A media may sit in any
+// number of collections.
+type Collection struct {
+	ID          string
+	OwnerUserID string
+	Name        string
+	// MediaCount counts live media only; it is not set by Create.
+	MediaCount int
+	CreatedAt  time.Time
+}
+
+type CollectionRepository interface {
+	Create(ctx context.Context, collection Collection) error
+	ListByOwner(ctx context.Context, ownerUserID string) ([]Collection, error)
+	GetByID(ctx context.Context, id string) (Collection, error)
+	Rename(ctx context.Context, id, name string) error
+	Delete(ctx context.Context, id string) error
+	ListIDsByMedia(ctx context.Context, mediaIDs []string) (map[string][]string, error)
+}
+
+type PostgresCollectionRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewPostgresCollectionRepository(pool *pgxpool.Pool) *PostgresCollectionRepository {
+	return &PostgresCollectionRepository{pool: pool}
+}
+
+func (r *PostgresCollectionRepository) Create(ctx context.Context, collection Collection) error {
+	_, err := r.pool.Exec(ctx, `
+		INSERT INTO collections (id, owner_user_id, name, created_at)
+		VALUES ($1, $2, $3, $4)
+	`, collection.ID, collection.OwnerUserID, collection.Name, collection.CreatedAt)
+	return collectionWriteError(err)
+}
+
+func (r *PostgresCollectionRepository) ListByOwner(ctx context.Context, ownerUserID string) ([]Collection, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT c.id, c.owner_user_id, c.name, c.created_at, COUNT(m.id)
+		FROM collections c
+		LEFT JOIN media_collections mc ON mc.collection_id = c.id
+		LEFT JOIN media m ON m.id = mc.media_id AND m.deleted_at IS NULL
+		WHERE c.owner_user_id = $1
+		GROUP BY c.id
+		ORDER BY c.name, c.id
+	`, ownerUserID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]Collection, 0)
+	for rows.Next() {
+		var collection Collection
+		if err := rows.Scan(&collection.ID, &collection.OwnerUserID, &collection.Name, &collection.CreatedAt, &collection.MediaCount); err != nil {
+			return nil, err
+		}
+		out = append(out, collection)
+	}
+	return out, rows.Err()
+}
+
+func (r *PostgresCollectionRepository) GetByID(ctx context.Context, id string) (Collection, error) {
+	var collection Collection
+	err := r.pool.QueryRow(ctx, `
+		SELECT c.id, c.owner_user_id, c.name, c.created_at, COUNT(m.id)
+		FROM collections c
+		LEFT JOIN media_collections mc ON mc.collection_id = c.id
+		LEFT JOIN media m ON m.id = mc.media_id AND m.deleted_at IS NULL
+		WHERE c.id = $1
+		GROUP BY c.id
+	`, id).Scan(&collection.ID, &collection.OwnerUserID, &collection.Name, &collection.CreatedAt, &collection.MediaCount)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return Collection{}, ErrNotFound
+		}
+		return Collection{}, err
+	}
+	return collection, nil
+}
+
+func (r *PostgresCollectionRepository) Rename(ctx context.Context, id, name string) error {
+	ct, err := r.pool.Exec(ctx, `UPDATE collections SET name = $2 WHERE id = $1`, id, name)
+	if err != nil {
+		return collectionWriteError(err)
+	}
+	if ct.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Delete removes the collection; its media stay in the library.
+func (r *PostgresCollectionRepository) Delete(ctx context.Context, id string) error {
+	ct, err := r.pool.Exec(ctx, `DELETE FROM collections WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if ct.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// setMediaCollections replaces the collections of mediaID within tx. It
+// fails with ErrCollectionNotFound when one of collectionIDs does not belong
+// to ownerUserID.
+func setMediaCollections(ctx context.Context, tx pgx.Tx, ownerUserID, mediaID string, collectionIDs []string) error {
+	if _, err := tx.Exec(ctx, `DELETE FROM media_collections WHERE media_id = $1`, mediaID); err != nil {
+		return err
+	}
+	if len(collectionIDs) == 0 {
+		return nil
+	}
+	ct, err := tx.Exec(ctx, `
+		INSERT INTO media_collections (media_id, collection_id)
+		SELECT $1, id FROM collections WHERE owner_user_id = $2 AND id = ANY($3)
+	`, mediaID, ownerUserID, collectionIDs)
+	if err != nil {
+		return err
+	}
+	if int(ct.RowsAffected()) != len(collectionIDs) {
+		return ErrCollectionNotFound
+	}
+	return nil
+}
+
+func (r *PostgresCollectionRepository) ListIDsByMedia(ctx context.Context, mediaIDs []string) (map[string][]string, error) {
+	out := make(map[string][]string, len(mediaIDs))
+	if len(mediaIDs) == 0 {
+		return out, nil
+	}
+
+	rows, err := r.pool.Query(ctx, `
+		SELECT media_id, collection_id
+		FROM media_collections
+		WHERE media_id = ANY($1)
+		ORDER BY added_at, collection_id
+	`, mediaIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var mediaID, collectionID string
+		if err := rows.Scan(&mediaID, &collectionID); err != nil {
+			return nil, err
+		}
+		out[mediaID] = append(out[mediaID], collectionID)
+	}
+	return out, rows.Err()
+}
+
+func collectionWriteError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return ErrAlreadyExists
+	}
+	return err
+}
@@ -2,7 +2,11 @@ package repository
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/jackc/pgx/v5"
@@ -17,35 +21,260 @@ const (
 	MediaProcessing MediaStatus = "processing"
 	MediaReady      MediaStatus = "ready"
 	MediaFailed     MediaStatus = "failed"
+	// MediaRejected uploads failed content validation; the object is gone.
+	MediaRejected MediaStatus = "rejected"
+	// MediaQuarantined uploads were flagged by the malware scanner; the
+	// original was moved out of the user's prefix.
+	MediaQuarantined MediaStatus = "quarantined"
 )
 
+type MediaScanStatus string
+
+const (
+	MediaScanClean    MediaScanStatus = "clean"
+	MediaScanInfected MediaScanStatus = "infected"
+	// MediaScanSkippedTooLarge originals exceed what the scanner accepts;
+	// they are transcoded without a verdict.
+	MediaScanSkippedTooLarge MediaScanStatus = "skipped_too_large"
+)
+
+// MediaOutputSource names the media whose transcoded outputs a media plays
+// instead of its own, after its original turned out to be identical.
+type MediaOutputSource struct {
+	MediaID     string
+	OwnerUserID string
+}
+
+// OutputRelease reports the stored outputs a deletion left unreferenced.
+type OutputRelease struct {
+	// OutputsInUse is set when other media still play the deleted media's
+	// outputs, so only its original may go.
+	OutputsInUse bool
+	// Released is the already deleted source whose outputs the deleted
+	// media was the last to share.
+	Released *MediaOutputSource
+}
+
+// MediaScan is the malware scanner's verdict on the uploaded original.
+type MediaScan struct {
+	Status    MediaScanStatus
+	Signature *string
+	ScannedAt time.Time
+}
+
+type MediaOutputFormat string
+
+const (
+	MediaFormatHLSTS MediaOutputFormat = "hls_ts"
+	MediaFormatCMAF  MediaOutputFormat = "cmaf"
+)
+
+type MediaStage string
+
+const (
+	MediaStageImport   MediaStage = "import"
+	MediaStageScan     MediaStage = "scan"
+	MediaStageDownload MediaStage = "download"
+	MediaStageEncode   MediaStage = "encode"
+	MediaStagePreview  MediaStage = "preview"
+	MediaStageUpload   MediaStage = "upload"
+	MediaStageDone     MediaStage = "done"
+)
+
+// MediaProgress is the last progress report of the processing pipeline.
+type MediaProgress struct {
+	Stage     MediaStage
+	Percent   float64
+	ETASec    *int
+	UpdatedAt time.Time
+}
+
+// MediaTechMetadata describes the uploaded source as probed by ffprobe.
+type MediaTechMetadata struct {
+	Container      string  `json:"container"`
+	DurationSec    float64 `json:"durationSec"`
+	BitRate        int64   `json:"bitRate"`
+	Width          int     `json:"width"`
+	Height         int     `json:"height"`
+	FrameRate      float64 `json:"frameRate"`
+	VideoCodec     string  `json:"videoCodec"`
+	VideoProfile   string  `json:"videoProfile,omitempty"`
+	PixelFormat    string  `json:"pixelFormat,omitempty"`
+	VideoBitRate   int64   `json:"videoBitRate,omitempty"`
+	AudioCodec     string  `json:"audioCodec,omitempty"`
+	AudioChannels  int     `json:"audioChannels,omitempty"`
+	ChannelLayout  string  `json:"channelLayout,omitempty"`
+	SampleRate     int     `json:"sampleRate,omitempty"`
+	AudioBitRate   int64   `json:"audioBitRate,omitempty"`
+	VideoTracks    int     `json:"videoTracks"`
+	AudioTracks    int     `json:"audioTracks"`
+	SubtitleTracks int     `json:"subtitleTracks"`
+}
+
 type Media struct {
 	ID            string
 	OwnerUserID   string
 	Title         string
+	Description   string
 	OriginalName  string
 	StorageKey    string
 	PlaybackURL   string
 	PreviewURL    *string
+	StoryboardURL *string
 	DurationSec   *int
 	FileSizeBytes int64
 	MimeType      string
 	Status        MediaStatus
-	CreatedAt     time.Time
-	DeletedAt     *time.Time
+	OutputFormat  MediaOutputFormat
+	// Encrypted media has AES-128 segments whose keys live in media_keys.
+	Encrypted bool
+	// EncodingProfile names the transcoding profile that was applied.
+	EncodingProfile *string
+	Width           *int
+	Height          *int
+	TechMetadata    *MediaTechMetadata
+	// OutputSizeBytes is the stored size of everything derived from the
+	// original: renditions, posters, storyboards and subtitles.
+	OutputSizeBytes int64
+	RejectReason    *string
+	CreatedAt       time.Time
+	DeletedAt       *time.Time
+	Progress        *MediaProgress
+	Scan            *MediaScan
+	// ContentSHA256 is declared by the client at upload init and replaced
+	// with the verified digest once the transcoder has downloaded the file.
+	ContentSHA256 *string
+	OutputSource  *MediaOutputSource
+	// Tags and CollectionIDs are loaded separately by the services that
+	// return them.
+	Tags          []string
+	CollectionIDs []string
+}
+
+// MediaSort orders a media listing by a column; a leading "-" sorts
+// descending.
+type MediaSort string
+
+const (
+	MediaSortCreatedAsc   MediaSort = "created_at"
+	MediaSortCreatedDesc  MediaSort = "-created_at"
+	MediaSortTitleAsc     MediaSort = "title"
+	MediaSortTitleDesc    MediaSort = "-title"
+	MediaSortDurationAsc  MediaSort = "duration"
+	MediaSortDurationDesc MediaSort = "-duration"
+	MediaSortSizeAsc      MediaSort = "size"
+	MediaSortSizeDesc     MediaSort = "-size"
+)
+
+// mediaSortColumns maps a sort to its SQL expression; every listing breaks
+// ties by id so pages never overlap.
+var mediaSortColumns = map[MediaSort]string{
+	MediaSortCreatedAsc:  "created_at",
+	MediaSortTitleAsc:    "title",
+	MediaSortDurationAsc: "COALESCE(duration_sec, 0)",
+	MediaSortSizeAsc:     "file_size_bytes",
+}
+
+// Valid reports whether sort is one of the supported orders.
+func (s MediaSort) Valid() bool {
+	_, ok := mediaSortColumns[MediaSort(strings.TrimPrefix(string(s), "-"))]
+	return ok
+}
+
+// Descending reports whether sort runs from the largest value down.
+func (s MediaSort) Descending() bool {
+	return strings.HasPrefix(string(s), "-")
+}
+
+// SortValue is the value of media the sort orders by, as stored in list
+// cursors.
+func (s MediaSort) SortValue(media Media) any {
+	switch MediaSort(strings.TrimPrefix(string(s), "-")) {
+	case MediaSortTitleAsc:
+		return media.Title
+	case MediaSortDurationAsc:
+		if media.DurationSec == nil {
+			return 0
+		}
+		return *media.DurationSec
+	case MediaSortSizeAsc:
+		return media.FileSizeBytes
+	default:
+		return media.CreatedAt
+	}
+}
+
+// MediaListFilter selects one page of an owner's library. Empty fields do
+// not filter.
+type MediaListFilter struct {
+	OwnerUserID  string
+	Status       MediaStatus
+	Tag          string
+	CollectionID string
+	// Query is matched against title and description with websearch_to_tsquery.
+	Query string
+	Sort  MediaSort
+	// After is the last media of the previous page; only its ID and the
+	// field the sort uses are read.
+	After *Media
+	Limit int
+}
+
+// MediaDetails are the fields a user edits on a media; nil leaves a field as
+// it is.
+type MediaDetails struct {
+	Title         *string
+	Description   *string
+	Tags          *[]string
+	CollectionIDs *[]string
 }
 
 type MediaRepository interface {
 	Create(ctx context.Context, media Media) (Media, error)
-	ListByOwner(ctx context.Context, ownerUserID string) ([]Media, error)
+	List(ctx context.Context, filter MediaListFilter) ([]Media, error)
 	GetByID(ctx context.Context, id string) (Media, error)
 	ListExistingIDs(ctx context.Context, ids []string) ([]string, error)
 	UpdateUploadState(ctx context.Context, id string, status MediaStatus, fileSizeBytes int64, mimeType string) error
 	UpdateStatus(ctx context.Context, id string, status MediaStatus) error
+	// UpdateDetails applies details in one transaction. It fails with
+	// ErrNotFound when the media is gone and with ErrCollectionNotFound when
+	// one of the collections does not belong to ownerUserID.
+	UpdateDetails(ctx context.Context, id, ownerUserID string, details MediaDetails) error
+	Reject(ctx context.Context, id, reason string) error
+	RecordScan(ctx context.Context, id string, scan MediaScan) error
+	// Quarantine records an infected verdict and points the media at the
+	// quarantined copy of its original.
+	Quarantine(ctx context.Context, id, storageKey string, scan MediaScan) error
 	UpdateTranscodeResult(ctx context.Context, id, playbackURL string, previewURL *string, durationSec *int, status MediaStatus) error
+	UpdateProgress(ctx context.Context, id string, progress MediaProgress) error
+	UpdateEncodingProfile(ctx context.Context, id, profile string) error
+	UpdateTechMetadata(ctx context.Context, id string, meta MediaTechMetadata) error
+	UpdateStoryboardURL(ctx context.Context, id string, storyboardURL *string) error
+	UpdatePreviewURL(ctx context.Context, id string, previewURL *string) error
+	// UpdateStoredBytes reports whether the recorded sizes changed.
+	UpdateStoredBytes(ctx context.Context, id string, originalBytes, outputBytes int64) (bool, error)
 	SoftDelete(ctx context.Context, id string, deletedAt time.Time) error
+	// SoftDeleteShared soft-deletes a media that may share outputs and
+	// reports which of them nothing references any more.
+	SoftDeleteShared(ctx context.Context, id string, deletedAt time.Time) (OutputRelease, error)
+	UpdateContentHash(ctx context.Context, id, contentSHA256 string) error
+	// FindOutputSource returns a ready media with its own unencrypted
+	// outputs in format whose original has the given digest.
+	FindOutputSource(ctx context.Context, contentSHA256 string, format MediaOutputFormat, excludeID string) (Media, error)
+	// LinkOutputs makes id ready on the outputs of sourceID. It fails with
+	// ErrNotFound once the source outputs are gone.
+	LinkOutputs(ctx context.Context, id, sourceID string, previewURL *string) error
+	// ListReferencedIDs returns the deleted media among ids whose outputs
+	// are still shared by live media.
+	ListReferencedIDs(ctx context.Context, ids []string) ([]string, error)
 }
 
+const mediaColumns = `id, owner_user_id, title, description, original_name, storage_key, playback_url, preview_url,
+			duration_sec, file_size_bytes, mime_type, status, output_format, created_at, deleted_at,
+			progress_stage, progress_percent, progress_eta_sec, progress_updated_at, encoding_profile,
+			width, height, tech_metadata, storyboard_url, encrypted, output_size_bytes, reject_reason,
+			scan_status, scan_signature, scanned_at, content_sha256, output_media_id, output_owner_user_id`
+
 type PostgresMediaRepository struct {
 	pool *pgxpool.Pool
 }
@@ -55,15 +284,18 @@ func NewPostgresMediaRepository(pool *pgxpool.Pool) *PostgresMediaRepository {
 }
 
 func (r *PostgresMediaRepository) Create(ctx context.Context, media Media) (Media, error) {
+	outputFormat := media.OutputFormat
+	if outputFormat == "" {
+		outputFormat = MediaFormatHLSTS
+	}
 	query := `
 		INSERT INTO media (
 			id, owner_user_id, title, original_name, storage_key, playback_url, preview_url,
-			duration_sec, file_size_bytes, mime_type, status, created_at, deleted_at
+			duration_sec, file_size_bytes, mime_type, status, output_format, created_at, deleted_at, encrypted,
+			content_sha256
 		)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
-		RETURNING id, owner_user_id, title, original_name, storage_key, playback_url, preview_url,
-			duration_sec, file_size_bytes, mime_type, status, created_at, deleted_at
-	`
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+		RETURNING ` + mediaColumns
 	row := r.pool.QueryRow(
 		ctx,
 		query,
@@ -78,42 +310,63 @@ func (r *PostgresMediaRepository) Create(ctx context.Context, media Media) (Medi
 		media.FileSizeBytes,
 		media.MimeType,
 		string(media.Status),
+		string(outputFormat),
 		media.CreatedAt,
 		media.DeletedAt,
+		media.Encrypted,
+		media.ContentSHA256,
 	)
 
-	var out Media
-	var status string
-	if err := row.Scan(
-		&out.ID,
-		&out.OwnerUserID,
-		&out.Title,
-		&out.OriginalName,
-		&out.StorageKey,
-		&out.PlaybackURL,
-		&out.PreviewURL,
-		&out.DurationSec,
-		&out.FileSizeBytes,
-		&out.MimeType,
-		&status,
-		&out.CreatedAt,
-		&out.DeletedAt,
-	); err != nil {
-		return Media{}, err
-	}
-	out.Status = MediaStatus(status)
-	return out, nil
+	return scanMedia(row)
 }
 
-func (r *PostgresMediaRepository) ListByOwner(ctx context.Context, ownerUserID string) ([]Media, error) {
+func (r *PostgresMediaRepository) List(ctx context.Context, filter MediaListFilter) ([]Media, error) {
+	sort := filter.Sort
+	if !sort.Valid() {
+		sort = MediaSortCreatedDesc
+	}
+	column := mediaSortColumns[MediaSort(strings.TrimPrefix(string(sort), "-"))]
+	direction, comparison := "ASC", ">"
+	if sort.Descending() {
+		direction, comparison = "DESC", "<"
+	}
+
+	args := []any{filter.OwnerUserID}
+	arg := func(value any) string {
+		args = append(args, value)
+		return "$" + strconv.Itoa(len(args))
+	}
+	conditions := []string{"owner_user_id = $1", "deleted_at IS NULL"}
+	if filter.Status != "" {
+		conditions = append(conditions, "status = "+arg(string(filter.Status)))
+	}
+	if filter.Tag != "" {
+		conditions = append(conditions, `EXISTS (
+			SELECT 1 FROM media_tags mt JOIN tags t ON t.id = mt.tag_id
+			WHERE mt.media_id = media.id AND t.name = `+arg(filter.Tag)+`
+		)`)
+	}
+	if filter.CollectionID != "" {
+		conditions = append(conditions, `EXISTS (
+			SELECT 1 FROM media_collections mc
+			WHERE mc.media_id = media.id AND mc.collection_id = `+arg(filter.CollectionID)+`
+		)`)
+	}
+	if filter.Query != "" {
+		conditions = append(conditions, "search_vector @@ websearch_to_tsquery('simple', "+arg(filter.Query)+")")
+	}
+	if filter.After != nil {
+		conditions = append(conditions, fmt.Sprintf("(%s, id) %s (%s, %s)",
+			column, comparison, arg(sort.SortValue(*filter.After)), arg(filter.After.ID)))
+	}
+
 	query := `
-		SELECT id, owner_user_id, title, original_name, storage_key, playback_url, preview_url,
-			duration_sec, file_size_bytes, mime_type, status, created_at, deleted_at
+		SELECT ` + mediaColumns + `
 		FROM media
-		WHERE owner_user_id = $1 AND deleted_at IS NULL
-		ORDER BY created_at DESC
-	`
-	rows, err := r.pool.Query(ctx, query, ownerUserID)
+		WHERE ` + strings.Join(conditions, " AND ") + `
+		ORDER BY ` + column + ` ` + direction + `, id ` + direction + `
+		LIMIT ` + arg(filter.Limit)
+	rows, err := r.pool.Query(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -121,26 +374,10 @@ func (r *PostgresMediaRepository) ListByOwner(ctx context.Context, ownerUserID s
 
 	items := make([]Media, 0)
 	for rows.Next() {
-		var out Media
-		var status string
-		if err := rows.Scan(
-			&out.ID,
-			&out.OwnerUserID,
-			&out.Title,
-			&out.OriginalName,
-			&out.StorageKey,
-			&out.PlaybackURL,
-			&out.PreviewURL,
-			&out.DurationSec,
-			&out.FileSizeBytes,
-			&out.MimeType,
-			&status,
-			&out.CreatedAt,
-			&out.DeletedAt,
-		); err != nil {
+		out, err := scanMedia(rows)
+		if err != nil {
 			return nil, err
 		}
-		out.Status = MediaStatus(status)
 		items = append(items, out)
 	}
 	if err := rows.Err(); err != nil {
@@ -152,36 +389,17 @@ func (r *PostgresMediaRepository) ListByOwner(ctx context.Context, ownerUserID s
 
 func (r *PostgresMediaRepository) GetByID(ctx context.Context, id string) (Media, error) {
 	query := `
-		SELECT id, owner_user_id, title, original_name, storage_key, playback_url, preview_url,
-			duration_sec, file_size_bytes, mime_type, status, created_at, deleted_at
+		SELECT ` + mediaColumns + `
 		FROM media
 		WHERE id = $1 AND deleted_at IS NULL
 	`
-	row := r.pool.QueryRow(ctx, query, id)
-
-	var out Media
-	var status string
-	if err := row.Scan(
-		&out.ID,
-		&out.OwnerUserID,
-		&out.Title,
-		&out.OriginalName,
-		&out.StorageKey,
-		&out.PlaybackURL,
-		&out.PreviewURL,
-		&out.DurationSec,
-		&out.FileSizeBytes,
-		&out.MimeType,
-		&status,
-		&out.CreatedAt,
-		&out.DeletedAt,
-	); err != nil {
+	out, err := scanMedia(r.pool.QueryRow(ctx, query, id))
+	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return Media{}, ErrNotFound
 		}
 		return Media{}, err
 	}
-	out.Status = MediaStatus(status)
 	return out, nil
 }
 
@@ -250,6 +468,87 @@ func (r *PostgresMediaRepository) UpdateStatus(ctx context.Context, id string, s
 	return nil
 }
 
+func (r *PostgresMediaRepository) UpdateDetails(ctx context.Context, id, ownerUserID string, details MediaDetails) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	// The update runs even without a new title or description: it locks the
+	// row against a concurrent delete.
+	ct, err := tx.Exec(ctx, `
+		UPDATE media
+		SET title = COALESCE($2, title), description = COALESCE($3, description)
+		WHERE id = $1 AND deleted_at IS NULL
+	`, id, details.Title, details.Description)
+	if err != nil {
+		return err
+	}
+	if ct.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	if details.CollectionIDs != nil {
+		if err := setMediaCollections(ctx, tx, ownerUserID, id, *details.CollectionIDs); err != nil {
+			return err
+		}
+	}
+	if details.Tags != nil {
+		if err := setMediaTags(ctx, tx, ownerUserID, id, *details.Tags); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+func (r *PostgresMediaRepository) Reject(ctx context.Context, id, reason string) error {
+	query := `
+		UPDATE media
+		SET status = $2, reject_reason = $3
+		WHERE id = $1 AND deleted_at IS NULL
+	`
+	ct, err := r.pool.Exec(ctx, query, id, string(MediaRejected), reason)
+	if err != nil {
+		return err
+	}
+	if ct.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *PostgresMediaRepository) RecordScan(ctx context.Context, id string, scan MediaScan) error {
+	query := `
+		UPDATE media
+		SET scan_status = $2, scan_signature = $3, scanned_at = $4
+		WHERE id = $1 AND deleted_at IS NULL
+	`
+	ct, err := r.pool.Exec(ctx, query, id, string(scan.Status), scan.Signature, scan.ScannedAt)
+	if err != nil {
+		return err
+	}
+	if ct.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *PostgresMediaRepository) Quarantine(ctx context.Context, id, storageKey string, scan MediaScan) error {
+	query := `
+		UPDATE media
+		SET status = $2, storage_key = $3, scan_status = $4, scan_signature = $5, scanned_at = $6
+		WHERE id = $1 AND deleted_at IS NULL
+	`
+	ct, err := r.pool.Exec(ctx, query, id, string(MediaQuarantined), storageKey, string(scan.Status), scan.Signature, scan.ScannedAt)
+	if err != nil {
+		return err
+	}
+	if ct.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
 func (r *PostgresMediaRepository) UpdateTranscodeResult(ctx context.Context, id, playbackURL string, previewURL *string, durationSec *int, status MediaStatus) error {
 	query := `
 		UPDATE media
@@ -270,6 +569,109 @@ func (r *PostgresMediaRepository) UpdateTranscodeResult(ctx context.Context, id,
 	return nil
 }
 
+func (r *PostgresMediaRepository) UpdateProgress(ctx context.Context, id string, progress MediaProgress) error {
+	query := `
+		UPDATE media
+		SET progress_stage = $2,
+			progress_percent = $3,
+			progress_eta_sec = $4,
+			progress_updated_at = $5
+		WHERE id = $1 AND deleted_at IS NULL
+	`
+	ct, err := r.pool.Exec(ctx, query, id, string(progress.Stage), progress.Percent, progress.ETASec, progress.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	if ct.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *PostgresMediaRepository) UpdateEncodingProfile(ctx context.Context, id, profile string) error {
+	query := `
+		UPDATE media
+		SET encoding_profile = $2
+		WHERE id = $1 AND deleted_at IS NULL
+	`
+	ct, err := r.pool.Exec(ctx, query, id, profile)
+	if err != nil {
+		return err
+	}
+	if ct.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *PostgresMediaRepository) UpdateTechMetadata(ctx context.Context, id string, meta MediaTechMetadata) error {
+	raw, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	query := `
+		UPDATE media
+		SET width = $2,
+			height = $3,
+			tech_metadata = $4
+		WHERE id = $1 AND deleted_at IS NULL
+	`
+	ct, err := r.pool.Exec(ctx, query, id, meta.Width, meta.Height, raw)
+	if err != nil {
+		return err
+	}
+	if ct.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *PostgresMediaRepository) UpdateStoryboardURL(ctx context.Context, id string, storyboardURL *string) error {
+	query := `
+		UPDATE media
+		SET storyboard_url = $2
+		WHERE id = $1 AND deleted_at IS NULL
+	`
+	ct, err := r.pool.Exec(ctx, query, id, storyboardURL)
+	if err != nil {
+		return err
+	}
+	if ct.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *PostgresMediaRepository) UpdatePreviewURL(ctx context.Context, id string, previewURL *string) error {
+	query := `
+		UPDATE media
+		SET preview_url = $2
+		WHERE id = $1 AND deleted_at IS NULL
+	`
+	ct, err := r.pool.Exec(ctx, query, id, previewURL)
+	if err != nil {
+		return err
+	}
+	if ct.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *PostgresMediaRepository) UpdateStoredBytes(ctx context.Context, id string, originalBytes, outputBytes int64) (bool, error) {
+	query := `
+		UPDATE media
+		SET file_size_bytes = $2, output_size_bytes = $3
+		WHERE id = $1 AND deleted_at IS NULL
+		  AND (file_size_bytes, output_size_bytes) IS DISTINCT FROM ($2, $3)
+	`
+	ct, err := r.pool.Exec(ctx, query, id, originalBytes, outputBytes)
+	if err != nil {
+		return false, err
+	}
+	return ct.RowsAffected() > 0, nil
+}
+
 func (r *PostgresMediaRepository) SoftDelete(ctx context.Context, id string, deletedAt time.Time) error {
 	query := `
 		UPDATE media
@@ -285,3 +687,268 @@ func (r *PostgresMediaRepository) SoftDelete(ctx context.Context, id string, del
 	}
 	return nil
 }
+
+func (r *PostgresMediaRepository) SoftDeleteShared(ctx context.Context, id string, deletedAt time.Time) (OutputRelease, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return OutputRelease{}, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	// Locking the row that owns the outputs serializes this with
+	// LinkOutputs, so no media can start sharing outputs being released.
+	var outputMediaID *string
+	err = tx.QueryRow(ctx, `
+		SELECT output_media_id FROM media WHERE id = $1 AND deleted_at IS NULL FOR UPDATE
+	`, id).Scan(&outputMediaID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return OutputRelease{}, ErrNotFound
+		}
+		return OutputRelease{}, err
+	}
+	var source MediaOutputSource
+	var sourceDeleted bool
+	if outputMediaID != nil {
+		err = tx.QueryRow(ctx, `
+			SELECT id, owner_user_id, deleted_at IS NOT NULL FROM media WHERE id = $1 FOR UPDATE
+		`, *outputMediaID).Scan(&source.MediaID, &source.OwnerUserID, &sourceDeleted)
+		if err != nil {
+			return OutputRelease{}, err
+		}
+	}
+
+	if _, err := tx.Exec(ctx, `UPDATE media SET deleted_at = $2 WHERE id = $1`, id, deletedAt); err != nil {
+		return OutputRelease{}, err
+	}
+
+	var out OutputRelease
+	ownerID := id
+	if outputMediaID != nil {
+		ownerID = source.MediaID
+	}
+	var references int
+	if err := tx.QueryRow(ctx, `
+		SELECT COUNT(*) FROM media WHERE output_media_id = $1 AND deleted_at IS NULL
+	`, ownerID).Scan(&references); err != nil {
+		return OutputRelease{}, err
+	}
+	switch {
+	case outputMediaID == nil:
+		out.OutputsInUse = references > 0
+	case sourceDeleted && references == 0:
+		out.Released = &source
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return OutputRelease{}, err
+	}
+	return out, nil
+}
+
+func (r *PostgresMediaRepository) UpdateContentHash(ctx context.Context, id, contentSHA256 string) error {
+	query := `
+		UPDATE media
+		SET content_sha256 = $2
+		WHERE id = $1 AND deleted_at IS NULL
+	`
+	ct, err := r.pool.Exec(ctx, query, id, contentSHA256)
+	if err != nil {
+		return err
+	}
+	if ct.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// sharedOutputsAlive matches media whose outputs can still be played: live
+// ones and deleted ones that live media reference.
+const sharedOutputsAlive = `(src.deleted_at IS NULL OR EXISTS (
+			SELECT 1 FROM media ref WHERE ref.output_media_id = src.id AND ref.deleted_at IS NULL
+		))`
+
+func (r *PostgresMediaRepository) FindOutputSource(ctx context.Context, contentSHA256 string, format MediaOutputFormat, excludeID string) (Media, error) {
+	query := `
+		SELECT ` + mediaColumns + `
+		FROM media src
+		WHERE src.content_sha256 = $1
+		  AND src.output_media_id IS NULL
+		  AND src.status = $2
+		  AND src.output_format = $3
+		  AND NOT src.encrypted
+		  AND src.id <> $4
+		  AND ` + sharedOutputsAlive + `
+		ORDER BY src.created_at
+		LIMIT 1
+	`
+	media, err := scanMedia(r.pool.QueryRow(ctx, query, contentSHA256, string(MediaReady), string(format), excludeID))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return Media{}, ErrNotFound
+		}
+		return Media{}, err
+	}
+	return media, nil
+}
+
+func (r *PostgresMediaRepository) LinkOutputs(ctx context.Context, id, sourceID string, previewURL *string) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	var locked string
+	err = tx.QueryRow(ctx, `
+		SELECT src.id FROM media src
+		WHERE src.id = $1 AND src.status = $2 AND src.output_media_id IS NULL AND `+sharedOutputsAlive+`
+		FOR UPDATE
+	`, sourceID, string(MediaReady)).Scan(&locked)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrNotFound
+		}
+		return err
+	}
+
+	ct, err := tx.Exec(ctx, `
+		UPDATE media m
+		SET output_media_id = src.id,
+			output_owner_user_id = src.owner_user_id,
+			playback_url = src.playback_url,
+			preview_url = $3,
+			storyboard_url = src.storyboard_url,
+			duration_sec = src.duration_sec,
+			width = src.width,
+			height = src.height,
+			tech_metadata = src.tech_metadata,
+			encoding_profile = src.encoding_profile,
+			status = $4
+		FROM media src
+		WHERE m.id = $1 AND m.deleted_at IS NULL AND src.id = $2
+	`, id, sourceID, previewURL, string(MediaReady))
+	if err != nil {
+		return err
+	}
+	if ct.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return tx.Commit(ctx)
+}
+
+func (r *PostgresMediaRepository) ListReferencedIDs(ctx context.Context, ids []string) ([]string, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	query := `
+		SELECT DISTINCT output_media_id
+		FROM media
+		WHERE deleted_at IS NULL
+		  AND output_media_id = ANY($1)
+	`
+
+	rows, err := r.pool.Query(ctx, query, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	referenced := make([]string, 0)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		referenced = append(referenced, id)
+	}
+	return referenced, rows.Err()
+}
+
+func scanMedia(row pgx.Row) (Media, error) {
+	var out Media
+	var status string
+	var outputFormat string
+	var progressStage *string
+	var progressPercent *float64
+	var progressETASec *int
+	var progressUpdatedAt *time.Time
+	var techMetadata []byte
+	var scanStatus *string
+	var scanSignature *string
+	var scannedAt *time.Time
+	var outputMediaID *string
+	var outputOwnerUserID *string
+	if err := row.Scan(
+		&out.ID,
+		&out.OwnerUserID,
+		&out.Title,
+		&out.Description,
+		&out.OriginalName,
+		&out.StorageKey,
+		&out.PlaybackURL,
+		&out.PreviewURL,
+		&out.DurationSec,
+		&out.FileSizeBytes,
+		&out.MimeType,
+		&status,
+		&outputFormat,
+		&out.CreatedAt,
+		&out.DeletedAt,
+		&progressStage,
+		&progressPercent,
+		&progressETASec,
+		&progressUpdatedAt,
+		&out.EncodingProfile,
+		&out.Width,
+		&out.Height,
+		&techMetadata,
+		&out.StoryboardURL,
+		&out.Encrypted,
+		&out.OutputSizeBytes,
+		&out.RejectReason,
+		&scanStatus,
+		&scanSignature,
+		&scannedAt,
+		&out.ContentSHA256,
+		&outputMediaID,
+		&outputOwnerUserID,
+	); err != nil {
+		return Media{}, err
+	}
+	out.Status = MediaStatus(status)
+	out.OutputFormat = MediaOutputFormat(outputFormat)
+	if len(techMetadata) > 0 {
+		var meta MediaTechMetadata
+		if err := json.Unmarshal(techMetadata, &meta); err != nil {
+			return Media{}, fmt.Errorf("decode media tech metadata: %w", err)
+		}
+		out.TechMetadata = &meta
+	}
+	if progressStage != nil {
+		out.Progress = &MediaProgress{
+			Stage:  MediaStage(*progressStage),
+			ETASec: progressETASec,
+		}
+		if progressPercent != nil {
+			out.Progress.Percent = *progressPercent
+		}
+		if progressUpdatedAt != nil {
+			out.Progress.UpdatedAt = *progressUpdatedAt
+		}
+	}
+	if scanStatus != nil {
+		out.Scan = &MediaScan{
+			Status:    MediaScanStatus(*scanStatus),
+			Signature: scanSignature,
+		}
+		if scannedAt != nil {
+			out.Scan.ScannedAt = *scannedAt
+		}
+	}
+	if outputMediaID != nil && outputOwnerUserID != nil {
+		out.OutputSource = &MediaOutputSource{MediaID: *outputMediaID, OwnerUserID: *outputOwnerUserID}
+	}
+	return out, nil
+}
@@ -0,0 +1,62 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// MediaKeyRepository stores the AES-128 content keys of encrypted media.
+// Key i protects segments [i*N, (i+1)*N) of every rendition.
+type MediaKeyRepository interface {
+	ReplaceForMedia(ctx context.Context, mediaID string, keys [][]byte) error
+	Get(ctx context.Context, mediaID string, keyIndex int) ([]byte, error)
+}
+
+type PostgresMediaKeyRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewPostgresMediaKeyRepository(pool *pgxpool.Pool) *PostgresMediaKeyRepository {
+	return &PostgresMediaKeyRepository{pool: pool}
+}
+
+// ReplaceForMedia drops the keys of a previous transcoding attempt and stores
+// keys in index order.
+func (r *PostgresMediaKeyRepository) ReplaceForMedia(ctx context.Context, mediaID string, keys [][]byte) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if _, err := tx.Exec(ctx, `DELETE FROM media_keys WHERE media_id = $1`, mediaID); err != nil {
+		return err
+	}
+	for i, key := range keys {
+		if _, err := tx.Exec(ctx,
+			`INSERT INTO media_keys (media_id, key_index, key_bytes) VALUES ($1, $2, $3)`,
+			mediaID, i, key,
+		); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+func (r *PostgresMediaKeyRepository) Get(ctx context.Context, mediaID string, keyIndex int) ([]byte, error) {
+	var key []byte
+	err := r.pool.QueryRow(ctx,
+		`SELECT key_bytes FROM media_keys WHERE media_id = $1 AND key_index = $2`,
+		mediaID, keyIndex,
+	).Scan(&key)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return key, nil
+}
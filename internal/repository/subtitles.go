@@ -0,0 +1,162 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type MediaSubtitleSource string
+
+const (
+	MediaSubtitleUpload   MediaSubtitleSource = "upload"
+	MediaSubtitleEmbedded MediaSubtitleSource = "embedded"
+)
+
+type MediaSubtitle struct {
+	ID        string
+	MediaID   string
+	Language  string
+	Label     string
+	Source    MediaSubtitleSource
+	IsDefault bool
+	CreatedAt time.Time
+}
+
+type MediaSubtitleRepository interface {
+	Create(ctx context.Context, subtitle MediaSubtitle) error
+	ListByMedia(ctx context.Context, mediaID string) ([]MediaSubtitle, error)
+	GetByID(ctx context.Context, mediaID, id string) (MediaSubtitle, error)
+	Delete(ctx context.Context, mediaID, id string) error
+	DeleteBySource(ctx context.Context, mediaID string, source MediaSubtitleSource) error
+}
+
+type PostgresMediaSubtitleRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewPostgresMediaSubtitleRepository(pool *pgxpool.Pool) *PostgresMediaSubtitleRepository {
+	return &PostgresMediaSubtitleRepository{pool: pool}
+}
+
+const mediaSubtitleColumns = `id, media_id, language, label, source, is_default, created_at`
+
+// Create stores subtitle; a second default for the same media clears the
+// previous one so the master playlist never has two DEFAULT=YES entries.
+func (r *PostgresMediaSubtitleRepository) Create(ctx context.Context, subtitle MediaSubtitle) error {
+	source := subtitle.Source
+	if source == "" {
+		source = MediaSubtitleUpload
+	}
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if subtitle.IsDefault {
+		if _, err := tx.Exec(ctx, `UPDATE media_subtitles SET is_default = false WHERE media_id = $1`, subtitle.MediaID); err != nil {
+			return err
+		}
+	}
+
+	query := `
+		INSERT INTO media_subtitles (id, media_id, language, label, source, is_default, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`
+	if _, err := tx.Exec(ctx, query,
+		subtitle.ID,
+		subtitle.MediaID,
+		subtitle.Language,
+		subtitle.Label,
+		string(source),
+		subtitle.IsDefault,
+		subtitle.CreatedAt,
+	); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return ErrAlreadyExists
+		}
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (r *PostgresMediaSubtitleRepository) ListByMedia(ctx context.Context, mediaID string) ([]MediaSubtitle, error) {
+	query := `
+		SELECT ` + mediaSubtitleColumns + `
+		FROM media_subtitles
+		WHERE media_id = $1
+		ORDER BY created_at, id
+	`
+	rows, err := r.pool.Query(ctx, query, mediaID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]MediaSubtitle, 0)
+	for rows.Next() {
+		subtitle, err := scanMediaSubtitle(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, subtitle)
+	}
+	return out, rows.Err()
+}
+
+func (r *PostgresMediaSubtitleRepository) GetByID(ctx context.Context, mediaID, id string) (MediaSubtitle, error) {
+	query := `
+		SELECT ` + mediaSubtitleColumns + `
+		FROM media_subtitles
+		WHERE media_id = $1 AND id = $2
+	`
+	subtitle, err := scanMediaSubtitle(r.pool.QueryRow(ctx, query, mediaID, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return MediaSubtitle{}, ErrNotFound
+		}
+		return MediaSubtitle{}, err
+	}
+	return subtitle, nil
+}
+
+func (r *PostgresMediaSubtitleRepository) Delete(ctx context.Context, mediaID, id string) error {
+	ct, err := r.pool.Exec(ctx, `DELETE FROM media_subtitles WHERE media_id = $1 AND id = $2`, mediaID, id)
+	if err != nil {
+		return err
+	}
+	if ct.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *PostgresMediaSubtitleRepository) DeleteBySource(ctx context.Context, mediaID string, source MediaSubtitleSource) error {
+	_, err := r.pool.Exec(ctx, `DELETE FROM media_subtitles WHERE media_id = $1 AND source = $2`, mediaID, string(source))
+	return err
+}
+
+func scanMediaSubtitle(row pgx.Row) (MediaSubtitle, error) {
+	var out MediaSubtitle
+	var source string
+	if err := row.Scan(
+		&out.ID,
+		&out.MediaID,
+		&out.Language,
+		&out.Label,
+		&source,
+		&out.IsDefault,
+		&out.CreatedAt,
+	); err != nil {
+		return MediaSubtitle{}, err
+	}
+	out.Source = MediaSubtitleSource(source)
+	return out, nil
+}
@@ -0,0 +1,115 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// TagUsage is a tag of an owner with the number of live media carrying it.
+type TagUsage struct {
+	Name       string
+	MediaCount int
+}
+
+// TagRepository keeps free-form tags per owner; a tag exists as long as at
+// least one media carries it.
+type TagRepository interface {
+	ListByMedia(ctx context.Context, mediaIDs []string) (map[string][]string, error)
+	ListByOwner(ctx context.Context, ownerUserID string) ([]TagUsage, error)
+}
+
+type PostgresTagRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewPostgresTagRepository(pool *pgxpool.Pool) *PostgresTagRepository {
+	return &PostgresTagRepository{pool: pool}
+}
+
+// setMediaTags replaces the tags of mediaID with names within tx, creating
+// the ones its owner does not have yet and dropping those no media carries
+// any more.
+func setMediaTags(ctx context.Context, tx pgx.Tx, ownerUserID, mediaID string, names []string) error {
+	if _, err := tx.Exec(ctx, `DELETE FROM media_tags WHERE media_id = $1`, mediaID); err != nil {
+		return err
+	}
+	if len(names) > 0 {
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO tags (owner_user_id, name)
+			SELECT $1, unnest($2::text[])
+			ON CONFLICT (owner_user_id, name) DO NOTHING
+		`, ownerUserID, names); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO media_tags (media_id, tag_id)
+			SELECT $1, id FROM tags WHERE owner_user_id = $2 AND name = ANY($3)
+		`, mediaID, ownerUserID, names); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(ctx, `
+		DELETE FROM tags t
+		WHERE t.owner_user_id = $1
+		  AND NOT EXISTS (SELECT 1 FROM media_tags mt WHERE mt.tag_id = t.id)
+	`, ownerUserID); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *PostgresTagRepository) ListByMedia(ctx context.Context, mediaIDs []string) (map[string][]string, error) {
+	out := make(map[string][]string, len(mediaIDs))
+	if len(mediaIDs) == 0 {
+		return out, nil
+	}
+
+	rows, err := r.pool.Query(ctx, `
+		SELECT mt.media_id, t.name
+		FROM media_tags mt
+		JOIN tags t ON t.id = mt.tag_id
+		WHERE mt.media_id = ANY($1)
+		ORDER BY t.name
+	`, mediaIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var mediaID, name string
+		if err := rows.Scan(&mediaID, &name); err != nil {
+			return nil, err
+		}
+		out[mediaID] = append(out[mediaID], name)
+	}
+	return out, rows.Err()
+}
+
+func (r *PostgresTagRepository) ListByOwner(ctx context.Context, ownerUserID string) ([]TagUsage, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT t.name, COUNT(m.id)
+		FROM tags t
+		JOIN media_tags mt ON mt.tag_id = t.id
+		JOIN media m ON m.id = mt.media_id AND m.deleted_at IS NULL
+		WHERE t.owner_user_id = $1
+		GROUP BY t.name
+		ORDER BY t.name
+	`, ownerUserID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]TagUsage, 0)
+	for rows.Next() {
+		var tag TagUsage
+		if err := rows.Scan(&tag.Name, &tag.MediaCount); err != nil {
+			return nil, err
+		}
+		out = append(out, tag)
+	}
+	return out, rows.Err()
+}
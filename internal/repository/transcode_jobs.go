@@ -0,0 +1,226 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type TranscodeJobStatus string
+
+const (
+	TranscodeJobQueued  TranscodeJobStatus = "queued"
+	TranscodeJobRunning TranscodeJobStatus = "running"
+	TranscodeJobDone    TranscodeJobStatus = "done"
+	TranscodeJobFailed  TranscodeJobStatus = "failed"
+)
+
+type TranscodeJob struct {
+	ID          int64
+	MediaID     string
+	Status      TranscodeJobStatus
+	Attempts    int
+	MaxAttempts int
+	LockedBy    *string
+	CreatedAt   time.Time
+}
+
+type TranscodeJobRepository interface {
+	Enqueue(ctx context.Context, mediaID string, maxAttempts int) error
+	Claim(ctx context.Context, workerID string, lease time.Duration) (TranscodeJob, error)
+	Heartbeat(ctx context.Context, jobID int64, workerID string, lease time.Duration) error
+	Complete(ctx context.Context, jobID int64, workerID string) error
+	Fail(ctx context.Context, jobID int64, workerID, lastError string, retryAfter time.Duration) (TranscodeJobStatus, error)
+	Release(ctx context.Context, jobID int64, workerID string) error
+	RequeueExpired(ctx context.Context, retryAfter time.Duration) (requeued int, exhaustedMediaIDs []string, err error)
+}
+
+type PostgresTranscodeJobRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewPostgresTranscodeJobRepository(pool *pgxpool.Pool) *PostgresTranscodeJobRepository {
+	return &PostgresTranscodeJobRepository{pool: pool}
+}
+
+// Enqueue is idempotent per media: a queued or running job for the same
+// media absorbs the call.
+func (r *PostgresTranscodeJobRepository) Enqueue(ctx context.Context, mediaID string, maxAttempts int) error {
+	if maxAttempts <= 0 {
+		maxAttempts = 1
+	}
+	query := `
+		INSERT INTO transcode_jobs (media_id, status, max_attempts)
+		VALUES ($1, 'queued', $2)
+		ON CONFLICT (media_id) WHERE status IN ('queued', 'running') DO NOTHING
+	`
+	_, err := r.pool.Exec(ctx, query, mediaID, maxAttempts)
+	return err
+}
+
+// Claim leases the oldest runnable job. SKIP LOCKED lets any number of
+// workers poll concurrently without blocking on each other's rows.
+func (r *PostgresTranscodeJobRepository) Claim(ctx context.Context, workerID string, lease time.Duration) (TranscodeJob, error) {
+	query := `
+		UPDATE transcode_jobs
+		SET status = 'running',
+		    attempts = attempts + 1,
+		    locked_by = $1,
+		    lease_expires_at = now() + $2 * interval '1 second',
+		    updated_at = now()
+		WHERE id = (
+			SELECT id FROM transcode_jobs
+			WHERE status = 'queued' AND run_after <= now()
+			ORDER BY run_after, id
+			FOR UPDATE SKIP LOCKED
+			LIMIT 1
+		)
+		RETURNING id, media_id, status, attempts, max_attempts, locked_by, created_at
+	`
+	var out TranscodeJob
+	var status string
+	if err := r.pool.QueryRow(ctx, query, workerID, lease.Seconds()).Scan(
+		&out.ID,
+		&out.MediaID,
+		&status,
+		&out.Attempts,
+		&out.MaxAttempts,
+		&out.LockedBy,
+		&out.CreatedAt,
+	); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return TranscodeJob{}, ErrNotFound
+		}
+		return TranscodeJob{}, err
+	}
+	out.Status = TranscodeJobStatus(status)
+	return out, nil
+}
+
+// Heartbeat extends the lease. ErrNotFound means the lease was lost, e.g. it
+// expired and the job was handed to another worker.
+func (r *PostgresTranscodeJobRepository) Heartbeat(ctx context.Context, jobID int64, workerID string, lease time.Duration) error {
+	query := `
+		UPDATE transcode_jobs
+		SET lease_expires_at = now() + $3 * interval '1 second',
+		    updated_at = now()
+		WHERE id = $1 AND locked_by = $2 AND status = 'running'
+	`
+	ct, err := r.pool.Exec(ctx, query, jobID, workerID, lease.Seconds())
+	if err != nil {
+		return err
+	}
+	if ct.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *PostgresTranscodeJobRepository) Complete(ctx context.Context, jobID int64, workerID string) error {
+	query := `
+		UPDATE transcode_jobs
+		SET status = 'done',
+		    locked_by = NULL,
+		    lease_expires_at = NULL,
+		    last_error = NULL,
+		    updated_at = now()
+		WHERE id = $1 AND locked_by = $2 AND status = 'running'
+	`
+	ct, err := r.pool.Exec(ctx, query, jobID, workerID)
+	if err != nil {
+		return err
+	}
+	if ct.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Fail re-queues the job after retryAfter, or marks it failed once its
+// attempts are used up. The resulting status is returned.
+func (r *PostgresTranscodeJobRepository) Fail(ctx context.Context, jobID int64, workerID, lastError string, retryAfter time.Duration) (TranscodeJobStatus, error) {
+	query := `
+		UPDATE transcode_jobs
+		SET status = CASE WHEN attempts >= max_attempts THEN 'failed' ELSE 'queued' END,
+		    run_after = now() + $4 * interval '1 second',
+		    locked_by = NULL,
+		    lease_expires_at = NULL,
+		    last_error = $3,
+		    updated_at = now()
+		WHERE id = $1 AND locked_by = $2 AND status = 'running'
+		RETURNING status
+	`
+	var status string
+	if err := r.pool.QueryRow(ctx, query, jobID, workerID, lastError, retryAfter.Seconds()).Scan(&status); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", ErrNotFound
+		}
+		return "", err
+	}
+	return TranscodeJobStatus(status), nil
+}
+
+// Release puts a job its worker gave up on, e.g. at shutdown, back in the
+// queue for immediate pickup. The interrupted attempt does not count.
+func (r *PostgresTranscodeJobRepository) Release(ctx context.Context, jobID int64, workerID string) error {
+	query := `
+		UPDATE transcode_jobs
+		SET status = 'queued',
+		    attempts = attempts - 1,
+		    run_after = now(),
+		    locked_by = NULL,
+		    lease_expires_at = NULL,
+		    updated_at = now()
+		WHERE id = $1 AND locked_by = $2 AND status = 'running'
+	`
+	ct, err := r.pool.Exec(ctx, query, jobID, workerID)
+	if err != nil {
+		return err
+	}
+	if ct.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// RequeueExpired releases running jobs whose worker stopped heartbeating.
+// Jobs that already used all attempts are failed and their media IDs returned.
+func (r *PostgresTranscodeJobRepository) RequeueExpired(ctx context.Context, retryAfter time.Duration) (int, []string, error) {
+	query := `
+		UPDATE transcode_jobs
+		SET status = CASE WHEN attempts >= max_attempts THEN 'failed' ELSE 'queued' END,
+		    run_after = now() + $1 * interval '1 second',
+		    locked_by = NULL,
+		    lease_expires_at = NULL,
+		    last_error = 'lease expired',
+		    updated_at = now()
+		WHERE status = 'running' AND lease_expires_at < now()
+		RETURNING media_id, status
+	`
+	rows, err := r.pool.Query(ctx, query, retryAfter.Seconds())
+	if err != nil {
+		return 0, nil, err
+	}
+	defer rows.Close()
+
+	requeued := 0
+	exhausted := make([]string, 0)
+	for rows.Next() {
+		var mediaID, status string
+		if err := rows.Scan(&mediaID, &status); err != nil {
+			return 0, nil, err
+		}
+		if TranscodeJobStatus(status) == TranscodeJobFailed {
+			exhausted = append(exhausted, mediaID)
+			continue
+		}
+		requeued++
+	}
+	if err := rows.Err(); err != nil {
+		return 0, nil, err
+	}
+	return requeued, exhausted, nil
+}
@@ -0,0 +1,143 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type UploadProtocol string
+
+const (
+	UploadProtocolMultipart UploadProtocol = "multipart"
+	UploadProtocolTus       UploadProtocol = "tus"
+	UploadProtocolImport    UploadProtocol = "import"
+)
+
+type MediaUpload struct {
+	MediaID       string
+	UploadID      string
+	Protocol      UploadProtocol
+	PartSizeBytes int64
+	OffsetBytes   int64
+	CreatedAt     time.Time
+}
+
+type MediaUploadRepository interface {
+	Create(ctx context.Context, upload MediaUpload) error
+	GetByMediaID(ctx context.Context, mediaID string) (MediaUpload, error)
+	UpdateOffset(ctx context.Context, mediaID string, fromOffset, toOffset int64) error
+	Delete(ctx context.Context, mediaID string) error
+	// ListCreatedBefore returns the uploads of a protocol started before
+	// the given time.
+	ListCreatedBefore(ctx context.Context, protocol UploadProtocol, before time.Time) ([]MediaUpload, error)
+}
+
+type PostgresMediaUploadRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewPostgresMediaUploadRepository(pool *pgxpool.Pool) *PostgresMediaUploadRepository {
+	return &PostgresMediaUploadRepository{pool: pool}
+}
+
+func (r *PostgresMediaUploadRepository) Create(ctx context.Context, upload MediaUpload) error {
+	protocol := upload.Protocol
+	if protocol == "" {
+		protocol = UploadProtocolMultipart
+	}
+	query := `
+		INSERT INTO media_uploads (media_id, upload_id, protocol, part_size_bytes, offset_bytes, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+	_, err := r.pool.Exec(ctx, query, upload.MediaID, upload.UploadID, string(protocol), upload.PartSizeBytes, upload.OffsetBytes, upload.CreatedAt)
+	return err
+}
+
+func (r *PostgresMediaUploadRepository) GetByMediaID(ctx context.Context, mediaID string) (MediaUpload, error) {
+	query := `
+		SELECT media_id, upload_id, protocol, part_size_bytes, offset_bytes, created_at
+		FROM media_uploads
+		WHERE media_id = $1
+	`
+	var out MediaUpload
+	var protocol string
+	if err := r.pool.QueryRow(ctx, query, mediaID).Scan(
+		&out.MediaID,
+		&out.UploadID,
+		&protocol,
+		&out.PartSizeBytes,
+		&out.OffsetBytes,
+		&out.CreatedAt,
+	); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return MediaUpload{}, ErrNotFound
+		}
+		return MediaUpload{}, err
+	}
+	out.Protocol = UploadProtocol(protocol)
+	return out, nil
+}
+
+// UpdateOffset moves the offset forward only if it still equals fromOffset,
+// so concurrent writers to the same upload cannot both succeed.
+func (r *PostgresMediaUploadRepository) UpdateOffset(ctx context.Context, mediaID string, fromOffset, toOffset int64) error {
+	query := `
+		UPDATE media_uploads
+		SET offset_bytes = $3
+		WHERE media_id = $1 AND offset_bytes = $2
+	`
+	ct, err := r.pool.Exec(ctx, query, mediaID, fromOffset, toOffset)
+	if err != nil {
+		return err
+	}
+	if ct.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *PostgresMediaUploadRepository) Delete(ctx context.Context, mediaID string) error {
+	query := `
+		DELETE FROM media_uploads
+		WHERE media_id = $1
+	`
+	_, err := r.pool.Exec(ctx, query, mediaID)
+	return err
+}
+
+func (r *PostgresMediaUploadRepository) ListCreatedBefore(ctx context.Context, protocol UploadProtocol, before time.Time) ([]MediaUpload, error) {
+	query := `
+		SELECT media_id, upload_id, protocol, part_size_bytes, offset_bytes, created_at
+		FROM media_uploads
+		WHERE protocol = $1 AND created_at < $2
+		ORDER BY created_at
+	`
+	rows, err := r.pool.Query(ctx, query, string(protocol), before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []MediaUpload
+	for rows.Next() {
+		var upload MediaUpload
+		var protocol string
+		if err := rows.Scan(
+			&upload.MediaID,
+			&upload.UploadID,
+			&protocol,
+			&upload.PartSizeBytes,
+			&upload.OffsetBytes,
+			&upload.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		upload.Protocol = UploadProtocol(protocol)
+		out = append(out, upload)
+	}
+	return out, rows.Err()
+}
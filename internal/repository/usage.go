@@ -0,0 +1,78 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// UserQuota holds the effective limits of a user: per-user overrides on the
+// users row, falling back to the user's storage plan. Nil means unlimited.
+type UserQuota struct {
+	Plan            string
+	MaxStorageBytes *int64
+	MaxMediaCount   *int
+	MaxDurationSec  *int64
+}
+
+// UserUsage sums the media a user owns. Storage counts originals and
+// transcoded outputs; media still uploading count with their declared size
+// and rejected or quarantined media do not count.
+type UserUsage struct {
+	StorageBytes int64
+	MediaCount   int
+	DurationSec  int64
+}
+
+type UsageRepository interface {
+	GetQuota(ctx context.Context, userID string) (UserQuota, error)
+	// GetUsage skips excludeMediaID so a media can be checked against the
+	// rest of its owner's library.
+	GetUsage(ctx context.Context, userID, excludeMediaID string) (UserUsage, error)
+}
+
+type PostgresUsageRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewPostgresUsageRepository(pool *pgxpool.Pool) *PostgresUsageRepository {
+	return &PostgresUsageRepository{pool: pool}
+}
+
+func (r *PostgresUsageRepository) GetQuota(ctx context.Context, userID string) (UserQuota, error) {
+	query := `
+		SELECT u.plan,
+			COALESCE(u.max_storage_bytes, p.max_storage_bytes),
+			COALESCE(u.max_media_count, p.max_media_count),
+			COALESCE(u.max_duration_sec, p.max_duration_sec)
+		FROM users u
+		LEFT JOIN storage_plans p ON p.name = u.plan
+		WHERE u.id = $1
+	`
+	var out UserQuota
+	err := r.pool.QueryRow(ctx, query, userID).Scan(&out.Plan, &out.MaxStorageBytes, &out.MaxMediaCount, &out.MaxDurationSec)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return UserQuota{}, ErrNotFound
+		}
+		return UserQuota{}, err
+	}
+	return out, nil
+}
+
+func (r *PostgresUsageRepository) GetUsage(ctx context.Context, userID, excludeMediaID string) (UserUsage, error) {
+	query := `
+		SELECT COALESCE(SUM(file_size_bytes + output_size_bytes), 0),
+			COUNT(*),
+			COALESCE(SUM(duration_sec), 0)
+		FROM media
+		WHERE owner_user_id = $1 AND deleted_at IS NULL AND status NOT IN ('rejected', 'quarantined') AND id <> $2
+	`
+	var out UserUsage
+	if err := r.pool.QueryRow(ctx, query, userID, excludeMediaID).Scan(&out.StorageBytes, &out.MediaCount, &out.DurationSec); err != nil {
+		return UserUsage{}, err
+	}
+	return out, nil
+}
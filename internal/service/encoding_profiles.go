@@ -0,0 +1,206 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// passthroughProfileName is recorded on media that were remuxed, not encoded.
+const passthroughProfileName = "passthrough"
+
+// encodingProfilesFile is the on-disk format of TRANSCODER_PROFILES_FILE,
+// accepted as YAML or JSON depending on the file extension.
+type encodingProfilesFile struct {
+	Default  string                `json:"default" yaml:"default"`
+	Profiles []encodingProfileSpec `json:"profiles" yaml:"profiles"`
+	Rules    []encodingProfileRule `json:"rules" yaml:"rules"`
+}
+
+type encodingProfileSpec struct {
+	Name        string `json:"name" yaml:"name"`
+	Codec       string `json:"codec" yaml:"codec"`
+	Preset      string `json:"preset" yaml:"preset"`
+	CRF         int    `json:"crf" yaml:"crf"`
+	MaxrateKbps int    `json:"maxrateKbps" yaml:"maxrateKbps"`
+	AudioKbps   int    `json:"audioKbps" yaml:"audioKbps"`
+	Threads     int    `json:"threads" yaml:"threads"`
+	MaxHeight   int    `json:"maxHeight" yaml:"maxHeight"`
+	SegmentSec  int    `json:"segmentSec" yaml:"segmentSec"`
+}
+
+// encodingProfileRule matches when every condition it sets holds. Rules are
+// evaluated in file order and the first match wins.
+type encodingProfileRule struct {
+	Profile         string   `json:"profile" yaml:"profile"`
+	MinDurationSec  int      `json:"minDurationSec" yaml:"minDurationSec"`
+	MaxDurationSec  int      `json:"maxDurationSec" yaml:"maxDurationSec"`
+	MinSourceHeight int      `json:"minSourceHeight" yaml:"minSourceHeight"`
+	MaxSourceHeight int      `json:"maxSourceHeight" yaml:"maxSourceHeight"`
+	OwnerUserIDs    []string `json:"ownerUserIds" yaml:"ownerUserIds"`
+}
+
+type encodingProfileSet struct {
+	profiles map[string]hlsEncodingProfile
+	rules    []encodingProfileRule
+	fallback string
+}
+
+// defaultEncodingProfiles mirrors the presets used before profiles became
+// configurable: long sources get a cheaper, capped encode.
+func defaultEncodingProfiles() encodingProfileSet {
+	return encodingProfileSet{
+		profiles: map[string]hlsEncodingProfile{
+			"default": {
+				name:        "default",
+				codec:       "libx264",
+				preset:      "veryfast",
+				crf:         21,
+				maxrateKbps: 5000,
+				audioKbps:   128,
+				threads:     0,
+			},
+			"long-form-safe": {
+				name:        "long-form-safe",
+				codec:       "libx264",
+				preset:      "superfast",
+				crf:         23,
+				maxrateKbps: 3500,
+				audioKbps:   96,
+				threads:     2,
+			},
+		},
+		rules: []encodingProfileRule{
+			{Profile: "long-form-safe", MinDurationSec: 90 * 60},
+		},
+		fallback: "default",
+	}
+}
+
+func loadEncodingProfiles(filePath string) (encodingProfileSet, error) {
+	filePath = strings.TrimSpace(filePath)
+	if filePath == "" {
+		return defaultEncodingProfiles(), nil
+	}
+
+	raw, err := os.ReadFile(filePath)
+	if err != nil {
+		return encodingProfileSet{}, fmt.Errorf("read encoding profiles: %w", err)
+	}
+
+	var file encodingProfilesFile
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".json":
+		err = json.Unmarshal(raw, &file)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(raw, &file)
+	default:
+		return encodingProfileSet{}, fmt.Errorf("encoding profiles file %q: unsupported extension", filePath)
+	}
+	if err != nil {
+		return encodingProfileSet{}, fmt.Errorf("parse encoding profiles: %w", err)
+	}
+
+	return buildEncodingProfileSet(file)
+}
+
+func buildEncodingProfileSet(file encodingProfilesFile) (encodingProfileSet, error) {
+	if len(file.Profiles) == 0 {
+		return encodingProfileSet{}, fmt.Errorf("encoding profiles: at least one profile is required")
+	}
+
+	set := encodingProfileSet{
+		profiles: make(map[string]hlsEncodingProfile, len(file.Profiles)),
+		rules:    file.Rules,
+		fallback: strings.TrimSpace(file.Default),
+	}
+	for _, spec := range file.Profiles {
+		name := strings.TrimSpace(spec.Name)
+		if name == "" {
+			return encodingProfileSet{}, fmt.Errorf("encoding profiles: profile name is required")
+		}
+		if _, dup := set.profiles[name]; dup {
+			return encodingProfileSet{}, fmt.Errorf("encoding profiles: duplicate profile %q", name)
+		}
+		profile := hlsEncodingProfile{
+			name:        name,
+			codec:       strings.TrimSpace(spec.Codec),
+			preset:      strings.TrimSpace(spec.Preset),
+			crf:         spec.CRF,
+			maxrateKbps: spec.MaxrateKbps,
+			audioKbps:   spec.AudioKbps,
+			threads:     spec.Threads,
+			maxHeight:   spec.MaxHeight,
+			segmentSec:  spec.SegmentSec,
+		}
+		if profile.codec == "" {
+			profile.codec = "libx264"
+		}
+		if profile.codec != "libx264" && profile.codec != "libx265" {
+			return encodingProfileSet{}, fmt.Errorf("encoding profile %q: unsupported codec %q", name, profile.codec)
+		}
+		if profile.preset == "" {
+			profile.preset = "veryfast"
+		}
+		if profile.crf <= 0 {
+			profile.crf = 21
+		}
+		if profile.audioKbps <= 0 {
+			profile.audioKbps = 128
+		}
+		if profile.threads < 0 || profile.maxrateKbps < 0 || profile.maxHeight < 0 || profile.segmentSec < 0 {
+			return encodingProfileSet{}, fmt.Errorf("encoding profile %q: negative values are not allowed", name)
+		}
+		set.profiles[name] = profile
+	}
+
+	if set.fallback == "" {
+		set.fallback = strings.TrimSpace(file.Profiles[0].Name)
+	}
+	if _, ok := set.profiles[set.fallback]; !ok {
+		return encodingProfileSet{}, fmt.Errorf("encoding profiles: default profile %q is not defined", set.fallback)
+	}
+	for i, rule := range set.rules {
+		if _, ok := set.profiles[strings.TrimSpace(rule.Profile)]; !ok {
+			return encodingProfileSet{}, fmt.Errorf("encoding profiles: rule %d references unknown profile %q", i, rule.Profile)
+		}
+	}
+	return set, nil
+}
+
+func (p encodingProfileSet) selectProfile(ownerUserID string, durationSec, srcHeight int) hlsEncodingProfile {
+	for _, rule := range p.rules {
+		if rule.matches(ownerUserID, durationSec, srcHeight) {
+			return p.profiles[strings.TrimSpace(rule.Profile)]
+		}
+	}
+	return p.profiles[p.fallback]
+}
+
+func (r encodingProfileRule) matches(ownerUserID string, durationSec, srcHeight int) bool {
+	if r.MinDurationSec > 0 && durationSec < r.MinDurationSec {
+		return false
+	}
+	if r.MaxDurationSec > 0 && durationSec > r.MaxDurationSec {
+		return false
+	}
+	if r.MinSourceHeight > 0 && srcHeight < r.MinSourceHeight {
+		return false
+	}
+	if r.MaxSourceHeight > 0 && srcHeight > r.MaxSourceHeight {
+		return false
+	}
+	if len(r.OwnerUserIDs) > 0 {
+		for _, id := range r.OwnerUserIDs {
+			if strings.TrimSpace(id) == ownerUserID {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
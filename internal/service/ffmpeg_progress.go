@@ -0,0 +1,82 @@
+package service
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ffmpegProgressWriter consumes the key=value stream of `-progress pipe:1`
+// and reports completion of the current encode against the probed duration.
+type ffmpegProgressWriter struct {
+	totalSec   float64
+	startedAt  time.Time
+	onProgress func(percent float64, eta *time.Duration)
+
+	pending    []byte
+	outTimeSec float64
+}
+
+func newFFmpegProgressWriter(totalSec int, onProgress func(percent float64, eta *time.Duration)) *ffmpegProgressWriter {
+	return &ffmpegProgressWriter{
+		totalSec:   float64(totalSec),
+		startedAt:  time.Now(),
+		onProgress: onProgress,
+	}
+}
+
+func (w *ffmpegProgressWriter) Write(p []byte) (int, error) {
+	w.pending = append(w.pending, p...)
+	for {
+		idx := bytes.IndexByte(w.pending, '\n')
+		if idx < 0 {
+			break
+		}
+		w.handleLine(strings.TrimSpace(string(w.pending[:idx])))
+		w.pending = w.pending[idx+1:]
+	}
+	return len(p), nil
+}
+
+func (w *ffmpegProgressWriter) handleLine(line string) {
+	key, value, ok := strings.Cut(line, "=")
+	if !ok {
+		return
+	}
+	switch key {
+	case "out_time_us", "out_time_ms":
+		// Both keys carry microseconds; out_time_ms is misnamed upstream.
+		if us, err := strconv.ParseInt(value, 10, 64); err == nil && us >= 0 {
+			w.outTimeSec = float64(us) / 1e6
+		}
+	case "progress":
+		if w.onProgress == nil {
+			return
+		}
+		if value == "end" {
+			zero := time.Duration(0)
+			w.onProgress(100, &zero)
+			return
+		}
+		w.report()
+	}
+}
+
+func (w *ffmpegProgressWriter) report() {
+	if w.totalSec <= 0 {
+		w.onProgress(0, nil)
+		return
+	}
+	percent := w.outTimeSec / w.totalSec * 100
+	if percent > 99.9 {
+		percent = 99.9
+	}
+	if percent <= 0 {
+		w.onProgress(0, nil)
+		return
+	}
+	elapsed := time.Since(w.startedAt)
+	eta := time.Duration(float64(elapsed) * (100 - percent) / percent)
+	w.onProgress(percent, &eta)
+}
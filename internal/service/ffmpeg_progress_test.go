@@ -0,0 +1,106 @@
+package service
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+type progressReport struct {
+	percent float64
+	hasETA  bool
+}
+
+func TestFFmpegProgressWriter(t *testing.T) {
+	tests := []struct {
+		name     string
+		totalSec int
+		writes   []string
+		want     []progressReport
+	}{
+		{
+			name:     "halfway",
+			totalSec: 100,
+			writes:   []string{"frame=10\nout_time_us=50000000\nprogress=continue\n"},
+			want:     []progressReport{{percent: 50, hasETA: true}},
+		},
+		{
+			name:     "out_time_ms carries microseconds",
+			totalSec: 100,
+			writes:   []string{"out_time_ms=25000000\nprogress=continue\n"},
+			want:     []progressReport{{percent: 25, hasETA: true}},
+		},
+		{
+			name:     "line split across writes",
+			totalSec: 10,
+			writes:   []string{"out_time_us=50", "00000\nprogr", "ess=continue\n"},
+			want:     []progressReport{{percent: 50, hasETA: true}},
+		},
+		{
+			name:     "crlf line endings",
+			totalSec: 10,
+			writes:   []string{"out_time_us=2000000\r\nprogress=continue\r\n"},
+			want:     []progressReport{{percent: 20, hasETA: true}},
+		},
+		{
+			name:     "capped below 100 until end",
+			totalSec: 10,
+			writes:   []string{"out_time_us=12000000\nprogress=continue\nprogress=end\n"},
+			want:     []progressReport{{percent: 99.9, hasETA: true}, {percent: 100, hasETA: true}},
+		},
+		{
+			name:     "unknown duration",
+			totalSec: 0,
+			writes:   []string{"out_time_us=5000000\nprogress=continue\n"},
+			want:     []progressReport{{percent: 0}},
+		},
+		{
+			name:     "nothing encoded yet",
+			totalSec: 10,
+			writes:   []string{"out_time_us=N/A\nprogress=continue\n"},
+			want:     []progressReport{{percent: 0}},
+		},
+		{
+			name:     "incomplete line is held back",
+			totalSec: 10,
+			writes:   []string{"out_time_us=5000000\nprogress=continue"},
+		},
+		{
+			name:     "garbage lines are ignored",
+			totalSec: 10,
+			writes:   []string{"not a key value\n=\nout_time_us=-1\n"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []progressReport
+			w := newFFmpegProgressWriter(tt.totalSec, func(percent float64, eta *time.Duration) {
+				if eta != nil && *eta < 0 {
+					t.Fatalf("negative eta %v", *eta)
+				}
+				got = append(got, progressReport{percent: percent, hasETA: eta != nil})
+			})
+			for _, chunk := range tt.writes {
+				n, err := w.Write([]byte(chunk))
+				if err != nil || n != len(chunk) {
+					t.Fatalf("Write(%q) = %d, %v", chunk, n, err)
+				}
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d reports %+v, want %+v", len(got), got, tt.want)
+			}
+			for i := range got {
+				if math.Abs(got[i].percent-tt.want[i].percent) > 1e-9 || got[i].hasETA != tt.want[i].hasETA {
+					t.Fatalf("report %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestFFmpegProgressWriterWithoutCallback(t *testing.T) {
+	w := newFFmpegProgressWriter(10, nil)
+	if _, err := w.Write([]byte("out_time_us=1000000\nprogress=continue\nprogress=end\n")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,153 @@
+package service
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	hlsKeyDir                  = "keys"
+	defaultKeyRotationSegments = 10
+)
+
+// hlsKeyName is the playlist URI of key index, resolved by the playback
+// proxy rather than object storage.
+func hlsKeyName(index int) string {
+	return path.Join(hlsKeyDir, strconv.Itoa(index)+".key")
+}
+
+func parseHLSKeyName(name string) (int, bool) {
+	raw, ok := strings.CutSuffix(path.Base(name), ".key")
+	if !ok {
+		return 0, false
+	}
+	index, err := strconv.Atoi(raw)
+	if err != nil || index < 0 {
+		return 0, false
+	}
+	return index, true
+}
+
+// hlsKeyRing hands out one random AES-128 key per rotation window. Windows
+// are counted in segments from the start of each playlist, so with aligned
+// segment boundaries every rendition shares the same keys.
+type hlsKeyRing struct {
+	rotateEvery int
+	keys        [][]byte
+}
+
+func newHLSKeyRing(rotateEvery int) *hlsKeyRing {
+	if rotateEvery <= 0 {
+		rotateEvery = defaultKeyRotationSegments
+	}
+	return &hlsKeyRing{rotateEvery: rotateEvery}
+}
+
+func (r *hlsKeyRing) keyIndex(segment int) int {
+	return segment / r.rotateEvery
+}
+
+func (r *hlsKeyRing) key(index int) ([]byte, error) {
+	for len(r.keys) <= index {
+		key := make([]byte, aes.BlockSize)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("generate hls key: %w", err)
+		}
+		r.keys = append(r.keys, key)
+	}
+	return r.keys[index], nil
+}
+
+// encryptHLSOutput encrypts every MPEG-TS segment below hlsDir in place
+// with AES-128-CBC and adds EXT-X-KEY tags to the media playlists. The
+// master playlist and subtitle playlists are left as they are.
+func encryptHLSOutput(hlsDir string, rotateEvery int) ([][]byte, error) {
+	ring := newHLSKeyRing(rotateEvery)
+	err := filepath.WalkDir(hlsDir, func(localPath string, entry os.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if entry.IsDir() {
+			if entry.Name() == subtitleDir && localPath != hlsDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(localPath) != ".m3u8" || filepath.Dir(localPath) == filepath.Clean(hlsDir) {
+			return nil
+		}
+		return encryptHLSPlaylist(localPath, ring)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ring.keys, nil
+}
+
+// encryptHLSPlaylist leaves the IV out of EXT-X-KEY, so players derive it
+// from the media sequence number of each segment, as the spec prescribes.
+func encryptHLSPlaylist(playlistPath string, ring *hlsKeyRing) error {
+	raw, err := os.ReadFile(playlistPath)
+	if err != nil {
+		return err
+	}
+
+	lines := strings.Split(string(raw), "\n")
+	out := make([]string, 0, len(lines)+8)
+	mediaSequence := 0
+	segment := 0
+	currentKey := -1
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "#EXT-X-MEDIA-SEQUENCE:"):
+			mediaSequence, _ = strconv.Atoi(strings.TrimPrefix(trimmed, "#EXT-X-MEDIA-SEQUENCE:"))
+		case strings.HasPrefix(trimmed, "#EXT-X-KEY:"):
+			return fmt.Errorf("%s is already encrypted", playlistPath)
+		case strings.HasPrefix(trimmed, "#EXTINF:"):
+			if index := ring.keyIndex(segment); index != currentKey {
+				currentKey = index
+				out = append(out, fmt.Sprintf("#EXT-X-KEY:METHOD=AES-128,URI=\"%s\"", hlsKeyName(index)))
+			}
+		case trimmed != "" && !strings.HasPrefix(trimmed, "#"):
+			key, err := ring.key(ring.keyIndex(segment))
+			if err != nil {
+				return err
+			}
+			segmentPath := filepath.Join(filepath.Dir(playlistPath), filepath.FromSlash(trimmed))
+			if err := encryptSegmentFile(segmentPath, key, uint64(mediaSequence+segment)); err != nil {
+				return err
+			}
+			segment++
+		}
+		out = append(out, line)
+	}
+	return os.WriteFile(playlistPath, []byte(strings.Join(out, "\n")), 0o644)
+}
+
+func encryptSegmentFile(segmentPath string, key []byte, sequence uint64) error {
+	plain, err := os.ReadFile(segmentPath)
+	if err != nil {
+		return err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+
+	iv := make([]byte, aes.BlockSize)
+	binary.BigEndian.PutUint64(iv[8:], sequence)
+	padding := aes.BlockSize - len(plain)%aes.BlockSize
+	padded := append(plain, bytes.Repeat([]byte{byte(padding)}, padding)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(padded, padded)
+	return os.WriteFile(segmentPath, padded, 0o644)
+}
@@ -0,0 +1,211 @@
+package service
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTestPlaylist writes a media playlist with one segment file per
+// entry of segments and returns the playlist path.
+func writeTestPlaylist(t *testing.T, dir string, mediaSequence int, segments []string) string {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:4\n")
+	fmt.Fprintf(&b, "#EXT-X-MEDIA-SEQUENCE:%d\n", mediaSequence)
+	for i, data := range segments {
+		name := fmt.Sprintf("seg_%03d.ts", i)
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(&b, "#EXTINF:4.000,\n%s\n", name)
+	}
+	b.WriteString("#EXT-X-ENDLIST\n")
+	playlistPath := filepath.Join(dir, "index.m3u8")
+	if err := os.WriteFile(playlistPath, []byte(b.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return playlistPath
+}
+
+func decryptTestSegment(t *testing.T, data, key []byte, sequence uint64) []byte {
+	t.Helper()
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		t.Fatalf("ciphertext length %d is not a multiple of the block size", len(data))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iv := make([]byte, aes.BlockSize)
+	binary.BigEndian.PutUint64(iv[8:], sequence)
+	plain := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, data)
+	padding := int(plain[len(plain)-1])
+	if padding < 1 || padding > aes.BlockSize || !bytes.Equal(plain[len(plain)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		t.Fatalf("bad padding %d", padding)
+	}
+	return plain[:len(plain)-padding]
+}
+
+func TestEncryptHLSPlaylist(t *testing.T) {
+	tests := []struct {
+		name          string
+		mediaSequence int
+		rotateEvery   int
+		segments      int
+		// wantKeyBefore lists the segment indexes preceded by EXT-X-KEY.
+		wantKeyBefore []int
+		wantKeys      int
+	}{
+		{name: "single window", rotateEvery: 10, segments: 3, wantKeyBefore: []int{0}, wantKeys: 1},
+		{name: "rotates every two", rotateEvery: 2, segments: 5, wantKeyBefore: []int{0, 2, 4}, wantKeys: 3},
+		{name: "rotates every segment", rotateEvery: 1, segments: 3, wantKeyBefore: []int{0, 1, 2}, wantKeys: 3},
+		{name: "default rotation", rotateEvery: 0, segments: 12, wantKeyBefore: []int{0, 10}, wantKeys: 2},
+		{name: "media sequence offsets the iv", mediaSequence: 7, rotateEvery: 2, segments: 3, wantKeyBefore: []int{0, 2}, wantKeys: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			segments := make([]string, tt.segments)
+			for i := range segments {
+				// Lengths around the block size exercise the padding.
+				segments[i] = strings.Repeat(string(rune('a'+i)), 15+i)
+			}
+			playlistPath := writeTestPlaylist(t, dir, tt.mediaSequence, segments)
+			ring := newHLSKeyRing(tt.rotateEvery)
+
+			if err := encryptHLSPlaylist(playlistPath, ring); err != nil {
+				t.Fatalf("encryptHLSPlaylist: %v", err)
+			}
+			if len(ring.keys) != tt.wantKeys {
+				t.Fatalf("generated %d keys, want %d", len(ring.keys), tt.wantKeys)
+			}
+
+			raw, err := os.ReadFile(playlistPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var keyBefore []int
+			var keyURIs []string
+			segment := 0
+			for _, line := range strings.Split(string(raw), "\n") {
+				switch {
+				case strings.HasPrefix(line, "#EXT-X-KEY:"):
+					keyBefore = append(keyBefore, segment)
+					keyURIs = append(keyURIs, line)
+				case strings.HasPrefix(line, "seg_"):
+					segment++
+				}
+			}
+			if fmt.Sprint(keyBefore) != fmt.Sprint(tt.wantKeyBefore) {
+				t.Fatalf("EXT-X-KEY before segments %v, want %v", keyBefore, tt.wantKeyBefore)
+			}
+			for i, line := range keyURIs {
+				want := fmt.Sprintf(`#EXT-X-KEY:METHOD=AES-128,URI="%s"`, hlsKeyName(i))
+				if line != want {
+					t.Fatalf("key line %d = %q, want %q", i, line, want)
+				}
+			}
+
+			for i, want := range segments {
+				data, err := os.ReadFile(filepath.Join(dir, fmt.Sprintf("seg_%03d.ts", i)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				key := ring.keys[ring.keyIndex(i)]
+				got := decryptTestSegment(t, data, key, uint64(tt.mediaSequence+i))
+				if string(got) != want {
+					t.Fatalf("segment %d decrypts to %q, want %q", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestEncryptHLSPlaylistRefusesEncryptedPlaylist(t *testing.T) {
+	playlistPath := writeTestPlaylist(t, t.TempDir(), 0, []string{"data"})
+	ring := newHLSKeyRing(10)
+	if err := encryptHLSPlaylist(playlistPath, ring); err != nil {
+		t.Fatalf("first pass: %v", err)
+	}
+	if err := encryptHLSPlaylist(playlistPath, ring); err == nil {
+		t.Fatal("second pass succeeded, want an error")
+	}
+}
+
+func TestEncryptHLSOutputSharesKeysAcrossRenditions(t *testing.T) {
+	hlsDir := t.TempDir()
+	writeTestPlaylist(t, filepath.Join(hlsDir, "720p"), 0, []string{"a1", "a2", "a3"})
+	writeTestPlaylist(t, filepath.Join(hlsDir, "360p"), 0, []string{"b1", "b2", "b3"})
+	writeTestPlaylist(t, filepath.Join(hlsDir, subtitleDir), 0, []string{"WEBVTT\n"})
+	master := "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=1\n720p/index.m3u8\n"
+	if err := os.WriteFile(filepath.Join(hlsDir, "master.m3u8"), []byte(master), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := encryptHLSOutput(hlsDir, 2)
+	if err != nil {
+		t.Fatalf("encryptHLSOutput: %v", err)
+	}
+	if len(keys) != 2 {
+		t.Fatalf("got %d keys, want 2", len(keys))
+	}
+	for _, rendition := range []string{"720p", "360p"} {
+		data, err := os.ReadFile(filepath.Join(hlsDir, rendition, "seg_002.ts"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		decryptTestSegment(t, data, keys[1], 2)
+	}
+
+	for _, name := range []string{"master.m3u8", filepath.Join(subtitleDir, "index.m3u8")} {
+		raw, err := os.ReadFile(filepath.Join(hlsDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(raw), "#EXT-X-KEY") {
+			t.Fatalf("%s was encrypted", name)
+		}
+	}
+	subtitle, err := os.ReadFile(filepath.Join(hlsDir, subtitleDir, "seg_000.ts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(subtitle) != "WEBVTT\n" {
+		t.Fatalf("subtitle segment was modified: %q", subtitle)
+	}
+}
+
+func TestParseHLSKeyName(t *testing.T) {
+	tests := []struct {
+		name      string
+		wantIndex int
+		wantOK    bool
+	}{
+		{name: hlsKeyName(0), wantIndex: 0, wantOK: true},
+		{name: hlsKeyName(12), wantIndex: 12, wantOK: true},
+		{name: "../keys/3.key", wantIndex: 3, wantOK: true},
+		{name: "keys/-1.key"},
+		{name: "keys/one.key"},
+		{name: "keys/1.ts"},
+		{name: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			index, ok := parseHLSKeyName(tt.name)
+			if ok != tt.wantOK || index != tt.wantIndex {
+				t.Fatalf("parseHLSKeyName(%q) = %d, %v; want %d, %v", tt.name, index, ok, tt.wantIndex, tt.wantOK)
+			}
+		})
+	}
+}
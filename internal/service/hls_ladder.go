@@ -0,0 +1,180 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// hlsRendition is one rung of the configured ladder, e.g. "720p:2800k".
+type hlsRendition struct {
+	name        string
+	height      int
+	maxrateKbps int
+}
+
+// hlsVariant is a rendition resolved against a concrete source.
+type hlsVariant struct {
+	name        string
+	width       int
+	height      int
+	maxrateKbps int
+	audioKbps   int
+}
+
+func parseHLSRenditions(specs []string) ([]hlsRendition, error) {
+	out := make([]hlsRendition, 0, len(specs))
+	seen := map[int]struct{}{}
+	for _, spec := range specs {
+		trimmed := strings.ToLower(strings.TrimSpace(spec))
+		if trimmed == "" {
+			continue
+		}
+		heightPart, ratePart, ok := strings.Cut(trimmed, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid hls rendition %q: expected <height>p:<maxrate>k", spec)
+		}
+		height, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(heightPart), "p"))
+		if err != nil || height <= 0 {
+			return nil, fmt.Errorf("invalid hls rendition height %q", spec)
+		}
+		rate, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(ratePart), "k"))
+		if err != nil || rate <= 0 {
+			return nil, fmt.Errorf("invalid hls rendition maxrate %q", spec)
+		}
+		if _, dup := seen[height]; dup {
+			continue
+		}
+		seen[height] = struct{}{}
+		out = append(out, hlsRendition{name: fmt.Sprintf("%dp", height), height: height, maxrateKbps: rate})
+	}
+	if len(out) == 0 {
+		out = append(out, hlsRendition{name: "720p", height: 720, maxrateKbps: 5000})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].height > out[j].height })
+	return out, nil
+}
+
+// buildHLSVariants drops every rung above the source height so nothing is
+// upscaled. A source smaller than the lowest rung gets a single variant at
+// its own height.
+// The profile's maxHeight caps the ladder the same way.
+func buildHLSVariants(ladder []hlsRendition, srcWidth, srcHeight int, profile hlsEncodingProfile) []hlsVariant {
+	capHeight := srcHeight
+	if profile.maxHeight > 0 && (capHeight <= 0 || capHeight > profile.maxHeight) {
+		capHeight = profile.maxHeight
+	}
+
+	selected := make([]hlsRendition, 0, len(ladder))
+	for _, rendition := range ladder {
+		if capHeight <= 0 || rendition.height <= capHeight {
+			selected = append(selected, rendition)
+		}
+	}
+	if len(selected) == 0 && len(ladder) > 0 {
+		lowest := ladder[len(ladder)-1]
+		height := evenDimension(capHeight)
+		selected = append(selected, hlsRendition{
+			name:        fmt.Sprintf("%dp", height),
+			height:      height,
+			maxrateKbps: lowest.maxrateKbps,
+		})
+	}
+
+	out := make([]hlsVariant, 0, len(selected))
+	for _, rendition := range selected {
+		maxrate := rendition.maxrateKbps
+		if profile.maxrateKbps > 0 && profile.maxrateKbps < maxrate {
+			maxrate = profile.maxrateKbps
+		}
+		width := 0
+		if srcWidth > 0 && srcHeight > 0 {
+			width = evenDimension(srcWidth * rendition.height / srcHeight)
+		}
+		out = append(out, hlsVariant{
+			name:        rendition.name,
+			width:       width,
+			height:      rendition.height,
+			maxrateKbps: maxrate,
+			audioKbps:   profile.audioKbps,
+		})
+	}
+	return out
+}
+
+func hlsVariantNames(variants []hlsVariant) []string {
+	names := make([]string, 0, len(variants))
+	for _, variant := range variants {
+		names = append(names, variant.name)
+	}
+	return names
+}
+
+func buildScaleFilterGraph(variants []hlsVariant) string {
+	if len(variants) == 1 {
+		return fmt.Sprintf("[0:v:0]scale=-2:%d[v0]", variants[0].height)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "[0:v:0]split=%d", len(variants))
+	for i := range variants {
+		fmt.Fprintf(&b, "[vs%d]", i)
+	}
+	for i, variant := range variants {
+		fmt.Fprintf(&b, ";[vs%d]scale=-2:%d[v%d]", i, variant.height, i)
+	}
+	return b.String()
+}
+
+// writeHLSMasterPlaylist lists the video variants. When the source has
+// several audio tracks they are separate renditions in one AUDIO group and
+// every variant refers to it.
+func writeHLSMasterPlaylist(filePath string, variants []hlsVariant, audio []hlsAudioRendition) error {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	b.WriteString("#EXT-X-VERSION:3\n")
+	b.WriteString("#EXT-X-INDEPENDENT-SEGMENTS\n")
+	for _, rendition := range audio {
+		isDefault := "NO"
+		if rendition.isDefault {
+			isDefault = "YES"
+		}
+		fmt.Fprintf(&b, "#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"%s\",NAME=\"%s\"", audioGroupID, hlsQuotedValue(rendition.label))
+		if rendition.language != "" {
+			fmt.Fprintf(&b, ",LANGUAGE=\"%s\"", hlsQuotedValue(rendition.language))
+		}
+		fmt.Fprintf(&b, ",DEFAULT=%s,AUTOSELECT=YES,URI=\"%s\"\n", isDefault, path.Join(rendition.name, "index.m3u8"))
+	}
+	for _, variant := range variants {
+		audioKbps := variant.audioKbps
+		if len(audio) > 0 {
+			audioKbps = maxAudioKbps(audio)
+		}
+		bandwidth := (variant.maxrateKbps + audioKbps) * 1000
+		fmt.Fprintf(&b, "#EXT-X-STREAM-INF:BANDWIDTH=%d", bandwidth)
+		if variant.width > 0 && variant.height > 0 {
+			fmt.Fprintf(&b, ",RESOLUTION=%dx%d", variant.width, variant.height)
+		}
+		if len(audio) > 0 {
+			fmt.Fprintf(&b, ",AUDIO=\"%s\"", audioGroupID)
+		}
+		b.WriteString("\n")
+		b.WriteString(path.Join(variant.name, "index.m3u8"))
+		b.WriteString("\n")
+	}
+	return os.WriteFile(filePath, []byte(b.String()), 0o644)
+}
+
+func evenDimension(v int) int {
+	if v <= 2 {
+		return 2
+	}
+	return v - v%2
+}
+
+func kbps(v int) string {
+	return strconv.Itoa(v) + "k"
+}
@@ -0,0 +1,270 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"calixio/internal/repository"
+	"go.uber.org/zap"
+)
+
+const (
+	audioGroupID          = "audio"
+	defaultAudioTrackKbps = 128
+)
+
+// textSubtitleCodecs are the subtitle codecs ffmpeg can turn into WebVTT.
+// Bitmap formats such as PGS and VobSub would need OCR and are skipped.
+var textSubtitleCodecs = map[string]struct{}{
+	"subrip":   {},
+	"srt":      {},
+	"ass":      {},
+	"ssa":      {},
+	"webvtt":   {},
+	"mov_text": {},
+	"text":     {},
+}
+
+// hlsAudioRendition is one source audio track served as an alternate
+// EXT-X-MEDIA TYPE=AUDIO rendition.
+type hlsAudioRendition struct {
+	name       string
+	trackIndex int
+	label      string
+	language   string
+	isDefault  bool
+	kbps       int
+}
+
+// buildAudioRenditions returns one rendition per source audio track, or nil
+// for sources with at most one track, which keep audio muxed into the video
+// variants.
+func buildAudioRenditions(probe mediaProbe, transcodeKbps int) []hlsAudioRendition {
+	if len(probe.audio) < 2 {
+		return nil
+	}
+	labels := newTrackLabeler()
+	out := make([]hlsAudioRendition, 0, len(probe.audio))
+	for i, track := range probe.audio {
+		kbps := transcodeKbps
+		if kbps <= 0 {
+			kbps = int(track.bitRate / 1000)
+		}
+		if kbps <= 0 {
+			kbps = defaultAudioTrackKbps
+		}
+		out = append(out, hlsAudioRendition{
+			name:       fmt.Sprintf("audio_%d", i),
+			trackIndex: i,
+			label:      labels.label(track.title, track.language, "Audio", i),
+			language:   trackLanguage(track.language),
+			isDefault:  i == 0,
+			kbps:       kbps,
+		})
+	}
+	return out
+}
+
+func maxAudioKbps(audio []hlsAudioRendition) int {
+	out := 0
+	for _, rendition := range audio {
+		if rendition.kbps > out {
+			out = rendition.kbps
+		}
+	}
+	return out
+}
+
+// hlsAudioOutputArgs adds one HLS output per audio rendition. codecArgs is
+// either an aac encode or "-c:a copy" for passthrough.
+func hlsAudioOutputArgs(outDir string, audio []hlsAudioRendition, segmentSec int, codecArgs func(hlsAudioRendition) []string) ([]string, error) {
+	args := make([]string, 0)
+	for _, rendition := range audio {
+		renditionDir := filepath.Join(outDir, rendition.name)
+		if err := os.MkdirAll(renditionDir, 0o755); err != nil {
+			return nil, err
+		}
+		args = append(args, "-map", fmt.Sprintf("0:a:%d", rendition.trackIndex))
+		args = append(args, codecArgs(rendition)...)
+		if rendition.language != "" {
+			args = append(args, "-metadata:s:a:0", "language="+rendition.language)
+		}
+		args = append(args,
+			"-f", "hls",
+			"-hls_time", strconv.Itoa(segmentSec),
+			"-hls_playlist_type", "vod",
+			"-hls_flags", "independent_segments",
+			"-hls_segment_filename", filepath.Join(renditionDir, "segment_%05d.ts"),
+			filepath.Join(renditionDir, "index.m3u8"),
+		)
+	}
+	return args, nil
+}
+
+func aacAudioArgs(kbps int) []string {
+	return []string{"-c:a", "aac", "-b:a", strconv.Itoa(kbps) + "k", "-ac", "2"}
+}
+
+// textSubtitles lists the embedded subtitle streams that can become WebVTT.
+func (p mediaProbe) textSubtitles() []probeSubtitleStream {
+	out := make([]probeSubtitleStream, 0, len(p.subtitles))
+	for _, stream := range p.subtitles {
+		if _, ok := textSubtitleCodecs[stream.codec]; ok {
+			out = append(out, stream)
+		}
+	}
+	return out
+}
+
+// extractEmbeddedSubtitles converts text subtitle streams to WebVTT under
+// hlsDir/subs so they upload with the rest of the HLS output. A track that
+// fails to convert is logged and skipped.
+func (s *MediaTranscoderService) extractEmbeddedSubtitles(ctx context.Context, srcPath, hlsDir string, media repository.Media, probe mediaProbe) ([]repository.MediaSubtitle, error) {
+	streams := probe.textSubtitles()
+	if len(streams) == 0 || s.subtitleRepo == nil {
+		return nil, nil
+	}
+	if err := os.MkdirAll(filepath.Join(hlsDir, subtitleDir), 0o755); err != nil {
+		return nil, err
+	}
+
+	labels := newTrackLabeler()
+	out := make([]repository.MediaSubtitle, 0, len(streams))
+	for i, stream := range streams {
+		subtitleID, err := newSubtitleID()
+		if err != nil {
+			return nil, err
+		}
+		vttPath := filepath.Join(hlsDir, subtitleVTTName(subtitleID))
+		if err := s.runFFmpegSubtitle(ctx, srcPath, stream.index, vttPath); err != nil {
+			s.logger.Warn("embedded subtitle extraction failed",
+				zap.String("media_id", media.ID),
+				zap.Int("stream_index", stream.index),
+				zap.String("codec", stream.codec),
+				zap.Error(err),
+			)
+			_ = os.Remove(vttPath)
+			continue
+		}
+
+		raw, err := os.ReadFile(vttPath)
+		if err != nil {
+			return nil, err
+		}
+		_, lastCueSec, err := normalizeWebVTT(strings.ReplaceAll(string(raw), "\r\n", "\n"))
+		if err != nil {
+			// Tracks with no cues are dropped rather than offered empty.
+			_ = os.Remove(vttPath)
+			continue
+		}
+		durationSec := probe.durationSec
+		if lastCueSec > durationSec {
+			durationSec = lastCueSec
+		}
+		playlist := buildSubtitlePlaylist(filepath.Base(vttPath), durationSec)
+		if err := os.WriteFile(filepath.Join(hlsDir, subtitlePlaylistName(subtitleID)), []byte(playlist), 0o644); err != nil {
+			return nil, err
+		}
+
+		language := trackLanguage(stream.language)
+		if language == "" {
+			language = "und"
+		}
+		out = append(out, repository.MediaSubtitle{
+			ID:       subtitleID,
+			MediaID:  media.ID,
+			Language: language,
+			Label:    labels.label(stream.title, stream.language, "Subtitles", i),
+			Source:   repository.MediaSubtitleEmbedded,
+		})
+	}
+	return out, nil
+}
+
+func (s *MediaTranscoderService) runFFmpegSubtitle(ctx context.Context, srcPath string, streamIndex int, vttPath string) error {
+	cmd := exec.CommandContext(ctx, s.ffmpegPath,
+		"-y",
+		"-hide_banner",
+		"-nostats",
+		"-loglevel", "warning",
+		"-i", srcPath,
+		"-map", fmt.Sprintf("0:%d", streamIndex),
+		"-c:s", "webvtt",
+		"-f", "webvtt",
+		vttPath,
+	)
+	stderr := &tailBuffer{maxBytes: 16 << 10}
+	cmd.Stdout = io.Discard
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return formatFFmpegError("ffmpeg subtitle", err, stderr.String())
+	}
+	return nil
+}
+
+// replaceEmbeddedSubtitles swaps the rows from a previous attempt for the
+// tracks of this run. Uploaded subtitles are left alone.
+func (s *MediaTranscoderService) replaceEmbeddedSubtitles(ctx context.Context, mediaID string, subtitles []repository.MediaSubtitle) error {
+	if s.subtitleRepo == nil {
+		return nil
+	}
+	if err := s.subtitleRepo.DeleteBySource(ctx, mediaID, repository.MediaSubtitleEmbedded); err != nil {
+		return err
+	}
+	for _, subtitle := range subtitles {
+		subtitle.CreatedAt = time.Now()
+		err := s.subtitleRepo.Create(ctx, subtitle)
+		if errors.Is(err, repository.ErrAlreadyExists) {
+			// The owner already uploaded a track with this label.
+			subtitle.Label += " (embedded)"
+			err = s.subtitleRepo.Create(ctx, subtitle)
+		}
+		if err != nil {
+			return fmt.Errorf("store embedded subtitle %s: %w", subtitle.ID, err)
+		}
+	}
+	return nil
+}
+
+// trackLanguage drops the "undetermined" tag so playlists omit LANGUAGE.
+func trackLanguage(raw string) string {
+	language := strings.ToLower(strings.TrimSpace(raw))
+	if language == "und" {
+		return ""
+	}
+	return language
+}
+
+// trackLabeler hands out NAME values, which must be unique within an
+// EXT-X-MEDIA group.
+type trackLabeler struct {
+	seen map[string]int
+}
+
+func newTrackLabeler() *trackLabeler {
+	return &trackLabeler{seen: map[string]int{}}
+}
+
+func (l *trackLabeler) label(title, language, kind string, position int) string {
+	base := strings.TrimSpace(title)
+	if base == "" {
+		if lang := trackLanguage(language); lang != "" {
+			base = strings.ToUpper(lang)
+		} else {
+			base = fmt.Sprintf("%s %d", kind, position+1)
+		}
+	}
+	l.seen[base]++
+	if n := l.seen[base]; n > 1 {
+		return fmt.Sprintf("%s (%d)", base, n)
+	}
+	return base
+}
@@ -0,0 +1,246 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"calixio/internal/config"
+)
+
+// ErrScanTooLarge is returned by scanners that refuse an object because of
+// its size.
+var ErrScanTooLarge = errors.New("object exceeds the scanner size limit")
+
+// MalwareScanner inspects an uploaded original before it is transcoded.
+type MalwareScanner interface {
+	Scan(ctx context.Context, body io.Reader) (ScanVerdict, error)
+}
+
+// sizeLimitedScanner is implemented by scanners that cannot take objects
+// above a size, so those are skipped without being streamed.
+type sizeLimitedScanner interface {
+	MaxScanBytes() int64
+}
+
+type ScanVerdict struct {
+	Infected bool
+	// Signature names what the scanner found, e.g. "Win.Test.EICAR_HDB-1".
+	Signature string
+}
+
+// NewMalwareScanner builds the scanner selected by MALWARE_SCANNER. It
+// returns nil when scanning is off.
+func NewMalwareScanner(cfg *config.Config) (MalwareScanner, error) {
+	switch cfg.MalwareScan.Scanner {
+	case "", "none":
+		return nil, nil
+	case "clamd":
+		return NewClamdScanner(NewClamdScannerInput{
+			Address:        cfg.MalwareScan.ClamdAddress,
+			Timeout:        cfg.MalwareScan.Timeout,
+			MaxStreamBytes: cfg.MalwareScan.MaxStreamBytes,
+		})
+	case "fake":
+		return NewFakeScanner(), nil
+	default:
+		return nil, fmt.Errorf("unsupported malware scanner %q", cfg.MalwareScan.Scanner)
+	}
+}
+
+const (
+	// clamdChunkSize stays well under clamd's default StreamMaxLength chunking.
+	clamdChunkSize = 64 << 10
+	// clamdStreamCeiling is the largest StreamMaxLength clamd supports.
+	clamdStreamCeiling = 4<<30 - 1
+	// clamdSizeLimitReply is what clamd answers once a stream passes its
+	// StreamMaxLength.
+	clamdSizeLimitReply = "INSTREAM size limit exceeded."
+)
+
+// ClamdScanner streams objects to clamd, or anything speaking its protocol,
+// with the INSTREAM command.
+type ClamdScanner struct {
+	network        string
+	address        string
+	timeout        time.Duration
+	maxStreamBytes int64
+}
+
+type NewClamdScannerInput struct {
+	// Address is "tcp://host:port", "unix:///path/to/clamd.sock" or a bare
+	// "host:port".
+	Address string
+	Timeout time.Duration
+	// MaxStreamBytes should match StreamMaxLength in clamd.conf; larger
+	// objects are not sent. It defaults to, and is capped at, 4 GiB.
+	MaxStreamBytes int64
+}
+
+func NewClamdScanner(in NewClamdScannerInput) (*ClamdScanner, error) {
+	address := strings.TrimSpace(in.Address)
+	network := "tcp"
+	switch {
+	case strings.HasPrefix(address, "tcp://"):
+		address = strings.TrimPrefix(address, "tcp://")
+	case strings.HasPrefix(address, "unix://"):
+		network = "unix"
+		address = strings.TrimPrefix(address, "unix://")
+	}
+	if address == "" {
+		return nil, errors.New("clamd address is required")
+	}
+
+	timeout := in.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Minute
+	}
+	maxStreamBytes := in.MaxStreamBytes
+	if maxStreamBytes <= 0 || maxStreamBytes > clamdStreamCeiling {
+		maxStreamBytes = clamdStreamCeiling
+	}
+
+	return &ClamdScanner{
+		network:        network,
+		address:        address,
+		timeout:        timeout,
+		maxStreamBytes: maxStreamBytes,
+	}, nil
+}
+
+func (s *ClamdScanner) MaxScanBytes() int64 {
+	return s.maxStreamBytes
+}
+
+func (s *ClamdScanner) Scan(ctx context.Context, body io.Reader) (ScanVerdict, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, s.network, s.address)
+	if err != nil {
+		return ScanVerdict{}, fmt.Errorf("connect to clamd: %w", err)
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	if err := conn.SetDeadline(time.Now().Add(s.timeout)); err != nil {
+		return ScanVerdict{}, err
+	}
+
+	reply := bufio.NewReader(conn)
+	if err := writeClamdStream(conn, body); err != nil {
+		// clamd answers and hangs up when the stream exceeds its
+		// StreamMaxLength; that answer explains the failed write.
+		if line, readErr := reply.ReadString(0); readErr == nil {
+			return parseClamdReply(line)
+		}
+		if ctx.Err() != nil {
+			return ScanVerdict{}, ctx.Err()
+		}
+		return ScanVerdict{}, fmt.Errorf("stream to clamd: %w", err)
+	}
+
+	line, err := reply.ReadString(0)
+	if err != nil && line == "" {
+		if ctx.Err() != nil {
+			return ScanVerdict{}, ctx.Err()
+		}
+		return ScanVerdict{}, fmt.Errorf("read clamd reply: %w", err)
+	}
+	return parseClamdReply(line)
+}
+
+// writeClamdStream sends body as INSTREAM chunks: each prefixed with its
+// length as a 4-byte big-endian integer, terminated by a zero length.
+func writeClamdStream(w io.Writer, body io.Reader) error {
+	if _, err := io.WriteString(w, "zINSTREAM\x00"); err != nil {
+		return err
+	}
+	buf := make([]byte, 4+clamdChunkSize)
+	for {
+		n, err := io.ReadFull(body, buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf[:4], uint32(n))
+			if _, writeErr := w.Write(buf[:4+n]); writeErr != nil {
+				return writeErr
+			}
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read object: %w", err)
+		}
+	}
+	_, err := w.Write([]byte{0, 0, 0, 0})
+	return err
+}
+
+// parseClamdReply understands "stream: OK", "stream: <signature> FOUND" and
+// "<message> ERROR"; the size limit error becomes ErrScanTooLarge.
+func parseClamdReply(line string) (ScanVerdict, error) {
+	reply := strings.TrimSpace(strings.TrimRight(line, "\x00"))
+	reply = strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case reply == "OK":
+		return ScanVerdict{}, nil
+	case strings.HasSuffix(reply, " FOUND"):
+		return ScanVerdict{Infected: true, Signature: strings.TrimSuffix(reply, " FOUND")}, nil
+	case reply == clamdSizeLimitReply+" ERROR":
+		return ScanVerdict{}, ErrScanTooLarge
+	case strings.HasSuffix(reply, " ERROR"):
+		return ScanVerdict{}, fmt.Errorf("clamd: %s", strings.TrimSuffix(reply, " ERROR"))
+	default:
+		return ScanVerdict{}, fmt.Errorf("unexpected clamd reply %q", reply)
+	}
+}
+
+// eicarTestFile is the standard antivirus test string; it is harmless and
+// every scanner reports it.
+const eicarTestFile = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// FakeScanner flags objects that contain the EICAR test string, the way
+// clamd does, without needing a daemon or signature database. It is meant
+// for local development and tests.
+type FakeScanner struct {
+	signature string
+	pattern   []byte
+}
+
+func NewFakeScanner() *FakeScanner {
+	return &FakeScanner{
+		signature: "Win.Test.EICAR_HDB-1",
+		pattern:   []byte(eicarTestFile),
+	}
+}
+
+func (s *FakeScanner) Scan(ctx context.Context, body io.Reader) (ScanVerdict, error) {
+	// Keep the tail of the previous chunk so a match across chunk
+	// boundaries is not missed.
+	overlap := len(s.pattern) - 1
+	buf := make([]byte, overlap+clamdChunkSize)
+	kept := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return ScanVerdict{}, err
+		}
+		n, err := io.ReadFull(body, buf[kept:])
+		window := buf[:kept+n]
+		if bytes.Contains(window, s.pattern) {
+			return ScanVerdict{Infected: true, Signature: s.signature}, nil
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return ScanVerdict{}, nil
+		}
+		if err != nil {
+			return ScanVerdict{}, fmt.Errorf("read object: %w", err)
+		}
+		kept = min(overlap, len(window))
+		copy(buf, window[len(window)-kept:])
+	}
+}
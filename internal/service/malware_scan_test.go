@@ -0,0 +1,193 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseClamdReply(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    ScanVerdict
+		wantErr error
+		anyErr  bool
+	}{
+		{name: "clean", line: "stream: OK\x00", want: ScanVerdict{}},
+		{name: "clean with newline", line: "stream: OK\n", want: ScanVerdict{}},
+		{name: "clean without prefix", line: "OK", want: ScanVerdict{}},
+		{
+			name: "infected",
+			line: "stream: Win.Test.EICAR_HDB-1 FOUND\x00",
+			want: ScanVerdict{Infected: true, Signature: "Win.Test.EICAR_HDB-1"},
+		},
+		{
+			name: "signature with spaces",
+			line: "stream: Heuristics.Broken.Executable FOUND",
+			want: ScanVerdict{Infected: true, Signature: "Heuristics.Broken.Executable"},
+		},
+		{name: "size limit", line: "INSTREAM size limit exceeded. ERROR\x00", wantErr: ErrScanTooLarge},
+		{name: "other error", line: "Can't allocate memory ERROR\x00", anyErr: true},
+		{name: "empty", line: "\x00", anyErr: true},
+		{name: "unknown reply", line: "stream: MAYBE", anyErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseClamdReply(tt.line)
+			switch {
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("error = %v, want %v", err, tt.wantErr)
+				}
+			case tt.anyErr:
+				if err == nil || errors.Is(err, ErrScanTooLarge) {
+					t.Fatalf("error = %v, want a scan error", err)
+				}
+			default:
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if got != tt.want {
+					t.Fatalf("verdict = %+v, want %+v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteClamdStream(t *testing.T) {
+	tests := []struct {
+		name       string
+		size       int
+		wantChunks []int
+	}{
+		{name: "empty", size: 0},
+		{name: "small", size: 10, wantChunks: []int{10}},
+		{name: "exactly one chunk", size: clamdChunkSize, wantChunks: []int{clamdChunkSize}},
+		{name: "spills over", size: clamdChunkSize + 1, wantChunks: []int{clamdChunkSize, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := bytes.Repeat([]byte{'x'}, tt.size)
+			var out bytes.Buffer
+			if err := writeClamdStream(&out, bytes.NewReader(body)); err != nil {
+				t.Fatalf("writeClamdStream: %v", err)
+			}
+
+			stream := out.Bytes()
+			command := "zINSTREAM\x00"
+			if !bytes.HasPrefix(stream, []byte(command)) {
+				t.Fatalf("stream does not start with %q", command)
+			}
+			stream = stream[len(command):]
+			var chunks []int
+			var received []byte
+			for {
+				if len(stream) < 4 {
+					t.Fatalf("stream ends without a terminating chunk")
+				}
+				n := int(binary.BigEndian.Uint32(stream[:4]))
+				stream = stream[4:]
+				if n == 0 {
+					break
+				}
+				chunks = append(chunks, n)
+				received = append(received, stream[:n]...)
+				stream = stream[n:]
+			}
+			if len(stream) != 0 {
+				t.Fatalf("%d bytes after the terminating chunk", len(stream))
+			}
+			if len(chunks) != len(tt.wantChunks) {
+				t.Fatalf("chunks %v, want %v", chunks, tt.wantChunks)
+			}
+			for i := range chunks {
+				if chunks[i] != tt.wantChunks[i] {
+					t.Fatalf("chunks %v, want %v", chunks, tt.wantChunks)
+				}
+			}
+			if !bytes.Equal(received, body) {
+				t.Fatal("received body differs from the sent one")
+			}
+		})
+	}
+}
+
+func TestNewClamdScanner(t *testing.T) {
+	tests := []struct {
+		name         string
+		in           NewClamdScannerInput
+		wantNetwork  string
+		wantAddress  string
+		wantMaxBytes int64
+		wantErr      bool
+	}{
+		{
+			name:        "tcp",
+			in:          NewClamdScannerInput{Address: "tcp://clamav:3310", MaxStreamBytes: 100 << 20},
+			wantNetwork: "tcp", wantAddress: "clamav:3310", wantMaxBytes: 100 << 20,
+		},
+		{
+			name:        "unix",
+			in:          NewClamdScannerInput{Address: "unix:///run/clamd.sock"},
+			wantNetwork: "unix", wantAddress: "/run/clamd.sock", wantMaxBytes: clamdStreamCeiling,
+		},
+		{
+			name:        "bare address above the ceiling",
+			in:          NewClamdScannerInput{Address: " 127.0.0.1:3310 ", MaxStreamBytes: 8 << 30},
+			wantNetwork: "tcp", wantAddress: "127.0.0.1:3310", wantMaxBytes: clamdStreamCeiling,
+		},
+		{name: "missing address", in: NewClamdScannerInput{Address: "tcp://"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scanner, err := NewClamdScanner(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if scanner.network != tt.wantNetwork || scanner.address != tt.wantAddress || scanner.MaxScanBytes() != tt.wantMaxBytes {
+				t.Fatalf("scanner = %s %s max %d, want %s %s max %d",
+					scanner.network, scanner.address, scanner.MaxScanBytes(),
+					tt.wantNetwork, tt.wantAddress, tt.wantMaxBytes)
+			}
+		})
+	}
+}
+
+func TestFakeScanner(t *testing.T) {
+	// The pattern straddles the first chunk boundary.
+	straddling := strings.Repeat("a", clamdChunkSize-10) + eicarTestFile
+	tests := []struct {
+		name         string
+		body         string
+		wantInfected bool
+	}{
+		{name: "clean", body: strings.Repeat("a", 3*clamdChunkSize)},
+		{name: "empty"},
+		{name: "eicar", body: eicarTestFile, wantInfected: true},
+		{name: "eicar across chunks", body: straddling, wantInfected: true},
+		{name: "eicar at the end", body: strings.Repeat("a", 2*clamdChunkSize) + eicarTestFile, wantInfected: true},
+		{name: "truncated eicar", body: eicarTestFile[:len(eicarTestFile)-1]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verdict, err := NewFakeScanner().Scan(context.Background(), strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if verdict.Infected != tt.wantInfected {
+				t.Fatalf("infected = %v, want %v", verdict.Infected, tt.wantInfected)
+			}
+		})
+	}
+}
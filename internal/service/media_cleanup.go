@@ -12,7 +12,7 @@ import (
 
 type MediaCleanupService struct {
 	mediaRepo  repository.MediaRepository
-	storage    *StorageService
+	storage    Storage
 	logger     *zap.Logger
 	batchSize  int
 	runTimeout time.Duration
@@ -20,7 +20,7 @@ type MediaCleanupService struct {
 
 type NewMediaCleanupServiceInput struct {
 	MediaRepo  repository.MediaRepository
-	Storage    *StorageService
+	Storage    Storage
 	Logger     *zap.Logger
 	BatchSize  int
 	RunTimeout time.Duration
@@ -83,6 +83,15 @@ func (s *MediaCleanupService) RunOnce(ctx context.Context) (scannedCount, delete
 		for _, id := range chunk {
 			existing[id] = struct{}{}
 		}
+
+		// Deleted media whose outputs others still share keep them.
+		referenced, listErr := s.mediaRepo.ListReferencedIDs(ctx, ids[start:end])
+		if listErr != nil {
+			return scannedCount, deletedCount, failedCount, listErr
+		}
+		for _, id := range referenced {
+			existing[id] = struct{}{}
+		}
 	}
 
 	for _, id := range ids {
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"calixio/internal/repository"
+)
+
+var (
+	ErrInvalidCollection   = errors.New("invalid collection")
+	ErrCollectionNotFound  = errors.New("collection not found")
+	ErrCollectionExists    = errors.New("collection with this name already exists")
+	ErrForbiddenCollection = errors.New("forbidden collection")
+)
+
+const maxCollectionNameRunes = 100
+
+func (s *MediaUploadService) ListCollections(ctx context.Context, ownerUserID string) ([]repository.Collection, error) {
+	if strings.TrimSpace(ownerUserID) == "" {
+		return nil, ErrInvalidUploadInput
+	}
+	return s.collectionRepo.ListByOwner(ctx, ownerUserID)
+}
+
+func (s *MediaUploadService) CreateCollection(ctx context.Context, ownerUserID, name string) (repository.Collection, error) {
+	if strings.TrimSpace(ownerUserID) == "" {
+		return repository.Collection{}, ErrInvalidUploadInput
+	}
+	name, ok := normalizeCollectionName(name)
+	if !ok {
+		return repository.Collection{}, ErrInvalidCollection
+	}
+
+	collectionID, err := newCollectionID()
+	if err != nil {
+		return repository.Collection{}, err
+	}
+	collection := repository.Collection{
+		ID:          collectionID,
+		OwnerUserID: ownerUserID,
+		Name:        name,
+		CreatedAt:   s.clock(),
+	}
+	if err := s.collectionRepo.Create(ctx, collection); err != nil {
+		if errors.Is(err, repository.ErrAlreadyExists) {
+			return repository.Collection{}, ErrCollectionExists
+		}
+		return repository.Collection{}, err
+	}
+	return collection, nil
+}
+
+func (s *MediaUploadService) RenameCollection(ctx context.Context, ownerUserID, collectionID, name string) (repository.Collection, error) {
+	name, ok := normalizeCollectionName(name)
+	if !ok {
+		return repository.Collection{}, ErrInvalidCollection
+	}
+	collection, err := s.getOwnedCollection(ctx, ownerUserID, collectionID)
+	if err != nil {
+		return repository.Collection{}, err
+	}
+	if err := s.collectionRepo.Rename(ctx, collection.ID, name); err != nil {
+		switch {
+		case errors.Is(err, repository.ErrAlreadyExists):
+			return repository.Collection{}, ErrCollectionExists
+		case errors.Is(err, repository.ErrNotFound):
+			return repository.Collection{}, ErrCollectionNotFound
+		}
+		return repository.Collection{}, err
+	}
+	collection.Name = name
+	return collection, nil
+}
+
+// DeleteCollection removes the collection only; its media stay in the
+// library.
+func (s *MediaUploadService) DeleteCollection(ctx context.Context, ownerUserID, collectionID string) error {
+	collection, err := s.getOwnedCollection(ctx, ownerUserID, collectionID)
+	if err != nil {
+		return err
+	}
+	if err := s.collectionRepo.Delete(ctx, collection.ID); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return ErrCollectionNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// ListTags returns the owner's tags with how many media carry each.
+func (s *MediaUploadService) ListTags(ctx context.Context, ownerUserID string) ([]repository.TagUsage, error) {
+	if strings.TrimSpace(ownerUserID) == "" {
+		return nil, ErrInvalidUploadInput
+	}
+	return s.tagRepo.ListByOwner(ctx, ownerUserID)
+}
+
+func (s *MediaUploadService) getOwnedCollection(ctx context.Context, ownerUserID, collectionID string) (repository.Collection, error) {
+	if strings.TrimSpace(ownerUserID) == "" || strings.TrimSpace(collectionID) == "" {
+		return repository.Collection{}, ErrInvalidUploadInput
+	}
+	collection, err := s.collectionRepo.GetByID(ctx, collectionID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return repository.Collection{}, ErrCollectionNotFound
+		}
+		return repository.Collection{}, err
+	}
+	if collection.OwnerUserID != ownerUserID {
+		return repository.Collection{}, ErrForbiddenCollection
+	}
+	return collection, nil
+}
+
+func normalizeCollectionName(name string) (string, bool) {
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > maxCollectionNameRunes {
+		return "", false
+	}
+	return name, true
+}
+
+func newCollectionID() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate collection id: %w", err)
+	}
+	return "coll_" + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,175 @@
+package service
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"calixio/internal/repository"
+
+	"go.uber.org/zap"
+)
+
+// normalizeContentSHA256 validates a client-declared digest and returns it
+// in lower case; "" stays "".
+func normalizeContentSHA256(raw string) (string, bool) {
+	digest := strings.ToLower(strings.TrimSpace(raw))
+	if digest == "" {
+		return "", true
+	}
+	if len(digest) != 64 {
+		return "", false
+	}
+	if _, err := hex.DecodeString(digest); err != nil {
+		return "", false
+	}
+	return digest, true
+}
+
+// mediaOutputPrefix is where the renditions and storyboard of media live:
+// under the media itself, or under the media whose outputs it shares. The
+// poster and subtitles always stay under the media's own prefix.
+func mediaOutputPrefix(media repository.Media) string {
+	if source := media.OutputSource; source != nil {
+		return path.Join("users", source.OwnerUserID, "media", source.MediaID)
+	}
+	return path.Join("users", media.OwnerUserID, "media", media.ID)
+}
+
+// hlsPrefixFor returns the prefix relPath of the HLS output is stored under.
+func hlsPrefixFor(media repository.Media, relPath string) string {
+	if strings.HasPrefix(relPath, subtitleDir+"/") {
+		return path.Join("users", media.OwnerUserID, "media", media.ID, "hls")
+	}
+	return path.Join(mediaOutputPrefix(media), "hls")
+}
+
+// rejectChecksumMismatch handles an original that does not match the digest
+// declared at upload init: it is deleted like any other rejected upload.
+func (s *MediaTranscoderService) rejectChecksumMismatch(ctx context.Context, media repository.Media, contentSHA256 string) error {
+	s.logger.Warn("uploaded original does not match its declared checksum",
+		zap.String("media_id", media.ID),
+		zap.String("declared_sha256", *media.ContentSHA256),
+		zap.String("content_sha256", contentSHA256),
+	)
+	if err := s.storage.DeleteObject(ctx, media.StorageKey); err != nil {
+		return err
+	}
+	return s.mediaRepo.Reject(ctx, media.ID, RejectReasonChecksumMismatch)
+}
+
+// shareExistingOutputs points media at the outputs of a ready media with the
+// same original instead of encoding it again. The renditions are shared and
+// counted as references on the source; the poster and embedded subtitles
+// are copied, since owners may replace or delete them. It reports false
+// when there is nothing to share and the media must be transcoded.
+func (s *MediaTranscoderService) shareExistingOutputs(ctx context.Context, media repository.Media, contentSHA256 string) (bool, error) {
+	// Keys are per media, so encrypted outputs are never shared.
+	if media.Encrypted {
+		return false, nil
+	}
+	source, err := s.mediaRepo.FindOutputSource(ctx, contentSHA256, media.OutputFormat, media.ID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return false, nil
+		}
+		return false, fmt.Errorf("find shared outputs: %w", err)
+	}
+
+	previewURL, err := s.copySharedPreview(ctx, source, media)
+	if err != nil {
+		return false, fmt.Errorf("copy shared preview: %w", err)
+	}
+	subtitles, err := s.copySharedSubtitles(ctx, source, media)
+	if err != nil {
+		return false, fmt.Errorf("copy shared subtitles: %w", err)
+	}
+	if err := s.mediaRepo.LinkOutputs(ctx, media.ID, source.ID, previewURL); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			// The source went away in the meantime.
+			return false, nil
+		}
+		return false, err
+	}
+	if err := s.replaceEmbeddedSubtitles(ctx, media.ID, subtitles); err != nil {
+		return true, err
+	}
+	if err := s.recordStoredBytes(ctx, media); err != nil {
+		s.logger.Warn("record media stored bytes failed", zap.String("media_id", media.ID), zap.Error(err))
+	}
+
+	zero := time.Duration(0)
+	s.setProgress(ctx, media.ID, repository.MediaStageDone, 100, &zero)
+	s.logger.Info("media shares existing outputs",
+		zap.String("media_id", media.ID),
+		zap.String("source_media_id", source.ID),
+	)
+	return true, nil
+}
+
+func (s *MediaTranscoderService) copySharedPreview(ctx context.Context, source, media repository.Media) (*string, error) {
+	if source.PreviewURL == nil {
+		return nil, nil
+	}
+	data, err := s.storage.GetObjectBytes(ctx, path.Join("users", source.OwnerUserID, "media", source.ID, previewName))
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	previewKey := path.Join("users", media.OwnerUserID, "media", media.ID, previewName)
+	if err := s.storage.UploadOutputBytes(ctx, previewKey, "image/jpeg", data); err != nil {
+		return nil, err
+	}
+	previewURL := s.storage.ObjectURL(previewKey)
+	return &previewURL, nil
+}
+
+func (s *MediaTranscoderService) copySharedSubtitles(ctx context.Context, source, media repository.Media) ([]repository.MediaSubtitle, error) {
+	if s.subtitleRepo == nil {
+		return nil, nil
+	}
+	sourceSubtitles, err := s.subtitleRepo.ListByMedia(ctx, source.ID)
+	if err != nil {
+		return nil, err
+	}
+	var durationSec float64
+	if source.DurationSec != nil {
+		durationSec = float64(*source.DurationSec)
+	}
+
+	sourceHLS := path.Join("users", source.OwnerUserID, "media", source.ID, "hls")
+	ownHLS := path.Join("users", media.OwnerUserID, "media", media.ID, "hls")
+	out := make([]repository.MediaSubtitle, 0, len(sourceSubtitles))
+	for _, subtitle := range sourceSubtitles {
+		if subtitle.Source != repository.MediaSubtitleEmbedded {
+			continue
+		}
+		vtt, err := s.storage.GetObjectBytes(ctx, path.Join(sourceHLS, subtitleVTTName(subtitle.ID)))
+		if err != nil {
+			return nil, err
+		}
+		subtitleID, err := newSubtitleID()
+		if err != nil {
+			return nil, err
+		}
+		vttName := subtitleVTTName(subtitleID)
+		if err := s.storage.UploadOutputBytes(ctx, path.Join(ownHLS, vttName), "text/vtt", vtt); err != nil {
+			return nil, err
+		}
+		playlist := buildSubtitlePlaylist(path.Base(vttName), durationSec)
+		if err := s.storage.UploadOutputBytes(ctx, path.Join(ownHLS, subtitlePlaylistName(subtitleID)), "application/vnd.apple.mpegurl", []byte(playlist)); err != nil {
+			return nil, err
+		}
+
+		subtitle.ID = subtitleID
+		subtitle.MediaID = media.ID
+		out = append(out, subtitle)
+	}
+	return out, nil
+}
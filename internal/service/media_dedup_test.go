@@ -0,0 +1,224 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"path"
+	"testing"
+	"time"
+
+	"calixio/internal/repository"
+
+	"go.uber.org/zap"
+)
+
+type dedupTestMediaRepo struct {
+	repository.MediaRepository
+	source     repository.Media
+	findErr    error
+	linkErr    error
+	findCalls  int
+	linked     []string
+	linkedURL  *string
+	progress   []repository.MediaStage
+	storedSize map[string]int64
+}
+
+func (r *dedupTestMediaRepo) FindOutputSource(_ context.Context, _ string, _ repository.MediaOutputFormat, excludeID string) (repository.Media, error) {
+	r.findCalls++
+	if r.findErr != nil {
+		return repository.Media{}, r.findErr
+	}
+	if r.source.ID == excludeID {
+		return repository.Media{}, repository.ErrNotFound
+	}
+	return r.source, nil
+}
+
+func (r *dedupTestMediaRepo) LinkOutputs(_ context.Context, id, sourceID string, previewURL *string) error {
+	if r.linkErr != nil {
+		return r.linkErr
+	}
+	r.linked = append(r.linked, id+"->"+sourceID)
+	r.linkedURL = previewURL
+	return nil
+}
+
+func (r *dedupTestMediaRepo) UpdateStoredBytes(_ context.Context, id string, originalBytes, outputBytes int64) (bool, error) {
+	r.storedSize[id] = originalBytes + outputBytes
+	return true, nil
+}
+
+func (r *dedupTestMediaRepo) UpdateProgress(_ context.Context, _ string, progress repository.MediaProgress) error {
+	r.progress = append(r.progress, progress.Stage)
+	return nil
+}
+
+type dedupTestSubtitleRepo struct {
+	repository.MediaSubtitleRepository
+	bySource map[string][]repository.MediaSubtitle
+	created  []repository.MediaSubtitle
+}
+
+func (r *dedupTestSubtitleRepo) ListByMedia(_ context.Context, mediaID string) ([]repository.MediaSubtitle, error) {
+	return r.bySource[mediaID], nil
+}
+
+func (r *dedupTestSubtitleRepo) DeleteBySource(context.Context, string, repository.MediaSubtitleSource) error {
+	return nil
+}
+
+func (r *dedupTestSubtitleRepo) Create(_ context.Context, subtitle repository.MediaSubtitle) error {
+	r.created = append(r.created, subtitle)
+	return nil
+}
+
+func TestShareExistingOutputs(t *testing.T) {
+	const digest = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	previewURL := "https://cdn.example/preview.jpg"
+	duration := 60
+	source := repository.Media{
+		ID:           "media-1",
+		OwnerUserID:  "user-1",
+		Status:       repository.MediaReady,
+		OutputFormat: repository.MediaFormatHLSTS,
+		PreviewURL:   &previewURL,
+		DurationSec:  &duration,
+	}
+	media := repository.Media{ID: "media-2", OwnerUserID: "user-2", OutputFormat: repository.MediaFormatHLSTS}
+	sourceSubtitles := []repository.MediaSubtitle{
+		{ID: "sub-embedded", MediaID: source.ID, Language: "en", Label: "English", Source: repository.MediaSubtitleEmbedded},
+		{ID: "sub-upload", MediaID: source.ID, Language: "de", Label: "Deutsch", Source: repository.MediaSubtitleUpload},
+	}
+
+	tests := []struct {
+		name          string
+		media         repository.Media
+		findErr       error
+		linkErr       error
+		noPreview     bool
+		wantShared    bool
+		wantErr       bool
+		wantNoLookup  bool
+		wantPreview   bool
+		wantSubtitles int
+	}{
+		{name: "shares outputs", media: media, wantShared: true, wantPreview: true, wantSubtitles: 1},
+		{name: "source preview gone", media: media, noPreview: true, wantShared: true, wantSubtitles: 1},
+		{name: "encrypted media", media: repository.Media{ID: "media-2", OwnerUserID: "user-2", Encrypted: true}, wantNoLookup: true},
+		{name: "no source", media: media, findErr: repository.ErrNotFound},
+		{name: "lookup failure", media: media, findErr: errors.New("connection reset"), wantErr: true},
+		{name: "source deleted before linking", media: media, linkErr: repository.ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			storage := newTestLocalStorage(t, "secret", time.Now())
+			sourcePrefix := path.Join("users", source.OwnerUserID, "media", source.ID)
+			if !tt.noPreview {
+				if err := storage.UploadBytes(ctx, path.Join(sourcePrefix, previewName), "image/jpeg", []byte("jpeg")); err != nil {
+					t.Fatalf("UploadBytes: %v", err)
+				}
+			}
+			for _, subtitle := range sourceSubtitles {
+				key := path.Join(sourcePrefix, "hls", subtitleVTTName(subtitle.ID))
+				if err := storage.UploadBytes(ctx, key, "text/vtt", []byte("WEBVTT\n")); err != nil {
+					t.Fatalf("UploadBytes: %v", err)
+				}
+			}
+
+			mediaRepo := &dedupTestMediaRepo{source: source, findErr: tt.findErr, linkErr: tt.linkErr, storedSize: map[string]int64{}}
+			subtitleRepo := &dedupTestSubtitleRepo{bySource: map[string][]repository.MediaSubtitle{source.ID: sourceSubtitles}}
+			svc := &MediaTranscoderService{
+				mediaRepo:    mediaRepo,
+				subtitleRepo: subtitleRepo,
+				storage:      storage,
+				logger:       zap.NewNop(),
+			}
+
+			shared, err := svc.shareExistingOutputs(ctx, tt.media, digest)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if shared != tt.wantShared {
+				t.Fatalf("shared = %v, want %v", shared, tt.wantShared)
+			}
+			if tt.wantNoLookup && mediaRepo.findCalls != 0 {
+				t.Fatalf("looked up a source %d times for an encrypted media", mediaRepo.findCalls)
+			}
+			if !shared {
+				if len(mediaRepo.linked) != 0 || len(subtitleRepo.created) != 0 {
+					t.Fatalf("linked %v and created %v without sharing", mediaRepo.linked, subtitleRepo.created)
+				}
+				return
+			}
+
+			if len(mediaRepo.linked) != 1 || mediaRepo.linked[0] != "media-2->media-1" {
+				t.Fatalf("linked %v, want [media-2->media-1]", mediaRepo.linked)
+			}
+			ownPrefix := path.Join("users", tt.media.OwnerUserID, "media", tt.media.ID)
+			ownPreview := path.Join(ownPrefix, previewName)
+			if tt.wantPreview {
+				if mediaRepo.linkedURL == nil || *mediaRepo.linkedURL != storage.ObjectURL(ownPreview) {
+					t.Fatalf("preview URL = %v, want the copy at %s", mediaRepo.linkedURL, ownPreview)
+				}
+				if _, err := storage.HeadObject(ctx, ownPreview); err != nil {
+					t.Fatalf("preview was not copied: %v", err)
+				}
+			} else if mediaRepo.linkedURL != nil {
+				t.Fatalf("preview URL = %q, want none", *mediaRepo.linkedURL)
+			}
+
+			if len(subtitleRepo.created) != tt.wantSubtitles {
+				t.Fatalf("created %d subtitles, want %d", len(subtitleRepo.created), tt.wantSubtitles)
+			}
+			for _, subtitle := range subtitleRepo.created {
+				if subtitle.MediaID != tt.media.ID || subtitle.ID == "sub-embedded" || subtitle.Source != repository.MediaSubtitleEmbedded {
+					t.Fatalf("subtitle %+v is not an own copy of the embedded track", subtitle)
+				}
+				for _, name := range []string{subtitleVTTName(subtitle.ID), subtitlePlaylistName(subtitle.ID)} {
+					if _, err := storage.HeadObject(ctx, path.Join(ownPrefix, "hls", name)); err != nil {
+						t.Fatalf("subtitle file %s was not written: %v", name, err)
+					}
+				}
+			}
+			if size, ok := mediaRepo.storedSize[tt.media.ID]; !ok || size == 0 {
+				t.Fatalf("stored bytes of the copies were not recorded: %v", mediaRepo.storedSize)
+			}
+			if len(mediaRepo.progress) != 1 || mediaRepo.progress[0] != repository.MediaStageDone {
+				t.Fatalf("progress stages = %v, want [done]", mediaRepo.progress)
+			}
+		})
+	}
+}
+
+func TestHLSPrefixFor(t *testing.T) {
+	own := repository.Media{ID: "media-2", OwnerUserID: "user-2"}
+	linked := own
+	linked.OutputSource = &repository.MediaOutputSource{MediaID: "media-1", OwnerUserID: "user-1"}
+
+	tests := []struct {
+		name    string
+		media   repository.Media
+		relPath string
+		want    string
+	}{
+		{name: "own rendition", media: own, relPath: "720p/index.m3u8", want: "users/user-2/media/media-2/hls"},
+		{name: "shared rendition", media: linked, relPath: "720p/segment_000.ts", want: "users/user-1/media/media-1/hls"},
+		{name: "shared master playlist", media: linked, relPath: "index.m3u8", want: "users/user-1/media/media-1/hls"},
+		{name: "subtitles stay own", media: linked, relPath: subtitleVTTName("sub-1"), want: "users/user-2/media/media-2/hls"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hlsPrefixFor(tt.media, tt.relPath); got != tt.want {
+				t.Fatalf("hlsPrefixFor(%q) = %q, want %q", tt.relPath, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,414 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	"calixio/internal/repository"
+
+	"go.uber.org/zap"
+)
+
+var (
+	ErrInvalidImportURL       = errors.New("invalid import url")
+	ErrImportHostNotAllowed   = errors.New("import host is not allowed")
+	ErrImportAddressForbidden = errors.New("import address is not public")
+	ErrImportSourceFailed     = errors.New("import source request failed")
+	ErrImportLengthRequired   = errors.New("import source did not report its length")
+	ErrImportTooLarge         = errors.New("import source is too large")
+	ErrImportMimeNotAllowed   = errors.New("import source type is not allowed")
+)
+
+// importReapGrace gives an import that ran into MEDIA_IMPORT_TIMEOUT time to
+// record its own failure before FailStaleImports does it.
+const importReapGrace = 10 * time.Minute
+
+// importBlockedPrefixes are special-purpose ranges that net/netip does not
+// classify as private but that must not be reachable from an import either.
+var importBlockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+	netip.MustParsePrefix("2002::/16"),
+}
+
+// importVideoTypes backs up mime.TypeByExtension, whose built-in table has
+// no video types when the host lacks a mime.types file.
+var importVideoTypes = map[string]string{
+	".mp4":  "video/mp4",
+	".m4v":  "video/mp4",
+	".mov":  "video/quicktime",
+	".webm": "video/webm",
+	".mkv":  "video/x-matroska",
+	".avi":  "video/x-msvideo",
+}
+
+type ImportMediaInput struct {
+	OwnerUserID string
+	URL         string
+	Format      string
+	Encrypted   bool
+}
+
+type ImportMediaOutput struct {
+	MediaID   string
+	Status    repository.MediaStatus
+	SizeBytes int64
+}
+
+// ImportFromURL requests the source synchronously, so an unreachable URL or
+// an unacceptable file is rejected in the response, and then streams the
+// body into storage in the background. Once stored, the media goes through
+// CompleteUpload like a browser upload; progress is reported on the media
+// under the "import" stage.
+func (s *MediaUploadService) ImportFromURL(ctx context.Context, in ImportMediaInput) (ImportMediaOutput, error) {
+	if strings.TrimSpace(in.OwnerUserID) == "" {
+		return ImportMediaOutput{}, ErrInvalidUploadInput
+	}
+	sourceURL, err := url.Parse(strings.TrimSpace(in.URL))
+	if err != nil || (sourceURL.Scheme != "http" && sourceURL.Scheme != "https") || sourceURL.Hostname() == "" || sourceURL.User != nil {
+		return ImportMediaOutput{}, ErrInvalidImportURL
+	}
+	if !s.importHostAllowed(sourceURL.Hostname()) {
+		return ImportMediaOutput{}, ErrImportHostNotAllowed
+	}
+
+	// The transfer outlives the API request that started it.
+	importCtx, cancel := context.WithTimeout(context.Background(), s.importTimeout)
+	resp, err := s.openImportSource(importCtx, sourceURL.String())
+	if err != nil {
+		cancel()
+		return ImportMediaOutput{}, err
+	}
+	started := false
+	defer func() {
+		if !started {
+			resp.Body.Close()
+			cancel()
+		}
+	}()
+
+	if resp.ContentLength <= 0 {
+		return ImportMediaOutput{}, ErrImportLengthRequired
+	}
+	if resp.ContentLength > s.maxSizeBytes {
+		return ImportMediaOutput{}, ErrImportTooLarge
+	}
+	fileName := importFileName(resp)
+	contentType := importContentType(resp, fileName)
+	if !s.isAllowedMime(contentType) {
+		return ImportMediaOutput{}, ErrImportMimeNotAllowed
+	}
+
+	media, err := s.newUploadMedia(InitUploadInput{
+		OwnerUserID: in.OwnerUserID,
+		FileName:    fileName,
+		ContentType: contentType,
+		SizeBytes:   resp.ContentLength,
+		Format:      in.Format,
+		Encrypted:   in.Encrypted,
+	})
+	if err != nil {
+		return ImportMediaOutput{}, err
+	}
+	if err := s.checkQuota(ctx, media.OwnerUserID, "", media.FileSizeBytes); err != nil {
+		return ImportMediaOutput{}, err
+	}
+
+	uploadID, err := s.storage.CreateMultipartUpload(ctx, media.StorageKey, media.MimeType)
+	if err != nil {
+		return ImportMediaOutput{}, err
+	}
+	if _, err := s.mediaRepo.Create(ctx, media); err != nil {
+		_ = s.storage.AbortMultipartUpload(context.Background(), media.StorageKey, uploadID)
+		return ImportMediaOutput{}, err
+	}
+	upload := repository.MediaUpload{
+		MediaID:       media.ID,
+		UploadID:      uploadID,
+		Protocol:      repository.UploadProtocolImport,
+		PartSizeBytes: multipartPartSize(media.FileSizeBytes),
+		CreatedAt:     media.CreatedAt,
+	}
+	if err := s.uploadRepo.Create(ctx, upload); err != nil {
+		_ = s.storage.AbortMultipartUpload(context.Background(), media.StorageKey, uploadID)
+		_ = s.mediaRepo.SoftDelete(context.Background(), media.ID, s.clock())
+		return ImportMediaOutput{}, err
+	}
+	s.reportImportProgress(importCtx, media.ID, 0, nil)
+
+	started = true
+	go func() {
+		defer cancel()
+		defer resp.Body.Close()
+		s.runImport(importCtx, media, upload, resp.Body)
+	}()
+
+	s.logger.Info("media import started",
+		zap.String("media_id", media.ID),
+		zap.String("host", sourceURL.Hostname()),
+		zap.Int64("size_bytes", media.FileSizeBytes),
+	)
+	return ImportMediaOutput{MediaID: media.ID, Status: media.Status, SizeBytes: media.FileSizeBytes}, nil
+}
+
+func (s *MediaUploadService) runImport(ctx context.Context, media repository.Media, upload repository.MediaUpload, body io.Reader) {
+	err := s.copyImportBody(ctx, media, upload, body)
+	if err == nil {
+		if err = s.uploadRepo.Delete(ctx, media.ID); err == nil {
+			_, err = s.CompleteUpload(ctx, CompleteUploadInput{OwnerUserID: media.OwnerUserID, MediaID: media.ID})
+		}
+	}
+	if err != nil {
+		if errors.Is(err, ErrMediaRejected) {
+			s.logger.Warn("media import rejected", zap.String("media_id", media.ID), zap.Error(err))
+			return
+		}
+		s.logger.Error("media import failed", zap.String("media_id", media.ID), zap.Error(err))
+		cleanupCtx := context.Background()
+		_ = s.storage.AbortMultipartUpload(cleanupCtx, media.StorageKey, upload.UploadID)
+		_ = s.uploadRepo.Delete(cleanupCtx, media.ID)
+		_ = s.mediaRepo.UpdateStatus(cleanupCtx, media.ID, repository.MediaFailed)
+		return
+	}
+	s.logger.Info("media import completed", zap.String("media_id", media.ID))
+}
+
+// copyImportBody uploads the body as multipart parts and refuses sources
+// that send more or fewer bytes than they announced.
+func (s *MediaUploadService) copyImportBody(ctx context.Context, media repository.Media, upload repository.MediaUpload, body io.Reader) error {
+	reader := io.LimitReader(body, media.FileSizeBytes+1)
+	buf := make([]byte, upload.PartSizeBytes)
+	parts := make([]CompletedPart, 0, multipartPartCount(media.FileSizeBytes, upload.PartSizeBytes))
+
+	var copied int64
+	startedAt := time.Now()
+	lastReportAt := startedAt
+	for partNumber := int32(1); ; partNumber++ {
+		n, readErr := io.ReadFull(reader, buf)
+		if n > 0 {
+			copied += int64(n)
+			if copied > media.FileSizeBytes {
+				return fmt.Errorf("%w: source sent more than %d bytes", ErrImportSourceFailed, media.FileSizeBytes)
+			}
+			etag, err := s.storage.UploadPart(ctx, media.StorageKey, upload.UploadID, partNumber, buf[:n])
+			if err != nil {
+				return err
+			}
+			parts = append(parts, CompletedPart{PartNumber: partNumber, ETag: etag})
+
+			if time.Since(lastReportAt) >= progressReportInterval && copied < media.FileSizeBytes {
+				lastReportAt = time.Now()
+				elapsed := time.Since(startedAt)
+				eta := time.Duration(float64(elapsed) * float64(media.FileSizeBytes-copied) / float64(copied))
+				s.reportImportProgress(ctx, media.ID, float64(copied)*100/float64(media.FileSizeBytes), &eta)
+			}
+		}
+		if errors.Is(readErr, io.EOF) || errors.Is(readErr, io.ErrUnexpectedEOF) {
+			break
+		}
+		if readErr != nil {
+			return fmt.Errorf("%w: %v", ErrImportSourceFailed, readErr)
+		}
+	}
+	if copied != media.FileSizeBytes {
+		return fmt.Errorf("%w: source sent %d of %d bytes", ErrImportSourceFailed, copied, media.FileSizeBytes)
+	}
+
+	if err := s.storage.CompleteMultipartUpload(ctx, media.StorageKey, upload.UploadID, parts); err != nil {
+		return err
+	}
+	zero := time.Duration(0)
+	s.reportImportProgress(ctx, media.ID, 100, &zero)
+	return nil
+}
+
+// FailStaleImports fails imports that have outlived MEDIA_IMPORT_TIMEOUT,
+// which only happens when the API process running them went away, and
+// aborts their multipart uploads so the parts stop taking up storage.
+func (s *MediaUploadService) FailStaleImports(ctx context.Context) (failedCount int, err error) {
+	uploads, err := s.uploadRepo.ListCreatedBefore(ctx, repository.UploadProtocolImport, s.clock().Add(-s.importTimeout-importReapGrace))
+	if err != nil {
+		return 0, err
+	}
+
+	for _, upload := range uploads {
+		media, err := s.mediaRepo.GetByID(ctx, upload.MediaID)
+		if err != nil {
+			if !errors.Is(err, repository.ErrNotFound) {
+				return failedCount, err
+			}
+			// The media was deleted; only its upload row is left.
+			if err := s.uploadRepo.Delete(ctx, upload.MediaID); err != nil {
+				return failedCount, err
+			}
+			continue
+		}
+		if err := s.storage.AbortMultipartUpload(ctx, media.StorageKey, upload.UploadID); err != nil {
+			s.logger.Warn("abort stale import failed", zap.String("media_id", media.ID), zap.Error(err))
+			continue
+		}
+		if err := s.uploadRepo.Delete(ctx, media.ID); err != nil {
+			return failedCount, err
+		}
+		if media.Status == repository.MediaUploading {
+			if err := s.mediaRepo.UpdateStatus(ctx, media.ID, repository.MediaFailed); err != nil {
+				return failedCount, err
+			}
+		}
+		failedCount++
+		s.logger.Warn("stale media import failed",
+			zap.String("media_id", media.ID),
+			zap.Time("started_at", upload.CreatedAt),
+		)
+	}
+	return failedCount, nil
+}
+
+func (s *MediaUploadService) reportImportProgress(ctx context.Context, mediaID string, percent float64, eta *time.Duration) {
+	progress := repository.MediaProgress{
+		Stage:     repository.MediaStageImport,
+		Percent:   percent,
+		UpdatedAt: s.clock(),
+	}
+	if eta != nil {
+		sec := int(eta.Round(time.Second) / time.Second)
+		progress.ETASec = &sec
+	}
+	if err := s.mediaRepo.UpdateProgress(ctx, mediaID, progress); err != nil {
+		s.logger.Warn("update import progress failed", zap.String("media_id", mediaID), zap.Error(err))
+	}
+}
+
+func (s *MediaUploadService) openImportSource(ctx context.Context, sourceURL string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sourceURL, nil)
+	if err != nil {
+		return nil, ErrInvalidImportURL
+	}
+	resp, err := s.importClient.Do(req)
+	if err != nil {
+		if errors.Is(err, ErrImportHostNotAllowed) || errors.Is(err, ErrImportAddressForbidden) || errors.Is(err, ErrInvalidImportURL) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %v", ErrImportSourceFailed, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: status %d", ErrImportSourceFailed, resp.StatusCode)
+	}
+	return resp, nil
+}
+
+// importHostAllowed matches host against MEDIA_IMPORT_ALLOWED_HOSTS; an entry
+// also covers its subdomains. An empty list allows any public host.
+func (s *MediaUploadService) importHostAllowed(host string) bool {
+	if len(s.importHosts) == 0 {
+		return true
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, allowed := range s.importHosts {
+		if host == allowed || strings.HasSuffix(host, "."+allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// newImportHTTPClient checks every redirect against the allowlist and every
+// connection against the resolved address, so a hostname that resolves to
+// a private address is refused even when it passed the allowlist.
+func (s *MediaUploadService) newImportHTTPClient(maxRedirects int) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil || !isPublicImportAddr(addrPort.Addr()) {
+				return fmt.Errorf("%w: %s", ErrImportAddressForbidden, address)
+			}
+			return nil
+		},
+	}
+	transport := &http.Transport{
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		TLSHandshakeTimeout:   15 * time.Second,
+		ResponseHeaderTimeout: 20 * time.Second,
+		IdleConnTimeout:       90 * time.Second,
+	}
+	return &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > maxRedirects {
+				return fmt.Errorf("%w: too many redirects", ErrImportSourceFailed)
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return ErrInvalidImportURL
+			}
+			if !s.importHostAllowed(req.URL.Hostname()) {
+				return ErrImportHostNotAllowed
+			}
+			return nil
+		},
+	}
+}
+
+func isPublicImportAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() || addr.IsLoopback() || addr.IsLinkLocalUnicast() {
+		return false
+	}
+	for _, prefix := range importBlockedPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// importFileName prefers the Content-Disposition name and falls back to the
+// last segment of the final URL.
+func importFileName(resp *http.Response) string {
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		if name := strings.TrimSpace(params["filename"]); name != "" {
+			return path.Base(strings.ReplaceAll(name, "\\", "/"))
+		}
+	}
+	if name := path.Base(resp.Request.URL.Path); name != "." && name != "/" {
+		return name
+	}
+	return "import"
+}
+
+// importContentType trusts the server unless it only claims a generic binary
+// type, in which case the file extension decides.
+func importContentType(resp *http.Response, fileName string) string {
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err == nil && mediaType != "application/octet-stream" && mediaType != "binary/octet-stream" {
+		return mediaType
+	}
+	ext := strings.ToLower(path.Ext(fileName))
+	if videoType, ok := importVideoTypes[ext]; ok {
+		return videoType
+	}
+	if byExt := mime.TypeByExtension(ext); byExt != "" {
+		mediaType, _, _ = mime.ParseMediaType(byExt)
+		return mediaType
+	}
+	return "application/octet-stream"
+}
@@ -0,0 +1,181 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"testing"
+	"time"
+
+	"calixio/internal/repository"
+)
+
+func TestIsPublicImportAddr(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{addr: "8.8.8.8", want: true},
+		{addr: "1.1.1.1", want: true},
+		{addr: "2606:4700:4700::1111", want: true},
+		{addr: "::ffff:8.8.8.8", want: true},
+		{addr: "127.0.0.1"},
+		{addr: "::1"},
+		{addr: "10.1.2.3"},
+		{addr: "172.16.0.1"},
+		{addr: "192.168.1.1"},
+		{addr: "169.254.169.254"},
+		{addr: "fe80::1"},
+		{addr: "fc00::1"},
+		{addr: "0.0.0.0"},
+		{addr: "0.1.2.3"},
+		{addr: "::"},
+		{addr: "100.64.0.1"},
+		{addr: "192.0.0.8"},
+		{addr: "198.18.0.1"},
+		{addr: "224.0.0.1"},
+		{addr: "ff02::1"},
+		{addr: "255.255.255.255"},
+		{addr: "::ffff:127.0.0.1"},
+		{addr: "::ffff:169.254.169.254"},
+		{addr: "64:ff9b::a9fe:a9fe"},
+		{addr: "2002:7f00:1::1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			if got := isPublicImportAddr(netip.MustParseAddr(tt.addr)); got != tt.want {
+				t.Fatalf("isPublicImportAddr(%s) = %v, want %v", tt.addr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImportHostAllowed(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		host    string
+		want    bool
+	}{
+		{name: "empty list allows any host", host: "example.com", want: true},
+		{name: "exact match", allowed: []string{"example.com"}, host: "example.com", want: true},
+		{name: "subdomain", allowed: []string{"example.com"}, host: "cdn.example.com", want: true},
+		{name: "case and trailing dot", allowed: []string{"Example.COM."}, host: "CDN.Example.com.", want: true},
+		{name: "second entry", allowed: []string{"example.com", " videos.net "}, host: "videos.net", want: true},
+		{name: "suffix without dot", allowed: []string{"example.com"}, host: "badexample.com"},
+		{name: "parent of entry", allowed: []string{"cdn.example.com"}, host: "example.com"},
+		{name: "entry as subdomain of host", allowed: []string{"example.com"}, host: "example.com.evil.net"},
+		{name: "other host", allowed: []string{"example.com"}, host: "evil.net"},
+		{name: "blank entries are ignored", allowed: []string{" ", "."}, host: "example.com", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := NewMediaUploadService(NewMediaUploadServiceInput{ImportAllowedHosts: tt.allowed})
+			if got := svc.importHostAllowed(tt.host); got != tt.want {
+				t.Fatalf("importHostAllowed(%q) with %q = %v, want %v", tt.host, tt.allowed, got, tt.want)
+			}
+		})
+	}
+}
+
+type importTestMediaRepo struct {
+	repository.MediaRepository
+	media    map[string]repository.Media
+	statuses map[string]repository.MediaStatus
+}
+
+func (r *importTestMediaRepo) GetByID(_ context.Context, id string) (repository.Media, error) {
+	media, ok := r.media[id]
+	if !ok {
+		return repository.Media{}, repository.ErrNotFound
+	}
+	return media, nil
+}
+
+func (r *importTestMediaRepo) UpdateStatus(_ context.Context, id string, status repository.MediaStatus) error {
+	r.statuses[id] = status
+	return nil
+}
+
+type importTestUploadRepo struct {
+	repository.MediaUploadRepository
+	uploads []repository.MediaUpload
+	deleted []string
+}
+
+func (r *importTestUploadRepo) ListCreatedBefore(_ context.Context, protocol repository.UploadProtocol, before time.Time) ([]repository.MediaUpload, error) {
+	var out []repository.MediaUpload
+	for _, upload := range r.uploads {
+		if upload.Protocol == protocol && upload.CreatedAt.Before(before) {
+			out = append(out, upload)
+		}
+	}
+	return out, nil
+}
+
+func (r *importTestUploadRepo) Delete(_ context.Context, mediaID string) error {
+	r.deleted = append(r.deleted, mediaID)
+	return nil
+}
+
+type importTestStorage struct {
+	Storage
+	aborted []string
+	failFor string
+}
+
+func (s *importTestStorage) AbortMultipartUpload(_ context.Context, key, _ string) error {
+	if key == s.failFor {
+		return errors.New("storage unavailable")
+	}
+	s.aborted = append(s.aborted, key)
+	return nil
+}
+
+func TestFailStaleImports(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	stale := now.Add(-7 * time.Hour)
+	mediaRepo := &importTestMediaRepo{
+		media: map[string]repository.Media{
+			"stale":       {ID: "stale", StorageKey: "users/u/media/stale/original", Status: repository.MediaUploading},
+			"fresh":       {ID: "fresh", StorageKey: "users/u/media/fresh/original", Status: repository.MediaUploading},
+			"tus":         {ID: "tus", StorageKey: "users/u/media/tus/original", Status: repository.MediaUploading},
+			"unreachable": {ID: "unreachable", StorageKey: "users/u/media/unreachable/original", Status: repository.MediaUploading},
+		},
+		statuses: map[string]repository.MediaStatus{},
+	}
+	uploadRepo := &importTestUploadRepo{
+		uploads: []repository.MediaUpload{
+			{MediaID: "stale", UploadID: "1", Protocol: repository.UploadProtocolImport, CreatedAt: stale},
+			{MediaID: "fresh", UploadID: "2", Protocol: repository.UploadProtocolImport, CreatedAt: now.Add(-time.Hour)},
+			{MediaID: "tus", UploadID: "3", Protocol: repository.UploadProtocolTus, CreatedAt: stale},
+			{MediaID: "deleted", UploadID: "4", Protocol: repository.UploadProtocolImport, CreatedAt: stale},
+			{MediaID: "unreachable", UploadID: "5", Protocol: repository.UploadProtocolImport, CreatedAt: stale},
+		},
+	}
+	storage := &importTestStorage{failFor: "users/u/media/unreachable/original"}
+	svc := NewMediaUploadService(NewMediaUploadServiceInput{
+		MediaRepo:     mediaRepo,
+		UploadRepo:    uploadRepo,
+		Storage:       storage,
+		ImportTimeout: 6 * time.Hour,
+	})
+	svc.clock = func() time.Time { return now }
+
+	failed, err := svc.FailStaleImports(context.Background())
+	if err != nil {
+		t.Fatalf("FailStaleImports: %v", err)
+	}
+	if failed != 1 {
+		t.Fatalf("failed %d imports, want 1", failed)
+	}
+	if len(storage.aborted) != 1 || storage.aborted[0] != "users/u/media/stale/original" {
+		t.Fatalf("aborted %v, want only the stale import", storage.aborted)
+	}
+	if len(uploadRepo.deleted) != 2 || uploadRepo.deleted[0] != "stale" || uploadRepo.deleted[1] != "deleted" {
+		t.Fatalf("deleted upload rows %v, want [stale deleted]", uploadRepo.deleted)
+	}
+	if len(mediaRepo.statuses) != 1 || mediaRepo.statuses["stale"] != repository.MediaFailed {
+		t.Fatalf("status updates %v, want only stale failed", mediaRepo.statuses)
+	}
+}
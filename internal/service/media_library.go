@@ -0,0 +1,318 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"calixio/internal/repository"
+)
+
+var (
+	ErrInvalidMediaDetails   = errors.New("invalid media details")
+	ErrInvalidMediaListQuery = errors.New("invalid media list query")
+	ErrInvalidMediaCursor    = errors.New("invalid media list cursor")
+)
+
+const (
+	maxMediaTitleRunes       = 200
+	maxMediaDescriptionRunes = 5000
+	maxMediaTags             = 50
+	maxMediaTagRunes         = 64
+	defaultMediaPageSize     = 50
+	maxMediaPageSize         = 200
+)
+
+type ListMediaInput struct {
+	OwnerUserID  string
+	Status       string
+	Tag          string
+	CollectionID string
+	Query        string
+	// Sort is one of the repository.MediaSort values; empty lists the
+	// newest first.
+	Sort string
+	// Cursor is the NextCursor of the previous page.
+	Cursor string
+	Limit  int
+}
+
+type MediaPage struct {
+	Items []repository.Media
+	// NextCursor is empty on the last page.
+	NextCursor string
+}
+
+// mediaCursor remembers where a page ended: the ID of its last media and
+// the value the listing is sorted by.
+type mediaCursor struct {
+	Sort        repository.MediaSort `json:"s"`
+	ID          string               `json:"id"`
+	CreatedAt   *time.Time           `json:"c,omitempty"`
+	Title       *string              `json:"t,omitempty"`
+	DurationSec *int                 `json:"d,omitempty"`
+	SizeBytes   *int64               `json:"z,omitempty"`
+}
+
+// ListMedia returns one page of the owner's library. Pages are keyset
+// paginated, so media uploaded or deleted between requests never shift
+// them.
+func (s *MediaUploadService) ListMedia(ctx context.Context, in ListMediaInput) (MediaPage, error) {
+	if strings.TrimSpace(in.OwnerUserID) == "" {
+		return MediaPage{}, ErrInvalidUploadInput
+	}
+	sort := repository.MediaSort(strings.TrimSpace(in.Sort))
+	if sort == "" {
+		sort = repository.MediaSortCreatedDesc
+	}
+	if !sort.Valid() {
+		return MediaPage{}, ErrInvalidMediaListQuery
+	}
+	status := repository.MediaStatus(strings.TrimSpace(in.Status))
+	if status != "" && !isMediaStatus(status) {
+		return MediaPage{}, ErrInvalidMediaListQuery
+	}
+	limit := in.Limit
+	if limit == 0 {
+		limit = defaultMediaPageSize
+	}
+	if limit < 0 || limit > maxMediaPageSize {
+		return MediaPage{}, ErrInvalidMediaListQuery
+	}
+
+	filter := repository.MediaListFilter{
+		OwnerUserID:  in.OwnerUserID,
+		Status:       status,
+		Tag:          normalizeTag(in.Tag),
+		CollectionID: strings.TrimSpace(in.CollectionID),
+		Query:        strings.TrimSpace(in.Query),
+		Sort:         sort,
+		// One extra row tells whether there is a next page.
+		Limit: limit + 1,
+	}
+	if in.Cursor != "" {
+		after, err := decodeMediaCursor(in.Cursor, sort)
+		if err != nil {
+			return MediaPage{}, err
+		}
+		filter.After = &after
+	}
+
+	items, err := s.mediaRepo.List(ctx, filter)
+	if err != nil {
+		return MediaPage{}, err
+	}
+	page := MediaPage{Items: items}
+	if len(items) > limit {
+		page.Items = items[:limit]
+		page.NextCursor = encodeMediaCursor(sort, page.Items[limit-1])
+	}
+
+	if err := s.loadMediaLabels(ctx, page.Items); err != nil {
+		return MediaPage{}, err
+	}
+	for i := range page.Items {
+		s.signPreviewURL(ctx, &page.Items[i])
+	}
+	return page, nil
+}
+
+type UpdateMediaInput struct {
+	OwnerUserID string
+	MediaID     string
+	// Nil fields are left unchanged; an empty Tags or CollectionIDs clears
+	// them.
+	Title         *string
+	Description   *string
+	Tags          *[]string
+	CollectionIDs *[]string
+}
+
+// UpdateMedia edits the details the owner controls and returns the media as
+// GetMedia would.
+func (s *MediaUploadService) UpdateMedia(ctx context.Context, in UpdateMediaInput) (repository.Media, error) {
+	if strings.TrimSpace(in.OwnerUserID) == "" || strings.TrimSpace(in.MediaID) == "" {
+		return repository.Media{}, ErrInvalidUploadInput
+	}
+
+	var details repository.MediaDetails
+	if in.Title != nil {
+		trimmed := strings.TrimSpace(*in.Title)
+		if trimmed == "" || utf8.RuneCountInString(trimmed) > maxMediaTitleRunes {
+			return repository.Media{}, ErrInvalidMediaDetails
+		}
+		details.Title = &trimmed
+	}
+	if in.Description != nil {
+		trimmed := strings.TrimSpace(*in.Description)
+		if utf8.RuneCountInString(trimmed) > maxMediaDescriptionRunes {
+			return repository.Media{}, ErrInvalidMediaDetails
+		}
+		details.Description = &trimmed
+	}
+	if in.Tags != nil {
+		tags, ok := normalizeTags(*in.Tags)
+		if !ok {
+			return repository.Media{}, ErrInvalidMediaDetails
+		}
+		details.Tags = &tags
+	}
+	if in.CollectionIDs != nil {
+		collectionIDs := uniqueTrimmed(*in.CollectionIDs)
+		details.CollectionIDs = &collectionIDs
+	}
+
+	media, err := s.mediaRepo.GetByID(ctx, in.MediaID)
+	if err != nil {
+		return repository.Media{}, err
+	}
+	if media.OwnerUserID != in.OwnerUserID {
+		return repository.Media{}, ErrForbiddenMedia
+	}
+
+	// All fields are written in one transaction, so a refused collection or
+	// a failed write leaves the media as it was.
+	if err := s.mediaRepo.UpdateDetails(ctx, media.ID, media.OwnerUserID, details); err != nil {
+		if errors.Is(err, repository.ErrCollectionNotFound) {
+			return repository.Media{}, ErrCollectionNotFound
+		}
+		return repository.Media{}, err
+	}
+
+	return s.GetMedia(ctx, in.OwnerUserID, in.MediaID)
+}
+
+// loadMediaLabels fills the tags and collections of items.
+func (s *MediaUploadService) loadMediaLabels(ctx context.Context, items []repository.Media) error {
+	if len(items) == 0 {
+		return nil
+	}
+	ids := make([]string, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item.ID)
+	}
+
+	tags, err := s.tagRepo.ListByMedia(ctx, ids)
+	if err != nil {
+		return err
+	}
+	collectionIDs, err := s.collectionRepo.ListIDsByMedia(ctx, ids)
+	if err != nil {
+		return err
+	}
+	for i := range items {
+		items[i].Tags = tags[items[i].ID]
+		items[i].CollectionIDs = collectionIDs[items[i].ID]
+	}
+	return nil
+}
+
+func isMediaStatus(status repository.MediaStatus) bool {
+	switch status {
+	case repository.MediaUploading, repository.MediaUploaded, repository.MediaProcessing,
+		repository.MediaReady, repository.MediaFailed, repository.MediaRejected, repository.MediaQuarantined:
+		return true
+	default:
+		return false
+	}
+}
+
+// normalizeTag makes tags case-insensitive: "Travel" and "travel " are the
+// same tag.
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.Join(strings.Fields(tag), " "))
+}
+
+func normalizeTags(raw []string) ([]string, bool) {
+	tags := make([]string, 0, len(raw))
+	seen := make(map[string]struct{}, len(raw))
+	for _, tag := range raw {
+		tag = normalizeTag(tag)
+		if tag == "" || utf8.RuneCountInString(tag) > maxMediaTagRunes {
+			return nil, false
+		}
+		if _, ok := seen[tag]; ok {
+			continue
+		}
+		seen[tag] = struct{}{}
+		tags = append(tags, tag)
+	}
+	if len(tags) > maxMediaTags {
+		return nil, false
+	}
+	return tags, true
+}
+
+func uniqueTrimmed(raw []string) []string {
+	out := make([]string, 0, len(raw))
+	seen := make(map[string]struct{}, len(raw))
+	for _, value := range raw {
+		value = strings.TrimSpace(value)
+		if _, ok := seen[value]; ok || value == "" {
+			continue
+		}
+		seen[value] = struct{}{}
+		out = append(out, value)
+	}
+	return out
+}
+
+func encodeMediaCursor(sort repository.MediaSort, last repository.Media) string {
+	cursor := mediaCursor{Sort: sort, ID: last.ID}
+	switch value := sort.SortValue(last).(type) {
+	case time.Time:
+		cursor.CreatedAt = &value
+	case string:
+		cursor.Title = &value
+	case int:
+		cursor.DurationSec = &value
+	case int64:
+		cursor.SizeBytes = &value
+	}
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeMediaCursor turns a cursor back into the fields of the media it was
+// made from. A cursor only continues the listing order it was issued for.
+func decodeMediaCursor(raw string, sort repository.MediaSort) (repository.Media, error) {
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return repository.Media{}, ErrInvalidMediaCursor
+	}
+	var cursor mediaCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.ID == "" || cursor.Sort != sort {
+		return repository.Media{}, ErrInvalidMediaCursor
+	}
+
+	after := repository.Media{ID: cursor.ID}
+	switch sort.SortValue(after).(type) {
+	case time.Time:
+		if cursor.CreatedAt == nil {
+			return repository.Media{}, ErrInvalidMediaCursor
+		}
+		after.CreatedAt = *cursor.CreatedAt
+	case string:
+		if cursor.Title == nil {
+			return repository.Media{}, ErrInvalidMediaCursor
+		}
+		after.Title = *cursor.Title
+	case int:
+		if cursor.DurationSec == nil {
+			return repository.Media{}, ErrInvalidMediaCursor
+		}
+		after.DurationSec = cursor.DurationSec
+	case int64:
+		if cursor.SizeBytes == nil {
+			return repository.Media{}, ErrInvalidMediaCursor
+		}
+		after.FileSizeBytes = *cursor.SizeBytes
+	default:
+		return repository.Media{}, ErrInvalidMediaCursor
+	}
+	return after, nil
+}
@@ -0,0 +1,218 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"calixio/internal/repository"
+)
+
+var allMediaSorts = []repository.MediaSort{
+	repository.MediaSortCreatedAsc,
+	repository.MediaSortCreatedDesc,
+	repository.MediaSortTitleAsc,
+	repository.MediaSortTitleDesc,
+	repository.MediaSortDurationAsc,
+	repository.MediaSortDurationDesc,
+	repository.MediaSortSizeAsc,
+	repository.MediaSortSizeDesc,
+}
+
+func TestMediaCursorRoundTrip(t *testing.T) {
+	duration := 5400
+	media := []struct {
+		name  string
+		media repository.Media
+	}{
+		{
+			name: "all fields",
+			media: repository.Media{
+				ID:            "media-1",
+				Title:         "Ünïcode, \"quoted\" title",
+				DurationSec:   &duration,
+				FileSizeBytes: 12 << 30,
+				CreatedAt:     time.Date(2026, 5, 6, 7, 8, 9, 123456789, time.FixedZone("MSK", 3*60*60)),
+			},
+		},
+		{
+			name:  "zero values",
+			media: repository.Media{ID: "media-2"},
+		},
+	}
+	for _, sort := range allMediaSorts {
+		for _, tt := range media {
+			t.Run(string(sort)+"/"+tt.name, func(t *testing.T) {
+				raw := encodeMediaCursor(sort, tt.media)
+				after, err := decodeMediaCursor(raw, sort)
+				if err != nil {
+					t.Fatalf("decodeMediaCursor: %v", err)
+				}
+				if after.ID != tt.media.ID {
+					t.Fatalf("id = %q, want %q", after.ID, tt.media.ID)
+				}
+				got, want := sort.SortValue(after), sort.SortValue(tt.media)
+				if gotTime, ok := got.(time.Time); ok {
+					if !gotTime.Equal(want.(time.Time)) {
+						t.Fatalf("sort value = %v, want %v", got, want)
+					}
+					return
+				}
+				if got != want {
+					t.Fatalf("sort value = %v, want %v", got, want)
+				}
+			})
+		}
+	}
+}
+
+func TestDecodeMediaCursorRejects(t *testing.T) {
+	encode := func(raw string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(raw))
+	}
+	titleCursor := encodeMediaCursor(repository.MediaSortTitleAsc, repository.Media{ID: "media-1", Title: "a"})
+
+	tests := []struct {
+		name string
+		raw  string
+		sort repository.MediaSort
+	}{
+		{name: "other sort", raw: titleCursor, sort: repository.MediaSortTitleDesc},
+		{name: "not base64", raw: "%%%", sort: repository.MediaSortTitleAsc},
+		{name: "padded base64", raw: base64.URLEncoding.EncodeToString([]byte(`{"s":"title","id":"m","t":"a"}`)) + "=", sort: repository.MediaSortTitleAsc},
+		{name: "not json", raw: encode("title"), sort: repository.MediaSortTitleAsc},
+		{name: "missing id", raw: encode(`{"s":"title","t":"a"}`), sort: repository.MediaSortTitleAsc},
+		{name: "missing title", raw: encode(`{"s":"title","id":"m"}`), sort: repository.MediaSortTitleAsc},
+		{name: "missing created at", raw: encode(`{"s":"-created_at","id":"m","t":"a"}`), sort: repository.MediaSortCreatedDesc},
+		{name: "missing duration", raw: encode(`{"s":"duration","id":"m","z":1}`), sort: repository.MediaSortDurationAsc},
+		{name: "missing size", raw: encode(`{"s":"-size","id":"m","d":1}`), sort: repository.MediaSortSizeDesc},
+		{name: "wrong value type", raw: encode(`{"s":"size","id":"m","z":"big"}`), sort: repository.MediaSortSizeAsc},
+		{name: "empty", raw: "", sort: repository.MediaSortCreatedDesc},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decodeMediaCursor(tt.raw, tt.sort); !errors.Is(err, ErrInvalidMediaCursor) {
+				t.Fatalf("error = %v, want ErrInvalidMediaCursor", err)
+			}
+		})
+	}
+}
+
+type libraryTestMediaRepo struct {
+	repository.MediaRepository
+	media   repository.Media
+	err     error
+	updates []repository.MediaDetails
+}
+
+func (r *libraryTestMediaRepo) GetByID(context.Context, string) (repository.Media, error) {
+	return r.media, nil
+}
+
+func (r *libraryTestMediaRepo) UpdateDetails(_ context.Context, _, _ string, details repository.MediaDetails) error {
+	r.updates = append(r.updates, details)
+	return r.err
+}
+
+type libraryTestTagRepo struct {
+	repository.TagRepository
+}
+
+func (libraryTestTagRepo) ListByMedia(context.Context, []string) (map[string][]string, error) {
+	return map[string][]string{}, nil
+}
+
+type libraryTestCollectionRepo struct {
+	repository.CollectionRepository
+}
+
+func (libraryTestCollectionRepo) ListIDsByMedia(context.Context, []string) (map[string][]string, error) {
+	return map[string][]string{}, nil
+}
+
+func TestUpdateMedia(t *testing.T) {
+	ptr := func(v string) *string { return &v }
+	list := func(v ...string) *[]string { return &v }
+
+	tests := []struct {
+		name        string
+		in          UpdateMediaInput
+		repoErr     error
+		wantErr     error
+		wantUpdates []repository.MediaDetails
+	}{
+		{
+			name: "all fields in one write",
+			in: UpdateMediaInput{
+				Title:         ptr("  Trip  "),
+				Description:   ptr(""),
+				Tags:          list("Travel", "travel"),
+				CollectionIDs: list(" c1 ", "c1", "c2"),
+			},
+			wantUpdates: []repository.MediaDetails{{
+				Title:         ptr("Trip"),
+				Description:   ptr(""),
+				Tags:          list("travel"),
+				CollectionIDs: list("c1", "c2"),
+			}},
+		},
+		{
+			name:        "clearing tags",
+			in:          UpdateMediaInput{Tags: &[]string{}},
+			wantUpdates: []repository.MediaDetails{{Tags: &[]string{}}},
+		},
+		{
+			name:        "refused collection",
+			in:          UpdateMediaInput{Title: ptr("Trip"), CollectionIDs: list("c3")},
+			repoErr:     repository.ErrCollectionNotFound,
+			wantErr:     ErrCollectionNotFound,
+			wantUpdates: []repository.MediaDetails{{Title: ptr("Trip"), CollectionIDs: list("c3")}},
+		},
+		{
+			name:        "failed write",
+			in:          UpdateMediaInput{Tags: list("travel")},
+			repoErr:     errors.New("connection reset"),
+			wantUpdates: []repository.MediaDetails{{Tags: list("travel")}},
+		},
+		{name: "blank title", in: UpdateMediaInput{Title: ptr(" "), Tags: list("travel")}, wantErr: ErrInvalidMediaDetails},
+		{name: "other owner", in: UpdateMediaInput{OwnerUserID: "user-2", Tags: list("travel")}, wantErr: ErrForbiddenMedia},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mediaRepo := &libraryTestMediaRepo{
+				media: repository.Media{ID: "media-1", OwnerUserID: "user-1"},
+				err:   tt.repoErr,
+			}
+			svc := NewMediaUploadService(NewMediaUploadServiceInput{
+				MediaRepo:      mediaRepo,
+				TagRepo:        libraryTestTagRepo{},
+				CollectionRepo: libraryTestCollectionRepo{},
+			})
+			in := tt.in
+			in.MediaID = "media-1"
+			if in.OwnerUserID == "" {
+				in.OwnerUserID = "user-1"
+			}
+
+			_, err := svc.UpdateMedia(context.Background(), in)
+			switch {
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("error = %v, want %v", err, tt.wantErr)
+				}
+			case tt.repoErr != nil:
+				if !errors.Is(err, tt.repoErr) {
+					t.Fatalf("error = %v, want %v", err, tt.repoErr)
+				}
+			case err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(mediaRepo.updates, tt.wantUpdates) {
+				t.Fatalf("updates = %+v, want %+v", mediaRepo.updates, tt.wantUpdates)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package service
+
+import (
+	"context"
+	"path"
+	"strings"
+	"time"
+
+	"calixio/internal/repository"
+)
+
+const previewName = "preview/preview.jpg"
+
+// playbackSegmentTypes lists the objects the segment proxy streams, keyed by
+// extension. Playlists are rendered by ResolvePlaybackManifest instead and
+// anything else is refused.
+var playbackSegmentTypes = map[string]string{
+	".ts":  "video/mp2t",
+	".m4s": "video/iso.segment",
+	".mp4": "video/mp4",
+	".aac": "audio/aac",
+	".vtt": "text/vtt",
+	".jpg": "image/jpeg",
+}
+
+type PlaybackSegment struct {
+	ObjectStream
+	// MaxAge is how long clients may cache the bytes; it never outlives the
+	// playback token that authorised them.
+	MaxAge time.Duration
+}
+
+// IsPlaybackPlaylist reports whether name, as requested below
+// /media/playback/{token}/, is a playlist rather than a segment.
+func IsPlaybackPlaylist(name string) bool {
+	_, ok := cleanPlaylistName(name)
+	return ok
+}
+
+// ProxiesPlaybackSegments reports whether playlists reference segments
+// through the API. Such playlists embed no signatures and stay valid for the
+// whole lifetime of their token.
+func (s *MediaUploadService) ProxiesPlaybackSegments() bool {
+	return s.segmentProxy
+}
+
+// OpenPlaybackSegment streams a segment, subtitle file, storyboard sprite or
+// the poster for a playback token. name uses the same layout as the playlist
+// URIs: relative to the HLS directory, except for "storyboard/" and
+// "preview/" which sit next to it. byteRange is passed to storage as is.
+func (s *MediaUploadService) OpenPlaybackSegment(ctx context.Context, token, name, byteRange string) (PlaybackSegment, error) {
+	if !s.segmentProxy {
+		return PlaybackSegment{}, ErrInvalidManifestKey
+	}
+	relPath, contentType, ok := cleanSegmentName(name)
+	if !ok {
+		return PlaybackSegment{}, ErrInvalidManifestKey
+	}
+
+	media, err := s.mediaForPlaybackToken(ctx, token)
+	if err != nil {
+		return PlaybackSegment{}, err
+	}
+	object, err := s.storage.OpenObject(ctx, playbackObjectKey(media, relPath), byteRange)
+	if err != nil {
+		return PlaybackSegment{}, err
+	}
+	object.ContentType = contentType
+	return PlaybackSegment{ObjectStream: object, MaxAge: s.playbackTokenLifetime(ctx, token)}, nil
+}
+
+// playbackTokenLifetime is how much longer token stays valid, or zero when
+// that cannot be told.
+func (s *MediaUploadService) playbackTokenLifetime(ctx context.Context, token string) time.Duration {
+	remaining, err := s.cache.PTTL(ctx, s.playbackManifestTokenKey(strings.TrimSpace(token))).Result()
+	if err != nil || remaining < 0 {
+		return 0
+	}
+	return min(remaining, s.playbackTTL)
+}
+
+// segmentURL is the URL a player fetches relPath below prefix from: the
+// playback proxy when segment proxying is on, a presigned URL otherwise.
+func (s *MediaUploadService) segmentURL(ctx context.Context, prefix, relPath, token string, ttl time.Duration) (string, error) {
+	if s.segmentProxy && token != "" {
+		return *playbackProxyURL(token, relPath), nil
+	}
+	return s.storage.PresignGetObject(ctx, path.Join(prefix, relPath), ttl)
+}
+
+func playbackObjectKey(media repository.Media, relPath string) string {
+	switch {
+	case relPath == previewName:
+		return path.Join("users", media.OwnerUserID, "media", media.ID, relPath)
+	case strings.HasPrefix(relPath, storyboardDir+"/"):
+		return path.Join(mediaOutputPrefix(media), relPath)
+	default:
+		return path.Join(hlsPrefixFor(media, relPath), relPath)
+	}
+}
+
+func cleanSegmentName(name string) (string, string, bool) {
+	cleaned := path.Clean("/" + strings.TrimSpace(name))[1:]
+	contentType, ok := playbackSegmentTypes[path.Ext(cleaned)]
+	if cleaned == "" || !ok {
+		return "", "", false
+	}
+	return cleaned, contentType, true
+}
@@ -0,0 +1,131 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"calixio/internal/repository"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+type proxyTestMediaRepo struct {
+	repository.MediaRepository
+	media repository.Media
+}
+
+func (r *proxyTestMediaRepo) GetByID(_ context.Context, id string) (repository.Media, error) {
+	if id != r.media.ID {
+		return repository.Media{}, repository.ErrNotFound
+	}
+	return r.media, nil
+}
+
+type proxyTestStorage struct {
+	Storage
+	opened []string
+}
+
+func (s *proxyTestStorage) OpenObject(_ context.Context, key, _ string) (ObjectStream, error) {
+	s.opened = append(s.opened, key)
+	return ObjectStream{Body: io.NopCloser(strings.NewReader("segment"))}, nil
+}
+
+func TestCleanSegmentName(t *testing.T) {
+	tests := []struct {
+		name            string
+		in              string
+		wantPath        string
+		wantContentType string
+		wantOK          bool
+	}{
+		{name: "ts segment", in: "720p/segment_000.ts", wantPath: "720p/segment_000.ts", wantContentType: "video/mp2t", wantOK: true},
+		{name: "fmp4 segment", in: "1080p/seg_1.m4s", wantPath: "1080p/seg_1.m4s", wantContentType: "video/iso.segment", wantOK: true},
+		{name: "poster", in: "preview/preview.jpg", wantPath: previewName, wantContentType: "image/jpeg", wantOK: true},
+		{name: "subtitles", in: " subs/en.vtt ", wantPath: "subs/en.vtt", wantContentType: "text/vtt", wantOK: true},
+		{name: "parent segments are clamped", in: "../../other/segment.ts", wantPath: "other/segment.ts", wantContentType: "video/mp2t", wantOK: true},
+		{name: "playlist", in: "720p/index.m3u8"},
+		{name: "key file", in: "enc.key"},
+		{name: "no extension", in: "720p/segment"},
+		{name: "empty", in: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotPath, gotContentType, ok := cleanSegmentName(tt.in)
+			if ok != tt.wantOK || gotPath != tt.wantPath || gotContentType != tt.wantContentType {
+				t.Fatalf("cleanSegmentName(%q) = %q, %q, %v, want %q, %q, %v",
+					tt.in, gotPath, gotContentType, ok, tt.wantPath, tt.wantContentType, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestOpenPlaybackSegment(t *testing.T) {
+	const (
+		token       = "0123456789abcdef0123456789abcdef"
+		playbackTTL = 3 * time.Hour
+	)
+	media := repository.Media{ID: "media-1", OwnerUserID: "user-1", Status: repository.MediaReady}
+
+	tests := []struct {
+		name       string
+		proxy      bool
+		segment    string
+		tokenTTL   time.Duration
+		noToken    bool
+		wantErr    error
+		wantMaxAge time.Duration
+	}{
+		{name: "fresh token", proxy: true, segment: "720p/segment_000.ts", tokenTTL: playbackTTL, wantMaxAge: playbackTTL},
+		{name: "token about to expire", proxy: true, segment: "720p/segment_000.ts", tokenTTL: 30 * time.Second, wantMaxAge: 30 * time.Second},
+		{name: "proxy disabled", segment: "720p/segment_000.ts", tokenTTL: playbackTTL, wantErr: ErrInvalidManifestKey},
+		{name: "playlist", proxy: true, segment: "720p/index.m3u8", tokenTTL: playbackTTL, wantErr: ErrInvalidManifestKey},
+		{name: "unknown token", proxy: true, segment: "720p/segment_000.ts", noToken: true, wantErr: ErrInvalidManifestKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := miniredis.RunT(t)
+			if !tt.noToken {
+				cache.Set("media:playback:manifest:v1:"+token, media.ID)
+				cache.SetTTL("media:playback:manifest:v1:"+token, tt.tokenTTL)
+			}
+			storage := &proxyTestStorage{}
+			svc := NewMediaUploadService(NewMediaUploadServiceInput{
+				MediaRepo:         &proxyTestMediaRepo{media: media},
+				Storage:           storage,
+				Cache:             redis.NewClient(&redis.Options{Addr: cache.Addr()}),
+				PlaybackSignedTTL: playbackTTL,
+				ProxySegments:     tt.proxy,
+			})
+
+			segment, err := svc.OpenPlaybackSegment(context.Background(), token, tt.segment, "")
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("error = %v, want %v", err, tt.wantErr)
+				}
+				if len(storage.opened) != 0 {
+					t.Fatalf("opened %v for a refused segment", storage.opened)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer segment.Body.Close()
+			if segment.MaxAge != tt.wantMaxAge {
+				t.Fatalf("max age = %v, want %v", segment.MaxAge, tt.wantMaxAge)
+			}
+			if want := "users/user-1/media/media-1/hls/720p/segment_000.ts"; len(storage.opened) != 1 || storage.opened[0] != want {
+				t.Fatalf("opened %v, want [%s]", storage.opened, want)
+			}
+			if segment.ContentType != "video/mp2t" {
+				t.Fatalf("content type = %q, want video/mp2t", segment.ContentType)
+			}
+		})
+	}
+}
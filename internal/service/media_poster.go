@@ -0,0 +1,122 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/jpeg"
+	_ "image/png"
+	"path"
+	"strings"
+
+	"calixio/internal/repository"
+)
+
+var (
+	ErrInvalidPosterTimestamp   = errors.New("poster timestamp is outside the media duration")
+	ErrInvalidPosterImage       = errors.New("poster image must be a jpeg or png")
+	ErrPosterCaptureUnavailable = errors.New("poster capture requires the transcoder and ffmpeg")
+)
+
+const (
+	MaxPosterImageBytes = 10 << 20
+	maxPosterDimension  = 8192
+)
+
+type PosterOutput struct {
+	MediaID    string
+	PreviewURL *string
+}
+
+// SetPosterAtTimestamp replaces the automatically chosen poster with the
+// frame at timestampSec.
+func (s *MediaUploadService) SetPosterAtTimestamp(ctx context.Context, ownerUserID, mediaID string, timestampSec float64) (PosterOutput, error) {
+	media, err := s.getOwnedReadyMedia(ctx, ownerUserID, mediaID)
+	if err != nil {
+		return PosterOutput{}, err
+	}
+	if s.transcoder == nil {
+		return PosterOutput{}, ErrPosterCaptureUnavailable
+	}
+	if timestampSec < 0 || (media.DurationSec != nil && timestampSec > float64(*media.DurationSec)) {
+		return PosterOutput{}, ErrInvalidPosterTimestamp
+	}
+
+	previewURL, err := s.transcoder.CapturePoster(ctx, media, timestampSec)
+	if err != nil {
+		return PosterOutput{}, err
+	}
+	return s.finishPosterUpdate(ctx, media, previewURL)
+}
+
+// UploadPosterImage stores an owner supplied image as the poster. PNGs are
+// re-encoded so the poster is always served as preview.jpg.
+func (s *MediaUploadService) UploadPosterImage(ctx context.Context, ownerUserID, mediaID string, data []byte) (PosterOutput, error) {
+	media, err := s.getOwnedReadyMedia(ctx, ownerUserID, mediaID)
+	if err != nil {
+		return PosterOutput{}, err
+	}
+	if len(data) == 0 || len(data) > MaxPosterImageBytes {
+		return PosterOutput{}, ErrInvalidPosterImage
+	}
+
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width > maxPosterDimension || cfg.Height > maxPosterDimension {
+		return PosterOutput{}, ErrInvalidPosterImage
+	}
+	switch format {
+	case "jpeg":
+	case "png":
+		img, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return PosterOutput{}, ErrInvalidPosterImage
+		}
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+			return PosterOutput{}, err
+		}
+		data = buf.Bytes()
+	default:
+		return PosterOutput{}, ErrInvalidPosterImage
+	}
+
+	previewKey := path.Join("users", media.OwnerUserID, "media", media.ID, "preview", "preview.jpg")
+	if err := s.storage.UploadOutputBytes(ctx, previewKey, "image/jpeg", data); err != nil {
+		return PosterOutput{}, err
+	}
+	previewURL := s.storage.ObjectURL(previewKey)
+	return s.finishPosterUpdate(ctx, media, &previewURL)
+}
+
+func (s *MediaUploadService) getOwnedReadyMedia(ctx context.Context, ownerUserID, mediaID string) (repository.Media, error) {
+	if strings.TrimSpace(ownerUserID) == "" || strings.TrimSpace(mediaID) == "" {
+		return repository.Media{}, ErrInvalidUploadInput
+	}
+
+	media, err := s.mediaRepo.GetByID(ctx, mediaID)
+	if err != nil {
+		return repository.Media{}, err
+	}
+	if media.OwnerUserID != ownerUserID {
+		return repository.Media{}, ErrForbiddenMedia
+	}
+	// A running transcode would overwrite posters and subtitles again.
+	if media.Status != repository.MediaReady {
+		return repository.Media{}, ErrMediaNotReady
+	}
+	return media, nil
+}
+
+func (s *MediaUploadService) finishPosterUpdate(ctx context.Context, media repository.Media, previewURL *string) (PosterOutput, error) {
+	if err := s.mediaRepo.UpdatePreviewURL(ctx, media.ID, previewURL); err != nil {
+		return PosterOutput{}, err
+	}
+	if s.cache != nil {
+		_ = s.cache.Del(ctx, s.playbackCacheKey(media.ID)).Err()
+	}
+
+	media.PreviewURL = previewURL
+	s.signPreviewURL(ctx, &media)
+	return PosterOutput{MediaID: media.ID, PreviewURL: media.PreviewURL}, nil
+}
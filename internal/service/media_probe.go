@@ -0,0 +1,281 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"calixio/internal/repository"
+)
+
+// mediaProbe is the subset of `ffprobe -show_format -show_streams` the
+// pipeline decides on.
+type mediaProbe struct {
+	formatName  string
+	durationSec float64
+	bitRate     int64
+	video       *probeVideoStream
+	audio       []probeAudioStream
+	subtitles   []probeSubtitleStream
+	videoCount  int
+}
+
+type probeVideoStream struct {
+	codec   string
+	profile string
+	pixFmt  string
+	width   int
+	height  int
+	fps     float64
+	bitRate int64
+}
+
+type probeAudioStream struct {
+	index         int
+	codec         string
+	profile       string
+	channels      int
+	channelLayout string
+	sampleRate    int
+	bitRate       int64
+	language      string
+	title         string
+}
+
+type probeSubtitleStream struct {
+	index    int
+	codec    string
+	language string
+	title    string
+}
+
+type ffprobeOutput struct {
+	Format struct {
+		FormatName string `json:"format_name"`
+		Duration   string `json:"duration"`
+		BitRate    string `json:"bit_rate"`
+	} `json:"format"`
+	Streams []struct {
+		Index         int               `json:"index"`
+		CodecType     string            `json:"codec_type"`
+		CodecName     string            `json:"codec_name"`
+		Profile       string            `json:"profile"`
+		PixFmt        string            `json:"pix_fmt"`
+		Width         int               `json:"width"`
+		Height        int               `json:"height"`
+		AvgFrameRate  string            `json:"avg_frame_rate"`
+		RFrameRate    string            `json:"r_frame_rate"`
+		BitRate       string            `json:"bit_rate"`
+		Channels      int               `json:"channels"`
+		ChannelLayout string            `json:"channel_layout"`
+		SampleRate    string            `json:"sample_rate"`
+		Tags          map[string]string `json:"tags"`
+		Disposition   map[string]int    `json:"disposition"`
+	} `json:"streams"`
+}
+
+func (s *MediaTranscoderService) probeMedia(ctx context.Context, srcPath string) (mediaProbe, error) {
+	return runFFprobe(ctx, s.ffprobePath, srcPath)
+}
+
+// runFFprobe probes input, a local path or a URL; extraArgs go before the
+// input, e.g. to restrict protocols.
+func runFFprobe(ctx context.Context, ffprobePath, input string, extraArgs ...string) (mediaProbe, error) {
+	args := []string{"-v", "error", "-print_format", "json", "-show_format", "-show_streams"}
+	args = append(args, extraArgs...)
+	cmd := exec.CommandContext(ctx, ffprobePath, append(args, input)...)
+	stderr := &tailBuffer{maxBytes: 16 << 10}
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return mediaProbe{}, fmt.Errorf("ffprobe: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return parseFFprobeOutput(out)
+}
+
+func parseFFprobeOutput(raw []byte) (mediaProbe, error) {
+	var parsed ffprobeOutput
+	if err := json.Unmarshal(raw, &parsed); err != nil {
+		return mediaProbe{}, fmt.Errorf("ffprobe: decode output: %w", err)
+	}
+
+	out := mediaProbe{
+		formatName:  parsed.Format.FormatName,
+		durationSec: parseProbeFloat(parsed.Format.Duration),
+		bitRate:     parseProbeInt(parsed.Format.BitRate),
+	}
+	for _, stream := range parsed.Streams {
+		switch stream.CodecType {
+		case "video":
+			// Cover art is stored as a single-frame video stream.
+			if stream.Disposition["attached_pic"] == 1 {
+				continue
+			}
+			out.videoCount++
+			if out.video != nil {
+				continue
+			}
+			fps := parseProbeRate(stream.AvgFrameRate)
+			if fps <= 0 {
+				fps = parseProbeRate(stream.RFrameRate)
+			}
+			out.video = &probeVideoStream{
+				codec:   stream.CodecName,
+				profile: stream.Profile,
+				pixFmt:  stream.PixFmt,
+				width:   stream.Width,
+				height:  stream.Height,
+				fps:     fps,
+				bitRate: parseProbeInt(stream.BitRate),
+			}
+		case "audio":
+			out.audio = append(out.audio, probeAudioStream{
+				index:         stream.Index,
+				codec:         stream.CodecName,
+				profile:       stream.Profile,
+				channels:      stream.Channels,
+				channelLayout: stream.ChannelLayout,
+				sampleRate:    int(parseProbeInt(stream.SampleRate)),
+				bitRate:       parseProbeInt(stream.BitRate),
+				language:      stream.Tags["language"],
+				title:         stream.Tags["title"],
+			})
+		case "subtitle":
+			out.subtitles = append(out.subtitles, probeSubtitleStream{
+				index:    stream.Index,
+				codec:    stream.CodecName,
+				language: stream.Tags["language"],
+				title:    stream.Tags["title"],
+			})
+		}
+	}
+	if out.video == nil {
+		return mediaProbe{}, errors.New("ffprobe: source has no video stream")
+	}
+	return out, nil
+}
+
+func (p mediaProbe) techMetadata() repository.MediaTechMetadata {
+	meta := repository.MediaTechMetadata{
+		Container:      p.formatName,
+		DurationSec:    math.Round(p.durationSec*1000) / 1000,
+		BitRate:        p.bitRate,
+		Width:          p.video.width,
+		Height:         p.video.height,
+		FrameRate:      math.Round(p.video.fps*1000) / 1000,
+		VideoCodec:     p.video.codec,
+		VideoProfile:   p.video.profile,
+		PixelFormat:    p.video.pixFmt,
+		VideoBitRate:   p.videoBitRate(),
+		VideoTracks:    p.videoCount,
+		AudioTracks:    len(p.audio),
+		SubtitleTracks: len(p.subtitles),
+	}
+	if p.hasAudio() {
+		audio := p.audio[0]
+		meta.AudioCodec = audio.codec
+		meta.AudioChannels = audio.channels
+		meta.ChannelLayout = audio.channelLayout
+		meta.SampleRate = audio.sampleRate
+		meta.AudioBitRate = audio.bitRate
+	}
+	return meta
+}
+
+func (p mediaProbe) roundedDurationSec() int {
+	if p.durationSec <= 0 {
+		return 0
+	}
+	return int(math.Round(p.durationSec))
+}
+
+func (p mediaProbe) hasAudio() bool {
+	return len(p.audio) > 0
+}
+
+// videoBitRate falls back to the container bitrate minus audio when the
+// stream does not declare its own, which is common for MKV sources.
+func (p mediaProbe) videoBitRate() int64 {
+	if p.video.bitRate > 0 {
+		return p.video.bitRate
+	}
+	total := p.bitRate
+	for _, audio := range p.audio {
+		total -= audio.bitRate
+	}
+	if total < 0 {
+		return 0
+	}
+	return total
+}
+
+func parseProbeInt(raw string) int64 {
+	v, err := strconv.ParseInt(strings.TrimSpace(raw), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return v
+}
+
+func parseProbeFloat(raw string) float64 {
+	v, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0
+	}
+	return v
+}
+
+// parseProbeRate turns ffprobe rationals such as "30000/1001" into fps.
+func parseProbeRate(raw string) float64 {
+	num, den, ok := strings.Cut(strings.TrimSpace(raw), "/")
+	if !ok {
+		return parseProbeFloat(raw)
+	}
+	n := parseProbeFloat(num)
+	d := parseProbeFloat(den)
+	if n <= 0 || d <= 0 {
+		return 0
+	}
+	return n / d
+}
+
+// passthroughBlocker returns why the source cannot be remuxed with -c copy,
+// or "" when its streams are playable as they are.
+func (p mediaProbe) passthroughBlocker(maxVideoKbps, maxHeight int) string {
+	video := p.video
+	if video.codec != "h264" {
+		return "video codec " + video.codec
+	}
+	switch strings.ToLower(video.profile) {
+	case "baseline", "constrained baseline", "main", "high":
+	default:
+		return "h264 profile " + video.profile
+	}
+	if video.pixFmt != "yuv420p" && video.pixFmt != "yuvj420p" {
+		return "pixel format " + video.pixFmt
+	}
+	if video.width <= 0 || video.height <= 0 {
+		return "unknown resolution"
+	}
+	if maxHeight > 0 && video.height > maxHeight {
+		return fmt.Sprintf("height %d above profile limit %d", video.height, maxHeight)
+	}
+	bitRate := p.videoBitRate()
+	if bitRate <= 0 {
+		return "unknown bitrate"
+	}
+	if maxVideoKbps > 0 && bitRate > int64(maxVideoKbps)*1000 {
+		return fmt.Sprintf("bitrate %dk above %dk", bitRate/1000, maxVideoKbps)
+	}
+	for i, audio := range p.audio {
+		if audio.codec != "aac" {
+			return fmt.Sprintf("audio track %d codec %s", i, audio.codec)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"time"
+
+	"calixio/internal/repository"
+
+	"go.uber.org/zap"
+)
+
+// scanSource streams the uploaded original to the malware scanner before it
+// is downloaded for transcoding. It reports false once the media has been
+// quarantined, in which case there is nothing left to transcode. Media
+// already scanned clean, or skipped, by an earlier attempt are not scanned
+// again. Originals larger than the scanner accepts are recorded as
+// skipped_too_large and transcoded anyway.
+func (s *MediaTranscoderService) scanSource(ctx context.Context, media repository.Media, tmpDir string) (bool, error) {
+	if s.scanner == nil || (media.Scan != nil && (media.Scan.Status == repository.MediaScanClean || media.Scan.Status == repository.MediaScanSkippedTooLarge)) {
+		return true, nil
+	}
+
+	s.setProgress(ctx, media.ID, repository.MediaStageScan, 0, nil)
+	object, err := s.storage.OpenObject(ctx, media.StorageKey, "")
+	if err != nil {
+		return false, fmt.Errorf("open source for scan: %w", err)
+	}
+	var verdict ScanVerdict
+	if limited, ok := s.scanner.(sizeLimitedScanner); ok && object.ContentLength > limited.MaxScanBytes() {
+		err = ErrScanTooLarge
+	} else {
+		verdict, err = s.scanner.Scan(ctx, object.Body)
+	}
+	object.Body.Close()
+
+	scan := repository.MediaScan{
+		Status:    repository.MediaScanClean,
+		ScannedAt: time.Now().UTC(),
+	}
+	if errors.Is(err, ErrScanTooLarge) {
+		s.logger.Warn("upload too large for malware scan",
+			zap.String("media_id", media.ID),
+			zap.Int64("size_bytes", object.ContentLength),
+		)
+		scan.Status = repository.MediaScanSkippedTooLarge
+		err = nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("malware scan: %w", err)
+	}
+	if !verdict.Infected {
+		if err := s.mediaRepo.RecordScan(ctx, media.ID, scan); err != nil {
+			return false, fmt.Errorf("record scan verdict: %w", err)
+		}
+		return true, nil
+	}
+
+	scan.Status = repository.MediaScanInfected
+	scan.Signature = &verdict.Signature
+	s.logger.Warn("malware found in upload",
+		zap.String("media_id", media.ID),
+		zap.String("owner_user_id", media.OwnerUserID),
+		zap.String("signature", verdict.Signature),
+	)
+	if err := s.quarantineSource(ctx, media, tmpDir, scan); err != nil {
+		return false, fmt.Errorf("quarantine source: %w", err)
+	}
+	return false, nil
+}
+
+// quarantineSource moves the original under the quarantine prefix, outside
+// users/, where nothing serves, lists or cleans it up.
+func (s *MediaTranscoderService) quarantineSource(ctx context.Context, media repository.Media, tmpDir string, scan repository.MediaScan) error {
+	quarantineKey := path.Join(s.quarantinePrefix, media.OwnerUserID, media.ID, path.Base(media.StorageKey))
+	localPath := filepath.Join(tmpDir, "quarantine")
+	if _, err := s.storage.DownloadObjectToFile(ctx, media.StorageKey, localPath, ""); err != nil {
+		return err
+	}
+	if err := s.storage.UploadFile(ctx, quarantineKey, "application/octet-stream", localPath); err != nil {
+		return err
+	}
+	if err := s.mediaRepo.Quarantine(ctx, media.ID, quarantineKey, scan); err != nil {
+		return err
+	}
+	if err := s.storage.DeleteObject(ctx, media.StorageKey); err != nil {
+		s.logger.Warn("delete quarantined original", zap.String("media_id", media.ID), zap.Error(err))
+	}
+
+	s.logger.Info("media quarantined",
+		zap.String("media_id", media.ID),
+		zap.String("quarantine_key", quarantineKey),
+	)
+	return nil
+}
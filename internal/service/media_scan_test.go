@@ -0,0 +1,158 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"calixio/internal/repository"
+
+	"go.uber.org/zap"
+)
+
+type scanTestMediaRepo struct {
+	repository.MediaRepository
+	recorded []repository.MediaScan
+}
+
+func (r *scanTestMediaRepo) UpdateProgress(context.Context, string, repository.MediaProgress) error {
+	return nil
+}
+
+func (r *scanTestMediaRepo) RecordScan(_ context.Context, _ string, scan repository.MediaScan) error {
+	r.recorded = append(r.recorded, scan)
+	return nil
+}
+
+type scanTestStorage struct {
+	Storage
+	size int64
+}
+
+func (s *scanTestStorage) OpenObject(context.Context, string, string) (ObjectStream, error) {
+	return ObjectStream{
+		ObjectHead: ObjectHead{ContentLength: s.size},
+		Body:       io.NopCloser(strings.NewReader(strings.Repeat("x", int(s.size)))),
+	}, nil
+}
+
+type scanTestScanner struct {
+	maxBytes int64
+	verdict  ScanVerdict
+	err      error
+	calls    int
+}
+
+func (s *scanTestScanner) Scan(context.Context, io.Reader) (ScanVerdict, error) {
+	s.calls++
+	return s.verdict, s.err
+}
+
+func (s *scanTestScanner) MaxScanBytes() int64 {
+	return s.maxBytes
+}
+
+func TestScanSource(t *testing.T) {
+	tests := []struct {
+		name       string
+		size       int64
+		scanner    *scanTestScanner
+		previous   repository.MediaScanStatus
+		wantCalls  int
+		wantStatus repository.MediaScanStatus
+		wantErr    bool
+	}{
+		{
+			name:       "clean",
+			size:       10,
+			scanner:    &scanTestScanner{maxBytes: 100},
+			wantCalls:  1,
+			wantStatus: repository.MediaScanClean,
+		},
+		{
+			name:       "at the limit",
+			size:       100,
+			scanner:    &scanTestScanner{maxBytes: 100},
+			wantCalls:  1,
+			wantStatus: repository.MediaScanClean,
+		},
+		{
+			name:       "over the limit",
+			size:       101,
+			scanner:    &scanTestScanner{maxBytes: 100},
+			wantStatus: repository.MediaScanSkippedTooLarge,
+		},
+		{
+			name:       "refused by clamd",
+			size:       10,
+			scanner:    &scanTestScanner{maxBytes: 100, err: ErrScanTooLarge},
+			wantCalls:  1,
+			wantStatus: repository.MediaScanSkippedTooLarge,
+		},
+		{
+			name:      "scanner failure",
+			size:      10,
+			scanner:   &scanTestScanner{maxBytes: 100, err: errors.New("connection refused")},
+			wantCalls: 1,
+			wantErr:   true,
+		},
+		{
+			name:     "skipped before",
+			size:     101,
+			scanner:  &scanTestScanner{maxBytes: 100},
+			previous: repository.MediaScanSkippedTooLarge,
+		},
+		{
+			name:     "clean before",
+			size:     10,
+			scanner:  &scanTestScanner{maxBytes: 100},
+			previous: repository.MediaScanClean,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mediaRepo := &scanTestMediaRepo{}
+			svc := &MediaTranscoderService{
+				mediaRepo: mediaRepo,
+				storage:   &scanTestStorage{size: tt.size},
+				scanner:   tt.scanner,
+				logger:    zap.NewNop(),
+			}
+			media := repository.Media{ID: "media-1", StorageKey: "users/u/media/media-1/original"}
+			if tt.previous != "" {
+				media.Scan = &repository.MediaScan{Status: tt.previous}
+			}
+
+			proceed, err := svc.scanSource(context.Background(), media, t.TempDir())
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("want an error")
+				}
+				if len(mediaRepo.recorded) != 0 {
+					t.Fatalf("recorded %+v after a failed scan", mediaRepo.recorded)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !proceed {
+				t.Fatal("scanSource stopped a media that is not infected")
+			}
+			if tt.scanner.calls != tt.wantCalls {
+				t.Fatalf("scanner called %d times, want %d", tt.scanner.calls, tt.wantCalls)
+			}
+			if tt.wantStatus == "" {
+				if len(mediaRepo.recorded) != 0 {
+					t.Fatalf("recorded %+v, want nothing", mediaRepo.recorded)
+				}
+				return
+			}
+			if len(mediaRepo.recorded) != 1 || mediaRepo.recorded[0].Status != tt.wantStatus {
+				t.Fatalf("recorded %+v, want status %s", mediaRepo.recorded, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"calixio/internal/repository"
+)
+
+var (
+	ErrInvalidSubtitle      = errors.New("invalid subtitle")
+	ErrSubtitleExists       = errors.New("subtitle with this label already exists")
+	ErrSubtitlesUnavailable = errors.New("subtitles are not configured")
+)
+
+const MaxSubtitleFileBytes = 5 << 20
+
+type AddSubtitleInput struct {
+	OwnerUserID string
+	MediaID     string
+	Language    string
+	Label       string
+	Format      string
+	FileName    string
+	IsDefault   bool
+	Data        []byte
+}
+
+// AddSubtitle converts an uploaded SRT, VTT or ASS file to WebVTT and stores
+// it next to the HLS output together with its single-segment playlist.
+func (s *MediaUploadService) AddSubtitle(ctx context.Context, in AddSubtitleInput) (repository.MediaSubtitle, error) {
+	if s.subtitleRepo == nil {
+		return repository.MediaSubtitle{}, ErrSubtitlesUnavailable
+	}
+	language := strings.ToLower(strings.TrimSpace(in.Language))
+	label := strings.TrimSpace(in.Label)
+	if language == "" || label == "" || len(in.Data) == 0 || len(in.Data) > MaxSubtitleFileBytes {
+		return repository.MediaSubtitle{}, ErrInvalidSubtitle
+	}
+	format, ok := detectSubtitleFormat(in.Format, in.FileName)
+	if !ok {
+		return repository.MediaSubtitle{}, fmt.Errorf("%w: unsupported format", ErrInvalidSubtitle)
+	}
+
+	media, err := s.getOwnedReadyMedia(ctx, in.OwnerUserID, in.MediaID)
+	if err != nil {
+		return repository.MediaSubtitle{}, err
+	}
+
+	vtt, lastCueSec, err := convertSubtitleToWebVTT(in.Data, format)
+	if err != nil {
+		return repository.MediaSubtitle{}, fmt.Errorf("%w: %v", ErrInvalidSubtitle, err)
+	}
+
+	subtitleID, err := newSubtitleID()
+	if err != nil {
+		return repository.MediaSubtitle{}, err
+	}
+	durationSec := lastCueSec
+	if media.DurationSec != nil && float64(*media.DurationSec) > durationSec {
+		durationSec = float64(*media.DurationSec)
+	}
+	if err := s.storeSubtitleFiles(ctx, media, subtitleID, vtt, durationSec); err != nil {
+		return repository.MediaSubtitle{}, err
+	}
+
+	subtitle := repository.MediaSubtitle{
+		ID:        subtitleID,
+		MediaID:   media.ID,
+		Language:  language,
+		Label:     label,
+		Source:    repository.MediaSubtitleUpload,
+		IsDefault: in.IsDefault,
+		CreatedAt: s.clock(),
+	}
+	if err := s.subtitleRepo.Create(ctx, subtitle); err != nil {
+		_ = s.storage.DeleteObjectsByPrefix(ctx, s.subtitleKeyPrefix(media, subtitleID))
+		if errors.Is(err, repository.ErrAlreadyExists) {
+			return repository.MediaSubtitle{}, ErrSubtitleExists
+		}
+		return repository.MediaSubtitle{}, err
+	}
+	s.invalidatePlaybackCache(ctx, media.ID)
+	return subtitle, nil
+}
+
+func (s *MediaUploadService) ListSubtitles(ctx context.Context, ownerUserID, mediaID string) ([]repository.MediaSubtitle, error) {
+	if s.subtitleRepo == nil {
+		return nil, ErrSubtitlesUnavailable
+	}
+	media, err := s.GetMedia(ctx, ownerUserID, mediaID)
+	if err != nil {
+		return nil, err
+	}
+	return s.subtitleRepo.ListByMedia(ctx, media.ID)
+}
+
+func (s *MediaUploadService) DeleteSubtitle(ctx context.Context, ownerUserID, mediaID, subtitleID string) error {
+	if s.subtitleRepo == nil {
+		return ErrSubtitlesUnavailable
+	}
+	media, err := s.GetMedia(ctx, ownerUserID, mediaID)
+	if err != nil {
+		return err
+	}
+	if _, err := s.subtitleRepo.GetByID(ctx, media.ID, subtitleID); err != nil {
+		return err
+	}
+	if err := s.storage.DeleteObjectsByPrefix(ctx, s.subtitleKeyPrefix(media, subtitleID)); err != nil {
+		return fmt.Errorf("%w: %v", ErrStorageDelete, err)
+	}
+	if err := s.subtitleRepo.Delete(ctx, media.ID, subtitleID); err != nil {
+		return err
+	}
+	s.invalidatePlaybackCache(ctx, media.ID)
+	return nil
+}
+
+func (s *MediaUploadService) storeSubtitleFiles(ctx context.Context, media repository.Media, subtitleID, vtt string, durationSec float64) error {
+	hlsPrefix := path.Join("users", media.OwnerUserID, "media", media.ID, "hls")
+	vttName := subtitleVTTName(subtitleID)
+	if err := s.storage.UploadBytes(ctx, path.Join(hlsPrefix, vttName), "text/vtt", []byte(vtt)); err != nil {
+		return err
+	}
+	playlist := buildSubtitlePlaylist(path.Base(vttName), durationSec)
+	return s.storage.UploadBytes(ctx, path.Join(hlsPrefix, subtitlePlaylistName(subtitleID)), "application/vnd.apple.mpegurl", []byte(playlist))
+}
+
+// subtitleKeyPrefix matches both the .vtt and the .m3u8 of one subtitle.
+func (s *MediaUploadService) subtitleKeyPrefix(media repository.Media, subtitleID string) string {
+	return path.Join("users", media.OwnerUserID, "media", media.ID, "hls", subtitleDir, subtitleID) + "."
+}
+
+// invalidatePlaybackCache drops the cached master playlist, which carries the
+// subtitle renditions.
+func (s *MediaUploadService) invalidatePlaybackCache(ctx context.Context, mediaID string) {
+	if s.cache != nil {
+		_ = s.cache.Del(ctx, s.playbackCacheKey(mediaID)).Err()
+	}
+}
+
+func newSubtitleID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate subtitle id: %w", err)
+	}
+	return "sub_" + hex.EncodeToString(b), nil
+}
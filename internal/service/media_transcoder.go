@@ -12,6 +12,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -21,37 +22,82 @@ import (
 )
 
 type MediaTranscoderService struct {
-	mediaRepo       repository.MediaRepository
-	storage         *StorageService
-	ffmpegPath      string
-	ffprobePath     string
-	workDir         string
-	segmentDuration int
-	jobTimeout      time.Duration
-	jobQueue        chan string
-	logger          *zap.Logger
+	mediaRepo          repository.MediaRepository
+	jobRepo            repository.TranscodeJobRepository
+	subtitleRepo       repository.MediaSubtitleRepository
+	keyRepo            repository.MediaKeyRepository
+	storage            Storage
+	ffmpegPath         string
+	ffprobePath        string
+	workDir            string
+	segmentDuration    int
+	renditions         []hlsRendition
+	profiles           encodingProfileSet
+	passthrough        bool
+	passthroughKbps    int
+	storyboardInterval int
+	storyboardWidth    int
+	keyRotation        int
+	scanner            MalwareScanner
+	quarantinePrefix   string
+	jobTimeout         time.Duration
+	workers            int
+	workerID           string
+	leaseTTL           time.Duration
+	pollInterval       time.Duration
+	maxAttempts        int
+	wake               chan struct{}
+	running            sync.WaitGroup
+	logger             *zap.Logger
+	// jobsCtx parents running jobs; Stop cancels it once the grace period
+	// is over.
+	jobsCtx    context.Context
+	cancelJobs context.CancelFunc
 }
 
 type hlsEncodingProfile struct {
-	name      string
-	preset    string
-	crf       int
-	maxrate   string
-	bufsize   string
-	audioBitr string
-	threads   int
+	name        string
+	codec       string
+	preset      string
+	crf         int
+	maxrateKbps int
+	audioKbps   int
+	threads     int
+	maxHeight   int
+	segmentSec  int
 }
 
 type NewMediaTranscoderServiceInput struct {
 	MediaRepo       repository.MediaRepository
-	Storage         *StorageService
+	JobRepo         repository.TranscodeJobRepository
+	SubtitleRepo    repository.MediaSubtitleRepository
+	KeyRepo         repository.MediaKeyRepository
+	Storage         Storage
 	FFmpegPath      string
 	FFprobePath     string
 	WorkDir         string
 	SegmentDuration int
-	QueueSize       int
-	JobTimeout      time.Duration
-	Logger          *zap.Logger
+	Renditions      []string
+	ProfilesFile    string
+	// Passthrough remuxes compatible H.264/AAC sources instead of encoding
+	// them, as long as the video bitrate stays under PassthroughMaxKbps.
+	Passthrough        bool
+	PassthroughMaxKbps int
+	// StoryboardIntervalSec is the spacing of seek thumbnails; 0 disables
+	// the storyboard.
+	StoryboardIntervalSec int
+	StoryboardThumbWidth  int
+	KeyRotationSegments   int
+	Workers               int
+	JobTimeout            time.Duration
+	JobLeaseTTL           time.Duration
+	JobPollInterval       time.Duration
+	MaxAttempts           int
+	Logger                *zap.Logger
+	// Scanner checks originals for malware before transcoding; nil skips
+	// the scan. Infected originals move under QuarantinePrefix.
+	Scanner          MalwareScanner
+	QuarantinePrefix string
 }
 
 func NewMediaTranscoderService(in NewMediaTranscoderServiceInput) (*MediaTranscoderService, error) {
@@ -63,75 +109,310 @@ func NewMediaTranscoderService(in NewMediaTranscoderServiceInput) (*MediaTransco
 	if ffprobePath == "" {
 		ffprobePath = "ffprobe"
 	}
-	if _, err := exec.LookPath(ffmpegPath); err != nil {
-		return nil, fmt.Errorf("ffmpeg binary is not available: %w", err)
-	}
-	if _, err := exec.LookPath(ffprobePath); err != nil {
-		return nil, fmt.Errorf("ffprobe binary is not available: %w", err)
-	}
 
 	segmentDuration := in.SegmentDuration
 	if segmentDuration <= 0 {
 		segmentDuration = 6
 	}
-	queueSize := in.QueueSize
-	if queueSize <= 0 {
-		queueSize = 32
+	renditions, err := parseHLSRenditions(in.Renditions)
+	if err != nil {
+		return nil, err
+	}
+	profiles, err := loadEncodingProfiles(in.ProfilesFile)
+	if err != nil {
+		return nil, err
+	}
+	storyboardWidth := in.StoryboardThumbWidth
+	if storyboardWidth <= 0 {
+		storyboardWidth = 160
+	}
+	keyRotation := in.KeyRotationSegments
+	if keyRotation <= 0 {
+		keyRotation = defaultKeyRotationSegments
+	}
+	quarantinePrefix := strings.Trim(strings.TrimSpace(in.QuarantinePrefix), "/")
+	if quarantinePrefix == "" {
+		quarantinePrefix = "quarantine"
+	}
+	if in.JobRepo == nil {
+		return nil, errors.New("transcode job repository is required")
+	}
+	workers := in.Workers
+	if workers <= 0 {
+		workers = 1
 	}
 	jobTimeout := in.JobTimeout
 	if jobTimeout <= 0 {
 		jobTimeout = 2 * time.Hour
 	}
+	leaseTTL := in.JobLeaseTTL
+	if leaseTTL <= 0 {
+		leaseTTL = 2 * time.Minute
+	}
+	pollInterval := in.JobPollInterval
+	if pollInterval <= 0 {
+		pollInterval = 5 * time.Second
+	}
+	maxAttempts := in.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = 3
+	}
 	logger := in.Logger
 	if logger == nil {
 		logger = zap.NewNop()
 	}
 
 	svc := &MediaTranscoderService{
-		mediaRepo:       in.MediaRepo,
-		storage:         in.Storage,
-		ffmpegPath:      ffmpegPath,
-		ffprobePath:     ffprobePath,
-		workDir:         strings.TrimSpace(in.WorkDir),
-		segmentDuration: segmentDuration,
-		jobTimeout:      jobTimeout,
-		jobQueue:        make(chan string, queueSize),
-		logger:          logger,
-	}
-
-	go svc.worker()
+		mediaRepo:          in.MediaRepo,
+		jobRepo:            in.JobRepo,
+		subtitleRepo:       in.SubtitleRepo,
+		keyRepo:            in.KeyRepo,
+		storage:            in.Storage,
+		ffmpegPath:         ffmpegPath,
+		ffprobePath:        ffprobePath,
+		workDir:            strings.TrimSpace(in.WorkDir),
+		segmentDuration:    segmentDuration,
+		renditions:         renditions,
+		profiles:           profiles,
+		passthrough:        in.Passthrough,
+		passthroughKbps:    in.PassthroughMaxKbps,
+		storyboardInterval: in.StoryboardIntervalSec,
+		storyboardWidth:    storyboardWidth,
+		keyRotation:        keyRotation,
+		scanner:            in.Scanner,
+		quarantinePrefix:   quarantinePrefix,
+		jobTimeout:         jobTimeout,
+		workers:            workers,
+		workerID:           newTranscodeWorkerID(),
+		leaseTTL:           leaseTTL,
+		pollInterval:       pollInterval,
+		maxAttempts:        maxAttempts,
+		wake:               make(chan struct{}, 1),
+		logger:             logger,
+	}
+
 	return svc, nil
 }
 
-func (s *MediaTranscoderService) Enqueue(mediaID string) error {
+// Start launches the job workers and the lease reaper. A service that is never
+// started only enqueues, which is how the API runs next to cmd/worker. Workers
+// stop claiming new jobs once ctx is done; running jobs only end through Stop.
+func (s *MediaTranscoderService) Start(ctx context.Context) error {
+	if _, err := exec.LookPath(s.ffmpegPath); err != nil {
+		return fmt.Errorf("ffmpeg binary is not available: %w", err)
+	}
+	if _, err := exec.LookPath(s.ffprobePath); err != nil {
+		return fmt.Errorf("ffprobe binary is not available: %w", err)
+	}
+
+	s.jobsCtx, s.cancelJobs = context.WithCancel(context.WithoutCancel(ctx))
+	s.logger.Info("transcoding workers started",
+		zap.String("worker_id", s.workerID),
+		zap.Int("workers", s.workers),
+	)
+	for i := 0; i < s.workers; i++ {
+		s.running.Add(1)
+		go func() {
+			defer s.running.Done()
+			s.worker(ctx)
+		}()
+	}
+	go s.reapExpiredLeases(ctx)
+	return nil
+}
+
+// Stop waits up to grace for the running jobs once the ctx passed to Start is
+// done, then cancels them, which kills their ffmpeg processes, and releases
+// their jobs so another worker retries them right away. It reports whether
+// jobs had to be cancelled.
+func (s *MediaTranscoderService) Stop(grace time.Duration) bool {
+	if s.cancelJobs == nil {
+		return false
+	}
+	defer s.cancelJobs()
+
+	done := make(chan struct{})
+	go func() {
+		s.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return false
+	case <-time.After(grace):
+	}
+	s.cancelJobs()
+	<-done
+	return true
+}
+
+// Enqueue persists a transcoding job; any worker sharing the database may
+// pick it up.
+func (s *MediaTranscoderService) Enqueue(ctx context.Context, mediaID string) error {
 	trimmed := strings.TrimSpace(mediaID)
 	if trimmed == "" {
 		return errors.New("media id is required")
 	}
 
+	if err := s.jobRepo.Enqueue(ctx, trimmed, s.maxAttempts); err != nil {
+		return fmt.Errorf("enqueue transcode job: %w", err)
+	}
 	select {
-	case s.jobQueue <- trimmed:
-		return nil
+	case s.wake <- struct{}{}:
 	default:
-		return errors.New("transcoding queue is full")
 	}
+	return nil
 }
 
-func (s *MediaTranscoderService) worker() {
-	for mediaID := range s.jobQueue {
-		ctx, cancel := context.WithTimeout(context.Background(), s.jobTimeout)
-		err := s.processMedia(ctx, mediaID)
-		cancel()
-
+func (s *MediaTranscoderService) worker(ctx context.Context) {
+	for ctx.Err() == nil {
+		job, err := s.jobRepo.Claim(ctx, s.workerID, s.leaseTTL)
 		if err != nil {
-			s.logger.Error("transcoding failed", zap.String("media_id", mediaID), zap.Error(err))
-			if markErr := s.mediaRepo.UpdateStatus(context.Background(), mediaID, repository.MediaFailed); markErr != nil {
-				s.logger.Error("mark media failed", zap.String("media_id", mediaID), zap.Error(markErr))
+			if !errors.Is(err, repository.ErrNotFound) && ctx.Err() == nil {
+				s.logger.Error("claim transcode job", zap.Error(err))
 			}
+			select {
+			case <-ctx.Done():
+			case <-s.wake:
+			case <-time.After(s.pollInterval):
+			}
+			continue
+		}
+		s.runJob(job)
+	}
+}
+
+func (s *MediaTranscoderService) runJob(job repository.TranscodeJob) {
+	ctx, cancel := context.WithTimeout(s.jobsCtx, s.jobTimeout)
+	defer cancel()
+
+	// The heartbeat keeps the lease alive; losing it means another worker may
+	// already own the job, so this attempt is abandoned.
+	leaseLost := make(chan struct{})
+	heartbeatDone := make(chan struct{})
+	go func() {
+		defer close(heartbeatDone)
+		ticker := time.NewTicker(s.leaseTTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				err := s.jobRepo.Heartbeat(ctx, job.ID, s.workerID, s.leaseTTL)
+				if errors.Is(err, repository.ErrNotFound) {
+					close(leaseLost)
+					cancel()
+					return
+				}
+				if err != nil {
+					s.logger.Warn("transcode job heartbeat", zap.Int64("job_id", job.ID), zap.Error(err))
+				}
+			}
+		}
+	}()
+
+	s.logger.Info("transcode job claimed",
+		zap.Int64("job_id", job.ID),
+		zap.String("media_id", job.MediaID),
+		zap.Int("attempt", job.Attempts),
+		zap.Int("max_attempts", job.MaxAttempts),
+	)
+	err := s.processMedia(ctx, job.MediaID)
+	cancel()
+	<-heartbeatDone
+
+	select {
+	case <-leaseLost:
+		s.logger.Warn("transcode job lease lost", zap.Int64("job_id", job.ID), zap.String("media_id", job.MediaID))
+		return
+	default:
+	}
+	if s.jobsCtx.Err() != nil {
+		s.logger.Warn("transcode job interrupted by shutdown", zap.Int64("job_id", job.ID), zap.String("media_id", job.MediaID))
+		if releaseErr := s.jobRepo.Release(context.Background(), job.ID, s.workerID); releaseErr != nil {
+			s.logger.Error("release transcode job", zap.Int64("job_id", job.ID), zap.Error(releaseErr))
 		}
+		return
+	}
+
+	// Media deleted while queued leaves nothing to retry.
+	if err == nil || errors.Is(err, repository.ErrNotFound) {
+		if completeErr := s.jobRepo.Complete(context.Background(), job.ID, s.workerID); completeErr != nil {
+			s.logger.Error("complete transcode job", zap.Int64("job_id", job.ID), zap.Error(completeErr))
+		}
+		return
+	}
+
+	s.logger.Error("transcoding failed",
+		zap.Int64("job_id", job.ID),
+		zap.String("media_id", job.MediaID),
+		zap.Int("attempt", job.Attempts),
+		zap.Error(err),
+	)
+	status, failErr := s.jobRepo.Fail(context.Background(), job.ID, s.workerID, err.Error(), retryBackoff(job.Attempts))
+	if failErr != nil {
+		s.logger.Error("fail transcode job", zap.Int64("job_id", job.ID), zap.Error(failErr))
+		return
+	}
+	if status == repository.TranscodeJobFailed {
+		s.markMediaFailed(job.MediaID)
 	}
 }
 
+// reapExpiredLeases returns jobs of crashed workers to the queue. Every worker
+// process runs it; the UPDATE is atomic, so concurrent reapers are harmless.
+func (s *MediaTranscoderService) reapExpiredLeases(ctx context.Context) {
+	ticker := time.NewTicker(s.leaseTTL / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		requeued, exhausted, err := s.jobRepo.RequeueExpired(ctx, s.pollInterval)
+		if err != nil {
+			s.logger.Error("requeue expired transcode jobs", zap.Error(err))
+			continue
+		}
+		if requeued > 0 || len(exhausted) > 0 {
+			s.logger.Warn("expired transcode leases released",
+				zap.Int("requeued", requeued),
+				zap.Strings("failed_media_ids", exhausted),
+			)
+		}
+		for _, mediaID := range exhausted {
+			s.markMediaFailed(mediaID)
+		}
+	}
+}
+
+func (s *MediaTranscoderService) markMediaFailed(mediaID string) {
+	if err := s.mediaRepo.UpdateStatus(context.Background(), mediaID, repository.MediaFailed); err != nil {
+		s.logger.Error("mark media failed", zap.String("media_id", mediaID), zap.Error(err))
+	}
+}
+
+func retryBackoff(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	backoff := time.Duration(attempt*attempt) * 30 * time.Second
+	if backoff > 30*time.Minute {
+		backoff = 30 * time.Minute
+	}
+	return backoff
+}
+
+func newTranscodeWorkerID() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "worker"
+	}
+	return fmt.Sprintf("%s-%d-%d", host, os.Getpid(), time.Now().UnixNano())
+}
+
 func (s *MediaTranscoderService) processMedia(ctx context.Context, mediaID string) error {
 	media, err := s.mediaRepo.GetByID(ctx, mediaID)
 	if err != nil {
@@ -181,53 +462,163 @@ func (s *MediaTranscoderService) processMedia(ctx context.Context, mediaID strin
 }
 
 func (s *MediaTranscoderService) processMediaInWorkspace(ctx context.Context, media repository.Media, tmpDir string) error {
+	clean, err := s.scanSource(ctx, media, tmpDir)
+	if err != nil || !clean {
+		return err
+	}
+
 	srcPath := filepath.Join(tmpDir, "input"+filepath.Ext(media.OriginalName))
+	s.setProgress(ctx, media.ID, repository.MediaStageDownload, 0, nil)
+	var declaredSHA256, contentSHA256 string
+	if media.ContentSHA256 != nil {
+		declaredSHA256 = *media.ContentSHA256
+	}
 	if err := s.withRetry(ctx, "download source", media.ID, func() error {
-		return s.storage.DownloadObjectToFile(ctx, media.StorageKey, srcPath)
+		var err error
+		contentSHA256, err = s.storage.DownloadObjectToFile(ctx, media.StorageKey, srcPath, declaredSHA256)
+		if errors.Is(err, ErrChecksumMismatch) {
+			// Downloading again yields the same bytes.
+			return nil
+		}
+		return err
 	}); err != nil {
 		return fmt.Errorf("download source: %w", err)
 	}
+	if declaredSHA256 != "" && contentSHA256 != declaredSHA256 {
+		return s.rejectChecksumMismatch(ctx, media, contentSHA256)
+	}
+	if err := s.mediaRepo.UpdateContentHash(ctx, media.ID, contentSHA256); err != nil {
+		return fmt.Errorf("record content hash: %w", err)
+	}
+	shared, err := s.shareExistingOutputs(ctx, media, contentSHA256)
+	if err != nil || shared {
+		return err
+	}
 
-	durationSec, err := s.probeDurationSec(ctx, srcPath)
+	probe, err := s.probeMedia(ctx, srcPath)
 	if err != nil {
 		return err
 	}
+	durationSec := probe.roundedDurationSec()
+	if err := s.mediaRepo.UpdateTechMetadata(ctx, media.ID, probe.techMetadata()); err != nil {
+		return fmt.Errorf("record tech metadata: %w", err)
+	}
+	srcWidth, srcHeight := probe.video.width, probe.video.height
 
 	hlsDir := filepath.Join(tmpDir, "hls")
 	if err := os.MkdirAll(hlsDir, 0o755); err != nil {
 		return err
 	}
 
-	manifestPath := filepath.Join(hlsDir, "index.m3u8")
-	segmentPattern := filepath.Join(hlsDir, "segment_%05d.ts")
+	profile := s.profiles.selectProfile(media.OwnerUserID, durationSec, srcHeight)
+	if profile.segmentSec <= 0 {
+		profile.segmentSec = s.segmentDuration
+	}
+
+	passthroughBlocker := "disabled"
+	if s.passthrough {
+		passthroughBlocker = probe.passthroughBlocker(s.passthroughKbps, profile.maxHeight)
+	}
+	var (
+		variants []hlsVariant
+		audio    []hlsAudioRendition
+	)
+	if passthroughBlocker == "" {
+		profile.name = passthroughProfileName
+		variant := hlsVariant{
+			name:        "source",
+			width:       srcWidth,
+			height:      srcHeight,
+			maxrateKbps: int(probe.videoBitRate() / 1000),
+		}
+		if probe.hasAudio() {
+			variant.audioKbps = int(probe.audio[0].bitRate / 1000)
+		}
+		variants = []hlsVariant{variant}
+		audio = buildAudioRenditions(probe, 0)
+	} else {
+		variants = buildHLSVariants(s.renditions, srcWidth, srcHeight, profile)
+		audio = buildAudioRenditions(probe, profile.audioKbps)
+	}
+	if err := s.mediaRepo.UpdateEncodingProfile(ctx, media.ID, profile.name); err != nil {
+		return fmt.Errorf("record encoding profile: %w", err)
+	}
 
 	s.logger.Info("media conversion started",
 		zap.String("media_id", media.ID),
 		zap.String("source_key", media.StorageKey),
 		zap.Int("duration_sec", durationSec),
+		zap.String("profile", profile.name),
+		zap.String("format", string(media.OutputFormat)),
+		zap.Strings("renditions", hlsVariantNames(variants)),
+		zap.String("encode_reason", passthroughBlocker),
+		zap.Int("audio_tracks", len(probe.audio)),
 	)
-	if err := s.runFFmpegHLS(ctx, srcPath, manifestPath, segmentPattern, durationSec); err != nil {
-		return err
+	s.setProgress(ctx, media.ID, repository.MediaStageEncode, 0, nil)
+	progress := s.encodeProgressWriter(ctx, media.ID, durationSec)
+	switch {
+	case passthroughBlocker == "":
+		if err := s.runFFmpegRemux(ctx, srcPath, hlsDir, variants[0], audio, media.OutputFormat, profile.segmentSec, probe.hasAudio(), progress); err != nil {
+			return err
+		}
+	case media.OutputFormat == repository.MediaFormatCMAF:
+		if err := s.runFFmpegCMAF(ctx, srcPath, hlsDir, variants, audio, profile, probe.hasAudio(), progress); err != nil {
+			return err
+		}
+	default:
+		if err := s.runFFmpegHLS(ctx, srcPath, hlsDir, variants, audio, profile, progress); err != nil {
+			return err
+		}
+	}
+	if media.OutputFormat != repository.MediaFormatCMAF {
+		if err := writeHLSMasterPlaylist(filepath.Join(hlsDir, "index.m3u8"), variants, audio); err != nil {
+			return err
+		}
+	}
+	embeddedSubtitles, err := s.extractEmbeddedSubtitles(ctx, srcPath, hlsDir, media, probe)
+	if err != nil {
+		return fmt.Errorf("extract subtitles: %w", err)
 	}
-
-	previewPath := filepath.Join(tmpDir, "preview.jpg")
 
 	s.logger.Info("media upload started",
 		zap.String("media_id", media.ID),
 		zap.String("target_prefix", path.Join("users", media.OwnerUserID, "media", media.ID)),
 	)
-	previewURL, err := s.createAndUploadPreview(ctx, srcPath, previewPath, media)
+	s.setProgress(ctx, media.ID, repository.MediaStagePreview, 0, nil)
+	previewURL, err := s.createAndUploadPoster(ctx, srcPath, tmpDir, media, probe.durationSec)
 	if err != nil {
 		return err
 	}
+	// Seek thumbnails are optional; playback works without them.
+	storyboardURL, err := s.createAndUploadStoryboard(ctx, srcPath, tmpDir, media, probe)
+	if err != nil {
+		s.logger.Warn("storyboard generation failed", zap.String("media_id", media.ID), zap.Error(err))
+		storyboardURL = nil
+	}
+	if err := s.mediaRepo.UpdateStoryboardURL(ctx, media.ID, storyboardURL); err != nil {
+		return err
+	}
+
+	if media.Encrypted {
+		if err := s.encryptOutput(ctx, media, hlsDir); err != nil {
+			return err
+		}
+	}
 
 	prefix := path.Join("users", media.OwnerUserID, "media", media.ID, "hls")
 	if err := s.uploadHLSOutput(ctx, hlsDir, prefix, media.ID); err != nil {
 		return err
 	}
+	if err := s.replaceEmbeddedSubtitles(ctx, media.ID, embeddedSubtitles); err != nil {
+		return err
+	}
+	// The usage reconcile job repairs the sizes if this fails.
+	if err := s.recordStoredBytes(ctx, media); err != nil {
+		s.logger.Warn("record media stored bytes failed", zap.String("media_id", media.ID), zap.Error(err))
+	}
 
 	playbackKey := path.Join(prefix, "index.m3u8")
-	playbackURL := s.storage.generateObjectURL(playbackKey)
+	playbackURL := s.storage.ObjectURL(playbackKey)
 
 	if err := s.mediaRepo.UpdateTranscodeResult(
 		ctx,
@@ -240,6 +631,9 @@ func (s *MediaTranscoderService) processMediaInWorkspace(ctx context.Context, me
 		return err
 	}
 
+	zero := time.Duration(0)
+	s.setProgress(ctx, media.ID, repository.MediaStageDone, 100, &zero)
+
 	s.logger.Info("media upload completed",
 		zap.String("media_id", media.ID),
 		zap.String("playback_url", playbackURL),
@@ -248,6 +642,41 @@ func (s *MediaTranscoderService) processMediaInWorkspace(ctx context.Context, me
 	return nil
 }
 
+// recordStoredBytes counts the uploaded outputs towards the owner's quota.
+func (s *MediaTranscoderService) recordStoredBytes(ctx context.Context, media repository.Media) error {
+	sizes, err := storedMediaBytes(ctx, s.storage, path.Join("users", media.OwnerUserID, "media", media.ID)+"/")
+	if err != nil {
+		return err
+	}
+	stored, ok := sizes[media.ID]
+	if !ok {
+		return nil
+	}
+	_, err = s.mediaRepo.UpdateStoredBytes(ctx, media.ID, stored.original, stored.output)
+	return err
+}
+
+// encryptOutput encrypts the segments before they leave the workspace and
+// stores the keys first, so a playlist never references a missing key.
+func (s *MediaTranscoderService) encryptOutput(ctx context.Context, media repository.Media, hlsDir string) error {
+	if media.OutputFormat == repository.MediaFormatCMAF {
+		// fMP4 needs SAMPLE-AES (cbcs) packaging, which ffmpeg cannot produce.
+		return errors.New("encryption is only supported for hls_ts output")
+	}
+	if s.keyRepo == nil {
+		return errors.New("encryption requested but no key repository is configured")
+	}
+	keys, err := encryptHLSOutput(hlsDir, s.keyRotation)
+	if err != nil {
+		return fmt.Errorf("encrypt hls output: %w", err)
+	}
+	if err := s.keyRepo.ReplaceForMedia(ctx, media.ID, keys); err != nil {
+		return fmt.Errorf("store hls keys: %w", err)
+	}
+	s.logger.Info("media output encrypted", zap.String("media_id", media.ID), zap.Int("keys", len(keys)))
+	return nil
+}
+
 func (s *MediaTranscoderService) tempBaseDirs() []string {
 	out := make([]string, 0, 4)
 	seen := map[string]struct{}{}
@@ -293,116 +722,260 @@ func isNoSpaceErr(err error) bool {
 	return strings.Contains(strings.ToLower(err.Error()), "no space left on device")
 }
 
-func (s *MediaTranscoderService) runFFmpegHLS(ctx context.Context, srcPath, manifestPath, segmentPattern string, durationSec int) error {
-	profile := selectHLSEncodingProfile(durationSec)
+func (s *MediaTranscoderService) runFFmpegHLS(ctx context.Context, srcPath, hlsDir string, variants []hlsVariant, audio []hlsAudioRendition, profile hlsEncodingProfile, progress io.Writer) error {
 	args := []string{
 		"-y",
 		"-hide_banner",
 		"-nostats",
 		"-loglevel", "warning",
+		"-progress", "pipe:1",
 		"-i", srcPath,
-		"-map", "0:v:0",
-		"-map", "0:a:0?",
-		"-c:v", "libx264",
-		"-preset", profile.preset,
-		"-profile:v", "main",
-		"-level", "4.0",
-		"-pix_fmt", "yuv420p",
-		"-crf", strconv.Itoa(profile.crf),
-		"-maxrate", profile.maxrate,
-		"-bufsize", profile.bufsize,
-		"-threads", strconv.Itoa(profile.threads),
-		"-c:a", "aac",
-		"-b:a", profile.audioBitr,
-		"-ac", "2",
-		"-f", "hls",
-		"-hls_time", strconv.Itoa(s.segmentDuration),
-		"-hls_playlist_type", "vod",
-		"-hls_flags", "independent_segments",
-		"-hls_segment_filename", segmentPattern,
-		manifestPath,
+		"-filter_complex", buildScaleFilterGraph(variants),
+	}
+
+	// One decode feeds every rendition; keyframes are forced on segment
+	// boundaries so players can switch variants cleanly.
+	keyframes := fmt.Sprintf("expr:gte(t,n_forced*%d)", profile.segmentSec)
+	for i, variant := range variants {
+		variantDir := filepath.Join(hlsDir, variant.name)
+		if err := os.MkdirAll(variantDir, 0o755); err != nil {
+			return err
+		}
+		args = append(args, "-map", fmt.Sprintf("[v%d]", i))
+		if len(audio) == 0 {
+			args = append(args, "-map", "0:a:0?")
+		}
+		args = append(args,
+			"-c:v", profile.codec,
+			"-preset", profile.preset,
+		)
+		args = append(args, videoCodecProfileArgs(profile.codec)...)
+		args = append(args,
+			"-pix_fmt", "yuv420p",
+			"-crf", strconv.Itoa(profile.crf),
+			"-maxrate", kbps(variant.maxrateKbps),
+			"-bufsize", kbps(variant.maxrateKbps*2),
+			"-force_key_frames", keyframes,
+			"-threads", strconv.Itoa(profile.threads),
+		)
+		if len(audio) == 0 {
+			args = append(args, aacAudioArgs(profile.audioKbps)...)
+		}
+		args = append(args,
+			"-f", "hls",
+			"-hls_time", strconv.Itoa(profile.segmentSec),
+			"-hls_playlist_type", "vod",
+			"-hls_flags", "independent_segments",
+			"-hls_segment_filename", filepath.Join(variantDir, "segment_%05d.ts"),
+			filepath.Join(variantDir, "index.m3u8"),
+		)
 	}
+	audioArgs, err := hlsAudioOutputArgs(hlsDir, audio, profile.segmentSec, func(rendition hlsAudioRendition) []string {
+		return aacAudioArgs(rendition.kbps)
+	})
+	if err != nil {
+		return err
+	}
+	args = append(args, audioArgs...)
 
 	cmd := exec.CommandContext(ctx, s.ffmpegPath, args...)
 	stderr := &tailBuffer{maxBytes: 64 << 10}
-	cmd.Stdout = io.Discard
+	cmd.Stdout = progress
 	cmd.Stderr = stderr
-	err := cmd.Run()
-	if err != nil {
+	if err := cmd.Run(); err != nil {
 		return formatFFmpegError("ffmpeg hls", err, stderr.String())
 	}
 	return nil
 }
 
-func selectHLSEncodingProfile(durationSec int) hlsEncodingProfile {
-	const longMediaThresholdSec = 90 * 60
-	if durationSec >= longMediaThresholdSec {
-		return hlsEncodingProfile{
-			name:      "long-form-safe",
-			preset:    "superfast",
-			crf:       23,
-			maxrate:   "3500k",
-			bufsize:   "7000k",
-			audioBitr: "96k",
-			threads:   2,
-		}
+// runFFmpegCMAF packages the ladder as fragmented MP4 once and describes it
+// with both a DASH MPD and an HLS master playlist.
+func (s *MediaTranscoderService) runFFmpegCMAF(ctx context.Context, srcPath, outDir string, variants []hlsVariant, audio []hlsAudioRendition, profile hlsEncodingProfile, hasAudio bool, progress io.Writer) error {
+	args := []string{
+		"-y",
+		"-hide_banner",
+		"-nostats",
+		"-loglevel", "warning",
+		"-progress", "pipe:1",
+		"-i", srcPath,
+		"-filter_complex", buildScaleFilterGraph(variants),
 	}
-	return hlsEncodingProfile{
-		name:      "default",
-		preset:    "veryfast",
-		crf:       21,
-		maxrate:   "5000k",
-		bufsize:   "10000k",
-		audioBitr: "128k",
-		threads:   0,
+	for i := range variants {
+		args = append(args, "-map", fmt.Sprintf("[v%d]", i))
+	}
+	audioArgs, adaptationSets := dashAudioMapping(len(variants), audio, hasAudio)
+	args = append(args, audioArgs...)
+
+	keyframes := fmt.Sprintf("expr:gte(t,n_forced*%d)", profile.segmentSec)
+	args = append(args,
+		"-c:v", profile.codec,
+		"-preset", profile.preset,
+	)
+	args = append(args, videoCodecProfileArgs(profile.codec)...)
+	if profile.codec == "libx265" {
+		// Apple players only accept HEVC in fMP4 when tagged hvc1.
+		args = append(args, "-tag:v", "hvc1")
+	}
+	args = append(args,
+		"-pix_fmt", "yuv420p",
+		"-crf", strconv.Itoa(profile.crf),
+		"-force_key_frames", keyframes,
+		"-threads", strconv.Itoa(profile.threads),
+	)
+	for i, variant := range variants {
+		args = append(args,
+			fmt.Sprintf("-maxrate:v:%d", i), kbps(variant.maxrateKbps),
+			fmt.Sprintf("-bufsize:v:%d", i), kbps(variant.maxrateKbps*2),
+		)
+	}
+	if hasAudio {
+		args = append(args,
+			"-c:a", "aac",
+			"-b:a", kbps(profile.audioKbps),
+			"-ac", "2",
+		)
+	}
+	args = append(args, dashOutputArgs(outDir, profile.segmentSec, adaptationSets)...)
+
+	cmd := exec.CommandContext(ctx, s.ffmpegPath, args...)
+	stderr := &tailBuffer{maxBytes: 64 << 10}
+	cmd.Stdout = progress
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return formatFFmpegError("ffmpeg cmaf", err, stderr.String())
 	}
+	return nil
 }
 
-func (s *MediaTranscoderService) createAndUploadPreview(ctx context.Context, srcPath, previewPath string, media repository.Media) (*string, error) {
+// runFFmpegRemux repackages an already compatible source without touching the
+// streams. Segments can only start on source keyframes, so their length
+// follows the source GOP rather than segmentSec exactly.
+func (s *MediaTranscoderService) runFFmpegRemux(ctx context.Context, srcPath, outDir string, variant hlsVariant, audio []hlsAudioRendition, format repository.MediaOutputFormat, segmentSec int, hasAudio bool, progress io.Writer) error {
 	args := []string{
 		"-y",
 		"-hide_banner",
 		"-nostats",
 		"-loglevel", "warning",
-		"-ss", "00:00:01",
+		"-progress", "pipe:1",
 		"-i", srcPath,
-		"-frames:v", "1",
-		"-vf", "scale=640:-2",
-		previewPath,
+		"-map", "0:v:0",
 	}
+
+	if format == repository.MediaFormatCMAF {
+		audioArgs, adaptationSets := dashAudioMapping(1, audio, hasAudio)
+		args = append(args, audioArgs...)
+		args = append(args, "-c", "copy")
+		args = append(args, dashOutputArgs(outDir, segmentSec, adaptationSets)...)
+	} else {
+		variantDir := filepath.Join(outDir, variant.name)
+		if err := os.MkdirAll(variantDir, 0o755); err != nil {
+			return err
+		}
+		if hasAudio && len(audio) == 0 {
+			args = append(args, "-map", "0:a:0")
+		}
+		args = append(args,
+			"-c", "copy",
+			"-f", "hls",
+			"-hls_time", strconv.Itoa(segmentSec),
+			"-hls_playlist_type", "vod",
+			"-hls_flags", "independent_segments",
+			"-hls_segment_filename", filepath.Join(variantDir, "segment_%05d.ts"),
+			filepath.Join(variantDir, "index.m3u8"),
+		)
+		audioArgs, err := hlsAudioOutputArgs(outDir, audio, segmentSec, func(hlsAudioRendition) []string {
+			return []string{"-c:a", "copy"}
+		})
+		if err != nil {
+			return err
+		}
+		args = append(args, audioArgs...)
+	}
+
 	cmd := exec.CommandContext(ctx, s.ffmpegPath, args...)
-	stderr := &tailBuffer{maxBytes: 32 << 10}
-	cmd.Stdout = io.Discard
+	stderr := &tailBuffer{maxBytes: 64 << 10}
+	cmd.Stdout = progress
 	cmd.Stderr = stderr
-	err := cmd.Run()
-	if err != nil {
-		return nil, formatFFmpegError("ffmpeg preview", err, stderr.String())
+	if err := cmd.Run(); err != nil {
+		return formatFFmpegError("ffmpeg remux", err, stderr.String())
+	}
+	return nil
+}
+
+// dashAudioMapping maps the audio tracks for a DASH/CMAF output whose first
+// videoStreams output streams are video. Each alternate track gets its own
+// adaptation set so the generated HLS master lists it as a separate rendition.
+func dashAudioMapping(videoStreams int, audio []hlsAudioRendition, hasAudio bool) ([]string, string) {
+	adaptationSets := "id=0,streams=v"
+	if !hasAudio {
+		return nil, adaptationSets
+	}
+	if len(audio) == 0 {
+		return []string{"-map", "0:a:0"}, adaptationSets + " id=1,streams=a"
 	}
 
-	previewKey := path.Join("users", media.OwnerUserID, "media", media.ID, "preview", "preview.jpg")
-	if err := s.withRetry(ctx, "upload preview", media.ID, func() error {
-		return s.storage.UploadFilePublic(ctx, previewKey, "image/jpeg", previewPath)
-	}); err != nil {
-		return nil, err
+	args := make([]string, 0, len(audio)*4)
+	for i, rendition := range audio {
+		args = append(args, "-map", fmt.Sprintf("0:a:%d", rendition.trackIndex))
+		if rendition.language != "" {
+			args = append(args, fmt.Sprintf("-metadata:s:a:%d", i), "language="+rendition.language)
+		}
+		adaptationSets += fmt.Sprintf(" id=%d,streams=%d", i+1, videoStreams+i)
 	}
-	previewURL := s.storage.generateObjectURL(previewKey)
-	return &previewURL, nil
+	return args, adaptationSets
+}
+
+// dashOutputArgs writes a DASH MPD plus an HLS master over the same fMP4
+// segments. Segment lists are used instead of templates so every segment
+// URL can be signed individually.
+func dashOutputArgs(outDir string, segmentSec int, adaptationSets string) []string {
+	return []string{
+		"-f", "dash",
+		"-seg_duration", strconv.Itoa(segmentSec),
+		"-use_template", "0",
+		"-use_timeline", "0",
+		"-init_seg_name", "init_$RepresentationID$.m4s",
+		"-media_seg_name", "chunk_$RepresentationID$_$Number%05d$.m4s",
+		"-adaptation_sets", adaptationSets,
+		"-hls_playlist", "1",
+		"-hls_master_name", "index.m3u8",
+		filepath.Join(outDir, dashManifestName),
+	}
+}
+
+// videoCodecProfileArgs pins a profile/level that smart TVs and mobile
+// hardware decoders accept.
+func videoCodecProfileArgs(codec string) []string {
+	if codec == "libx265" {
+		return []string{"-profile:v", "main"}
+	}
+	return []string{"-profile:v", "main", "-level", "4.0"}
 }
 
 func (s *MediaTranscoderService) uploadHLSOutput(ctx context.Context, hlsDir, prefix, mediaID string) error {
-	entries, err := os.ReadDir(hlsDir)
-	if err != nil {
+	localPaths := make([]string, 0)
+	if err := filepath.WalkDir(hlsDir, func(localPath string, entry os.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if !entry.IsDir() {
+			localPaths = append(localPaths, localPath)
+		}
+		return nil
+	}); err != nil {
 		return err
 	}
 
-	for _, entry := range entries {
-		if entry.IsDir() {
-			continue
+	startedAt := time.Now()
+	lastReportAt := startedAt
+	s.setProgress(ctx, mediaID, repository.MediaStageUpload, 0, nil)
+	for idx, localPath := range localPaths {
+		relPath, err := filepath.Rel(hlsDir, localPath)
+		if err != nil {
+			return err
 		}
-
-		fileName := entry.Name()
-		localPath := filepath.Join(hlsDir, fileName)
-		targetKey := path.Join(prefix, fileName)
+		fileName := filepath.Base(localPath)
+		targetKey := path.Join(prefix, filepath.ToSlash(relPath))
 
 		contentType := "application/octet-stream"
 		switch {
@@ -410,17 +983,57 @@ func (s *MediaTranscoderService) uploadHLSOutput(ctx context.Context, hlsDir, pr
 			contentType = "application/vnd.apple.mpegurl"
 		case strings.HasSuffix(fileName, ".ts"):
 			contentType = "video/mp2t"
+		case strings.HasSuffix(fileName, ".m4s"):
+			contentType = "video/iso.segment"
+		case strings.HasSuffix(fileName, ".mpd"):
+			contentType = "application/dash+xml"
 		}
 
 		if err := s.withRetry(ctx, "upload hls segment", mediaID, func() error {
-			return s.storage.UploadFilePublic(ctx, targetKey, contentType, localPath)
+			return s.storage.UploadOutputFile(ctx, targetKey, contentType, localPath)
 		}); err != nil {
 			return err
 		}
+
+		if done := idx + 1; time.Since(lastReportAt) >= progressReportInterval && done < len(localPaths) {
+			lastReportAt = time.Now()
+			elapsed := time.Since(startedAt)
+			eta := elapsed * time.Duration(len(localPaths)-done) / time.Duration(done)
+			s.setProgress(ctx, mediaID, repository.MediaStageUpload, float64(done)*100/float64(len(localPaths)), &eta)
+		}
 	}
 	return nil
 }
 
+// progressReportInterval throttles progress writes to the media row.
+const progressReportInterval = 3 * time.Second
+
+func (s *MediaTranscoderService) setProgress(ctx context.Context, mediaID string, stage repository.MediaStage, percent float64, eta *time.Duration) {
+	progress := repository.MediaProgress{
+		Stage:     stage,
+		Percent:   math.Round(percent*10) / 10,
+		UpdatedAt: time.Now().UTC(),
+	}
+	if eta != nil {
+		etaSec := int(eta.Round(time.Second).Seconds())
+		progress.ETASec = &etaSec
+	}
+	if err := s.mediaRepo.UpdateProgress(ctx, mediaID, progress); err != nil && ctx.Err() == nil {
+		s.logger.Warn("update media progress", zap.String("media_id", mediaID), zap.Error(err))
+	}
+}
+
+func (s *MediaTranscoderService) encodeProgressWriter(ctx context.Context, mediaID string, durationSec int) io.Writer {
+	lastReportAt := time.Now()
+	return newFFmpegProgressWriter(durationSec, func(percent float64, eta *time.Duration) {
+		if percent < 100 && time.Since(lastReportAt) < progressReportInterval {
+			return
+		}
+		lastReportAt = time.Now()
+		s.setProgress(ctx, mediaID, repository.MediaStageEncode, percent, eta)
+	})
+}
+
 func (s *MediaTranscoderService) withRetry(ctx context.Context, opName, mediaID string, fn func() error) error {
 	const maxAttempts = 4
 	backoff := time.Second
@@ -477,14 +1090,14 @@ func deadlineRemaining(ctx context.Context) (time.Duration, bool) {
 }
 
 func wrapContextOpError(opName string, ctx context.Context, ctxErr error, lastErr error) error {
-	msg := fmt.Sprintf("%s: %w", opName, ctxErr)
+	var details string
 	if remaining, ok := deadlineRemaining(ctx); ok {
-		msg = fmt.Sprintf("%s (deadline_remaining=%s)", msg, remaining.Round(time.Millisecond))
+		details = fmt.Sprintf(" (deadline_remaining=%s)", remaining.Round(time.Millisecond))
 	}
 	if lastErr != nil && !errors.Is(lastErr, ctxErr) {
-		msg = fmt.Sprintf("%s; last error: %v", msg, lastErr)
+		details = fmt.Sprintf("%s; last error: %v", details, lastErr)
 	}
-	return errors.New(msg)
+	return fmt.Errorf("%s: %w%s", opName, ctxErr, details)
 }
 
 type tailBuffer struct {
@@ -527,31 +1140,3 @@ func formatFFmpegError(prefix string, err error, stderr string) error {
 	}
 	return fmt.Errorf("%s: %w: %s", prefix, err, stderr)
 }
-
-func (s *MediaTranscoderService) probeDurationSec(ctx context.Context, srcPath string) (int, error) {
-	cmd := exec.CommandContext(
-		ctx,
-		s.ffprobePath,
-		"-v", "error",
-		"-show_entries", "format=duration",
-		"-of", "default=noprint_wrappers=1:nokey=1",
-		srcPath,
-	)
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		return 0, fmt.Errorf("ffprobe duration: %w: %s", err, strings.TrimSpace(string(out)))
-	}
-
-	trimmed := strings.TrimSpace(string(out))
-	if trimmed == "" {
-		return 0, nil
-	}
-	durationFloat, err := strconv.ParseFloat(trimmed, 64)
-	if err != nil {
-		return 0, err
-	}
-	if durationFloat <= 0 {
-		return 0, nil
-	}
-	return int(math.Round(durationFloat)), nil
-}
@@ -0,0 +1,151 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"calixio/internal/repository"
+
+	"go.uber.org/zap"
+)
+
+type stopTestJobRepo struct {
+	repository.TranscodeJobRepository
+	mu       sync.Mutex
+	claimed  bool
+	finished []string
+}
+
+func (r *stopTestJobRepo) Claim(context.Context, string, time.Duration) (repository.TranscodeJob, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.claimed {
+		return repository.TranscodeJob{}, repository.ErrNotFound
+	}
+	r.claimed = true
+	return repository.TranscodeJob{ID: 1, MediaID: "media-1", Attempts: 1, MaxAttempts: 3}, nil
+}
+
+func (r *stopTestJobRepo) Heartbeat(context.Context, int64, string, time.Duration) error {
+	return nil
+}
+
+func (r *stopTestJobRepo) record(outcome string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.finished = append(r.finished, outcome)
+}
+
+func (r *stopTestJobRepo) Complete(context.Context, int64, string) error {
+	r.record("complete")
+	return nil
+}
+
+func (r *stopTestJobRepo) Fail(context.Context, int64, string, string, time.Duration) (repository.TranscodeJobStatus, error) {
+	r.record("fail")
+	return repository.TranscodeJobQueued, nil
+}
+
+func (r *stopTestJobRepo) Release(context.Context, int64, string) error {
+	r.record("release")
+	return nil
+}
+
+// stopTestMediaRepo stands in for a transcode: GetByID takes encodeTime
+// unless the job is cancelled first. The media is gone afterwards, which
+// completes the job without touching storage.
+type stopTestMediaRepo struct {
+	repository.MediaRepository
+	started    chan struct{}
+	encodeTime time.Duration
+}
+
+func (r *stopTestMediaRepo) GetByID(ctx context.Context, _ string) (repository.Media, error) {
+	close(r.started)
+	select {
+	case <-ctx.Done():
+		return repository.Media{}, ctx.Err()
+	case <-time.After(r.encodeTime):
+		return repository.Media{}, repository.ErrNotFound
+	}
+}
+
+func TestMediaTranscoderStop(t *testing.T) {
+	tests := []struct {
+		name          string
+		encodeTime    time.Duration
+		wantCancelled bool
+		wantOutcome   string
+	}{
+		{name: "finishes within grace", encodeTime: 10 * time.Millisecond, wantOutcome: "complete"},
+		{name: "outlives grace", encodeTime: time.Hour, wantCancelled: true, wantOutcome: "release"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jobRepo := &stopTestJobRepo{}
+			mediaRepo := &stopTestMediaRepo{started: make(chan struct{}), encodeTime: tt.encodeTime}
+			svc := &MediaTranscoderService{
+				mediaRepo:    mediaRepo,
+				jobRepo:      jobRepo,
+				jobTimeout:   time.Hour,
+				leaseTTL:     time.Minute,
+				pollInterval: time.Hour,
+				wake:         make(chan struct{}, 1),
+				logger:       zap.NewNop(),
+			}
+			// What Start does, without looking for ffmpeg.
+			ctx, stopClaiming := context.WithCancel(context.Background())
+			svc.jobsCtx, svc.cancelJobs = context.WithCancel(context.WithoutCancel(ctx))
+			svc.running.Add(1)
+			go func() {
+				defer svc.running.Done()
+				svc.worker(ctx)
+			}()
+
+			<-mediaRepo.started
+			stopClaiming()
+			if cancelled := svc.Stop(100 * time.Millisecond); cancelled != tt.wantCancelled {
+				t.Fatalf("Stop() = %v, want %v", cancelled, tt.wantCancelled)
+			}
+			if len(jobRepo.finished) != 1 || jobRepo.finished[0] != tt.wantOutcome {
+				t.Fatalf("job outcomes = %v, want [%s]", jobRepo.finished, tt.wantOutcome)
+			}
+		})
+	}
+}
+
+func TestWrapContextOpError(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name    string
+		lastErr error
+		want    string
+	}{
+		{name: "no attempt failed", want: "upload: context canceled"},
+		{name: "attempt failed", lastErr: errors.New("connection reset"), want: "upload: context canceled; last error: connection reset"},
+		{name: "attempt cancelled", lastErr: context.Canceled, want: "upload: context canceled"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := wrapContextOpError("upload", ctx, ctx.Err(), tt.lastErr)
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("error %v does not wrap context.Canceled", err)
+			}
+			if err.Error() != tt.want {
+				t.Fatalf("error = %q, want %q", err.Error(), tt.want)
+			}
+		})
+	}
+
+	deadlineCtx, cancelDeadline := context.WithTimeout(context.Background(), time.Hour)
+	defer cancelDeadline()
+	err := wrapContextOpError("upload", deadlineCtx, context.DeadlineExceeded, nil)
+	if !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "deadline_remaining=") {
+		t.Fatalf("error = %v, want a wrapped deadline with the time left", err)
+	}
+}
@@ -0,0 +1,246 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"calixio/internal/repository"
+)
+
+var ErrTusOffsetMismatch = errors.New("tus upload offset mismatch")
+
+type TusUploadInfo struct {
+	MediaID     string
+	LengthBytes int64
+	OffsetBytes int64
+	Status      repository.MediaStatus
+}
+
+type WriteTusChunkInput struct {
+	OwnerUserID string
+	MediaID     string
+	OffsetBytes int64
+	Body        io.Reader
+}
+
+// CreateTusUpload registers the media row exactly like InitUpload and opens an
+// S3 multipart upload that incoming tus chunks are streamed into.
+func (s *MediaUploadService) CreateTusUpload(ctx context.Context, in InitUploadInput) (TusUploadInfo, error) {
+	media, err := s.newUploadMedia(in)
+	if err != nil {
+		return TusUploadInfo{}, err
+	}
+	if err := s.checkQuota(ctx, media.OwnerUserID, "", media.FileSizeBytes); err != nil {
+		return TusUploadInfo{}, err
+	}
+
+	uploadID, err := s.storage.CreateMultipartUpload(ctx, media.StorageKey, media.MimeType)
+	if err != nil {
+		return TusUploadInfo{}, err
+	}
+
+	if _, err := s.mediaRepo.Create(ctx, media); err != nil {
+		_ = s.storage.AbortMultipartUpload(context.Background(), media.StorageKey, uploadID)
+		return TusUploadInfo{}, err
+	}
+	if err := s.uploadRepo.Create(ctx, repository.MediaUpload{
+		MediaID:       media.ID,
+		UploadID:      uploadID,
+		Protocol:      repository.UploadProtocolTus,
+		PartSizeBytes: multipartPartSize(in.SizeBytes),
+		CreatedAt:     media.CreatedAt,
+	}); err != nil {
+		_ = s.storage.AbortMultipartUpload(context.Background(), media.StorageKey, uploadID)
+		_ = s.mediaRepo.SoftDelete(context.Background(), media.ID, s.clock())
+		return TusUploadInfo{}, err
+	}
+
+	return TusUploadInfo{
+		MediaID:     media.ID,
+		LengthBytes: media.FileSizeBytes,
+		Status:      media.Status,
+	}, nil
+}
+
+func (s *MediaUploadService) GetTusUpload(ctx context.Context, ownerUserID, mediaID string) (TusUploadInfo, error) {
+	if strings.TrimSpace(ownerUserID) == "" || strings.TrimSpace(mediaID) == "" {
+		return TusUploadInfo{}, ErrInvalidUploadInput
+	}
+
+	media, err := s.mediaRepo.GetByID(ctx, mediaID)
+	if err != nil {
+		return TusUploadInfo{}, err
+	}
+	if media.OwnerUserID != ownerUserID {
+		return TusUploadInfo{}, ErrForbiddenMedia
+	}
+
+	upload, err := s.uploadRepo.GetByMediaID(ctx, media.ID)
+	if err != nil {
+		// The upload row is dropped once the last chunk has been assembled.
+		if errors.Is(err, repository.ErrNotFound) && media.Status != repository.MediaUploading {
+			return TusUploadInfo{
+				MediaID:     media.ID,
+				LengthBytes: media.FileSizeBytes,
+				OffsetBytes: media.FileSizeBytes,
+				Status:      media.Status,
+			}, nil
+		}
+		if errors.Is(err, repository.ErrNotFound) {
+			return TusUploadInfo{}, ErrMultipartUploadNotFound
+		}
+		return TusUploadInfo{}, err
+	}
+	if upload.Protocol != repository.UploadProtocolTus {
+		return TusUploadInfo{}, ErrMultipartUploadNotFound
+	}
+
+	return TusUploadInfo{
+		MediaID:     media.ID,
+		LengthBytes: media.FileSizeBytes,
+		OffsetBytes: upload.OffsetBytes,
+		Status:      media.Status,
+	}, nil
+}
+
+// WriteTusChunk appends a PATCH body to the upload. Full parts go straight to
+// S3; a trailing partial part is parked in a side object until the next chunk
+// fills it, because S3 only accepts the last part below the minimum size.
+func (s *MediaUploadService) WriteTusChunk(ctx context.Context, in WriteTusChunkInput) (TusUploadInfo, error) {
+	if in.Body == nil {
+		return TusUploadInfo{}, ErrInvalidUploadInput
+	}
+
+	media, upload, err := s.getPendingMultipartUpload(ctx, in.OwnerUserID, in.MediaID, repository.UploadProtocolTus)
+	if err != nil {
+		return TusUploadInfo{}, err
+	}
+	if in.OffsetBytes != upload.OffsetBytes {
+		return TusUploadInfo{}, ErrTusOffsetMismatch
+	}
+
+	incompleteKey := tusIncompletePartKey(media.StorageKey)
+	partSize := upload.PartSizeBytes
+	nextPart := int32(upload.OffsetBytes/partSize) + 1
+	buf := make([]byte, 0, partSize)
+	if buffered := upload.OffsetBytes % partSize; buffered > 0 && upload.OffsetBytes < media.FileSizeBytes {
+		data, err := s.storage.GetObjectBytes(ctx, incompleteKey)
+		if err != nil {
+			return TusUploadInfo{}, fmt.Errorf("load incomplete tus part: %w", err)
+		}
+		if int64(len(data)) != buffered {
+			return TusUploadInfo{}, fmt.Errorf("incomplete tus part has %d bytes, expected %d", len(data), buffered)
+		}
+		buf = append(buf, data...)
+	}
+
+	persisted := upload.OffsetBytes
+	offset := upload.OffsetBytes
+	body := io.LimitReader(in.Body, media.FileSizeBytes-offset)
+	var readErr error
+	for readErr == nil {
+		n, err := io.ReadFull(body, buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+n]
+		offset += int64(n)
+		readErr = err
+
+		if len(buf) == 0 || (int64(len(buf)) < partSize && offset < media.FileSizeBytes) {
+			continue
+		}
+		if _, err := s.storage.UploadPart(ctx, media.StorageKey, upload.UploadID, nextPart, buf); err != nil {
+			return TusUploadInfo{}, err
+		}
+		if err := s.uploadRepo.UpdateOffset(ctx, media.ID, persisted, offset); err != nil {
+			return TusUploadInfo{}, mapTusOffsetErr(err)
+		}
+		persisted = offset
+		nextPart++
+		buf = buf[:0]
+	}
+
+	// A dropped connection still keeps whatever bytes were received.
+	if len(buf) > 0 {
+		if err := s.storage.UploadBytes(ctx, incompleteKey, "application/octet-stream", buf); err != nil {
+			return TusUploadInfo{}, err
+		}
+		if err := s.uploadRepo.UpdateOffset(ctx, media.ID, persisted, offset); err != nil {
+			return TusUploadInfo{}, mapTusOffsetErr(err)
+		}
+		persisted = offset
+	}
+
+	info := TusUploadInfo{
+		MediaID:     media.ID,
+		LengthBytes: media.FileSizeBytes,
+		OffsetBytes: persisted,
+		Status:      media.Status,
+	}
+	if persisted < media.FileSizeBytes {
+		if readErr != nil && !errors.Is(readErr, io.EOF) && !errors.Is(readErr, io.ErrUnexpectedEOF) {
+			return info, readErr
+		}
+		return info, nil
+	}
+
+	// A PATCH at the final offset retries an assembly that failed earlier.
+	completed, err := s.finishTusUpload(ctx, media, upload)
+	if err != nil {
+		return TusUploadInfo{}, err
+	}
+	info.Status = completed.Status
+	return info, nil
+}
+
+func (s *MediaUploadService) TerminateTusUpload(ctx context.Context, ownerUserID, mediaID string) error {
+	media, upload, err := s.getPendingMultipartUpload(ctx, ownerUserID, mediaID, repository.UploadProtocolTus)
+	if err != nil {
+		return err
+	}
+
+	if err := s.storage.AbortMultipartUpload(ctx, media.StorageKey, upload.UploadID); err != nil {
+		return err
+	}
+	_ = s.storage.DeleteObject(ctx, tusIncompletePartKey(media.StorageKey))
+	if err := s.uploadRepo.Delete(ctx, media.ID); err != nil {
+		return err
+	}
+	return s.mediaRepo.SoftDelete(ctx, media.ID, s.clock())
+}
+
+func (s *MediaUploadService) finishTusUpload(ctx context.Context, media repository.Media, upload repository.MediaUpload) (CompleteUploadOutput, error) {
+	parts, err := s.storage.ListParts(ctx, media.StorageKey, upload.UploadID)
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		// Already assembled by a previous attempt; CompleteUpload checks the object.
+	case err != nil:
+		return CompleteUploadOutput{}, err
+	default:
+		parts, err = normalizeCompletedParts(parts, multipartPartCount(media.FileSizeBytes, upload.PartSizeBytes), requiresPartETags(s.storage))
+		if err != nil {
+			return CompleteUploadOutput{}, err
+		}
+		if err := s.storage.CompleteMultipartUpload(ctx, media.StorageKey, upload.UploadID, parts); err != nil {
+			return CompleteUploadOutput{}, err
+		}
+	}
+	_ = s.storage.DeleteObject(ctx, tusIncompletePartKey(media.StorageKey))
+	if err := s.uploadRepo.Delete(ctx, media.ID); err != nil {
+		return CompleteUploadOutput{}, err
+	}
+
+	return s.CompleteUpload(ctx, CompleteUploadInput{OwnerUserID: media.OwnerUserID, MediaID: media.ID})
+}
+
+func tusIncompletePartKey(storageKey string) string {
+	return storageKey + ".tus-incomplete"
+}
+
+func mapTusOffsetErr(err error) error {
+	if errors.Is(err, repository.ErrNotFound) {
+		return ErrTusOffsetMismatch
+	}
+	return err
+}
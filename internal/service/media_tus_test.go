@@ -0,0 +1,139 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"calixio/internal/repository"
+)
+
+type tusTestMediaRepo struct {
+	repository.MediaRepository
+	media repository.Media
+}
+
+func (r *tusTestMediaRepo) GetByID(_ context.Context, id string) (repository.Media, error) {
+	if id != r.media.ID {
+		return repository.Media{}, repository.ErrNotFound
+	}
+	return r.media, nil
+}
+
+type tusTestUploadRepo struct {
+	repository.MediaUploadRepository
+	upload repository.MediaUpload
+	// movedTo simulates another PATCH moving the offset between the read
+	// and the update.
+	movedTo *int64
+}
+
+func (r *tusTestUploadRepo) GetByMediaID(_ context.Context, mediaID string) (repository.MediaUpload, error) {
+	if mediaID != r.upload.MediaID {
+		return repository.MediaUpload{}, repository.ErrNotFound
+	}
+	return r.upload, nil
+}
+
+func (r *tusTestUploadRepo) UpdateOffset(_ context.Context, _ string, fromOffset, toOffset int64) error {
+	if r.movedTo != nil {
+		r.upload.OffsetBytes = *r.movedTo
+	}
+	if r.upload.OffsetBytes != fromOffset {
+		return repository.ErrNotFound
+	}
+	r.upload.OffsetBytes = toOffset
+	return nil
+}
+
+type tusTestStorage struct {
+	Storage
+	parts   []int32
+	pending []byte
+}
+
+func (s *tusTestStorage) UploadPart(_ context.Context, _, _ string, partNumber int32, _ []byte) (string, error) {
+	s.parts = append(s.parts, partNumber)
+	return "etag", nil
+}
+
+func (s *tusTestStorage) UploadBytes(_ context.Context, _, _ string, data []byte) error {
+	s.pending = append([]byte(nil), data...)
+	return nil
+}
+
+func TestWriteTusChunkOffset(t *testing.T) {
+	movedTo := int64(4)
+	tests := []struct {
+		name        string
+		stored      int64
+		client      int64
+		body        string
+		movedTo     *int64
+		wantErr     error
+		wantOffset  int64
+		wantParts   int
+		wantPending string
+	}{
+		{name: "client behind", stored: 4, client: 0, body: "abcd", wantErr: ErrTusOffsetMismatch, wantOffset: 4},
+		{name: "client ahead", stored: 0, client: 4, body: "abcd", wantErr: ErrTusOffsetMismatch, wantOffset: 0},
+		{name: "concurrent write", stored: 0, client: 0, body: "abcd", movedTo: &movedTo, wantErr: ErrTusOffsetMismatch, wantOffset: 4, wantParts: 1},
+		{name: "matching offset", stored: 0, client: 0, body: "abcdef", wantOffset: 6, wantParts: 1, wantPending: "ef"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			media := repository.Media{
+				ID:            "media-1",
+				OwnerUserID:   "user-1",
+				StorageKey:    "users/user-1/media/media-1/original.mp4",
+				FileSizeBytes: 10,
+				Status:        repository.MediaUploading,
+			}
+			uploads := &tusTestUploadRepo{
+				upload: repository.MediaUpload{
+					MediaID:       media.ID,
+					UploadID:      "upload-1",
+					Protocol:      repository.UploadProtocolTus,
+					PartSizeBytes: 4,
+					OffsetBytes:   tt.stored,
+				},
+				movedTo: tt.movedTo,
+			}
+			storage := &tusTestStorage{}
+			svc := NewMediaUploadService(NewMediaUploadServiceInput{
+				MediaRepo:  &tusTestMediaRepo{media: media},
+				UploadRepo: uploads,
+				Storage:    storage,
+			})
+
+			info, err := svc.WriteTusChunk(context.Background(), WriteTusChunkInput{
+				OwnerUserID: media.OwnerUserID,
+				MediaID:     media.ID,
+				OffsetBytes: tt.client,
+				Body:        strings.NewReader(tt.body),
+			})
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("error = %v, want %v", err, tt.wantErr)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if info.OffsetBytes != tt.wantOffset {
+					t.Fatalf("reported offset = %d, want %d", info.OffsetBytes, tt.wantOffset)
+				}
+			}
+			if uploads.upload.OffsetBytes != tt.wantOffset {
+				t.Fatalf("stored offset = %d, want %d", uploads.upload.OffsetBytes, tt.wantOffset)
+			}
+			if len(storage.parts) != tt.wantParts {
+				t.Fatalf("uploaded %d parts, want %d", len(storage.parts), tt.wantParts)
+			}
+			if string(storage.pending) != tt.wantPending {
+				t.Fatalf("pending part = %q, want %q", storage.pending, tt.wantPending)
+			}
+		})
+	}
+}
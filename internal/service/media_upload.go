@@ -7,45 +7,98 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"html"
+	"net/http"
 	"path"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
 	"time"
 
 	"calixio/internal/repository"
 	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
 )
 
 var (
-	ErrInvalidUploadInput = errors.New("invalid upload input")
-	ErrForbiddenMedia     = errors.New("forbidden media")
-	ErrMediaNotReady      = errors.New("media is not ready")
-	ErrUploadedObjectNotFound = errors.New("uploaded object not found")
-	ErrStorageDelete      = errors.New("media storage delete failed")
-	ErrInvalidManifestKey = errors.New("invalid playback manifest token")
+	ErrInvalidUploadInput      = errors.New("invalid upload input")
+	ErrForbiddenMedia          = errors.New("forbidden media")
+	ErrMediaNotReady           = errors.New("media is not ready")
+	ErrUploadedObjectNotFound  = errors.New("uploaded object not found")
+	ErrStorageDelete           = errors.New("media storage delete failed")
+	ErrInvalidManifestKey      = errors.New("invalid playback manifest token")
+	ErrMultipartUploadRequired = errors.New("multipart upload is required for this size")
+	ErrMultipartUploadNotFound = errors.New("multipart upload not found")
+	ErrInvalidMultipartParts   = errors.New("invalid multipart upload parts")
+)
+
+const (
+	dashManifestName  = "manifest.mpd"
+	storyboardDir     = "storyboard"
+	storyboardVTTName = storyboardDir + "/thumbnails.vtt"
+)
+
+const (
+	// S3 rejects single PUT uploads above 5 GiB.
+	maxSinglePutUploadBytes = 5 * 1024 * 1024 * 1024
+	minMultipartPartBytes   = 16 * 1024 * 1024
+	maxMultipartParts       = 10000
 )
 
 type MediaUploadService struct {
 	mediaRepo        repository.MediaRepository
-	storage          *StorageService
+	uploadRepo       repository.MediaUploadRepository
+	subtitleRepo     repository.MediaSubtitleRepository
+	keyRepo          repository.MediaKeyRepository
+	tagRepo          repository.TagRepository
+	collectionRepo   repository.CollectionRepository
+	storage          Storage
 	transcoder       *MediaTranscoderService
+	usage            *UsageService
 	cache            *redis.Client
 	maxSizeBytes     int64
 	allowedMimeTypes map[string]struct{}
 	presignTTL       time.Duration
 	playbackTTL      time.Duration
+	segmentProxy     bool
+	probeUploads     bool
+	ffprobePath      string
+	importClient     *http.Client
+	importHosts      []string
+	importTimeout    time.Duration
+	logger           *zap.Logger
 	clock            func() time.Time
 }
 
 type NewMediaUploadServiceInput struct {
 	MediaRepo         repository.MediaRepository
-	Storage           *StorageService
+	UploadRepo        repository.MediaUploadRepository
+	SubtitleRepo      repository.MediaSubtitleRepository
+	KeyRepo           repository.MediaKeyRepository
+	TagRepo           repository.TagRepository
+	CollectionRepo    repository.CollectionRepository
+	Storage           Storage
 	Transcoder        *MediaTranscoderService
+	Usage             *UsageService
 	Cache             *redis.Client
 	MaxSizeBytes      int64
 	AllowedMimeTypes  []string
 	PresignURLTTL     time.Duration
 	PlaybackSignedTTL time.Duration
+	// ProxySegments points playlists at /media/playback/{token}/... for
+	// segments too, so the API streams them instead of presigning.
+	ProxySegments bool
+	// ProbeUploads runs ffprobe on every completed upload; content
+	// sniffing happens regardless.
+	ProbeUploads bool
+	FFprobePath  string
+	// ImportAllowedHosts limits POST /media/import sources to these hosts
+	// and their subdomains; empty allows any public host.
+	ImportAllowedHosts []string
+	ImportMaxRedirects int
+	ImportTimeout      time.Duration
+	Logger             *zap.Logger
 }
 
 func NewMediaUploadService(in NewMediaUploadServiceInput) *MediaUploadService {
@@ -78,17 +131,54 @@ func NewMediaUploadService(in NewMediaUploadServiceInput) *MediaUploadService {
 		allowed["video/x-matroska"] = struct{}{}
 	}
 
-	return &MediaUploadService{
+	importHosts := make([]string, 0, len(in.ImportAllowedHosts))
+	for _, host := range in.ImportAllowedHosts {
+		if normalized := strings.ToLower(strings.Trim(strings.TrimSpace(host), ".")); normalized != "" {
+			importHosts = append(importHosts, normalized)
+		}
+	}
+	importMaxRedirects := in.ImportMaxRedirects
+	if importMaxRedirects < 0 {
+		importMaxRedirects = 0
+	}
+	importTimeout := in.ImportTimeout
+	if importTimeout <= 0 {
+		importTimeout = 6 * time.Hour
+	}
+	ffprobePath := strings.TrimSpace(in.FFprobePath)
+	if ffprobePath == "" {
+		ffprobePath = "ffprobe"
+	}
+	logger := in.Logger
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+
+	svc := &MediaUploadService{
 		mediaRepo:        in.MediaRepo,
+		uploadRepo:       in.UploadRepo,
+		subtitleRepo:     in.SubtitleRepo,
+		keyRepo:          in.KeyRepo,
+		tagRepo:          in.TagRepo,
+		collectionRepo:   in.CollectionRepo,
 		storage:          in.Storage,
 		transcoder:       in.Transcoder,
+		usage:            in.Usage,
 		cache:            in.Cache,
 		maxSizeBytes:     maxSize,
 		allowedMimeTypes: allowed,
 		presignTTL:       ttl,
 		playbackTTL:      playbackTTL,
+		segmentProxy:     in.ProxySegments,
+		probeUploads:     in.ProbeUploads,
+		ffprobePath:      ffprobePath,
+		importHosts:      importHosts,
+		importTimeout:    importTimeout,
+		logger:           logger,
 		clock:            time.Now,
 	}
+	svc.importClient = svc.newImportHTTPClient(importMaxRedirects)
+	return svc
 }
 
 type InitUploadInput struct {
@@ -96,6 +186,12 @@ type InitUploadInput struct {
 	FileName    string
 	ContentType string
 	SizeBytes   int64
+	Format      string
+	// Encrypted asks for AES-128 segments; only hls_ts output supports it.
+	Encrypted bool
+	// ContentSHA256 is the optional hex digest of the file. The transcoder
+	// verifies it and reuses the outputs of an identical ready media.
+	ContentSHA256 string
 }
 
 type InitUploadOutput struct {
@@ -104,6 +200,31 @@ type InitUploadOutput struct {
 	UploadURL  string
 }
 
+type InitMultipartUploadOutput struct {
+	MediaID       string
+	StorageKey    string
+	UploadID      string
+	PartSizeBytes int64
+	PartCount     int
+}
+
+type SignUploadPartsInput struct {
+	OwnerUserID string
+	MediaID     string
+	PartNumbers []int32
+}
+
+type UploadPartURL struct {
+	PartNumber int32
+	UploadURL  string
+}
+
+type CompleteMultipartUploadInput struct {
+	OwnerUserID string
+	MediaID     string
+	Parts       []CompletedPart
+}
+
 type CompleteUploadInput struct {
 	OwnerUserID string
 	MediaID     string
@@ -115,89 +236,322 @@ type CompleteUploadOutput struct {
 }
 
 type PlaybackOutput struct {
-	MediaID     string
-	Status      repository.MediaStatus
-	Manifest    string
-	ManifestURL *string
-	PreviewURL  *string
-	ExpiresAt   time.Time
+	MediaID         string
+	Status          repository.MediaStatus
+	Format          repository.MediaOutputFormat
+	Manifest        string
+	ManifestURL     *string
+	DashManifestURL *string
+	PreviewURL      *string
+	StoryboardURL   *string
+	ExpiresAt       time.Time
+
+	hasStoryboard bool
 }
 
 type playbackCacheRecord struct {
-	MediaID    string  `json:"mediaId"`
-	Status     string  `json:"status"`
-	Manifest   string  `json:"manifest"`
-	PreviewURL *string `json:"previewUrl,omitempty"`
-	ExpiresAt  string  `json:"expiresAt"`
+	MediaID       string  `json:"mediaId"`
+	Status        string  `json:"status"`
+	Format        string  `json:"format,omitempty"`
+	Manifest      string  `json:"manifest"`
+	PreviewURL    *string `json:"previewUrl,omitempty"`
+	HasStoryboard bool    `json:"hasStoryboard,omitempty"`
+	ExpiresAt     string  `json:"expiresAt"`
+}
+
+func (s *MediaUploadService) MaxUploadBytes() int64 {
+	return s.maxSizeBytes
+}
+
+func (s *MediaUploadService) GetMedia(ctx context.Context, ownerUserID, mediaID string) (repository.Media, error) {
+	if strings.TrimSpace(ownerUserID) == "" || strings.TrimSpace(mediaID) == "" {
+		return repository.Media{}, ErrInvalidUploadInput
+	}
+
+	media, err := s.mediaRepo.GetByID(ctx, mediaID)
+	if err != nil {
+		return repository.Media{}, err
+	}
+	if media.OwnerUserID != ownerUserID {
+		return repository.Media{}, ErrForbiddenMedia
+	}
+
+	items := []repository.Media{media}
+	if err := s.loadMediaLabels(ctx, items); err != nil {
+		return repository.Media{}, err
+	}
+	media = items[0]
+	s.signPreviewURL(ctx, &media)
+	return media, nil
 }
 
-func (s *MediaUploadService) ListByOwner(ctx context.Context, ownerUserID string) ([]repository.Media, error) {
-	if strings.TrimSpace(ownerUserID) == "" {
+func (s *MediaUploadService) signPreviewURL(ctx context.Context, media *repository.Media) {
+	if media.PreviewURL == nil {
+		return
+	}
+
+	previewKey := path.Join("users", media.OwnerUserID, "media", media.ID, "preview", "preview.jpg")
+	signedURL, err := s.storage.PresignGetObject(ctx, previewKey, s.presignTTL)
+	if err != nil {
+		return
+	}
+	media.PreviewURL = &signedURL
+}
+
+func (s *MediaUploadService) InitUpload(ctx context.Context, in InitUploadInput) (InitUploadOutput, error) {
+	media, err := s.newUploadMedia(in)
+	if err != nil {
+		return InitUploadOutput{}, err
+	}
+	if in.SizeBytes > maxSinglePutUploadBytes {
+		return InitUploadOutput{}, ErrMultipartUploadRequired
+	}
+	if err := s.checkQuota(ctx, media.OwnerUserID, "", media.FileSizeBytes); err != nil {
+		return InitUploadOutput{}, err
+	}
+
+	uploadURL, err := s.storage.PresignPutObject(ctx, media.StorageKey, in.ContentType, s.presignTTL)
+	if err != nil {
+		return InitUploadOutput{}, err
+	}
+
+	if _, err := s.mediaRepo.Create(ctx, media); err != nil {
+		return InitUploadOutput{}, err
+	}
+
+	return InitUploadOutput{MediaID: media.ID, StorageKey: media.StorageKey, UploadURL: uploadURL}, nil
+}
+
+func (s *MediaUploadService) InitMultipartUpload(ctx context.Context, in InitUploadInput) (InitMultipartUploadOutput, error) {
+	media, err := s.newUploadMedia(in)
+	if err != nil {
+		return InitMultipartUploadOutput{}, err
+	}
+	if err := s.checkQuota(ctx, media.OwnerUserID, "", media.FileSizeBytes); err != nil {
+		return InitMultipartUploadOutput{}, err
+	}
+
+	uploadID, err := s.storage.CreateMultipartUpload(ctx, media.StorageKey, media.MimeType)
+	if err != nil {
+		return InitMultipartUploadOutput{}, err
+	}
+
+	partSize := multipartPartSize(in.SizeBytes)
+	if _, err := s.mediaRepo.Create(ctx, media); err != nil {
+		_ = s.storage.AbortMultipartUpload(context.Background(), media.StorageKey, uploadID)
+		return InitMultipartUploadOutput{}, err
+	}
+	if err := s.uploadRepo.Create(ctx, repository.MediaUpload{
+		MediaID:       media.ID,
+		UploadID:      uploadID,
+		Protocol:      repository.UploadProtocolMultipart,
+		PartSizeBytes: partSize,
+		CreatedAt:     media.CreatedAt,
+	}); err != nil {
+		_ = s.storage.AbortMultipartUpload(context.Background(), media.StorageKey, uploadID)
+		_ = s.mediaRepo.SoftDelete(context.Background(), media.ID, s.clock())
+		return InitMultipartUploadOutput{}, err
+	}
+
+	return InitMultipartUploadOutput{
+		MediaID:       media.ID,
+		StorageKey:    media.StorageKey,
+		UploadID:      uploadID,
+		PartSizeBytes: partSize,
+		PartCount:     multipartPartCount(in.SizeBytes, partSize),
+	}, nil
+}
+
+func (s *MediaUploadService) SignUploadParts(ctx context.Context, in SignUploadPartsInput) ([]UploadPartURL, error) {
+	if len(in.PartNumbers) == 0 {
 		return nil, ErrInvalidUploadInput
 	}
-	items, err := s.mediaRepo.ListByOwner(ctx, ownerUserID)
+
+	media, upload, err := s.getPendingMultipartUpload(ctx, in.OwnerUserID, in.MediaID, repository.UploadProtocolMultipart)
 	if err != nil {
 		return nil, err
 	}
 
-	for i := range items {
-		if items[i].PreviewURL == nil {
-			continue
+	partCount := multipartPartCount(media.FileSizeBytes, upload.PartSizeBytes)
+	out := make([]UploadPartURL, 0, len(in.PartNumbers))
+	for _, partNumber := range in.PartNumbers {
+		if partNumber < 1 || int(partNumber) > partCount {
+			return nil, ErrInvalidMultipartParts
+		}
+		uploadURL, err := s.storage.PresignUploadPart(ctx, media.StorageKey, upload.UploadID, partNumber, s.presignTTL)
+		if err != nil {
+			return nil, err
 		}
+		out = append(out, UploadPartURL{PartNumber: partNumber, UploadURL: uploadURL})
+	}
+	return out, nil
+}
 
-		previewKey := path.Join("users", items[i].OwnerUserID, "media", items[i].ID, "preview", "preview.jpg")
-		signedURL, signErr := s.storage.PresignGetObject(ctx, previewKey, s.presignTTL)
-		if signErr != nil {
-			continue
+func (s *MediaUploadService) CompleteMultipartUpload(ctx context.Context, in CompleteMultipartUploadInput) (CompleteUploadOutput, error) {
+	media, upload, err := s.getPendingMultipartUpload(ctx, in.OwnerUserID, in.MediaID, repository.UploadProtocolMultipart)
+	if err != nil {
+		return CompleteUploadOutput{}, err
+	}
+
+	parts, err := normalizeCompletedParts(in.Parts, multipartPartCount(media.FileSizeBytes, upload.PartSizeBytes), requiresPartETags(s.storage))
+	if err != nil {
+		return CompleteUploadOutput{}, err
+	}
+
+	if err := s.storage.CompleteMultipartUpload(ctx, media.StorageKey, upload.UploadID, parts); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return CompleteUploadOutput{}, ErrMultipartUploadNotFound
 		}
-		items[i].PreviewURL = &signedURL
+		return CompleteUploadOutput{}, err
+	}
+	if err := s.uploadRepo.Delete(ctx, media.ID); err != nil {
+		return CompleteUploadOutput{}, err
 	}
 
-	return items, nil
+	return s.CompleteUpload(ctx, CompleteUploadInput{OwnerUserID: in.OwnerUserID, MediaID: media.ID})
 }
 
-func (s *MediaUploadService) InitUpload(ctx context.Context, in InitUploadInput) (InitUploadOutput, error) {
+func (s *MediaUploadService) AbortMultipartUpload(ctx context.Context, ownerUserID, mediaID string) error {
+	media, upload, err := s.getPendingMultipartUpload(ctx, ownerUserID, mediaID, repository.UploadProtocolMultipart)
+	if err != nil {
+		return err
+	}
+
+	if err := s.storage.AbortMultipartUpload(ctx, media.StorageKey, upload.UploadID); err != nil {
+		return err
+	}
+	if err := s.uploadRepo.Delete(ctx, media.ID); err != nil {
+		return err
+	}
+	return s.mediaRepo.SoftDelete(ctx, media.ID, s.clock())
+}
+
+func (s *MediaUploadService) checkQuota(ctx context.Context, ownerUserID, mediaID string, sizeBytes int64) error {
+	if s.usage == nil {
+		return nil
+	}
+	return s.usage.CheckQuota(ctx, ownerUserID, mediaID, sizeBytes)
+}
+
+// discardUpload drops an upload that may not be kept, so it stops counting
+// towards the owner's usage.
+func (s *MediaUploadService) discardUpload(media repository.Media) {
+	ctx := context.Background()
+	_ = s.storage.DeleteObject(ctx, media.StorageKey)
+	_ = s.mediaRepo.SoftDelete(ctx, media.ID, s.clock())
+}
+
+func (s *MediaUploadService) newUploadMedia(in InitUploadInput) (repository.Media, error) {
 	if strings.TrimSpace(in.OwnerUserID) == "" || strings.TrimSpace(in.FileName) == "" {
-		return InitUploadOutput{}, ErrInvalidUploadInput
+		return repository.Media{}, ErrInvalidUploadInput
 	}
 	if in.SizeBytes <= 0 || in.SizeBytes > s.maxSizeBytes {
-		return InitUploadOutput{}, ErrInvalidUploadInput
+		return repository.Media{}, ErrInvalidUploadInput
 	}
 	if !s.isAllowedMime(in.ContentType) {
-		return InitUploadOutput{}, ErrInvalidUploadInput
+		return repository.Media{}, ErrInvalidUploadInput
+	}
+	format, ok := parseOutputFormat(in.Format)
+	if !ok {
+		return repository.Media{}, ErrInvalidUploadInput
+	}
+	if in.Encrypted && format != repository.MediaFormatHLSTS {
+		return repository.Media{}, ErrInvalidUploadInput
+	}
+	contentSHA256, ok := normalizeContentSHA256(in.ContentSHA256)
+	if !ok {
+		return repository.Media{}, ErrInvalidUploadInput
 	}
 
 	mediaID, err := newMediaID()
 	if err != nil {
-		return InitUploadOutput{}, err
+		return repository.Media{}, err
 	}
 
 	safeName := sanitizeFilename(in.FileName)
 	storageKey := path.Join("users", in.OwnerUserID, "media", mediaID, "original", safeName)
 
-	uploadURL, err := s.storage.PresignPutObject(ctx, storageKey, in.ContentType, s.presignTTL)
-	if err != nil {
-		return InitUploadOutput{}, err
-	}
-
-	created := s.clock()
 	media := repository.Media{
 		ID:            mediaID,
 		OwnerUserID:   in.OwnerUserID,
 		Title:         buildTitleFromFilename(safeName),
 		OriginalName:  in.FileName,
 		StorageKey:    storageKey,
-		PlaybackURL:   s.storage.generateObjectURL(storageKey),
+		PlaybackURL:   s.storage.ObjectURL(storageKey),
 		FileSizeBytes: in.SizeBytes,
 		MimeType:      strings.ToLower(strings.TrimSpace(in.ContentType)),
 		Status:        repository.MediaUploading,
-		CreatedAt:     created,
+		OutputFormat:  format,
+		Encrypted:     in.Encrypted,
+		CreatedAt:     s.clock(),
 	}
-	if _, err := s.mediaRepo.Create(ctx, media); err != nil {
-		return InitUploadOutput{}, err
+	if contentSHA256 != "" {
+		media.ContentSHA256 = &contentSHA256
+	}
+	return media, nil
+}
+
+func (s *MediaUploadService) getPendingMultipartUpload(ctx context.Context, ownerUserID, mediaID string, protocol repository.UploadProtocol) (repository.Media, repository.MediaUpload, error) {
+	if strings.TrimSpace(ownerUserID) == "" || strings.TrimSpace(mediaID) == "" {
+		return repository.Media{}, repository.MediaUpload{}, ErrInvalidUploadInput
 	}
 
-	return InitUploadOutput{MediaID: mediaID, StorageKey: storageKey, UploadURL: uploadURL}, nil
+	media, err := s.mediaRepo.GetByID(ctx, mediaID)
+	if err != nil {
+		return repository.Media{}, repository.MediaUpload{}, err
+	}
+	if media.OwnerUserID != ownerUserID {
+		return repository.Media{}, repository.MediaUpload{}, ErrForbiddenMedia
+	}
+
+	upload, err := s.uploadRepo.GetByMediaID(ctx, media.ID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return repository.Media{}, repository.MediaUpload{}, ErrMultipartUploadNotFound
+		}
+		return repository.Media{}, repository.MediaUpload{}, err
+	}
+	if upload.Protocol != protocol {
+		return repository.Media{}, repository.MediaUpload{}, ErrMultipartUploadNotFound
+	}
+	if media.Status != repository.MediaUploading {
+		return repository.Media{}, repository.MediaUpload{}, ErrInvalidUploadInput
+	}
+	return media, upload, nil
+}
+
+// multipartPartSize keeps the part count under the S3 limit of 10000 parts.
+func multipartPartSize(totalBytes int64) int64 {
+	partSize := int64(minMultipartPartBytes)
+	for multipartPartCount(totalBytes, partSize) > maxMultipartParts {
+		partSize *= 2
+	}
+	return partSize
+}
+
+func multipartPartCount(totalBytes, partSize int64) int {
+	if totalBytes <= 0 || partSize <= 0 {
+		return 0
+	}
+	return int((totalBytes + partSize - 1) / partSize)
+}
+
+// normalizeCompletedParts sorts parts and checks that they number 1 to
+// partCount, each with an ETag when requireETags is set.
+func normalizeCompletedParts(parts []CompletedPart, partCount int, requireETags bool) ([]CompletedPart, error) {
+	if len(parts) == 0 || len(parts) != partCount {
+		return nil, ErrInvalidMultipartParts
+	}
+
+	out := make([]CompletedPart, len(parts))
+	copy(out, parts)
+	sort.Slice(out, func(i, j int) bool { return out[i].PartNumber < out[j].PartNumber })
+	for i, part := range out {
+		if part.PartNumber != int32(i+1) || (requireETags && strings.TrimSpace(part.ETag) == "") {
+			return nil, ErrInvalidMultipartParts
+		}
+	}
+	return out, nil
 }
 
 func (s *MediaUploadService) CompleteUpload(ctx context.Context, in CompleteUploadInput) (CompleteUploadOutput, error) {
@@ -223,11 +577,24 @@ func (s *MediaUploadService) CompleteUpload(ctx context.Context, in CompleteUplo
 	if head.ContentLength <= 0 {
 		return CompleteUploadOutput{}, ErrInvalidUploadInput
 	}
-	if !s.isAllowedMime(head.ContentType) {
-		return CompleteUploadOutput{}, ErrInvalidUploadInput
+	mimeType, err := s.validateUploadedObject(ctx, media.StorageKey)
+	if err != nil {
+		var rejected *MediaRejectedError
+		if errors.As(err, &rejected) {
+			if rejectErr := s.rejectUpload(media, rejected.Reason); rejectErr != nil {
+				return CompleteUploadOutput{}, rejectErr
+			}
+		}
+		return CompleteUploadOutput{}, err
+	}
+	// The declared size was checked at init; the stored object may differ,
+	// and other uploads may have finished in the meantime.
+	if err := s.checkQuota(ctx, media.OwnerUserID, media.ID, head.ContentLength); err != nil {
+		s.discardUpload(media)
+		return CompleteUploadOutput{}, err
 	}
 
-	if err := s.mediaRepo.UpdateUploadState(ctx, media.ID, repository.MediaUploaded, head.ContentLength, normalizeMime(head.ContentType)); err != nil {
+	if err := s.mediaRepo.UpdateUploadState(ctx, media.ID, repository.MediaUploaded, head.ContentLength, mimeType); err != nil {
 		return CompleteUploadOutput{}, err
 	}
 
@@ -238,7 +605,7 @@ func (s *MediaUploadService) CompleteUpload(ctx context.Context, in CompleteUplo
 	if err := s.mediaRepo.UpdateStatus(ctx, media.ID, repository.MediaProcessing); err != nil {
 		return CompleteUploadOutput{}, err
 	}
-	if err := s.transcoder.Enqueue(media.ID); err != nil {
+	if err := s.transcoder.Enqueue(ctx, media.ID); err != nil {
 		_ = s.mediaRepo.UpdateStatus(context.Background(), media.ID, repository.MediaFailed)
 		return CompleteUploadOutput{}, err
 	}
@@ -251,26 +618,8 @@ func (s *MediaUploadService) GetPlayback(ctx context.Context, ownerUserID, media
 		return PlaybackOutput{}, ErrInvalidUploadInput
 	}
 
-	cacheKey := s.playbackCacheKey(mediaID)
-	if s.cache != nil {
-		cached, err := s.cache.Get(ctx, cacheKey).Result()
-		if err == nil && strings.TrimSpace(cached) != "" {
-			var record playbackCacheRecord
-			if unmarshalErr := json.Unmarshal([]byte(cached), &record); unmarshalErr == nil {
-				expiresAt, parseErr := time.Parse(time.RFC3339, record.ExpiresAt)
-				if parseErr == nil {
-					manifestURL := s.buildManifestProxyURL(ctx, record.MediaID)
-					return PlaybackOutput{
-						MediaID:     record.MediaID,
-						Status:      repository.MediaStatus(record.Status),
-						Manifest:    record.Manifest,
-						ManifestURL: manifestURL,
-						PreviewURL:  record.PreviewURL,
-						ExpiresAt:   expiresAt,
-					}, nil
-				}
-			}
-		}
+	if out, ok := s.cachedPlayback(ctx, mediaID); ok {
+		return s.withPlaybackURLs(out, s.issuePlaybackToken(ctx, out.MediaID)), nil
 	}
 
 	media, err := s.mediaRepo.GetByID(ctx, mediaID)
@@ -280,25 +629,14 @@ func (s *MediaUploadService) GetPlayback(ctx context.Context, ownerUserID, media
 	if media.OwnerUserID != ownerUserID {
 		return PlaybackOutput{}, ErrForbiddenMedia
 	}
-	out, err := s.buildPlayback(ctx, media)
+	token := s.issuePlaybackToken(ctx, media.ID)
+	out, err := s.buildPlayback(ctx, media, token)
 	if err != nil {
 		return PlaybackOutput{}, err
 	}
 
-	if s.cache != nil {
-		record := playbackCacheRecord{
-			MediaID:    out.MediaID,
-			Status:     string(out.Status),
-			Manifest:   out.Manifest,
-			PreviewURL: out.PreviewURL,
-			ExpiresAt:  out.ExpiresAt.UTC().Format(time.RFC3339),
-		}
-		if payload, marshalErr := json.Marshal(record); marshalErr == nil {
-			_ = s.cache.Set(ctx, cacheKey, payload, s.playbackTTL).Err()
-		}
-	}
-	out.ManifestURL = s.buildManifestProxyURL(ctx, out.MediaID)
-	return out, nil
+	s.storePlaybackCache(ctx, out)
+	return s.withPlaybackURLs(out, token), nil
 }
 
 func (s *MediaUploadService) GetPlaybackByMediaID(ctx context.Context, mediaID string) (PlaybackOutput, error) {
@@ -306,26 +644,8 @@ func (s *MediaUploadService) GetPlaybackByMediaID(ctx context.Context, mediaID s
 		return PlaybackOutput{}, ErrInvalidUploadInput
 	}
 
-	cacheKey := s.playbackCacheKey(mediaID)
-	if s.cache != nil {
-		cached, err := s.cache.Get(ctx, cacheKey).Result()
-		if err == nil && strings.TrimSpace(cached) != "" {
-			var record playbackCacheRecord
-			if unmarshalErr := json.Unmarshal([]byte(cached), &record); unmarshalErr == nil {
-				expiresAt, parseErr := time.Parse(time.RFC3339, record.ExpiresAt)
-				if parseErr == nil {
-					manifestURL := s.buildManifestProxyURL(ctx, record.MediaID)
-					return PlaybackOutput{
-						MediaID:     record.MediaID,
-						Status:      repository.MediaStatus(record.Status),
-						Manifest:    record.Manifest,
-						ManifestURL: manifestURL,
-						PreviewURL:  record.PreviewURL,
-						ExpiresAt:   expiresAt,
-					}, nil
-				}
-			}
-		}
+	if out, ok := s.cachedPlayback(ctx, mediaID); ok {
+		return s.withPlaybackURLs(out, s.issuePlaybackToken(ctx, out.MediaID)), nil
 	}
 
 	media, err := s.mediaRepo.GetByID(ctx, mediaID)
@@ -333,55 +653,146 @@ func (s *MediaUploadService) GetPlaybackByMediaID(ctx context.Context, mediaID s
 		return PlaybackOutput{}, err
 	}
 
-	out, err := s.buildPlayback(ctx, media)
+	token := s.issuePlaybackToken(ctx, media.ID)
+	out, err := s.buildPlayback(ctx, media, token)
 	if err != nil {
 		return PlaybackOutput{}, err
 	}
 
-	if s.cache != nil {
-		record := playbackCacheRecord{
-			MediaID:    out.MediaID,
-			Status:     string(out.Status),
-			Manifest:   out.Manifest,
-			PreviewURL: out.PreviewURL,
-			ExpiresAt:  out.ExpiresAt.UTC().Format(time.RFC3339),
-		}
-		if payload, marshalErr := json.Marshal(record); marshalErr == nil {
-			_ = s.cache.Set(ctx, cacheKey, payload, s.playbackTTL).Err()
-		}
+	s.storePlaybackCache(ctx, out)
+	return s.withPlaybackURLs(out, token), nil
+}
+
+func (s *MediaUploadService) cachedPlayback(ctx context.Context, mediaID string) (PlaybackOutput, bool) {
+	if s.cache == nil {
+		return PlaybackOutput{}, false
 	}
-	out.ManifestURL = s.buildManifestProxyURL(ctx, out.MediaID)
-	return out, nil
+	cached, err := s.cache.Get(ctx, s.playbackCacheKey(mediaID)).Result()
+	if err != nil || strings.TrimSpace(cached) == "" {
+		return PlaybackOutput{}, false
+	}
+	var record playbackCacheRecord
+	if err := json.Unmarshal([]byte(cached), &record); err != nil {
+		return PlaybackOutput{}, false
+	}
+	expiresAt, err := time.Parse(time.RFC3339, record.ExpiresAt)
+	if err != nil {
+		return PlaybackOutput{}, false
+	}
+
+	format := repository.MediaOutputFormat(record.Format)
+	if format == "" {
+		format = repository.MediaFormatHLSTS
+	}
+	return PlaybackOutput{
+		MediaID:       record.MediaID,
+		Status:        repository.MediaStatus(record.Status),
+		Format:        format,
+		Manifest:      record.Manifest,
+		PreviewURL:    record.PreviewURL,
+		ExpiresAt:     expiresAt,
+		hasStoryboard: record.HasStoryboard,
+	}, true
+}
+
+func (s *MediaUploadService) storePlaybackCache(ctx context.Context, out PlaybackOutput) {
+	if s.cache == nil {
+		return
+	}
+	record := playbackCacheRecord{
+		MediaID:       out.MediaID,
+		Status:        string(out.Status),
+		Format:        string(out.Format),
+		Manifest:      out.Manifest,
+		PreviewURL:    out.PreviewURL,
+		HasStoryboard: out.hasStoryboard,
+		ExpiresAt:     out.ExpiresAt.UTC().Format(time.RFC3339),
+	}
+	ttl := s.playbackCacheTTL(out.ExpiresAt)
+	if ttl <= 0 {
+		return
+	}
+	if payload, err := json.Marshal(record); err == nil {
+		_ = s.cache.Set(ctx, s.playbackCacheKey(out.MediaID), payload, ttl).Err()
+	}
+}
+
+// playbackCacheTTL keeps a cached playback only while its token and signed
+// URLs have at least half of their lifetime left, so a cache hit never hands
+// out a manifest that is about to expire.
+func (s *MediaUploadService) playbackCacheTTL(expiresAt time.Time) time.Duration {
+	return min(s.playbackTTL, expiresAt.Sub(s.clock())-s.playbackTTL/2)
 }
 
-func (s *MediaUploadService) ResolvePlaybackManifest(ctx context.Context, token string) (string, error) {
+// withPlaybackURLs points the token-scoped URLs of out at token.
+func (s *MediaUploadService) withPlaybackURLs(out PlaybackOutput, token string) PlaybackOutput {
+	out.ManifestURL = playbackProxyURL(token, "index.m3u8")
+	out.DashManifestURL = dashManifestProxyURL(out.Format, token)
+	if out.hasStoryboard {
+		out.StoryboardURL = playbackProxyURL(token, storyboardVTTName)
+	}
+	return out
+}
+
+// ResolvePlaybackManifest serves a playlist from the media HLS directory for a
+// playback token. name is relative to that directory, e.g. "index.m3u8" for the
+// master playlist or "720p/index.m3u8" for a variant. The storyboard VTT is
+// served under the same token as "storyboard/thumbnails.vtt".
+func (s *MediaUploadService) ResolvePlaybackManifest(ctx context.Context, token, name string) (string, error) {
 	token = strings.TrimSpace(token)
-	if token == "" {
+	playlistName, ok := cleanPlaylistName(name)
+	if !ok {
 		return "", ErrInvalidManifestKey
 	}
-	if s.cache == nil {
-		return "", ErrInvalidManifestKey
+
+	media, err := s.mediaForPlaybackToken(ctx, token)
+	if err != nil {
+		return "", err
+	}
+	return s.loadSignedPlaylist(ctx, media, token, playlistName)
+}
+
+// ResolvePlaybackKey returns the AES-128 key referenced as name, e.g.
+// "3.key", from the playlists of an encrypted media. Holding the playback
+// token is what authorises the request, as for the playlists themselves.
+func (s *MediaUploadService) ResolvePlaybackKey(ctx context.Context, token, name string) ([]byte, error) {
+	keyIndex, ok := parseHLSKeyName(name)
+	if !ok || s.keyRepo == nil {
+		return nil, ErrInvalidManifestKey
+	}
+
+	media, err := s.mediaForPlaybackToken(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if !media.Encrypted {
+		return nil, ErrInvalidManifestKey
+	}
+	return s.keyRepo.Get(ctx, media.ID, keyIndex)
+}
+
+func (s *MediaUploadService) mediaForPlaybackToken(ctx context.Context, token string) (repository.Media, error) {
+	token = strings.TrimSpace(token)
+	if token == "" || s.cache == nil {
+		return repository.Media{}, ErrInvalidManifestKey
 	}
 
 	mediaID, err := s.cache.Get(ctx, s.playbackManifestTokenKey(token)).Result()
 	if err != nil {
 		if errors.Is(err, redis.Nil) {
-			return "", ErrInvalidManifestKey
+			return repository.Media{}, ErrInvalidManifestKey
 		}
-		return "", err
+		return repository.Media{}, err
 	}
 
 	media, err := s.mediaRepo.GetByID(ctx, mediaID)
 	if err != nil {
-		return "", err
+		return repository.Media{}, err
 	}
-
-	out, err := s.buildPlayback(ctx, media)
-	if err != nil {
-		return "", err
+	if media.Status != repository.MediaReady {
+		return repository.Media{}, ErrMediaNotReady
 	}
-
-	return out.Manifest, nil
+	return media, nil
 }
 
 func (s *MediaUploadService) DeleteMedia(ctx context.Context, ownerUserID, mediaID string) error {
@@ -397,13 +808,31 @@ func (s *MediaUploadService) DeleteMedia(ctx context.Context, ownerUserID, media
 		return ErrForbiddenMedia
 	}
 
+	// The row goes first: once it is deleted no new media can start sharing
+	// its outputs. Objects left behind by a failed delete are collected by
+	// MediaCleanupService.
+	release, err := s.mediaRepo.SoftDeleteShared(ctx, media.ID, s.clock())
+	if err != nil {
+		return err
+	}
+
 	mediaPrefix := path.Join("users", media.OwnerUserID, "media", media.ID) + "/"
-	if err := s.storage.DeleteObjectsByPrefix(ctx, mediaPrefix); err != nil {
+	if release.OutputsInUse {
+		// Other media still play the renditions; only the original and
+		// the per-media poster and subtitles go.
+		for _, dir := range []string{"original/", "preview/", "hls/" + subtitleDir + "/"} {
+			if err := s.storage.DeleteObjectsByPrefix(ctx, mediaPrefix+dir); err != nil {
+				return fmt.Errorf("%w: %v", ErrStorageDelete, err)
+			}
+		}
+	} else if err := s.storage.DeleteObjectsByPrefix(ctx, mediaPrefix); err != nil {
 		return fmt.Errorf("%w: %v", ErrStorageDelete, err)
 	}
-
-	if err := s.mediaRepo.SoftDelete(ctx, media.ID, s.clock()); err != nil {
-		return err
+	if source := release.Released; source != nil {
+		sourcePrefix := path.Join("users", source.OwnerUserID, "media", source.MediaID) + "/"
+		if err := s.storage.DeleteObjectsByPrefix(ctx, sourcePrefix); err != nil {
+			return fmt.Errorf("%w: %v", ErrStorageDelete, err)
+		}
 	}
 
 	if s.cache != nil {
@@ -421,71 +850,145 @@ func (s *MediaUploadService) playbackManifestTokenKey(token string) string {
 	return "media:playback:manifest:v1:" + token
 }
 
-func (s *MediaUploadService) buildManifestProxyURL(ctx context.Context, mediaID string) *string {
+// issuePlaybackToken returns an opaque token that lets players fetch
+// playlists through the API, or "" when no cache is configured.
+func (s *MediaUploadService) issuePlaybackToken(ctx context.Context, mediaID string) string {
 	if s.cache == nil {
-		return nil
+		return ""
 	}
 	tokenBytes := make([]byte, 16)
 	if _, err := rand.Read(tokenBytes); err != nil {
-		return nil
+		return ""
 	}
 	token := hex.EncodeToString(tokenBytes)
 	if err := s.cache.Set(ctx, s.playbackManifestTokenKey(token), mediaID, s.playbackTTL).Err(); err != nil {
+		return ""
+	}
+	return token
+}
+
+func playbackProxyURL(token, name string) *string {
+	if token == "" {
 		return nil
 	}
-	url := path.Join("/media/playback", token, "index.m3u8")
+	url := path.Join("/media/playback", token, name)
 	return &url
 }
 
-func (s *MediaUploadService) buildPlayback(ctx context.Context, media repository.Media) (PlaybackOutput, error) {
+func (s *MediaUploadService) buildPlayback(ctx context.Context, media repository.Media, token string) (PlaybackOutput, error) {
 	if media.Status != repository.MediaReady {
 		return PlaybackOutput{}, ErrMediaNotReady
 	}
 
-	manifestKey := path.Join("users", media.OwnerUserID, "media", media.ID, "hls", "index.m3u8")
-	manifestBytes, err := s.storage.GetObjectBytes(ctx, manifestKey)
-	if err != nil {
-		return PlaybackOutput{}, err
-	}
-
-	signedManifest, err := s.signManifest(ctx, path.Dir(manifestKey), string(manifestBytes), s.playbackTTL)
+	signedManifest, err := s.loadSignedPlaylist(ctx, media, token, "index.m3u8")
 	if err != nil {
 		return PlaybackOutput{}, err
 	}
 
 	var previewURL *string
-	previewKey := path.Join("users", media.OwnerUserID, "media", media.ID, "preview", "preview.jpg")
-	if signedPreviewURL, signErr := s.storage.PresignGetObject(ctx, previewKey, s.playbackTTL); signErr == nil {
+	mediaPrefix := path.Join("users", media.OwnerUserID, "media", media.ID)
+	if signedPreviewURL, signErr := s.segmentURL(ctx, mediaPrefix, previewName, token, s.playbackTTL); signErr == nil {
 		previewURL = &signedPreviewURL
 	}
 
+	format := media.OutputFormat
+	if format == "" {
+		format = repository.MediaFormatHLSTS
+	}
+
 	expiresAt := s.clock().Add(s.playbackTTL)
 	return PlaybackOutput{
-		MediaID:    media.ID,
-		Status:     media.Status,
-		Manifest:   signedManifest,
-		PreviewURL: previewURL,
-		ExpiresAt:  expiresAt,
+		MediaID:       media.ID,
+		Status:        media.Status,
+		Format:        format,
+		Manifest:      signedManifest,
+		PreviewURL:    previewURL,
+		ExpiresAt:     expiresAt,
+		hasStoryboard: media.StoryboardURL != nil,
 	}, nil
 }
 
-func (s *MediaUploadService) signManifest(ctx context.Context, baseDirKey, manifest string, ttl time.Duration) (string, error) {
+func (s *MediaUploadService) loadSignedPlaylist(ctx context.Context, media repository.Media, token, playlistName string) (string, error) {
+	if strings.HasPrefix(playlistName, storyboardDir+"/") {
+		outputPrefix := mediaOutputPrefix(media)
+		vtt, err := s.storage.GetObjectBytes(ctx, path.Join(outputPrefix, playlistName))
+		if err != nil {
+			return "", err
+		}
+		return s.signStoryboard(ctx, outputPrefix, path.Dir(playlistName), token, string(vtt), s.playbackTTL)
+	}
+
+	hlsPrefix := hlsPrefixFor(media, playlistName)
+	manifestKey := path.Join(hlsPrefix, playlistName)
+	manifestBytes, err := s.storage.GetObjectBytes(ctx, manifestKey)
+	if err != nil {
+		return "", err
+	}
+
+	if strings.HasSuffix(playlistName, ".mpd") {
+		return s.signDashManifest(ctx, hlsPrefix, path.Dir(playlistName), token, string(manifestBytes), s.playbackTTL)
+	}
+	manifest := string(manifestBytes)
+	if playlistName == "index.m3u8" && s.subtitleRepo != nil {
+		subtitles, err := s.subtitleRepo.ListByMedia(ctx, media.ID)
+		if err != nil {
+			return "", err
+		}
+		manifest = withSubtitleRenditions(manifest, subtitles)
+	}
+	return s.signManifest(ctx, media, path.Dir(playlistName), token, manifest, s.playbackTTL)
+}
+
+// signManifest presigns every segment URI, including URI attributes of tags
+// such as EXT-X-MAP. Child playlists of a master playlist are routed back
+// through the playback proxy so that their segments get signed as well;
+// without a token they are presigned directly. With segment proxying on,
+// segments go through the proxy as well and nothing is presigned.
+func (s *MediaUploadService) signManifest(ctx context.Context, media repository.Media, relDir, token, manifest string, ttl time.Duration) (string, error) {
+	signURI := func(uri string) (string, error) {
+		if strings.HasPrefix(uri, "http://") || strings.HasPrefix(uri, "https://") {
+			return uri, nil
+		}
+		relPath := path.Join(relDir, uri)
+		if token != "" && strings.HasSuffix(relPath, ".m3u8") {
+			return *playbackProxyURL(token, relPath), nil
+		}
+		return s.segmentURL(ctx, hlsPrefixFor(media, relPath), relPath, token, ttl)
+	}
+
 	lines := strings.Split(manifest, "\n")
 	signedLines := make([]string, 0, len(lines))
 	for _, rawLine := range lines {
 		line := strings.TrimSpace(rawLine)
-		if line == "" || strings.HasPrefix(line, "#") {
+		if line == "" {
 			signedLines = append(signedLines, rawLine)
 			continue
 		}
 
-		if strings.HasPrefix(line, "http://") || strings.HasPrefix(line, "https://") {
-			signedLines = append(signedLines, rawLine)
+		if strings.HasPrefix(line, "#EXT-X-KEY:") {
+			// Keys never sit in object storage; the proxy serves them.
+			signedLine, err := replaceAttributeURIs(rawLine, hlsURIAttrPattern, func(uri string) (string, error) {
+				if token == "" {
+					return uri, nil
+				}
+				return *playbackProxyURL(token, uri), nil
+			})
+			if err != nil {
+				return "", err
+			}
+			signedLines = append(signedLines, signedLine)
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			signedLine, err := replaceAttributeURIs(rawLine, hlsURIAttrPattern, signURI)
+			if err != nil {
+				return "", err
+			}
+			signedLines = append(signedLines, signedLine)
 			continue
 		}
 
-		segmentKey := path.Join(baseDirKey, line)
-		signedURL, err := s.storage.PresignGetObject(ctx, segmentKey, ttl)
+		signedURL, err := signURI(line)
 		if err != nil {
 			return "", err
 		}
@@ -494,6 +997,100 @@ func (s *MediaUploadService) signManifest(ctx context.Context, baseDirKey, manif
 	return strings.Join(signedLines, "\n"), nil
 }
 
+// signDashManifest presigns the SegmentList URLs of an MPD written with
+// use_template=0, so every segment carries its own signature like in HLS.
+func (s *MediaUploadService) signDashManifest(ctx context.Context, hlsPrefix, relDir, token, manifest string, ttl time.Duration) (string, error) {
+	return replaceAttributeURIs(manifest, dashURIAttrPattern, func(uri string) (string, error) {
+		if strings.HasPrefix(uri, "http://") || strings.HasPrefix(uri, "https://") {
+			return uri, nil
+		}
+		signedURL, err := s.segmentURL(ctx, hlsPrefix, path.Join(relDir, html.UnescapeString(uri)), token, ttl)
+		if err != nil {
+			return "", err
+		}
+		return html.EscapeString(signedURL), nil
+	})
+}
+
+// signStoryboard presigns the sprite references of a thumbnails VTT while
+// keeping their #xywh fragments.
+func (s *MediaUploadService) signStoryboard(ctx context.Context, mediaPrefix, relDir, token, vtt string, ttl time.Duration) (string, error) {
+	lines := strings.Split(vtt, "\n")
+	for i, rawLine := range lines {
+		line := strings.TrimSpace(rawLine)
+		if line == "" || line == "WEBVTT" || strings.Contains(line, "-->") {
+			continue
+		}
+		file, fragment, _ := strings.Cut(line, "#")
+		if strings.HasPrefix(file, "http://") || strings.HasPrefix(file, "https://") {
+			continue
+		}
+		signedURL, err := s.segmentURL(ctx, mediaPrefix, path.Join(relDir, file), token, ttl)
+		if err != nil {
+			return "", err
+		}
+		if fragment != "" {
+			signedURL += "#" + fragment
+		}
+		lines[i] = signedURL
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+var (
+	hlsURIAttrPattern  = regexp.MustCompile(`(URI=")([^"]*)(")`)
+	dashURIAttrPattern = regexp.MustCompile(`((?:media|sourceURL|initialization)=")([^"]*)(")`)
+)
+
+func replaceAttributeURIs(text string, pattern *regexp.Regexp, fn func(string) (string, error)) (string, error) {
+	var firstErr error
+	out := pattern.ReplaceAllStringFunc(text, func(match string) string {
+		parts := pattern.FindStringSubmatch(match)
+		if firstErr != nil || len(parts) != 4 || parts[2] == "" {
+			return match
+		}
+		replaced, err := fn(parts[2])
+		if err != nil {
+			firstErr = err
+			return match
+		}
+		return parts[1] + replaced + parts[3]
+	})
+	if firstErr != nil {
+		return "", firstErr
+	}
+	return out, nil
+}
+
+func dashManifestProxyURL(format repository.MediaOutputFormat, token string) *string {
+	if format != repository.MediaFormatCMAF {
+		return nil
+	}
+	return playbackProxyURL(token, dashManifestName)
+}
+
+func cleanPlaylistName(name string) (string, bool) {
+	cleaned := path.Clean("/" + strings.TrimSpace(name))[1:]
+	if cleaned == storyboardVTTName {
+		return cleaned, true
+	}
+	if cleaned == "" || (!strings.HasSuffix(cleaned, ".m3u8") && !strings.HasSuffix(cleaned, ".mpd")) {
+		return "", false
+	}
+	return cleaned, true
+}
+
+func parseOutputFormat(raw string) (repository.MediaOutputFormat, bool) {
+	switch repository.MediaOutputFormat(strings.ToLower(strings.TrimSpace(raw))) {
+	case "", repository.MediaFormatHLSTS:
+		return repository.MediaFormatHLSTS, true
+	case repository.MediaFormatCMAF:
+		return repository.MediaFormatCMAF, true
+	default:
+		return "", false
+	}
+}
+
 func (s *MediaUploadService) isAllowedMime(mime string) bool {
 	_, ok := s.allowedMimeTypes[normalizeMime(mime)]
 	return ok
@@ -0,0 +1,146 @@
+package service
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestMultipartPartSize(t *testing.T) {
+	tests := []struct {
+		name       string
+		totalBytes int64
+		want       int64
+	}{
+		{name: "empty", totalBytes: 0, want: minMultipartPartBytes},
+		{name: "single part", totalBytes: 1, want: minMultipartPartBytes},
+		{name: "exactly max parts", totalBytes: minMultipartPartBytes * maxMultipartParts, want: minMultipartPartBytes},
+		{name: "one byte over max parts", totalBytes: minMultipartPartBytes*maxMultipartParts + 1, want: 2 * minMultipartPartBytes},
+		{name: "one terabyte", totalBytes: 1 << 40, want: 128 << 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := multipartPartSize(tt.totalBytes)
+			if got != tt.want {
+				t.Fatalf("multipartPartSize(%d) = %d, want %d", tt.totalBytes, got, tt.want)
+			}
+			if count := multipartPartCount(tt.totalBytes, got); count > maxMultipartParts {
+				t.Fatalf("part count %d exceeds %d", count, maxMultipartParts)
+			}
+		})
+	}
+}
+
+func TestMultipartPartCount(t *testing.T) {
+	tests := []struct {
+		name       string
+		totalBytes int64
+		partSize   int64
+		want       int
+	}{
+		{name: "empty", totalBytes: 0, partSize: 10, want: 0},
+		{name: "zero part size", totalBytes: 10, partSize: 0, want: 0},
+		{name: "exact multiple", totalBytes: 30, partSize: 10, want: 3},
+		{name: "short last part", totalBytes: 31, partSize: 10, want: 4},
+		{name: "smaller than a part", totalBytes: 5, partSize: 10, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := multipartPartCount(tt.totalBytes, tt.partSize); got != tt.want {
+				t.Fatalf("multipartPartCount(%d, %d) = %d, want %d", tt.totalBytes, tt.partSize, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeCompletedParts(t *testing.T) {
+	tests := []struct {
+		name         string
+		parts        []CompletedPart
+		partCount    int
+		requireETags bool
+		want         []CompletedPart
+		wantErr      bool
+	}{
+		{
+			name:      "sorted",
+			parts:     []CompletedPart{{PartNumber: 1, ETag: "a"}, {PartNumber: 2, ETag: "b"}},
+			partCount: 2, requireETags: true,
+			want: []CompletedPart{{PartNumber: 1, ETag: "a"}, {PartNumber: 2, ETag: "b"}},
+		},
+		{
+			name:      "out of order",
+			parts:     []CompletedPart{{PartNumber: 3, ETag: "c"}, {PartNumber: 1, ETag: "a"}, {PartNumber: 2, ETag: "b"}},
+			partCount: 3, requireETags: true,
+			want: []CompletedPart{{PartNumber: 1, ETag: "a"}, {PartNumber: 2, ETag: "b"}, {PartNumber: 3, ETag: "c"}},
+		},
+		{
+			name:      "no parts",
+			partCount: 0, requireETags: true,
+			wantErr: true,
+		},
+		{
+			name:      "fewer parts than expected",
+			parts:     []CompletedPart{{PartNumber: 1, ETag: "a"}},
+			partCount: 2, requireETags: true,
+			wantErr: true,
+		},
+		{
+			name:      "gap",
+			parts:     []CompletedPart{{PartNumber: 1, ETag: "a"}, {PartNumber: 3, ETag: "c"}},
+			partCount: 2, requireETags: true,
+			wantErr: true,
+		},
+		{
+			name:      "duplicate",
+			parts:     []CompletedPart{{PartNumber: 1, ETag: "a"}, {PartNumber: 1, ETag: "b"}},
+			partCount: 2, requireETags: true,
+			wantErr: true,
+		},
+		{
+			name:      "starts at zero",
+			parts:     []CompletedPart{{PartNumber: 0, ETag: "a"}, {PartNumber: 1, ETag: "b"}},
+			partCount: 2, requireETags: true,
+			wantErr: true,
+		},
+		{
+			name:      "blank etag",
+			parts:     []CompletedPart{{PartNumber: 1, ETag: "a"}, {PartNumber: 2, ETag: " "}},
+			partCount: 2, requireETags: true,
+			wantErr: true,
+		},
+		{
+			name:      "blank etag checked by presence",
+			parts:     []CompletedPart{{PartNumber: 2}, {PartNumber: 1}},
+			partCount: 2, requireETags: false,
+			want: []CompletedPart{{PartNumber: 1}, {PartNumber: 2}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeCompletedParts(tt.parts, tt.partCount, tt.requireETags)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidMultipartParts) {
+					t.Fatalf("error = %v, want ErrInvalidMultipartParts", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeCompletedPartsKeepsInput(t *testing.T) {
+	parts := []CompletedPart{{PartNumber: 2, ETag: "b"}, {PartNumber: 1, ETag: "a"}}
+	if _, err := normalizeCompletedParts(parts, 2, true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if parts[0].PartNumber != 2 {
+		t.Fatalf("input was reordered: %+v", parts)
+	}
+}
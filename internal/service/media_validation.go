@@ -0,0 +1,154 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"time"
+
+	"calixio/internal/repository"
+)
+
+var ErrMediaRejected = errors.New("media rejected")
+
+// Reject reasons recorded on media with the rejected status.
+const (
+	RejectReasonUnknownFormat  = "unknown_format"
+	RejectReasonTypeNotAllowed = "type_not_allowed"
+	RejectReasonUnreadable     = "unreadable"
+	RejectReasonNoVideoStream  = "no_video_stream"
+	// RejectReasonChecksumMismatch is set by the transcoder when the
+	// original does not match the SHA-256 declared at upload init.
+	RejectReasonChecksumMismatch = "checksum_mismatch"
+)
+
+const (
+	// sniffHeadBytes covers every signature below, including the second
+	// MPEG-TS sync byte.
+	sniffHeadBytes      = 512
+	uploadProbeTimeout  = 20 * time.Second
+	uploadProbeProtocol = "http,https,tls,tcp"
+)
+
+// MediaRejectedError carries the reason an upload was refused.
+type MediaRejectedError struct {
+	Reason string
+}
+
+func (e *MediaRejectedError) Error() string {
+	return "media rejected: " + e.Reason
+}
+
+func (e *MediaRejectedError) Unwrap() error {
+	return ErrMediaRejected
+}
+
+// mimeAliases lists equivalent names, so either may be configured in
+// AWS_S3_ALLOWED_MIME_TYPES.
+var mimeAliases = map[string]string{
+	"video/x-matroska": "video/matroska",
+}
+
+// validateUploadedObject checks what the object really is instead of the
+// Content-Type the client sent: first its magic bytes, then ffprobe on a
+// presigned URL, so nothing has to be downloaded. It returns the detected
+// MIME type, or a *MediaRejectedError.
+func (s *MediaUploadService) validateUploadedObject(ctx context.Context, key string) (string, error) {
+	object, err := s.storage.OpenObject(ctx, key, fmt.Sprintf("bytes=0-%d", sniffHeadBytes-1))
+	if err != nil {
+		return "", err
+	}
+	head, err := io.ReadAll(object.Body)
+	object.Body.Close()
+	if err != nil {
+		return "", err
+	}
+
+	mimeType := sniffVideoType(head)
+	if mimeType == "" {
+		return "", &MediaRejectedError{Reason: RejectReasonUnknownFormat}
+	}
+	if !s.isAllowedMime(mimeType) && !s.isAllowedMime(mimeAliases[mimeType]) {
+		return "", &MediaRejectedError{Reason: RejectReasonTypeNotAllowed}
+	}
+	if !s.probeUploads {
+		return mimeType, nil
+	}
+
+	probeURL, err := s.storage.PresignGetObject(ctx, key, s.presignTTL)
+	if err != nil {
+		return "", err
+	}
+	probeCtx, cancel := context.WithTimeout(ctx, uploadProbeTimeout)
+	defer cancel()
+	probe, err := runFFprobe(probeCtx, s.ffprobePath, probeURL, "-protocol_whitelist", uploadProbeProtocol)
+	if err != nil {
+		// Only a verdict of ffprobe itself rejects the upload; a missing
+		// binary or a timeout is our problem, not the file's.
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && probeCtx.Err() == nil {
+			return "", &MediaRejectedError{Reason: RejectReasonUnreadable}
+		}
+		return "", err
+	}
+	if probe.video == nil || probe.video.width <= 0 || probe.video.height <= 0 {
+		return "", &MediaRejectedError{Reason: RejectReasonNoVideoStream}
+	}
+	return mimeType, nil
+}
+
+// rejectUpload deletes the object and records why, so the media stays
+// visible to its owner with the reason.
+func (s *MediaUploadService) rejectUpload(media repository.Media, reason string) error {
+	ctx := context.Background()
+	if err := s.storage.DeleteObject(ctx, media.StorageKey); err != nil {
+		return err
+	}
+	return s.mediaRepo.Reject(ctx, media.ID, reason)
+}
+
+// sniffVideoType recognizes the containers the pipeline accepts by their
+// signatures and returns "" for anything else.
+func sniffVideoType(head []byte) string {
+	switch {
+	case len(head) >= 12 && string(head[4:8]) == "ftyp":
+		switch string(head[8:12]) {
+		case "qt  ":
+			return "video/quicktime"
+		case "3gp4", "3gp5", "3gp6", "3ge6", "3gg6":
+			return "video/3gpp"
+		default:
+			return "video/mp4"
+		}
+	case len(head) >= 8 && isQuickTimeAtom(string(head[4:8])):
+		// QuickTime files older than the ftyp atom.
+		return "video/quicktime"
+	case bytes.HasPrefix(head, []byte{0x1a, 0x45, 0xdf, 0xa3}):
+		if bytes.Contains(head[:min(len(head), 64)], []byte("webm")) {
+			return "video/webm"
+		}
+		return "video/x-matroska"
+	case len(head) >= 12 && string(head[0:4]) == "RIFF" && string(head[8:12]) == "AVI ":
+		return "video/x-msvideo"
+	case len(head) >= 377 && head[0] == 0x47 && head[188] == 0x47 && head[376] == 0x47:
+		return "video/mp2t"
+	case bytes.HasPrefix(head, []byte{0x00, 0x00, 0x01, 0xba}):
+		return "video/mpeg"
+	case bytes.HasPrefix(head, []byte("FLV\x01")):
+		return "video/x-flv"
+	default:
+		return ""
+	}
+}
+
+func isQuickTimeAtom(atom string) bool {
+	switch atom {
+	case "moov", "mdat", "wide", "free", "skip", "pnot":
+		return true
+	default:
+		return false
+	}
+}
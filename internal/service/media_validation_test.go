@@ -0,0 +1,50 @@
+package service
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSniffVideoType(t *testing.T) {
+	ebml := []byte{0x1a, 0x45, 0xdf, 0xa3, 0x9f, 0x42, 0x86, 0x81, 0x01, 0x42, 0x82, 0x84}
+	transportStream := make([]byte, 512)
+	for _, offset := range []int{0, 188, 376} {
+		transportStream[offset] = 0x47
+	}
+	brokenStream := bytes.Clone(transportStream)
+	brokenStream[376] = 0
+
+	tests := []struct {
+		name string
+		head []byte
+		want string
+	}{
+		{name: "mp4 isom", head: []byte("\x00\x00\x00\x20ftypisom\x00\x00\x02\x00"), want: "video/mp4"},
+		{name: "mp4 brand", head: []byte("\x00\x00\x00\x18ftypmp42"), want: "video/mp4"},
+		{name: "quicktime brand", head: []byte("\x00\x00\x00\x14ftypqt  "), want: "video/quicktime"},
+		{name: "3gpp", head: []byte("\x00\x00\x00\x14ftyp3gp5"), want: "video/3gpp"},
+		{name: "quicktime without ftyp", head: []byte("\x00\x00\x00\x08wide\x00\x00\x00\x00mdat"), want: "video/quicktime"},
+		{name: "quicktime starting with moov", head: []byte("\x00\x00\x01\x00moov"), want: "video/quicktime"},
+		{name: "webm", head: append(bytes.Clone(ebml), []byte("webm")...), want: "video/webm"},
+		{name: "matroska", head: append(bytes.Clone(ebml), []byte("matroska")...), want: "video/x-matroska"},
+		{name: "avi", head: []byte("RIFF\x00\x10\x00\x00AVI LIST"), want: "video/x-msvideo"},
+		{name: "mpeg-ts", head: transportStream, want: "video/mp2t"},
+		{name: "mpeg-ps", head: []byte{0x00, 0x00, 0x01, 0xba, 0x44}, want: "video/mpeg"},
+		{name: "flv", head: []byte("FLV\x01\x05\x00\x00\x00\x09"), want: "video/x-flv"},
+		{name: "wav is not avi", head: []byte("RIFF\x00\x10\x00\x00WAVEfmt "), want: ""},
+		{name: "ts with one sync byte missing", head: brokenStream, want: ""},
+		{name: "ts too short", head: transportStream[:300], want: ""},
+		{name: "truncated ftyp", head: []byte("\x00\x00\x00\x20ftyp"), want: ""},
+		{name: "png", head: []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR"), want: ""},
+		{name: "html", head: []byte("<!doctype html><html>"), want: ""},
+		{name: "unknown atom", head: []byte("\x00\x00\x00\x08abcd"), want: ""},
+		{name: "empty", head: nil, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sniffVideoType(tt.head); got != tt.want {
+				t.Fatalf("sniffVideoType() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
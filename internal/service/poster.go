@@ -0,0 +1,252 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"calixio/internal/repository"
+
+	"go.uber.org/zap"
+)
+
+const (
+	posterCandidates = 8
+	posterWidth      = 640
+	// Candidates are taken between these fractions of the duration, which
+	// skips fade-ins, studio logos and end credits.
+	posterWindowStart = 0.05
+	posterWindowEnd   = 0.8
+	// posterSceneLookback is how far before a candidate the comparison frame
+	// for the scene-change score is taken.
+	posterSceneLookbackSec = 1.0
+	posterSourceURLTTL     = 15 * time.Minute
+)
+
+type posterCandidate struct {
+	timestampSec float64
+	path         string
+	score        float64
+}
+
+// createAndUploadPoster samples frames across the video, scores them and
+// uploads the best one as preview.jpg.
+func (s *MediaTranscoderService) createAndUploadPoster(ctx context.Context, srcPath, workDir string, media repository.Media, durationSec float64) (*string, error) {
+	posterDir := filepath.Join(workDir, "poster")
+	if err := os.MkdirAll(posterDir, 0o755); err != nil {
+		return nil, err
+	}
+
+	best := posterCandidate{score: -1}
+	for i, ts := range posterTimestamps(durationSec) {
+		candidate, err := s.scorePosterCandidate(ctx, srcPath, posterDir, i, ts)
+		if err != nil {
+			s.logger.Warn("poster candidate skipped",
+				zap.String("media_id", media.ID),
+				zap.Float64("timestamp_sec", ts),
+				zap.Error(err),
+			)
+			continue
+		}
+		if candidate.score > best.score {
+			best = candidate
+		}
+	}
+	if best.path == "" {
+		return nil, fmt.Errorf("no poster candidate could be extracted")
+	}
+
+	s.logger.Info("poster selected",
+		zap.String("media_id", media.ID),
+		zap.Float64("timestamp_sec", best.timestampSec),
+		zap.Float64("score", best.score),
+	)
+	return s.uploadPosterFile(ctx, media, best.path)
+}
+
+// CapturePoster replaces the poster with the frame at timestampSec. The
+// source is streamed from a presigned URL, so no local copy is needed. It
+// runs in the API process, which therefore needs ffmpeg even when encoding
+// is left to cmd/worker.
+func (s *MediaTranscoderService) CapturePoster(ctx context.Context, media repository.Media, timestampSec float64) (*string, error) {
+	if _, err := exec.LookPath(s.ffmpegPath); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPosterCaptureUnavailable, err)
+	}
+	sourceURL, err := s.storage.PresignGetObject(ctx, media.StorageKey, posterSourceURLTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpDir, err := os.MkdirTemp(s.workDir, "calixio-poster-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	posterPath := filepath.Join(tmpDir, "preview.jpg")
+	if err := s.extractFrame(ctx, sourceURL, posterPath, timestampSec); err != nil {
+		return nil, err
+	}
+	return s.uploadPosterFile(ctx, media, posterPath)
+}
+
+func (s *MediaTranscoderService) scorePosterCandidate(ctx context.Context, srcPath, dir string, idx int, ts float64) (posterCandidate, error) {
+	framePath := filepath.Join(dir, fmt.Sprintf("candidate_%02d.jpg", idx))
+	if err := s.extractFrame(ctx, srcPath, framePath, ts); err != nil {
+		return posterCandidate{}, err
+	}
+	frame, err := decodeJPEGFile(framePath)
+	if err != nil {
+		return posterCandidate{}, err
+	}
+
+	var previous image.Image
+	if ts >= posterSceneLookbackSec {
+		prevPath := filepath.Join(dir, fmt.Sprintf("candidate_%02d_prev.jpg", idx))
+		if err := s.extractFrame(ctx, srcPath, prevPath, ts-posterSceneLookbackSec); err == nil {
+			previous, _ = decodeJPEGFile(prevPath)
+		}
+	}
+
+	return posterCandidate{
+		timestampSec: ts,
+		path:         framePath,
+		score:        scorePosterFrame(frame, previous),
+	}, nil
+}
+
+func (s *MediaTranscoderService) extractFrame(ctx context.Context, input, outPath string, timestampSec float64) error {
+	args := []string{
+		"-y",
+		"-hide_banner",
+		"-nostats",
+		"-loglevel", "warning",
+		"-ss", strconv.FormatFloat(timestampSec, 'f', 3, 64),
+		"-i", input,
+		"-frames:v", "1",
+		"-vf", fmt.Sprintf("scale=%d:-2", posterWidth),
+		outPath,
+	}
+	cmd := exec.CommandContext(ctx, s.ffmpegPath, args...)
+	stderr := &tailBuffer{maxBytes: 32 << 10}
+	cmd.Stdout = io.Discard
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return formatFFmpegError("ffmpeg poster", err, stderr.String())
+	}
+	if _, err := os.Stat(outPath); err != nil {
+		return fmt.Errorf("ffmpeg poster: no frame at %.3fs", timestampSec)
+	}
+	return nil
+}
+
+func (s *MediaTranscoderService) uploadPosterFile(ctx context.Context, media repository.Media, localPath string) (*string, error) {
+	previewKey := path.Join("users", media.OwnerUserID, "media", media.ID, "preview", "preview.jpg")
+	if err := s.withRetry(ctx, "upload preview", media.ID, func() error {
+		return s.storage.UploadOutputFile(ctx, previewKey, "image/jpeg", localPath)
+	}); err != nil {
+		return nil, err
+	}
+	previewURL := s.storage.ObjectURL(previewKey)
+	return &previewURL, nil
+}
+
+func posterTimestamps(durationSec float64) []float64 {
+	if durationSec <= 2 {
+		return []float64{0}
+	}
+	start := durationSec * posterWindowStart
+	end := durationSec * posterWindowEnd
+	out := make([]float64, 0, posterCandidates)
+	for i := 0; i < posterCandidates; i++ {
+		out = append(out, start+(end-start)*float64(i)/float64(posterCandidates-1))
+	}
+	return out
+}
+
+// scorePosterFrame rates a frame in [0, 1]. Near-black and washed-out frames
+// score zero; otherwise luma entropy dominates, with a bonus for frames that
+// differ from the moment before (a scene change rather than a slow fade).
+func scorePosterFrame(frame, previous image.Image) float64 {
+	hist, mean := lumaHistogram(frame)
+	if mean < 0.08 || mean > 0.95 {
+		return 0
+	}
+
+	total := 0
+	for _, n := range hist {
+		total += n
+	}
+	entropy := 0.0
+	for _, n := range hist {
+		if n == 0 {
+			continue
+		}
+		p := float64(n) / float64(total)
+		entropy -= p * math.Log2(p)
+	}
+	score := 0.7 * entropy / 8
+
+	if previous != nil {
+		score += 0.3 * math.Min(1, lumaDifference(frame, previous)*4)
+	}
+	return score
+}
+
+// lumaHistogram samples every other pixel in both directions, which is plenty
+// for a 640px frame.
+func lumaHistogram(img image.Image) ([256]int, float64) {
+	var hist [256]int
+	bounds := img.Bounds()
+	sum, count := 0.0, 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += 2 {
+		for x := bounds.Min.X; x < bounds.Max.X; x += 2 {
+			l := luma(img, x, y)
+			hist[l]++
+			sum += float64(l)
+			count++
+		}
+	}
+	if count == 0 {
+		return hist, 0
+	}
+	return hist, sum / float64(count) / 255
+}
+
+func lumaDifference(a, b image.Image) float64 {
+	bounds := a.Bounds().Intersect(b.Bounds())
+	sum, count := 0.0, 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += 4 {
+		for x := bounds.Min.X; x < bounds.Max.X; x += 4 {
+			sum += math.Abs(float64(luma(a, x, y)) - float64(luma(b, x, y)))
+			count++
+		}
+	}
+	if count == 0 {
+		return 0
+	}
+	return sum / float64(count) / 255
+}
+
+func luma(img image.Image, x, y int) uint8 {
+	r, g, b, _ := img.At(x, y).RGBA()
+	return uint8((19595*r + 38470*g + 7471*b + 1<<15) >> 24)
+}
+
+func decodeJPEGFile(filePath string) (image.Image, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return jpeg.Decode(f)
+}
@@ -30,6 +30,10 @@ type RoomPlaybackState struct {
 	UpdatedAt    int64          `json:"updatedAt"`
 	Version      int64          `json:"version"`
 	HostID       string         `json:"hostId"`
+	// AudioTrack and SubtitleTrack hold the NAME of the selected
+	// EXT-X-MEDIA rendition; empty means the player default / captions off.
+	AudioTrack    string `json:"audioTrack,omitempty"`
+	SubtitleTrack string `json:"subtitleTrack,omitempty"`
 }
 
 type UpdateRoomPlaybackInput struct {
@@ -37,6 +41,9 @@ type UpdateRoomPlaybackInput struct {
 	Status       PlaybackStatus
 	PositionMs   int64
 	PlaybackRate float64
+	// nil keeps the current selection, "" clears it.
+	AudioTrack    *string
+	SubtitleTrack *string
 }
 
 type RoomPlaybackService struct {
@@ -99,8 +106,19 @@ func (s *RoomPlaybackService) SaveByHost(ctx context.Context, roomID, hostUserID
 	}
 
 	if in.MediaID != "" {
+		if in.MediaID != current.MediaID {
+			// Track names belong to the previous media's manifest.
+			current.AudioTrack = ""
+			current.SubtitleTrack = ""
+		}
 		current.MediaID = in.MediaID
 	}
+	if in.AudioTrack != nil {
+		current.AudioTrack = *in.AudioTrack
+	}
+	if in.SubtitleTrack != nil {
+		current.SubtitleTrack = *in.SubtitleTrack
+	}
 	if in.Status != "" {
 		current.Status = in.Status
 	}
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -21,7 +22,10 @@ import (
 	"github.com/aws/smithy-go"
 )
 
-type StorageService struct {
+var ErrInvalidRange = errors.New("requested range not satisfiable")
+
+// S3Storage keeps objects in an S3-compatible bucket (AWS, MinIO).
+type S3Storage struct {
 	s3Client  *s3.Client
 	presigner *s3.PresignClient
 	bucket    string
@@ -29,9 +33,12 @@ type StorageService struct {
 	endpoint  string
 	pathStyle bool
 	publicURL string
+	// privateOutputs keeps derived objects (HLS, posters, storyboards)
+	// private so they are reachable only through presigned URLs.
+	privateOutputs bool
 }
 
-func NewStorageService(ctx context.Context, cfg *config.Config) (*StorageService, error) {
+func NewS3Storage(ctx context.Context, cfg *config.Config) (*S3Storage, error) {
 	var awsCfg aws.Config
 	var err error
 	if cfg.AWS.AccessKeyID != "" && cfg.AWS.SecretAccessKey != "" {
@@ -66,18 +73,19 @@ func NewStorageService(ctx context.Context, cfg *config.Config) (*StorageService
 
 	s3Client := s3.NewFromConfig(awsCfg, clientOpts...)
 
-	return &StorageService{
-		s3Client:  s3Client,
-		presigner: s3.NewPresignClient(s3Client),
-		bucket:    cfg.AWS.Bucket,
-		region:    cfg.AWS.Region,
-		endpoint:  cfg.AWS.Endpoint,
-		pathStyle: cfg.AWS.PathStyle,
-		publicURL: cfg.AWS.PublicURL,
+	return &S3Storage{
+		s3Client:       s3Client,
+		presigner:      s3.NewPresignClient(s3Client),
+		bucket:         cfg.AWS.Bucket,
+		region:         cfg.AWS.Region,
+		endpoint:       cfg.AWS.Endpoint,
+		pathStyle:      cfg.AWS.PathStyle,
+		publicURL:      cfg.AWS.PublicURL,
+		privateOutputs: cfg.AWS.PrivateOutputs,
 	}, nil
 }
 
-func (s *StorageService) PresignPutObject(ctx context.Context, key, contentType string, expires time.Duration) (string, error) {
+func (s *S3Storage) PresignPutObject(ctx context.Context, key, contentType string, expires time.Duration) (string, error) {
 	if s.bucket == "" {
 		return "", fmt.Errorf("s3 bucket is not configured")
 	}
@@ -100,7 +108,7 @@ func (s *StorageService) PresignPutObject(ctx context.Context, key, contentType
 	return resp.URL, nil
 }
 
-func (s *StorageService) PresignGetObject(ctx context.Context, key string, expires time.Duration) (string, error) {
+func (s *S3Storage) PresignGetObject(ctx context.Context, key string, expires time.Duration) (string, error) {
 	if s.bucket == "" {
 		return "", fmt.Errorf("s3 bucket is not configured")
 	}
@@ -118,6 +126,155 @@ func (s *StorageService) PresignGetObject(ctx context.Context, key string, expir
 	return resp.URL, nil
 }
 
+type CompletedPart struct {
+	PartNumber int32
+	ETag       string
+}
+
+func (s *S3Storage) CreateMultipartUpload(ctx context.Context, key, contentType string) (string, error) {
+	if s.bucket == "" {
+		return "", fmt.Errorf("s3 bucket is not configured")
+	}
+
+	input := &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}
+	if contentType != "" {
+		input.ContentType = aws.String(contentType)
+	}
+
+	out, err := s.s3Client.CreateMultipartUpload(ctx, input)
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(out.UploadId), nil
+}
+
+func (s *S3Storage) PresignUploadPart(ctx context.Context, key, uploadID string, partNumber int32, expires time.Duration) (string, error) {
+	if s.bucket == "" {
+		return "", fmt.Errorf("s3 bucket is not configured")
+	}
+	if expires <= 0 {
+		expires = 15 * time.Minute
+	}
+
+	resp, err := s.presigner.PresignUploadPart(ctx, &s3.UploadPartInput{
+		Bucket:     aws.String(s.bucket),
+		Key:        aws.String(key),
+		UploadId:   aws.String(uploadID),
+		PartNumber: aws.Int32(partNumber),
+	}, s3.WithPresignExpires(expires))
+	if err != nil {
+		return "", err
+	}
+	return resp.URL, nil
+}
+
+func (s *S3Storage) UploadPart(ctx context.Context, key, uploadID string, partNumber int32, data []byte) (string, error) {
+	if s.bucket == "" {
+		return "", fmt.Errorf("s3 bucket is not configured")
+	}
+
+	out, err := s.s3Client.UploadPart(ctx, &s3.UploadPartInput{
+		Bucket:        aws.String(s.bucket),
+		Key:           aws.String(key),
+		UploadId:      aws.String(uploadID),
+		PartNumber:    aws.Int32(partNumber),
+		Body:          bytes.NewReader(data),
+		ContentLength: aws.Int64(int64(len(data))),
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(out.ETag), nil
+}
+
+func (s *S3Storage) ListParts(ctx context.Context, key, uploadID string) ([]CompletedPart, error) {
+	if s.bucket == "" {
+		return nil, fmt.Errorf("s3 bucket is not configured")
+	}
+
+	pager := s3.NewListPartsPaginator(s.s3Client, &s3.ListPartsInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	})
+
+	parts := make([]CompletedPart, 0)
+	for pager.HasMorePages() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			var apiErr smithy.APIError
+			if errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchUpload" {
+				return nil, repository.ErrNotFound
+			}
+			return nil, err
+		}
+		for _, part := range page.Parts {
+			parts = append(parts, CompletedPart{
+				PartNumber: aws.ToInt32(part.PartNumber),
+				ETag:       aws.ToString(part.ETag),
+			})
+		}
+	}
+	return parts, nil
+}
+
+func (s *S3Storage) CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []CompletedPart) error {
+	if s.bucket == "" {
+		return fmt.Errorf("s3 bucket is not configured")
+	}
+
+	completed := make([]s3types.CompletedPart, 0, len(parts))
+	for _, part := range parts {
+		completed = append(completed, s3types.CompletedPart{
+			PartNumber: aws.Int32(part.PartNumber),
+			ETag:       aws.String(part.ETag),
+		})
+	}
+
+	_, err := s.s3Client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.bucket),
+		Key:             aws.String(key),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &s3types.CompletedMultipartUpload{Parts: completed},
+	})
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) {
+			switch apiErr.ErrorCode() {
+			case "NoSuchUpload":
+				return repository.ErrNotFound
+			case "InvalidPart", "InvalidPartOrder", "EntityTooSmall":
+				return fmt.Errorf("%w: %s", ErrInvalidMultipartParts, apiErr.ErrorCode())
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *S3Storage) AbortMultipartUpload(ctx context.Context, key, uploadID string) error {
+	if s.bucket == "" {
+		return fmt.Errorf("s3 bucket is not configured")
+	}
+
+	_, err := s.s3Client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	})
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchUpload" {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
 type ObjectHead struct {
 	ContentLength int64
 	ContentType   string
@@ -125,7 +282,7 @@ type ObjectHead struct {
 	LastModified  time.Time
 }
 
-func (s *StorageService) HeadObject(ctx context.Context, key string) (ObjectHead, error) {
+func (s *S3Storage) HeadObject(ctx context.Context, key string) (ObjectHead, error) {
 	if s.bucket == "" {
 		return ObjectHead{}, fmt.Errorf("s3 bucket is not configured")
 	}
@@ -156,33 +313,72 @@ func (s *StorageService) HeadObject(ctx context.Context, key string) (ObjectHead
 	return head, nil
 }
 
-func (s *StorageService) DownloadObjectToFile(ctx context.Context, key, filePath string) error {
+// ObjectStream is an open object body. ContentRange is set when only part
+// of the object was requested.
+type ObjectStream struct {
+	ObjectHead
+	ContentRange string
+	Body         io.ReadCloser
+}
+
+// OpenObject streams key from storage. byteRange is an HTTP Range value such
+// as "bytes=0-1023"; empty reads the whole object. The caller closes Body.
+func (s *S3Storage) OpenObject(ctx context.Context, key, byteRange string) (ObjectStream, error) {
 	if s.bucket == "" {
-		return fmt.Errorf("s3 bucket is not configured")
+		return ObjectStream{}, fmt.Errorf("s3 bucket is not configured")
 	}
 
-	out, err := s.s3Client.GetObject(ctx, &s3.GetObjectInput{
+	in := &s3.GetObjectInput{
 		Bucket: aws.String(s.bucket),
 		Key:    aws.String(key),
-	})
+	}
+	if byteRange != "" {
+		in.Range = aws.String(byteRange)
+	}
+	out, err := s.s3Client.GetObject(ctx, in)
 	if err != nil {
-		return err
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) {
+			switch apiErr.ErrorCode() {
+			case "NotFound", "NoSuchKey":
+				return ObjectStream{}, repository.ErrNotFound
+			case "InvalidRange":
+				return ObjectStream{}, ErrInvalidRange
+			}
+		}
+		return ObjectStream{}, err
 	}
-	defer out.Body.Close()
 
-	f, err := os.Create(filePath)
-	if err != nil {
-		return err
+	return ObjectStream{
+		ObjectHead: ObjectHead{
+			ContentLength: aws.ToInt64(out.ContentLength),
+			ContentType:   aws.ToString(out.ContentType),
+			ETag:          aws.ToString(out.ETag),
+			LastModified:  aws.ToTime(out.LastModified),
+		},
+		ContentRange: aws.ToString(out.ContentRange),
+		Body:         out.Body,
+	}, nil
+}
+
+func (s *S3Storage) DownloadObjectToFile(ctx context.Context, key, filePath, expectedSHA256 string) (string, error) {
+	if s.bucket == "" {
+		return "", fmt.Errorf("s3 bucket is not configured")
 	}
-	defer f.Close()
 
-	if _, err := io.Copy(f, out.Body); err != nil {
-		return err
+	out, err := s.s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return "", err
 	}
-	return nil
+	defer out.Body.Close()
+
+	return writeObjectFile(out.Body, filePath, expectedSHA256)
 }
 
-func (s *StorageService) GetObjectBytes(ctx context.Context, key string) ([]byte, error) {
+func (s *S3Storage) GetObjectBytes(ctx context.Context, key string) ([]byte, error) {
 	if s.bucket == "" {
 		return nil, fmt.Errorf("s3 bucket is not configured")
 	}
@@ -203,15 +399,95 @@ func (s *StorageService) GetObjectBytes(ctx context.Context, key string) ([]byte
 	return data, nil
 }
 
-func (s *StorageService) UploadFile(ctx context.Context, key, contentType, filePath string) error {
+func (s *S3Storage) UploadFile(ctx context.Context, key, contentType, filePath string) error {
 	return s.uploadFile(ctx, key, contentType, filePath, "")
 }
 
-func (s *StorageService) UploadFilePublic(ctx context.Context, key, contentType, filePath string) error {
-	return s.uploadFile(ctx, key, contentType, filePath, "public-read")
+func (s *S3Storage) UploadBytes(ctx context.Context, key, contentType string, data []byte) error {
+	return s.uploadBytes(ctx, key, contentType, data, "")
+}
+
+// UploadOutputFile stores a derived object such as an HLS segment, poster or
+// storyboard sprite, honouring AWS_S3_PRIVATE_OUTPUTS.
+func (s *S3Storage) UploadOutputFile(ctx context.Context, key, contentType, filePath string) error {
+	return s.uploadFile(ctx, key, contentType, filePath, s.OutputACL())
+}
+
+func (s *S3Storage) UploadOutputBytes(ctx context.Context, key, contentType string, data []byte) error {
+	return s.uploadBytes(ctx, key, contentType, data, s.OutputACL())
+}
+
+// OutputACL is the canned ACL applied to derived objects: "private" or
+// "public-read".
+func (s *S3Storage) OutputACL() string {
+	if s.privateOutputs {
+		return string(s3types.ObjectCannedACLPrivate)
+	}
+	return string(s3types.ObjectCannedACLPublicRead)
+}
+
+func (s *S3Storage) SetObjectACL(ctx context.Context, key, acl string) error {
+	if s.bucket == "" {
+		return fmt.Errorf("s3 bucket is not configured")
+	}
+
+	_, err := s.s3Client.PutObjectAcl(ctx, &s3.PutObjectAclInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		ACL:    s3types.ObjectCannedACL(acl),
+	})
+	return err
+}
+
+// WalkObjects calls fn for every object key and size under prefix.
+func (s *S3Storage) WalkObjects(ctx context.Context, prefix string, fn func(key string, size int64) error) error {
+	if s.bucket == "" {
+		return fmt.Errorf("s3 bucket is not configured")
+	}
+
+	pager := s3.NewListObjectsV2Paginator(s.s3Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	})
+	for pager.HasMorePages() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		for _, obj := range page.Contents {
+			if key := strings.TrimSpace(aws.ToString(obj.Key)); key != "" {
+				if err := fn(key, aws.ToInt64(obj.Size)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (s *S3Storage) uploadBytes(ctx context.Context, key, contentType string, data []byte, acl string) error {
+	if s.bucket == "" {
+		return fmt.Errorf("s3 bucket is not configured")
+	}
+
+	input := &s3.PutObjectInput{
+		Bucket:        aws.String(s.bucket),
+		Key:           aws.String(key),
+		Body:          bytes.NewReader(data),
+		ContentLength: aws.Int64(int64(len(data))),
+	}
+	if contentType != "" {
+		input.ContentType = aws.String(contentType)
+	}
+	if acl != "" {
+		input.ACL = s3types.ObjectCannedACL(acl)
+	}
+
+	_, err := s.s3Client.PutObject(ctx, input)
+	return err
 }
 
-func (s *StorageService) DeleteObject(ctx context.Context, key string) error {
+func (s *S3Storage) DeleteObject(ctx context.Context, key string) error {
 	if s.bucket == "" {
 		return fmt.Errorf("s3 bucket is not configured")
 	}
@@ -223,7 +499,7 @@ func (s *StorageService) DeleteObject(ctx context.Context, key string) error {
 	return err
 }
 
-func (s *StorageService) DeleteObjectsByPrefix(ctx context.Context, prefix string) error {
+func (s *S3Storage) DeleteObjectsByPrefix(ctx context.Context, prefix string) error {
 	if s.bucket == "" {
 		return fmt.Errorf("s3 bucket is not configured")
 	}
@@ -275,7 +551,7 @@ func (s *StorageService) DeleteObjectsByPrefix(ctx context.Context, prefix strin
 	return flush()
 }
 
-func (s *StorageService) ListMediaPrefixes(ctx context.Context) (map[string]string, error) {
+func (s *S3Storage) ListMediaPrefixes(ctx context.Context) (map[string]string, error) {
 	if s.bucket == "" {
 		return nil, fmt.Errorf("s3 bucket is not configured")
 	}
@@ -306,7 +582,7 @@ func (s *StorageService) ListMediaPrefixes(ctx context.Context) (map[string]stri
 	return prefixes, nil
 }
 
-func (s *StorageService) uploadFile(ctx context.Context, key, contentType, filePath, acl string) error {
+func (s *S3Storage) uploadFile(ctx context.Context, key, contentType, filePath, acl string) error {
 	if s.bucket == "" {
 		return fmt.Errorf("s3 bucket is not configured")
 	}
@@ -347,7 +623,7 @@ func extractMediaPrefix(key string) (mediaID string, prefix string, ok bool) {
 	return parts[3], path.Join(parts[0], parts[1], parts[2], parts[3]) + "/", true
 }
 
-func (s *StorageService) generateObjectURL(key string) string {
+func (s *S3Storage) ObjectURL(key string) string {
 	if s.publicURL != "" {
 		return strings.TrimRight(s.publicURL, "/") + "/" + strings.TrimLeft(key, "/")
 	}
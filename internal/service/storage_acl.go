@@ -0,0 +1,78 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+
+	"go.uber.org/zap"
+)
+
+// outputDirs are the derived directories under a media prefix. Originals
+// are uploaded through presigned PUTs and are private already.
+var outputDirs = []string{"hls", "preview", "storyboard"}
+
+type MigrateOutputACLInput struct {
+	// ACL is "private" or "public-read".
+	ACL string
+	// MediaID limits the run to one media; empty walks the whole bucket.
+	MediaID string
+	DryRun  bool
+	Logger  *zap.Logger
+}
+
+type MigrateOutputACLResult struct {
+	Prefixes int
+	Objects  int
+	Failed   int
+}
+
+// MigrateOutputACLs applies in.ACL to the derived objects of existing media,
+// for switching AWS_S3_PRIVATE_OUTPUTS on or off after media was stored.
+// Failures on single objects are counted and logged so one bad key does not
+// stop the run.
+func MigrateOutputACLs(ctx context.Context, storage *S3Storage, in MigrateOutputACLInput) (MigrateOutputACLResult, error) {
+	if in.ACL != "private" && in.ACL != "public-read" {
+		return MigrateOutputACLResult{}, fmt.Errorf("unsupported acl %q", in.ACL)
+	}
+	logger := in.Logger
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+
+	prefixes, err := storage.ListMediaPrefixes(ctx)
+	if err != nil {
+		return MigrateOutputACLResult{}, err
+	}
+	mediaIDs := make([]string, 0, len(prefixes))
+	for mediaID := range prefixes {
+		if in.MediaID == "" || in.MediaID == mediaID {
+			mediaIDs = append(mediaIDs, mediaID)
+		}
+	}
+	sort.Strings(mediaIDs)
+
+	var result MigrateOutputACLResult
+	for _, mediaID := range mediaIDs {
+		result.Prefixes++
+		for _, dir := range outputDirs {
+			err := storage.WalkObjects(ctx, path.Join(prefixes[mediaID], dir)+"/", func(key string, _ int64) error {
+				result.Objects++
+				if in.DryRun {
+					return nil
+				}
+				if err := storage.SetObjectACL(ctx, key, in.ACL); err != nil {
+					result.Failed++
+					logger.Warn("set object acl failed", zap.String("key", key), zap.Error(err))
+				}
+				return ctx.Err()
+			})
+			if err != nil {
+				return result, err
+			}
+		}
+		logger.Info("media outputs migrated", zap.String("media_id", mediaID), zap.String("acl", in.ACL), zap.Bool("dry_run", in.DryRun))
+	}
+	return result, nil
+}
@@ -0,0 +1,461 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"calixio/internal/repository"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/sas"
+)
+
+// AzureStorage keeps objects as block blobs in an Azure Storage container.
+// Point AZURE_STORAGE_ENDPOINT at Azurite, e.g.
+// http://127.0.0.1:10000/devstoreaccount1, to run against the emulator.
+//
+// Multipart uploads map onto uncommitted blocks: each part is a block whose
+// ID encodes the upload ID and part number, and completion commits the
+// block list. A marker blob under .multipart/{uploadId}/ remembers the
+// target key and content type until then. Presigned PUTs of whole objects must send
+// "x-ms-blob-type: BlockBlob", as Azure requires.
+type AzureStorage struct {
+	container    *container.Client
+	containerURL string
+}
+
+type NewAzureStorageInput struct {
+	Account   string
+	Key       string
+	Container string
+	// Endpoint is the blob service URL; empty means the public Azure
+	// endpoint of Account.
+	Endpoint string
+}
+
+func NewAzureStorage(in NewAzureStorageInput) (*AzureStorage, error) {
+	if strings.TrimSpace(in.Account) == "" || strings.TrimSpace(in.Key) == "" || strings.TrimSpace(in.Container) == "" {
+		return nil, errors.New("azure storage account, key or container is not configured")
+	}
+	endpoint := strings.TrimRight(strings.TrimSpace(in.Endpoint), "/")
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.blob.core.windows.net", in.Account)
+	}
+	containerURL := endpoint + "/" + in.Container
+
+	cred, err := container.NewSharedKeyCredential(in.Account, in.Key)
+	if err != nil {
+		return nil, err
+	}
+	client, err := container.NewClientWithSharedKeyCredential(containerURL, cred, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &AzureStorage{container: client, containerURL: containerURL}, nil
+}
+
+func (s *AzureStorage) PresignPutObject(ctx context.Context, key, contentType string, expires time.Duration) (string, error) {
+	return s.sasURL(key, sas.BlobPermissions{Create: true, Write: true}, expires)
+}
+
+func (s *AzureStorage) PresignGetObject(ctx context.Context, key string, expires time.Duration) (string, error) {
+	return s.sasURL(key, sas.BlobPermissions{Read: true}, expires)
+}
+
+// CreateMultipartUpload allocates an ID and writes the marker blob; Azure
+// keeps the uncommitted blocks on the target blob itself.
+func (s *AzureStorage) CreateMultipartUpload(ctx context.Context, key, contentType string) (string, error) {
+	uploadID, err := newMultipartUploadID()
+	if err != nil {
+		return "", err
+	}
+	// The marker carries the content type of the target as its own, so
+	// completion can apply it.
+	_, err = s.container.NewBlockBlobClient(azureMultipartMarker(uploadID)).UploadBuffer(ctx, []byte(key), &blockblob.UploadBufferOptions{
+		HTTPHeaders: azureHTTPHeaders(key, contentType),
+	})
+	if err != nil {
+		return "", err
+	}
+	return uploadID, nil
+}
+
+// ChecksPartsByPresence reports that completion only needs part numbers:
+// Put Block responses carry no ETag for clients to send back.
+func (s *AzureStorage) ChecksPartsByPresence() bool {
+	return true
+}
+
+// PresignUploadPart returns a Put Block URL. Put Block responses carry no
+// ETag, so completion checks parts by presence rather than by ETag.
+func (s *AzureStorage) PresignUploadPart(ctx context.Context, key, uploadID string, partNumber int32, expires time.Duration) (string, error) {
+	if !isMultipartUploadID(uploadID) {
+		return "", repository.ErrNotFound
+	}
+	signedURL, err := s.sasURL(key, sas.BlobPermissions{Write: true}, expires)
+	if err != nil {
+		return "", err
+	}
+	return signedURL + "&comp=block&blockid=" + url.QueryEscape(azureBlockID(uploadID, partNumber)), nil
+}
+
+func (s *AzureStorage) UploadPart(ctx context.Context, key, uploadID string, partNumber int32, data []byte) (string, error) {
+	if !isMultipartUploadID(uploadID) {
+		return "", repository.ErrNotFound
+	}
+	blockID := azureBlockID(uploadID, partNumber)
+	body := streaming.NopCloser(bytes.NewReader(data))
+	if _, err := s.container.NewBlockBlobClient(key).StageBlock(ctx, blockID, body, nil); err != nil {
+		return "", err
+	}
+	return `"` + blockID + `"`, nil
+}
+
+// ListParts reports an upload as missing once its marker is gone, i.e.
+// after completion or abort, like S3 and GCS do.
+func (s *AzureStorage) ListParts(ctx context.Context, key, uploadID string) ([]CompletedPart, error) {
+	if !isMultipartUploadID(uploadID) {
+		return nil, repository.ErrNotFound
+	}
+	if _, err := s.container.NewBlobClient(azureMultipartMarker(uploadID)).GetProperties(ctx, nil); err != nil {
+		return nil, azureError(err)
+	}
+	resp, err := s.container.NewBlockBlobClient(key).GetBlockList(ctx, blockblob.BlockListTypeAll, nil)
+	if err != nil {
+		if bloberror.HasCode(err, bloberror.BlobNotFound) {
+			// No block has been staged yet.
+			return []CompletedPart{}, nil
+		}
+		return nil, err
+	}
+
+	parts := azureUploadParts(uploadID, resp.UncommittedBlocks)
+	if len(parts) == 0 && len(azureUploadParts(uploadID, resp.CommittedBlocks)) > 0 {
+		// Committed already; only dropping the marker failed.
+		return nil, repository.ErrNotFound
+	}
+	return parts, nil
+}
+
+func (s *AzureStorage) CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []CompletedPart) error {
+	contentType, err := s.multipartContentType(ctx, key, uploadID)
+	if err != nil {
+		return err
+	}
+	stored, err := s.ListParts(ctx, key, uploadID)
+	if err != nil {
+		return err
+	}
+	if len(stored) == 0 {
+		return repository.ErrNotFound
+	}
+	staged := make(map[int32]struct{}, len(stored))
+	for _, part := range stored {
+		staged[part.PartNumber] = struct{}{}
+	}
+
+	blockIDs := make([]string, 0, len(parts))
+	for i, part := range parts {
+		if i > 0 && part.PartNumber <= parts[i-1].PartNumber {
+			return fmt.Errorf("%w: InvalidPartOrder", ErrInvalidMultipartParts)
+		}
+		if _, ok := staged[part.PartNumber]; !ok {
+			return fmt.Errorf("%w: InvalidPart", ErrInvalidMultipartParts)
+		}
+		blockIDs = append(blockIDs, azureBlockID(uploadID, part.PartNumber))
+	}
+
+	_, err = s.container.NewBlockBlobClient(key).CommitBlockList(ctx, blockIDs, &blockblob.CommitBlockListOptions{
+		HTTPHeaders: azureHTTPHeaders(key, contentType),
+	})
+	if err != nil {
+		return err
+	}
+	return s.DeleteObject(ctx, azureMultipartMarker(uploadID))
+}
+
+// AbortMultipartUpload only drops the marker: Azure discards uncommitted
+// blocks on its own after a week and offers no call to drop them earlier.
+func (s *AzureStorage) AbortMultipartUpload(ctx context.Context, key, uploadID string) error {
+	if !isMultipartUploadID(uploadID) {
+		return nil
+	}
+	return s.DeleteObject(ctx, azureMultipartMarker(uploadID))
+}
+
+// multipartContentType reads the content type recorded for uploadID.
+func (s *AzureStorage) multipartContentType(ctx context.Context, key, uploadID string) (string, error) {
+	if !isMultipartUploadID(uploadID) {
+		return "", repository.ErrNotFound
+	}
+	resp, err := s.container.NewBlobClient(azureMultipartMarker(uploadID)).DownloadStream(ctx, nil)
+	if err != nil {
+		return "", azureError(err)
+	}
+	defer resp.Body.Close()
+	storedKey, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if string(storedKey) != key {
+		return "", repository.ErrNotFound
+	}
+	if resp.ContentType == nil {
+		return "", nil
+	}
+	return *resp.ContentType, nil
+}
+
+func (s *AzureStorage) HeadObject(ctx context.Context, key string) (ObjectHead, error) {
+	props, err := s.container.NewBlobClient(key).GetProperties(ctx, nil)
+	if err != nil {
+		return ObjectHead{}, azureError(err)
+	}
+	head := ObjectHead{
+		ContentLength: derefInt64(props.ContentLength),
+		LastModified:  derefTime(props.LastModified),
+	}
+	if props.ContentType != nil {
+		head.ContentType = *props.ContentType
+	}
+	if props.ETag != nil {
+		head.ETag = string(*props.ETag)
+	}
+	return head, nil
+}
+
+func (s *AzureStorage) OpenObject(ctx context.Context, key, byteRange string) (ObjectStream, error) {
+	options := &blob.DownloadStreamOptions{}
+	if byteRange != "" {
+		head, err := s.HeadObject(ctx, key)
+		if err != nil {
+			return ObjectStream{}, err
+		}
+		start, end, ok := parseByteRange(byteRange, head.ContentLength)
+		if !ok {
+			return ObjectStream{}, ErrInvalidRange
+		}
+		options.Range = blob.HTTPRange{Offset: start, Count: end - start + 1}
+	}
+
+	resp, err := s.container.NewBlobClient(key).DownloadStream(ctx, options)
+	if err != nil {
+		return ObjectStream{}, azureError(err)
+	}
+	object := ObjectStream{
+		ObjectHead: ObjectHead{
+			ContentLength: derefInt64(resp.ContentLength),
+			LastModified:  derefTime(resp.LastModified),
+		},
+		Body: resp.Body,
+	}
+	if resp.ContentType != nil {
+		object.ContentType = *resp.ContentType
+	}
+	if resp.ETag != nil {
+		object.ETag = string(*resp.ETag)
+	}
+	if byteRange != "" && resp.ContentRange != nil {
+		object.ContentRange = *resp.ContentRange
+	}
+	return object, nil
+}
+
+func (s *AzureStorage) DownloadObjectToFile(ctx context.Context, key, filePath, expectedSHA256 string) (string, error) {
+	resp, err := s.container.NewBlobClient(key).DownloadStream(ctx, nil)
+	if err != nil {
+		return "", azureError(err)
+	}
+	body := resp.NewRetryReader(ctx, nil)
+	defer body.Close()
+
+	return writeObjectFile(body, filePath, expectedSHA256)
+}
+
+func (s *AzureStorage) GetObjectBytes(ctx context.Context, key string) ([]byte, error) {
+	resp, err := s.container.NewBlobClient(key).DownloadStream(ctx, nil)
+	if err != nil {
+		return nil, azureError(err)
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+func (s *AzureStorage) UploadFile(ctx context.Context, key, contentType, filePath string) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = s.container.NewBlockBlobClient(key).UploadFile(ctx, f, &blockblob.UploadFileOptions{
+		HTTPHeaders: azureHTTPHeaders(key, contentType),
+	})
+	return err
+}
+
+func (s *AzureStorage) UploadBytes(ctx context.Context, key, contentType string, data []byte) error {
+	_, err := s.container.NewBlockBlobClient(key).UploadBuffer(ctx, data, &blockblob.UploadBufferOptions{
+		HTTPHeaders: azureHTTPHeaders(key, contentType),
+	})
+	return err
+}
+
+// UploadOutputFile stores a derived object. Azure sets public access per
+// container, not per blob, so outputs are written like any other object.
+func (s *AzureStorage) UploadOutputFile(ctx context.Context, key, contentType, filePath string) error {
+	return s.UploadFile(ctx, key, contentType, filePath)
+}
+
+func (s *AzureStorage) UploadOutputBytes(ctx context.Context, key, contentType string, data []byte) error {
+	return s.UploadBytes(ctx, key, contentType, data)
+}
+
+func (s *AzureStorage) DeleteObject(ctx context.Context, key string) error {
+	_, err := s.container.NewBlobClient(key).Delete(ctx, nil)
+	if err != nil && !bloberror.HasCode(err, bloberror.BlobNotFound) {
+		return err
+	}
+	return nil
+}
+
+func (s *AzureStorage) DeleteObjectsByPrefix(ctx context.Context, prefix string) error {
+	return deleteWalkedObjects(ctx, s, prefix)
+}
+
+func (s *AzureStorage) WalkObjects(ctx context.Context, prefix string, fn func(key string, size int64) error) error {
+	pager := s.container.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{Prefix: &prefix})
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		if page.Segment == nil {
+			continue
+		}
+		for _, item := range page.Segment.BlobItems {
+			if item == nil || item.Name == nil {
+				continue
+			}
+			if err := fn(*item.Name, azureBlobSize(item)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *AzureStorage) ListMediaPrefixes(ctx context.Context) (map[string]string, error) {
+	return listMediaPrefixes(ctx, s)
+}
+
+func (s *AzureStorage) ObjectURL(key string) string {
+	return s.containerURL + "/" + escapeObjectKey(key)
+}
+
+func (s *AzureStorage) sasURL(key string, permissions sas.BlobPermissions, expires time.Duration) (string, error) {
+	if expires <= 0 {
+		expires = 15 * time.Minute
+	}
+	return s.container.NewBlobClient(key).GetSASURL(permissions, time.Now().Add(expires), nil)
+}
+
+// azureBlockID encodes the upload ID and part number. Azure requires all
+// block IDs of a blob to have the same length, hence the fixed width.
+func azureBlockID(uploadID string, partNumber int32) string {
+	return base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s-%05d", uploadID, partNumber)))
+}
+
+func parseAzureBlockID(uploadID, blockID string) (int32, bool) {
+	raw, err := base64.StdEncoding.DecodeString(blockID)
+	if err != nil {
+		return 0, false
+	}
+	number, ok := strings.CutPrefix(string(raw), uploadID+"-")
+	if !ok {
+		return 0, false
+	}
+	partNumber, err := strconv.ParseInt(number, 10, 32)
+	if err != nil {
+		return 0, false
+	}
+	return int32(partNumber), true
+}
+
+// azureUploadParts picks the blocks staged for uploadID, in part order.
+func azureUploadParts(uploadID string, blocks []*blockblob.Block) []CompletedPart {
+	parts := make([]CompletedPart, 0, len(blocks))
+	for _, block := range blocks {
+		if block == nil || block.Name == nil {
+			continue
+		}
+		partNumber, ok := parseAzureBlockID(uploadID, *block.Name)
+		if !ok {
+			continue
+		}
+		parts = append(parts, CompletedPart{PartNumber: partNumber, ETag: `"` + *block.Name + `"`})
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].PartNumber < parts[j].PartNumber })
+	return parts
+}
+
+func azureBlobSize(item *container.BlobItem) int64 {
+	if item.Properties == nil {
+		return 0
+	}
+	return derefInt64(item.Properties.ContentLength)
+}
+
+func azureMultipartMarker(uploadID string) string {
+	return multipartStagingDir + "/" + uploadID + "/key"
+}
+
+func azureHTTPHeaders(key, contentType string) *blob.HTTPHeaders {
+	if contentType == "" {
+		contentType = mime.TypeByExtension(path.Ext(key))
+	}
+	if contentType == "" {
+		return nil
+	}
+	return &blob.HTTPHeaders{BlobContentType: &contentType}
+}
+
+func azureError(err error) error {
+	if bloberror.HasCode(err, bloberror.BlobNotFound) {
+		return repository.ErrNotFound
+	}
+	if bloberror.HasCode(err, bloberror.InvalidRange) {
+		return ErrInvalidRange
+	}
+	return err
+}
+
+func derefInt64(v *int64) int64 {
+	if v == nil {
+		return 0
+	}
+	return *v
+}
+
+func derefTime(v *time.Time) time.Time {
+	if v == nil {
+		return time.Time{}
+	}
+	return *v
+}
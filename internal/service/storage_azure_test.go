@@ -0,0 +1,69 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+)
+
+func TestAzureBlockID(t *testing.T) {
+	const uploadID = "0123456789abcdef0123456789abcdef"
+	first, last := azureBlockID(uploadID, 1), azureBlockID(uploadID, 10000)
+	if len(first) != len(last) {
+		t.Fatalf("block IDs differ in length: %q and %q", first, last)
+	}
+	for _, partNumber := range []int32{1, 42, 10000} {
+		got, ok := parseAzureBlockID(uploadID, azureBlockID(uploadID, partNumber))
+		if !ok || got != partNumber {
+			t.Fatalf("parseAzureBlockID(azureBlockID(%d)) = %d, %v", partNumber, got, ok)
+		}
+	}
+	if _, ok := parseAzureBlockID("fedcba9876543210fedcba9876543210", first); ok {
+		t.Fatal("a block of another upload was accepted")
+	}
+	if _, ok := parseAzureBlockID(uploadID, "not base64!"); ok {
+		t.Fatal("a malformed block ID was accepted")
+	}
+}
+
+func TestAzureUploadParts(t *testing.T) {
+	const uploadID = "0123456789abcdef0123456789abcdef"
+	block := func(name string) *blockblob.Block {
+		return &blockblob.Block{Name: &name}
+	}
+	part := func(partNumber int32) CompletedPart {
+		return CompletedPart{PartNumber: partNumber, ETag: `"` + azureBlockID(uploadID, partNumber) + `"`}
+	}
+
+	tests := []struct {
+		name   string
+		blocks []*blockblob.Block
+		want   []CompletedPart
+	}{
+		{name: "none", want: []CompletedPart{}},
+		{
+			name:   "sorted by part number",
+			blocks: []*blockblob.Block{block(azureBlockID(uploadID, 3)), block(azureBlockID(uploadID, 1)), block(azureBlockID(uploadID, 2))},
+			want:   []CompletedPart{part(1), part(2), part(3)},
+		},
+		{
+			name: "other uploads and broken entries",
+			blocks: []*blockblob.Block{
+				block(azureBlockID("fedcba9876543210fedcba9876543210", 1)),
+				nil,
+				{},
+				block("garbage"),
+				block(azureBlockID(uploadID, 2)),
+			},
+			want: []CompletedPart{part(2)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := azureUploadParts(uploadID, tt.blocks); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("azureUploadParts() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
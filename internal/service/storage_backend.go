@@ -0,0 +1,239 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"calixio/internal/config"
+)
+
+// multipartStagingDir holds in-progress multipart uploads on backends
+// without native support for them. It sits outside "users/" so media
+// listings never see it.
+const multipartStagingDir = ".multipart"
+
+var ErrChecksumMismatch = errors.New("object checksum mismatch")
+
+// Storage is the object store behind uploads and derived media outputs.
+// Keys are slash-separated, e.g. users/{uid}/media/{id}/hls/index.m3u8.
+// Missing objects are reported as repository.ErrNotFound.
+type Storage interface {
+	PresignPutObject(ctx context.Context, key, contentType string, expires time.Duration) (string, error)
+	PresignGetObject(ctx context.Context, key string, expires time.Duration) (string, error)
+
+	CreateMultipartUpload(ctx context.Context, key, contentType string) (string, error)
+	PresignUploadPart(ctx context.Context, key, uploadID string, partNumber int32, expires time.Duration) (string, error)
+	UploadPart(ctx context.Context, key, uploadID string, partNumber int32, data []byte) (string, error)
+	ListParts(ctx context.Context, key, uploadID string) ([]CompletedPart, error)
+	CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []CompletedPart) error
+	AbortMultipartUpload(ctx context.Context, key, uploadID string) error
+
+	HeadObject(ctx context.Context, key string) (ObjectHead, error)
+	OpenObject(ctx context.Context, key, byteRange string) (ObjectStream, error)
+	// DownloadObjectToFile returns the hex SHA-256 of the object and fails
+	// with ErrChecksumMismatch when it differs from a non-empty
+	// expectedSHA256.
+	DownloadObjectToFile(ctx context.Context, key, filePath, expectedSHA256 string) (string, error)
+	GetObjectBytes(ctx context.Context, key string) ([]byte, error)
+
+	UploadFile(ctx context.Context, key, contentType, filePath string) error
+	UploadBytes(ctx context.Context, key, contentType string, data []byte) error
+	UploadOutputFile(ctx context.Context, key, contentType, filePath string) error
+	UploadOutputBytes(ctx context.Context, key, contentType string, data []byte) error
+
+	DeleteObject(ctx context.Context, key string) error
+	DeleteObjectsByPrefix(ctx context.Context, prefix string) error
+	WalkObjects(ctx context.Context, prefix string, fn func(key string, size int64) error) error
+	// ListMediaPrefixes maps media IDs to their "users/{uid}/media/{id}/"
+	// prefix for every media that has at least one object.
+	ListMediaPrefixes(ctx context.Context) (map[string]string, error)
+
+	// ObjectURL is the unsigned URL of key, stored on media rows. It only
+	// resolves for objects that are publicly readable.
+	ObjectURL(key string) string
+}
+
+// presenceCheckedParts is implemented by backends whose part uploads return
+// no ETag; completing a multipart upload on them needs only part numbers.
+type presenceCheckedParts interface {
+	ChecksPartsByPresence() bool
+}
+
+// requiresPartETags reports whether clients must send the ETag of every part
+// when completing a multipart upload on storage.
+func requiresPartETags(storage Storage) bool {
+	checker, ok := storage.(presenceCheckedParts)
+	return !ok || !checker.ChecksPartsByPresence()
+}
+
+// NewStorage builds the backend selected by STORAGE_BACKEND.
+func NewStorage(ctx context.Context, cfg *config.Config) (Storage, error) {
+	switch cfg.Storage.Backend {
+	case "", "s3":
+		return NewS3Storage(ctx, cfg)
+	case "local":
+		return NewLocalStorage(NewLocalStorageInput{
+			Root:           cfg.Storage.LocalDir,
+			BaseURL:        cfg.Storage.LocalBaseURL,
+			Secret:         cfg.Storage.LocalSecret,
+			PrivateOutputs: cfg.AWS.PrivateOutputs,
+		})
+	case "gcs":
+		return NewGCSStorage(ctx, NewGCSStorageInput{
+			Bucket:          cfg.GCS.Bucket,
+			CredentialsFile: cfg.GCS.CredentialsFile,
+			PublicURL:       cfg.GCS.PublicURL,
+		})
+	case "azure":
+		return NewAzureStorage(NewAzureStorageInput{
+			Account:   cfg.Azure.Account,
+			Key:       cfg.Azure.Key,
+			Container: cfg.Azure.Container,
+			Endpoint:  cfg.Azure.Endpoint,
+		})
+	default:
+		return nil, fmt.Errorf("unsupported storage backend %q", cfg.Storage.Backend)
+	}
+}
+
+// listMediaPrefixes implements ListMediaPrefixes on top of WalkObjects for
+// backends without a cheaper way to group keys.
+func listMediaPrefixes(ctx context.Context, storage Storage) (map[string]string, error) {
+	prefixes := make(map[string]string)
+	err := storage.WalkObjects(ctx, "users/", func(key string, _ int64) error {
+		mediaID, mediaPrefix, ok := extractMediaPrefix(key)
+		if !ok {
+			return nil
+		}
+		if _, exists := prefixes[mediaID]; !exists {
+			prefixes[mediaID] = mediaPrefix
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return prefixes, nil
+}
+
+// deleteWalkedObjects implements DeleteObjectsByPrefix for backends without
+// a bulk delete.
+func deleteWalkedObjects(ctx context.Context, storage Storage, prefix string) error {
+	trimmedPrefix := strings.TrimSpace(prefix)
+	if trimmedPrefix == "" {
+		return errors.New("prefix is required")
+	}
+	keys := make([]string, 0)
+	if err := storage.WalkObjects(ctx, trimmedPrefix, func(key string, _ int64) error {
+		keys = append(keys, key)
+		return nil
+	}); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := storage.DeleteObject(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeObjectFile implements the copy behind DownloadObjectToFile, hashing
+// the object on the way to disk.
+func writeObjectFile(body io.Reader, filePath, expectedSHA256 string) (string, error) {
+	f, err := os.Create(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(f, io.TeeReader(body, hash)); err != nil {
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	digest := hex.EncodeToString(hash.Sum(nil))
+	if expectedSHA256 != "" && !strings.EqualFold(digest, expectedSHA256) {
+		return digest, ErrChecksumMismatch
+	}
+	return digest, nil
+}
+
+// escapeObjectKey escapes each segment of key for use in a URL path.
+func escapeObjectKey(key string) string {
+	segments := strings.Split(strings.TrimLeft(key, "/"), "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+func newMultipartUploadID() (string, error) {
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(idBytes), nil
+}
+
+func isMultipartUploadID(uploadID string) bool {
+	_, err := hex.DecodeString(uploadID)
+	return err == nil && len(uploadID) == 32
+}
+
+func formatContentRange(start, end, size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", start, end, size)
+}
+
+// parseByteRange resolves a single "bytes=" range against size and returns
+// the inclusive start and end offsets.
+func parseByteRange(raw string, size int64) (int64, int64, bool) {
+	spec, ok := strings.CutPrefix(strings.TrimSpace(raw), "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, 0, false
+	}
+	first, last, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, 0, false
+	}
+	first, last = strings.TrimSpace(first), strings.TrimSpace(last)
+
+	if first == "" {
+		suffix, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || suffix <= 0 || size == 0 {
+			return 0, 0, false
+		}
+		if suffix > size {
+			suffix = size
+		}
+		return size - suffix, size - 1, true
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, false
+	}
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, false
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return start, end, true
+}
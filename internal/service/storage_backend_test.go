@@ -0,0 +1,45 @@
+package service
+
+import "testing"
+
+func TestParseByteRange(t *testing.T) {
+	tests := []struct {
+		name      string
+		raw       string
+		size      int64
+		wantStart int64
+		wantEnd   int64
+		wantOK    bool
+	}{
+		{name: "closed", raw: "bytes=0-99", size: 1000, wantStart: 0, wantEnd: 99, wantOK: true},
+		{name: "single byte", raw: "bytes=5-5", size: 10, wantStart: 5, wantEnd: 5, wantOK: true},
+		{name: "open ended", raw: "bytes=100-", size: 1000, wantStart: 100, wantEnd: 999, wantOK: true},
+		{name: "end past size", raw: "bytes=900-5000", size: 1000, wantStart: 900, wantEnd: 999, wantOK: true},
+		{name: "suffix", raw: "bytes=-100", size: 1000, wantStart: 900, wantEnd: 999, wantOK: true},
+		{name: "suffix longer than object", raw: "bytes=-5000", size: 1000, wantStart: 0, wantEnd: 999, wantOK: true},
+		{name: "spaces", raw: " bytes= 10 - 20 ", size: 1000, wantStart: 10, wantEnd: 20, wantOK: true},
+		{name: "start at size", raw: "bytes=1000-", size: 1000},
+		{name: "end before start", raw: "bytes=20-10", size: 1000},
+		{name: "zero suffix", raw: "bytes=-0", size: 1000},
+		{name: "suffix of empty object", raw: "bytes=-10", size: 0},
+		{name: "empty object", raw: "bytes=0-", size: 0},
+		{name: "multiple ranges", raw: "bytes=0-1,5-6", size: 1000},
+		{name: "other unit", raw: "items=0-1", size: 1000},
+		{name: "no dash", raw: "bytes=10", size: 1000},
+		{name: "empty spec", raw: "bytes=-", size: 1000},
+		{name: "negative start", raw: "bytes=-5-10", size: 1000},
+		{name: "not a number", raw: "bytes=a-b", size: 1000},
+		{name: "empty", raw: "", size: 1000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end, ok := parseByteRange(tt.raw, tt.size)
+			if ok != tt.wantOK {
+				t.Fatalf("parseByteRange(%q, %d) ok = %v, want %v", tt.raw, tt.size, ok, tt.wantOK)
+			}
+			if ok && (start != tt.wantStart || end != tt.wantEnd) {
+				t.Fatalf("parseByteRange(%q, %d) = %d-%d, want %d-%d", tt.raw, tt.size, start, end, tt.wantStart, tt.wantEnd)
+			}
+		})
+	}
+}
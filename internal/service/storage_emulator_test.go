@@ -0,0 +1,222 @@
+//go:build integration
+
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"calixio/internal/repository"
+)
+
+// These tests run the GCS and Azure backends against the emulators from
+// docker-compose.dev.yml:
+//
+//	docker compose -f docker-compose.dev.yml --profile emulators up -d
+//	STORAGE_EMULATOR_HOST=http://localhost:4443 \
+//	AZURE_STORAGE_ENDPOINT=http://127.0.0.1:10000/devstoreaccount1 \
+//	go test -tags integration ./internal/service/ -run Emulator
+//
+// A backend whose variable is unset is skipped.
+
+// azuriteAccountKey is the well-known key of the Azurite devstoreaccount1.
+const azuriteAccountKey = "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw=="
+
+func newEmulatorGCSStorage(t *testing.T) *GCSStorage {
+	t.Helper()
+	if os.Getenv("STORAGE_EMULATOR_HOST") == "" {
+		t.Skip("STORAGE_EMULATOR_HOST is not set")
+	}
+	ctx := context.Background()
+	bucket := "calixio-test-" + mustMultipartUploadID(t)[:12]
+	storage, err := NewGCSStorage(ctx, NewGCSStorageInput{Bucket: bucket})
+	if err != nil {
+		t.Fatalf("NewGCSStorage: %v", err)
+	}
+	if err := storage.bucket.Create(ctx, "calixio-test", nil); err != nil {
+		t.Fatalf("create bucket %s: %v", bucket, err)
+	}
+	t.Cleanup(func() {
+		_ = storage.DeleteObjectsByPrefix(ctx, "")
+		_ = storage.bucket.Delete(ctx)
+		_ = storage.client.Close()
+	})
+	return storage
+}
+
+func newEmulatorAzureStorage(t *testing.T) *AzureStorage {
+	t.Helper()
+	endpoint := os.Getenv("AZURE_STORAGE_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("AZURE_STORAGE_ENDPOINT is not set")
+	}
+	ctx := context.Background()
+	storage, err := NewAzureStorage(NewAzureStorageInput{
+		Account:   "devstoreaccount1",
+		Key:       azuriteAccountKey,
+		Container: "calixio-test-" + mustMultipartUploadID(t)[:12],
+		Endpoint:  endpoint,
+	})
+	if err != nil {
+		t.Fatalf("NewAzureStorage: %v", err)
+	}
+	if _, err := storage.container.Create(ctx, nil); err != nil {
+		t.Fatalf("create container: %v", err)
+	}
+	t.Cleanup(func() {
+		_, _ = storage.container.Delete(ctx, nil)
+	})
+	return storage
+}
+
+var emulatorBackends = []struct {
+	name    string
+	storage func(t *testing.T) Storage
+}{
+	{name: "gcs", storage: func(t *testing.T) Storage { return newEmulatorGCSStorage(t) }},
+	{name: "azure", storage: func(t *testing.T) Storage { return newEmulatorAzureStorage(t) }},
+}
+
+func mustMultipartUploadID(t *testing.T) string {
+	t.Helper()
+	id, err := newMultipartUploadID()
+	if err != nil {
+		t.Fatalf("newMultipartUploadID: %v", err)
+	}
+	return id
+}
+
+func TestEmulatorMultipartUpload(t *testing.T) {
+	tests := []struct {
+		name      string
+		partCount int
+	}{
+		{name: "single part", partCount: 1},
+		// More than gcsMaxComposeSources parts need an intermediate compose round.
+		{name: "two compose rounds", partCount: gcsMaxComposeSources + 8},
+	}
+	for _, backend := range emulatorBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			storage := backend.storage(t)
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					ctx := context.Background()
+					key := fmt.Sprintf("users/user-1/media/%d/original.mp4", tt.partCount)
+					uploadID, err := storage.CreateMultipartUpload(ctx, key, "video/mp4")
+					if err != nil {
+						t.Fatalf("CreateMultipartUpload: %v", err)
+					}
+
+					parts, err := storage.ListParts(ctx, key, uploadID)
+					if err != nil || len(parts) != 0 {
+						t.Fatalf("ListParts before any part = %v, %v, want no parts", parts, err)
+					}
+
+					// Parts go up in reverse order to check ListParts sorts them.
+					var want bytes.Buffer
+					for number := tt.partCount; number >= 1; number-- {
+						data := bytes.Repeat([]byte{byte('a' + number%26)}, 100+number)
+						if _, err := storage.UploadPart(ctx, key, uploadID, int32(number), data); err != nil {
+							t.Fatalf("UploadPart %d: %v", number, err)
+						}
+					}
+					for number := 1; number <= tt.partCount; number++ {
+						want.Write(bytes.Repeat([]byte{byte('a' + number%26)}, 100+number))
+					}
+
+					parts, err = storage.ListParts(ctx, key, uploadID)
+					if err != nil {
+						t.Fatalf("ListParts: %v", err)
+					}
+					if len(parts) != tt.partCount {
+						t.Fatalf("ListParts returned %d parts, want %d", len(parts), tt.partCount)
+					}
+					for i, part := range parts {
+						if part.PartNumber != int32(i+1) {
+							t.Fatalf("part %d has number %d", i, part.PartNumber)
+						}
+					}
+
+					if err := storage.CompleteMultipartUpload(ctx, key, uploadID, parts); err != nil {
+						t.Fatalf("CompleteMultipartUpload: %v", err)
+					}
+					got, err := storage.GetObjectBytes(ctx, key)
+					if err != nil {
+						t.Fatalf("GetObjectBytes: %v", err)
+					}
+					if !bytes.Equal(got, want.Bytes()) {
+						t.Fatalf("assembled %d bytes that differ from the %d sent", len(got), want.Len())
+					}
+					head, err := storage.HeadObject(ctx, key)
+					if err != nil {
+						t.Fatalf("HeadObject: %v", err)
+					}
+					if head.ContentType != "video/mp4" {
+						t.Fatalf("content type = %q, want video/mp4", head.ContentType)
+					}
+
+					// A retried completion must see the upload as gone.
+					if _, err := storage.ListParts(ctx, key, uploadID); !errors.Is(err, repository.ErrNotFound) {
+						t.Fatalf("ListParts after completion: error = %v, want ErrNotFound", err)
+					}
+					if err := storage.CompleteMultipartUpload(ctx, key, uploadID, parts); !errors.Is(err, repository.ErrNotFound) {
+						t.Fatalf("CompleteMultipartUpload again: error = %v, want ErrNotFound", err)
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestEmulatorMultipartUploadRejects(t *testing.T) {
+	for _, backend := range emulatorBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			storage := backend.storage(t)
+			ctx := context.Background()
+			const key = "users/user-1/media/media-1/original.mp4"
+
+			t.Run("unknown upload", func(t *testing.T) {
+				if _, err := storage.ListParts(ctx, key, mustMultipartUploadID(t)); !errors.Is(err, repository.ErrNotFound) {
+					t.Fatalf("error = %v, want ErrNotFound", err)
+				}
+			})
+
+			t.Run("missing part", func(t *testing.T) {
+				uploadID, err := storage.CreateMultipartUpload(ctx, key, "video/mp4")
+				if err != nil {
+					t.Fatalf("CreateMultipartUpload: %v", err)
+				}
+				if _, err := storage.UploadPart(ctx, key, uploadID, 1, []byte("part")); err != nil {
+					t.Fatalf("UploadPart: %v", err)
+				}
+				err = storage.CompleteMultipartUpload(ctx, key, uploadID, []CompletedPart{{PartNumber: 1}, {PartNumber: 2}})
+				if !errors.Is(err, ErrInvalidMultipartParts) {
+					t.Fatalf("error = %v, want ErrInvalidMultipartParts", err)
+				}
+			})
+
+			t.Run("aborted", func(t *testing.T) {
+				uploadID, err := storage.CreateMultipartUpload(ctx, key, "video/mp4")
+				if err != nil {
+					t.Fatalf("CreateMultipartUpload: %v", err)
+				}
+				if _, err := storage.UploadPart(ctx, key, uploadID, 1, []byte("part")); err != nil {
+					t.Fatalf("UploadPart: %v", err)
+				}
+				if err := storage.AbortMultipartUpload(ctx, key, uploadID); err != nil {
+					t.Fatalf("AbortMultipartUpload: %v", err)
+				}
+				if _, err := storage.ListParts(ctx, key, uploadID); !errors.Is(err, repository.ErrNotFound) {
+					t.Fatalf("ListParts after abort: error = %v, want ErrNotFound", err)
+				}
+				if err := storage.AbortMultipartUpload(ctx, key, uploadID); err != nil {
+					t.Fatalf("AbortMultipartUpload again: %v", err)
+				}
+			})
+		})
+	}
+}
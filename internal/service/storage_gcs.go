@@ -0,0 +1,423 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"calixio/internal/repository"
+
+	gcs "cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+// gcsMaxComposeSources is the limit of source objects per compose request.
+const gcsMaxComposeSources = 32
+
+// GCSStorage keeps objects in a Google Cloud Storage bucket through the
+// native API. With STORAGE_EMULATOR_HOST set, the client talks to an
+// emulator such as fake-gcs-server instead.
+//
+// GCS has no S3-style multipart uploads, so parts are stored as objects
+// under .multipart/{uploadID}/ and composed into the target on completion.
+type GCSStorage struct {
+	client     *gcs.Client
+	bucket     *gcs.BucketHandle
+	bucketName string
+	publicURL  string
+	// accessID and privateKey sign URLs when a service account key is
+	// configured; otherwise the client credentials are used.
+	accessID   string
+	privateKey []byte
+	insecure   bool
+}
+
+type NewGCSStorageInput struct {
+	Bucket string
+	// CredentialsFile is a service account JSON key, used for API calls and
+	// for signing URLs. Empty falls back to application default credentials.
+	CredentialsFile string
+	PublicURL       string
+}
+
+func NewGCSStorage(ctx context.Context, in NewGCSStorageInput) (*GCSStorage, error) {
+	if strings.TrimSpace(in.Bucket) == "" {
+		return nil, errors.New("gcs bucket is not configured")
+	}
+
+	emulatorHost := os.Getenv("STORAGE_EMULATOR_HOST")
+	storage := &GCSStorage{
+		bucketName: in.Bucket,
+		publicURL:  strings.TrimRight(in.PublicURL, "/"),
+		insecure:   strings.HasPrefix(emulatorHost, "http://"),
+	}
+
+	opts := make([]option.ClientOption, 0, 1)
+	if in.CredentialsFile != "" {
+		raw, err := os.ReadFile(in.CredentialsFile)
+		if err != nil {
+			return nil, fmt.Errorf("read gcs credentials: %w", err)
+		}
+		var key struct {
+			ClientEmail string `json:"client_email"`
+			PrivateKey  string `json:"private_key"`
+		}
+		if err := json.Unmarshal(raw, &key); err != nil {
+			return nil, fmt.Errorf("parse gcs credentials: %w", err)
+		}
+		storage.accessID = key.ClientEmail
+		storage.privateKey = []byte(key.PrivateKey)
+		// The emulator accepts no credentials; the key then only signs URLs.
+		if emulatorHost == "" {
+			opts = append(opts, option.WithAuthCredentialsJSON(option.ServiceAccount, raw))
+		}
+	}
+
+	client, err := gcs.NewClient(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	storage.client = client
+	storage.bucket = client.Bucket(in.Bucket)
+	return storage, nil
+}
+
+func (s *GCSStorage) PresignPutObject(ctx context.Context, key, contentType string, expires time.Duration) (string, error) {
+	return s.signedURL("PUT", key, contentType, expires)
+}
+
+func (s *GCSStorage) PresignGetObject(ctx context.Context, key string, expires time.Duration) (string, error) {
+	return s.signedURL("GET", key, "", expires)
+}
+
+// CreateMultipartUpload writes a marker object that binds the upload to key
+// and remembers the content type for the composed object.
+func (s *GCSStorage) CreateMultipartUpload(ctx context.Context, key, contentType string) (string, error) {
+	uploadID, err := newMultipartUploadID()
+	if err != nil {
+		return "", err
+	}
+	w := s.bucket.Object(gcsMultipartKey(uploadID, "key")).NewWriter(ctx)
+	w.ContentType = "text/plain"
+	w.Metadata = map[string]string{"target-content-type": contentType}
+	if _, err := io.WriteString(w, key); err != nil {
+		_ = w.Close()
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return uploadID, nil
+}
+
+func (s *GCSStorage) PresignUploadPart(ctx context.Context, key, uploadID string, partNumber int32, expires time.Duration) (string, error) {
+	if !isMultipartUploadID(uploadID) {
+		return "", repository.ErrNotFound
+	}
+	return s.signedURL("PUT", gcsMultipartKey(uploadID, gcsPartName(partNumber)), "", expires)
+}
+
+func (s *GCSStorage) UploadPart(ctx context.Context, key, uploadID string, partNumber int32, data []byte) (string, error) {
+	if _, err := s.multipartTarget(ctx, key, uploadID); err != nil {
+		return "", err
+	}
+	w := s.bucket.Object(gcsMultipartKey(uploadID, gcsPartName(partNumber))).NewWriter(ctx)
+	if _, err := w.Write(data); err != nil {
+		_ = w.Close()
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return gcsETag(w.Attrs().MD5), nil
+}
+
+func (s *GCSStorage) ListParts(ctx context.Context, key, uploadID string) ([]CompletedPart, error) {
+	if _, err := s.multipartTarget(ctx, key, uploadID); err != nil {
+		return nil, err
+	}
+
+	parts := make([]CompletedPart, 0)
+	it := s.bucket.Objects(ctx, &gcs.Query{Prefix: gcsMultipartKey(uploadID, "")})
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		partNumber, err := strconv.Atoi(path.Base(attrs.Name))
+		if err != nil {
+			continue
+		}
+		parts = append(parts, CompletedPart{PartNumber: int32(partNumber), ETag: gcsETag(attrs.MD5)})
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].PartNumber < parts[j].PartNumber })
+	return parts, nil
+}
+
+// CompleteMultipartUpload composes the parts into key, in rounds of at most
+// 32 sources, then drops the staged objects.
+func (s *GCSStorage) CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []CompletedPart) error {
+	marker, err := s.multipartTarget(ctx, key, uploadID)
+	if err != nil {
+		return err
+	}
+	stored, err := s.ListParts(ctx, key, uploadID)
+	if err != nil {
+		return err
+	}
+	etags := make(map[int32]string, len(stored))
+	for _, part := range stored {
+		etags[part.PartNumber] = strings.Trim(part.ETag, `"`)
+	}
+
+	sources := make([]*gcs.ObjectHandle, 0, len(parts))
+	for i, part := range parts {
+		if i > 0 && part.PartNumber <= parts[i-1].PartNumber {
+			return fmt.Errorf("%w: InvalidPartOrder", ErrInvalidMultipartParts)
+		}
+		if etag, ok := etags[part.PartNumber]; !ok || etag != strings.Trim(part.ETag, `"`) {
+			return fmt.Errorf("%w: InvalidPart", ErrInvalidMultipartParts)
+		}
+		sources = append(sources, s.bucket.Object(gcsMultipartKey(uploadID, gcsPartName(part.PartNumber))))
+	}
+	if len(sources) == 0 {
+		return fmt.Errorf("%w: no parts", ErrInvalidMultipartParts)
+	}
+
+	for round := 0; len(sources) > gcsMaxComposeSources; round++ {
+		next := make([]*gcs.ObjectHandle, 0, (len(sources)+gcsMaxComposeSources-1)/gcsMaxComposeSources)
+		for start := 0; start < len(sources); start += gcsMaxComposeSources {
+			end := min(start+gcsMaxComposeSources, len(sources))
+			intermediate := s.bucket.Object(gcsMultipartKey(uploadID, fmt.Sprintf("compose-%d-%05d", round, start/gcsMaxComposeSources)))
+			if _, err := intermediate.ComposerFrom(sources[start:end]...).Run(ctx); err != nil {
+				return err
+			}
+			next = append(next, intermediate)
+		}
+		sources = next
+	}
+
+	composer := s.bucket.Object(key).ComposerFrom(sources...)
+	composer.ContentType = marker.Metadata["target-content-type"]
+	if _, err := composer.Run(ctx); err != nil {
+		return err
+	}
+	return deleteWalkedObjects(ctx, s, gcsMultipartKey(uploadID, ""))
+}
+
+func (s *GCSStorage) AbortMultipartUpload(ctx context.Context, key, uploadID string) error {
+	if _, err := s.multipartTarget(ctx, key, uploadID); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	return deleteWalkedObjects(ctx, s, gcsMultipartKey(uploadID, ""))
+}
+
+func (s *GCSStorage) HeadObject(ctx context.Context, key string) (ObjectHead, error) {
+	attrs, err := s.bucket.Object(key).Attrs(ctx)
+	if err != nil {
+		return ObjectHead{}, gcsError(err)
+	}
+	return ObjectHead{
+		ContentLength: attrs.Size,
+		ContentType:   attrs.ContentType,
+		ETag:          `"` + attrs.Etag + `"`,
+		LastModified:  attrs.Updated,
+	}, nil
+}
+
+func (s *GCSStorage) OpenObject(ctx context.Context, key, byteRange string) (ObjectStream, error) {
+	head, err := s.HeadObject(ctx, key)
+	if err != nil {
+		return ObjectStream{}, err
+	}
+	if byteRange == "" {
+		r, err := s.bucket.Object(key).NewReader(ctx)
+		if err != nil {
+			return ObjectStream{}, gcsError(err)
+		}
+		return ObjectStream{ObjectHead: head, Body: r}, nil
+	}
+
+	start, end, ok := parseByteRange(byteRange, head.ContentLength)
+	if !ok {
+		return ObjectStream{}, ErrInvalidRange
+	}
+	r, err := s.bucket.Object(key).NewRangeReader(ctx, start, end-start+1)
+	if err != nil {
+		return ObjectStream{}, gcsError(err)
+	}
+	size := head.ContentLength
+	head.ContentLength = end - start + 1
+	return ObjectStream{ObjectHead: head, ContentRange: formatContentRange(start, end, size), Body: r}, nil
+}
+
+func (s *GCSStorage) DownloadObjectToFile(ctx context.Context, key, filePath, expectedSHA256 string) (string, error) {
+	r, err := s.bucket.Object(key).NewReader(ctx)
+	if err != nil {
+		return "", gcsError(err)
+	}
+	defer r.Close()
+
+	return writeObjectFile(r, filePath, expectedSHA256)
+}
+
+func (s *GCSStorage) GetObjectBytes(ctx context.Context, key string) ([]byte, error) {
+	r, err := s.bucket.Object(key).NewReader(ctx)
+	if err != nil {
+		return nil, gcsError(err)
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+func (s *GCSStorage) UploadFile(ctx context.Context, key, contentType, filePath string) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return s.upload(ctx, key, contentType, f)
+}
+
+func (s *GCSStorage) UploadBytes(ctx context.Context, key, contentType string, data []byte) error {
+	return s.upload(ctx, key, contentType, bytes.NewReader(data))
+}
+
+// UploadOutputFile stores a derived object. Buckets usually run with
+// uniform bucket-level access, so public reads are granted through bucket
+// IAM rather than per-object ACLs.
+func (s *GCSStorage) UploadOutputFile(ctx context.Context, key, contentType, filePath string) error {
+	return s.UploadFile(ctx, key, contentType, filePath)
+}
+
+func (s *GCSStorage) UploadOutputBytes(ctx context.Context, key, contentType string, data []byte) error {
+	return s.UploadBytes(ctx, key, contentType, data)
+}
+
+func (s *GCSStorage) DeleteObject(ctx context.Context, key string) error {
+	err := s.bucket.Object(key).Delete(ctx)
+	if err != nil && !errors.Is(err, gcs.ErrObjectNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *GCSStorage) DeleteObjectsByPrefix(ctx context.Context, prefix string) error {
+	return deleteWalkedObjects(ctx, s, prefix)
+}
+
+func (s *GCSStorage) WalkObjects(ctx context.Context, prefix string, fn func(key string, size int64) error) error {
+	query := &gcs.Query{Prefix: prefix}
+	if err := query.SetAttrSelection([]string{"Name", "Size"}); err != nil {
+		return err
+	}
+	it := s.bucket.Objects(ctx, query)
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(attrs.Name, attrs.Size); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *GCSStorage) ListMediaPrefixes(ctx context.Context) (map[string]string, error) {
+	return listMediaPrefixes(ctx, s)
+}
+
+func (s *GCSStorage) ObjectURL(key string) string {
+	if s.publicURL != "" {
+		return s.publicURL + "/" + escapeObjectKey(key)
+	}
+	return "https://storage.googleapis.com/" + s.bucketName + "/" + escapeObjectKey(key)
+}
+
+func (s *GCSStorage) signedURL(method, key, contentType string, expires time.Duration) (string, error) {
+	if expires <= 0 {
+		expires = 15 * time.Minute
+	}
+	return s.bucket.SignedURL(key, &gcs.SignedURLOptions{
+		GoogleAccessID: s.accessID,
+		PrivateKey:     s.privateKey,
+		Method:         method,
+		Expires:        time.Now().Add(expires),
+		ContentType:    contentType,
+		Scheme:         gcs.SigningSchemeV4,
+		Insecure:       s.insecure,
+	})
+}
+
+func (s *GCSStorage) upload(ctx context.Context, key, contentType string, r io.Reader) error {
+	w := s.bucket.Object(key).NewWriter(ctx)
+	w.ContentType = contentType
+	if _, err := io.Copy(w, r); err != nil {
+		_ = w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// multipartTarget checks that uploadID was created for key and returns the
+// attributes of its marker object.
+func (s *GCSStorage) multipartTarget(ctx context.Context, key, uploadID string) (*gcs.ObjectAttrs, error) {
+	if !isMultipartUploadID(uploadID) {
+		return nil, repository.ErrNotFound
+	}
+	marker := s.bucket.Object(gcsMultipartKey(uploadID, "key"))
+	r, err := marker.NewReader(ctx)
+	if err != nil {
+		return nil, gcsError(err)
+	}
+	defer r.Close()
+	storedKey, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if string(storedKey) != key {
+		return nil, repository.ErrNotFound
+	}
+	return marker.Attrs(ctx)
+}
+
+func gcsMultipartKey(uploadID, name string) string {
+	return multipartStagingDir + "/" + uploadID + "/" + name
+}
+
+func gcsPartName(partNumber int32) string {
+	return fmt.Sprintf("%05d", partNumber)
+}
+
+// gcsETag formats an object MD5 the way the XML API returns it in the ETag
+// header of a PUT, which is what clients hand back on completion.
+func gcsETag(md5 []byte) string {
+	return `"` + hex.EncodeToString(md5) + `"`
+}
+
+func gcsError(err error) error {
+	if errors.Is(err, gcs.ErrObjectNotExist) {
+		return repository.ErrNotFound
+	}
+	return err
+}
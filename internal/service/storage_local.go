@@ -0,0 +1,619 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"calixio/internal/repository"
+)
+
+var (
+	ErrInvalidStorageKey       = errors.New("invalid storage key")
+	ErrInvalidStorageSignature = errors.New("invalid or expired storage signature")
+)
+
+const (
+	// LocalStorageRoute is where the API serves local objects and accepts
+	// the uploads that stand in for presigned S3 requests.
+	LocalStorageRoute = "/storage/"
+
+	localTempPrefix = ".upload-"
+	// localContentTypeDir mirrors the object tree with one small file per
+	// object holding the Content-Type it was uploaded with. Files have no
+	// such attribute, and guessing from the extension fails for keys
+	// without one or on hosts without /etc/mime.types.
+	localContentTypeDir = ".content-type"
+)
+
+// LocalStorage keeps objects as files below a root directory, for running
+// the stack without S3. Presigned URLs are API routes carrying an HMAC of
+// the method, key and expiry; they are checked by VerifyRequest.
+type LocalStorage struct {
+	root           string
+	baseURL        string
+	secret         []byte
+	privateOutputs bool
+	clock          func() time.Time
+}
+
+type NewLocalStorageInput struct {
+	Root string
+	// BaseURL is the public address of the API, e.g. http://localhost:8080.
+	BaseURL        string
+	Secret         string
+	PrivateOutputs bool
+}
+
+func NewLocalStorage(in NewLocalStorageInput) (*LocalStorage, error) {
+	if strings.TrimSpace(in.Secret) == "" {
+		return nil, errors.New("local storage signing secret is required")
+	}
+	root, err := filepath.Abs(strings.TrimSpace(in.Root))
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, err
+	}
+	return &LocalStorage{
+		root:           root,
+		baseURL:        strings.TrimRight(strings.TrimSpace(in.BaseURL), "/"),
+		secret:         []byte(in.Secret),
+		privateOutputs: in.PrivateOutputs,
+		clock:          time.Now,
+	}, nil
+}
+
+func (s *LocalStorage) PresignPutObject(ctx context.Context, key, contentType string, expires time.Duration) (string, error) {
+	params := url.Values{}
+	if contentType != "" {
+		params.Set("contentType", contentType)
+	}
+	return s.signedURL("PUT", key, expires, params)
+}
+
+func (s *LocalStorage) PresignGetObject(ctx context.Context, key string, expires time.Duration) (string, error) {
+	return s.signedURL("GET", key, expires, url.Values{})
+}
+
+func (s *LocalStorage) CreateMultipartUpload(ctx context.Context, key, contentType string) (string, error) {
+	if _, err := s.objectPath(key); err != nil {
+		return "", err
+	}
+	uploadID, err := newMultipartUploadID()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(s.root, multipartStagingDir, uploadID)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "key"), []byte(key), 0o644); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "content-type"), []byte(contentType), 0o644); err != nil {
+		return "", err
+	}
+	return uploadID, nil
+}
+
+func (s *LocalStorage) PresignUploadPart(ctx context.Context, key, uploadID string, partNumber int32, expires time.Duration) (string, error) {
+	params := url.Values{}
+	params.Set("uploadId", uploadID)
+	params.Set("partNumber", strconv.Itoa(int(partNumber)))
+	return s.signedURL("PUT", key, expires, params)
+}
+
+func (s *LocalStorage) UploadPart(ctx context.Context, key, uploadID string, partNumber int32, data []byte) (string, error) {
+	return s.WritePart(ctx, key, uploadID, partNumber, bytes.NewReader(data))
+}
+
+// WritePart stores one part of a multipart upload and returns its ETag,
+// the quoted MD5 of the part as S3 reports it.
+func (s *LocalStorage) WritePart(ctx context.Context, key, uploadID string, partNumber int32, r io.Reader) (string, error) {
+	if partNumber < 1 || partNumber > maxMultipartParts {
+		return "", fmt.Errorf("%w: part number %d", ErrInvalidMultipartParts, partNumber)
+	}
+	dir, err := s.multipartDir(key, uploadID)
+	if err != nil {
+		return "", err
+	}
+
+	hash := md5.New()
+	if err := writeLocalFile(filepath.Join(dir, partFileName(partNumber)), io.TeeReader(r, hash)); err != nil {
+		return "", err
+	}
+	etag := `"` + hex.EncodeToString(hash.Sum(nil)) + `"`
+	if err := os.WriteFile(filepath.Join(dir, partFileName(partNumber)+".etag"), []byte(etag), 0o644); err != nil {
+		return "", err
+	}
+	return etag, nil
+}
+
+func (s *LocalStorage) ListParts(ctx context.Context, key, uploadID string) ([]CompletedPart, error) {
+	dir, err := s.multipartDir(key, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			// Completed or aborted since the marker was read.
+			return nil, repository.ErrNotFound
+		}
+		return nil, err
+	}
+
+	parts := make([]CompletedPart, 0, len(entries))
+	for _, entry := range entries {
+		raw, ok := strings.CutSuffix(entry.Name(), ".part")
+		if !ok {
+			continue
+		}
+		partNumber, err := strconv.Atoi(raw)
+		if err != nil {
+			continue
+		}
+		etag, err := os.ReadFile(filepath.Join(dir, entry.Name()+".etag"))
+		if err != nil {
+			// The part is still being written.
+			continue
+		}
+		parts = append(parts, CompletedPart{PartNumber: int32(partNumber), ETag: string(etag)})
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].PartNumber < parts[j].PartNumber })
+	return parts, nil
+}
+
+func (s *LocalStorage) CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []CompletedPart) error {
+	dir, err := s.multipartDir(key, uploadID)
+	if err != nil {
+		return err
+	}
+	target, err := s.objectPath(key)
+	if err != nil {
+		return err
+	}
+	stored, err := s.ListParts(ctx, key, uploadID)
+	if err != nil {
+		return err
+	}
+	etags := make(map[int32]string, len(stored))
+	for _, part := range stored {
+		etags[part.PartNumber] = strings.Trim(part.ETag, `"`)
+	}
+
+	files := make([]io.Reader, 0, len(parts))
+	for i, part := range parts {
+		if i > 0 && part.PartNumber <= parts[i-1].PartNumber {
+			return fmt.Errorf("%w: InvalidPartOrder", ErrInvalidMultipartParts)
+		}
+		if etag, ok := etags[part.PartNumber]; !ok || etag != strings.Trim(part.ETag, `"`) {
+			return fmt.Errorf("%w: InvalidPart", ErrInvalidMultipartParts)
+		}
+		f, err := os.Open(filepath.Join(dir, partFileName(part.PartNumber)))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		files = append(files, f)
+	}
+
+	if err := writeLocalFile(target, io.MultiReader(files...)); err != nil {
+		return err
+	}
+	// Uploads started before content types were recorded have no file.
+	contentType, err := os.ReadFile(filepath.Join(dir, "content-type"))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := s.writeContentType(key, string(contentType)); err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+func (s *LocalStorage) AbortMultipartUpload(ctx context.Context, key, uploadID string) error {
+	dir, err := s.multipartDir(key, uploadID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+func (s *LocalStorage) HeadObject(ctx context.Context, key string) (ObjectHead, error) {
+	_, head, err := s.stat(key)
+	return head, err
+}
+
+// OpenFile opens key for serving; the file is seekable, so callers can hand
+// it to http.ServeContent for range and conditional requests.
+func (s *LocalStorage) OpenFile(key string) (*os.File, ObjectHead, error) {
+	localPath, head, err := s.stat(key)
+	if err != nil {
+		return nil, ObjectHead{}, err
+	}
+	f, err := os.Open(localPath)
+	if err != nil {
+		return nil, ObjectHead{}, err
+	}
+	return f, head, nil
+}
+
+func (s *LocalStorage) OpenObject(ctx context.Context, key, byteRange string) (ObjectStream, error) {
+	f, head, err := s.OpenFile(key)
+	if err != nil {
+		return ObjectStream{}, err
+	}
+	if byteRange == "" {
+		return ObjectStream{ObjectHead: head, Body: f}, nil
+	}
+
+	start, end, ok := parseByteRange(byteRange, head.ContentLength)
+	if !ok {
+		_ = f.Close()
+		return ObjectStream{}, ErrInvalidRange
+	}
+	if _, err := f.Seek(start, io.SeekStart); err != nil {
+		_ = f.Close()
+		return ObjectStream{}, err
+	}
+	size := head.ContentLength
+	head.ContentLength = end - start + 1
+	return ObjectStream{
+		ObjectHead:   head,
+		ContentRange: formatContentRange(start, end, size),
+		Body: struct {
+			io.Reader
+			io.Closer
+		}{io.LimitReader(f, head.ContentLength), f},
+	}, nil
+}
+
+func (s *LocalStorage) DownloadObjectToFile(ctx context.Context, key, filePath, expectedSHA256 string) (string, error) {
+	src, _, err := s.OpenFile(key)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	return writeObjectFile(src, filePath, expectedSHA256)
+}
+
+func (s *LocalStorage) GetObjectBytes(ctx context.Context, key string) ([]byte, error) {
+	localPath, _, err := s.stat(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(localPath)
+}
+
+func (s *LocalStorage) UploadFile(ctx context.Context, key, contentType, filePath string) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = s.WriteObject(ctx, key, contentType, f)
+	return err
+}
+
+func (s *LocalStorage) UploadBytes(ctx context.Context, key, contentType string, data []byte) error {
+	_, err := s.WriteObject(ctx, key, contentType, bytes.NewReader(data))
+	return err
+}
+
+// UploadOutputFile stores a derived object. Local files carry no ACL; whether
+// outputs are readable without a signature is decided by PublicReadable.
+func (s *LocalStorage) UploadOutputFile(ctx context.Context, key, contentType, filePath string) error {
+	return s.UploadFile(ctx, key, contentType, filePath)
+}
+
+func (s *LocalStorage) UploadOutputBytes(ctx context.Context, key, contentType string, data []byte) error {
+	return s.UploadBytes(ctx, key, contentType, data)
+}
+
+// WriteObject replaces key with the contents of r and returns the new ETag.
+// Readers never observe a partially written object. contentType is what
+// HeadObject reports afterwards; "" falls back to the key's extension.
+func (s *LocalStorage) WriteObject(ctx context.Context, key, contentType string, r io.Reader) (string, error) {
+	target, err := s.objectPath(key)
+	if err != nil {
+		return "", err
+	}
+	if err := writeLocalFile(target, r); err != nil {
+		return "", err
+	}
+	if err := s.writeContentType(key, contentType); err != nil {
+		return "", err
+	}
+	_, head, err := s.stat(key)
+	if err != nil {
+		return "", err
+	}
+	return head.ETag, nil
+}
+
+func (s *LocalStorage) DeleteObject(ctx context.Context, key string) error {
+	localPath, err := s.objectPath(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(localPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return s.writeContentType(key, "")
+}
+
+func (s *LocalStorage) DeleteObjectsByPrefix(ctx context.Context, prefix string) error {
+	trimmedPrefix := strings.TrimSpace(prefix)
+	if trimmedPrefix == "" {
+		return errors.New("prefix is required")
+	}
+	if dirKey, ok := strings.CutSuffix(trimmedPrefix, "/"); ok {
+		dir, err := s.objectPath(dirKey)
+		if err != nil {
+			return err
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+		return os.RemoveAll(s.contentTypePath(dir))
+	}
+	return deleteWalkedObjects(ctx, s, trimmedPrefix)
+}
+
+// WalkObjects calls fn for every object key and size under prefix in lexical order,
+// the way S3 lists them.
+func (s *LocalStorage) WalkObjects(ctx context.Context, prefix string, fn func(key string, size int64) error) error {
+	walkRoot := s.root
+	if dir := path.Dir(prefix + "x"); dir != "." {
+		var err error
+		if walkRoot, err = s.objectPath(dir); err != nil {
+			return err
+		}
+	}
+
+	err := filepath.WalkDir(walkRoot, func(localPath string, entry fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if entry.Name() == multipartStagingDir || entry.Name() == localContentTypeDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasPrefix(entry.Name(), localTempPrefix) {
+			return nil
+		}
+		rel, err := filepath.Rel(s.root, localPath)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		return fn(key, info.Size())
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (s *LocalStorage) ListMediaPrefixes(ctx context.Context) (map[string]string, error) {
+	return listMediaPrefixes(ctx, s)
+}
+
+func (s *LocalStorage) ObjectURL(key string) string {
+	return s.baseURL + LocalStorageRoute + escapeObjectKey(key)
+}
+
+// PublicReadable reports whether key may be read without a signature. As
+// with public-read ACLs on S3, that holds for derived outputs unless
+// AWS_S3_PRIVATE_OUTPUTS is set.
+func (s *LocalStorage) PublicReadable(key string) bool {
+	if s.privateOutputs {
+		return false
+	}
+	_, mediaPrefix, ok := extractMediaPrefix(key)
+	if !ok {
+		return false
+	}
+	dir, _, _ := strings.Cut(strings.TrimPrefix(key, mediaPrefix), "/")
+	for _, outputDir := range outputDirs {
+		if dir == outputDir {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifyRequest checks the signature and expiry of a presigned URL. method
+// and contentType come from the request being served.
+func (s *LocalStorage) VerifyRequest(method, key string, query url.Values, contentType string) error {
+	expiresAt, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil || s.clock().Unix() > expiresAt {
+		return ErrInvalidStorageSignature
+	}
+	expected := s.signature(method, key, query)
+	if !hmac.Equal([]byte(query.Get("sig")), []byte(expected)) {
+		return ErrInvalidStorageSignature
+	}
+	if signedType := query.Get("contentType"); signedType != "" && normalizeMime(signedType) != normalizeMime(contentType) {
+		return ErrInvalidStorageSignature
+	}
+	return nil
+}
+
+func (s *LocalStorage) signedURL(method, key string, expires time.Duration, params url.Values) (string, error) {
+	if _, err := s.objectPath(key); err != nil {
+		return "", err
+	}
+	if expires <= 0 {
+		expires = 15 * time.Minute
+	}
+	params.Set("expires", strconv.FormatInt(s.clock().Add(expires).Unix(), 10))
+	params.Set("sig", s.signature(method, key, params))
+	return s.ObjectURL(key) + "?" + params.Encode(), nil
+}
+
+func (s *LocalStorage) signature(method, key string, params url.Values) string {
+	mac := hmac.New(sha256.New, s.secret)
+	for _, field := range []string{
+		method,
+		key,
+		params.Get("expires"),
+		params.Get("contentType"),
+		params.Get("uploadId"),
+		params.Get("partNumber"),
+	} {
+		mac.Write([]byte(field))
+		mac.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// objectPath maps key to a file below the root. Keys that are not already
+// clean, such as ones with ".." or empty segments, are rejected.
+func (s *LocalStorage) objectPath(key string) (string, error) {
+	cleaned := path.Clean("/" + key)[1:]
+	if cleaned == "" || cleaned != key {
+		return "", ErrInvalidStorageKey
+	}
+	for _, reserved := range []string{multipartStagingDir, localContentTypeDir} {
+		if cleaned == reserved || strings.HasPrefix(cleaned, reserved+"/") {
+			return "", ErrInvalidStorageKey
+		}
+	}
+	return filepath.Join(s.root, filepath.FromSlash(cleaned)), nil
+}
+
+func (s *LocalStorage) stat(key string) (string, ObjectHead, error) {
+	localPath, err := s.objectPath(key)
+	if err != nil {
+		return "", ObjectHead{}, err
+	}
+	info, err := os.Stat(localPath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", ObjectHead{}, repository.ErrNotFound
+		}
+		return "", ObjectHead{}, err
+	}
+	if info.IsDir() {
+		return "", ObjectHead{}, repository.ErrNotFound
+	}
+	contentType, err := os.ReadFile(s.contentTypePath(localPath))
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", ObjectHead{}, err
+		}
+		contentType = []byte(mime.TypeByExtension(path.Ext(key)))
+	}
+	return localPath, ObjectHead{
+		ContentLength: info.Size(),
+		ContentType:   string(contentType),
+		ETag:          fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()),
+		LastModified:  info.ModTime(),
+	}, nil
+}
+
+// contentTypePath is where the content type of the object at localPath is
+// kept.
+func (s *LocalStorage) contentTypePath(localPath string) string {
+	rel, _ := filepath.Rel(s.root, localPath)
+	return filepath.Join(s.root, localContentTypeDir, rel)
+}
+
+// writeContentType records contentType for key; "" removes the record.
+func (s *LocalStorage) writeContentType(key, contentType string) error {
+	localPath, err := s.objectPath(key)
+	if err != nil {
+		return err
+	}
+	target := s.contentTypePath(localPath)
+	if contentType == "" {
+		if err := os.Remove(target); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	return writeLocalFile(target, strings.NewReader(contentType))
+}
+
+// multipartDir returns the staging directory of uploadID, which must have
+// been created for key.
+func (s *LocalStorage) multipartDir(key, uploadID string) (string, error) {
+	if !isMultipartUploadID(uploadID) {
+		return "", repository.ErrNotFound
+	}
+	dir := filepath.Join(s.root, multipartStagingDir, uploadID)
+	storedKey, err := os.ReadFile(filepath.Join(dir, "key"))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", repository.ErrNotFound
+		}
+		return "", err
+	}
+	if string(storedKey) != key {
+		return "", repository.ErrNotFound
+	}
+	return dir, nil
+}
+
+func partFileName(partNumber int32) string {
+	return fmt.Sprintf("%05d.part", partNumber)
+}
+
+// writeLocalFile writes through a temporary file in the target directory and
+// renames it into place.
+func writeLocalFile(target string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(target), localTempPrefix+"*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), target)
+}
@@ -0,0 +1,242 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"calixio/internal/repository"
+)
+
+func newTestLocalStorage(t *testing.T, secret string, now time.Time) *LocalStorage {
+	t.Helper()
+	storage, err := NewLocalStorage(NewLocalStorageInput{
+		Root:    t.TempDir(),
+		BaseURL: "http://localhost:8080",
+		Secret:  secret,
+	})
+	if err != nil {
+		t.Fatalf("NewLocalStorage: %v", err)
+	}
+	storage.clock = func() time.Time { return now }
+	return storage
+}
+
+func TestLocalStorageVerifyRequest(t *testing.T) {
+	const key = "users/u1/media/m1/original.mp4"
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	ctx := context.Background()
+
+	signer := newTestLocalStorage(t, "secret", now)
+	getURL, err := signer.PresignGetObject(ctx, key, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	putURL, err := signer.PresignPutObject(ctx, key, "video/mp4", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	partURL, err := signer.PresignUploadPart(ctx, key, strings.Repeat("ab", 16), 3, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		rawURL      string
+		method      string
+		key         string
+		contentType string
+		// edit tampers with the query before it is verified.
+		edit    func(url.Values)
+		at      time.Time
+		secret  string
+		wantErr bool
+	}{
+		{name: "get", rawURL: getURL, method: "GET", key: key},
+		{name: "put", rawURL: putURL, method: "PUT", key: key, contentType: "video/mp4"},
+		{name: "put with equivalent content type", rawURL: putURL, method: "PUT", key: key, contentType: "Video/MP4; codecs=avc1"},
+		{name: "part", rawURL: partURL, method: "PUT", key: key},
+		{name: "at expiry", rawURL: getURL, method: "GET", key: key, at: now.Add(time.Minute)},
+		{name: "expired", rawURL: getURL, method: "GET", key: key, at: now.Add(time.Minute + time.Second), wantErr: true},
+		{name: "method changed", rawURL: getURL, method: "PUT", key: key, wantErr: true},
+		{name: "put used for get", rawURL: putURL, method: "GET", key: key, wantErr: true},
+		{name: "key changed", rawURL: getURL, method: "GET", key: "users/u2/media/m1/original.mp4", wantErr: true},
+		{name: "wrong content type", rawURL: putURL, method: "PUT", key: key, contentType: "text/html", wantErr: true},
+		{name: "missing content type", rawURL: putURL, method: "PUT", key: key, wantErr: true},
+		{name: "other secret", rawURL: getURL, method: "GET", key: key, secret: "other", wantErr: true},
+		{
+			name: "expiry extended", rawURL: getURL, method: "GET", key: key, wantErr: true,
+			edit: func(q url.Values) { q.Set("expires", "9999999999") },
+		},
+		{
+			name: "expiry missing", rawURL: getURL, method: "GET", key: key, wantErr: true,
+			edit: func(q url.Values) { q.Del("expires") },
+		},
+		{
+			name: "signature tampered", rawURL: getURL, method: "GET", key: key, wantErr: true,
+			edit: func(q url.Values) { q.Set("sig", strings.Repeat("0", 64)) },
+		},
+		{
+			name: "signature missing", rawURL: getURL, method: "GET", key: key, wantErr: true,
+			edit: func(q url.Values) { q.Del("sig") },
+		},
+		{
+			name: "signed content type changed", rawURL: putURL, method: "PUT", key: key, contentType: "text/html", wantErr: true,
+			edit: func(q url.Values) { q.Set("contentType", "text/html") },
+		},
+		{
+			name: "signed content type dropped", rawURL: putURL, method: "PUT", key: key, contentType: "text/html", wantErr: true,
+			edit: func(q url.Values) { q.Del("contentType") },
+		},
+		{
+			name: "part number changed", rawURL: partURL, method: "PUT", key: key, wantErr: true,
+			edit: func(q url.Values) { q.Set("partNumber", "4") },
+		},
+		{
+			name: "upload id changed", rawURL: partURL, method: "PUT", key: key, wantErr: true,
+			edit: func(q url.Values) { q.Set("uploadId", strings.Repeat("cd", 16)) },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed, err := url.Parse(tt.rawURL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			query := parsed.Query()
+			if tt.edit != nil {
+				tt.edit(query)
+			}
+			at := now
+			if !tt.at.IsZero() {
+				at = tt.at
+			}
+			secret := "secret"
+			if tt.secret != "" {
+				secret = tt.secret
+			}
+			verifier := newTestLocalStorage(t, secret, at)
+
+			err = verifier.VerifyRequest(tt.method, tt.key, query, tt.contentType)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidStorageSignature) {
+					t.Fatalf("error = %v, want ErrInvalidStorageSignature", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestLocalStorageObjectPath(t *testing.T) {
+	storage := newTestLocalStorage(t, "secret", time.Now())
+	tests := []struct {
+		key     string
+		wantErr bool
+	}{
+		{key: "users/u1/media/m1/original.mp4"},
+		{key: "", wantErr: true},
+		{key: "/users/u1", wantErr: true},
+		{key: "users/../etc/passwd", wantErr: true},
+		{key: "users//u1", wantErr: true},
+		{key: "users/u1/", wantErr: true},
+		{key: multipartStagingDir + "/0123/key", wantErr: true},
+		{key: localContentTypeDir, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			_, err := storage.objectPath(tt.key)
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("objectPath(%q) error = %v, want error %v", tt.key, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLocalStorageListParts(t *testing.T) {
+	const key = "users/u1/media/m1/original.mp4"
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		// finish runs after part 1 has been uploaded.
+		finish    func(storage *LocalStorage, uploadID string) error
+		listKey   string
+		uploadID  string
+		wantParts int
+	}{
+		{name: "staged", wantParts: 1},
+		{
+			name: "completed",
+			finish: func(storage *LocalStorage, uploadID string) error {
+				parts, err := storage.ListParts(ctx, key, uploadID)
+				if err != nil {
+					return err
+				}
+				return storage.CompleteMultipartUpload(ctx, key, uploadID, parts)
+			},
+		},
+		{
+			name: "aborted",
+			finish: func(storage *LocalStorage, uploadID string) error {
+				return storage.AbortMultipartUpload(ctx, key, uploadID)
+			},
+		},
+		{
+			name: "staging dir gone",
+			finish: func(storage *LocalStorage, uploadID string) error {
+				return os.RemoveAll(filepath.Join(storage.root, multipartStagingDir, uploadID))
+			},
+		},
+		{name: "other key", listKey: "users/u1/media/m2/original.mp4"},
+		{name: "unknown upload", uploadID: "0123456789abcdef0123456789abcdef"},
+		{name: "malformed upload id", uploadID: "../m1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := newTestLocalStorage(t, "secret", time.Now())
+			uploadID, err := storage.CreateMultipartUpload(ctx, key, "video/mp4")
+			if err != nil {
+				t.Fatalf("CreateMultipartUpload: %v", err)
+			}
+			if _, err := storage.UploadPart(ctx, key, uploadID, 1, []byte("part")); err != nil {
+				t.Fatalf("UploadPart: %v", err)
+			}
+			if tt.finish != nil {
+				if err := tt.finish(storage, uploadID); err != nil {
+					t.Fatalf("finish: %v", err)
+				}
+			}
+			listKey, listUploadID := key, uploadID
+			if tt.listKey != "" {
+				listKey = tt.listKey
+			}
+			if tt.uploadID != "" {
+				listUploadID = tt.uploadID
+			}
+
+			parts, err := storage.ListParts(ctx, listKey, listUploadID)
+			if tt.wantParts == 0 {
+				if !errors.Is(err, repository.ErrNotFound) {
+					t.Fatalf("ListParts error = %v, want ErrNotFound", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ListParts: %v", err)
+			}
+			if len(parts) != tt.wantParts {
+				t.Fatalf("ListParts returned %d parts, want %d", len(parts), tt.wantParts)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"calixio/internal/repository"
+)
+
+const (
+	storyboardColumns = 10
+	storyboardRows    = 10
+)
+
+// createAndUploadStoryboard renders tiled sprite sheets with one thumbnail
+// every intervalSec and a thumbnails.vtt that maps each time range to its
+// sprite region. Players use it for hover-scrub previews.
+func (s *MediaTranscoderService) createAndUploadStoryboard(ctx context.Context, srcPath, workDir string, media repository.Media, probe mediaProbe) (*string, error) {
+	intervalSec := s.storyboardInterval
+	durationSec := probe.durationSec
+	if intervalSec <= 0 || durationSec <= 0 {
+		return nil, nil
+	}
+
+	thumbWidth := evenDimension(s.storyboardWidth)
+	thumbHeight := evenDimension(thumbWidth * probe.video.height / probe.video.width)
+
+	outDir := filepath.Join(workDir, storyboardDir)
+	if err := os.MkdirAll(outDir, 0o755); err != nil {
+		return nil, err
+	}
+
+	args := []string{
+		"-y",
+		"-hide_banner",
+		"-nostats",
+		"-loglevel", "warning",
+		"-i", srcPath,
+		"-map", "0:v:0",
+		"-vf", fmt.Sprintf("fps=1/%d,scale=%d:%d,tile=%dx%d",
+			intervalSec, thumbWidth, thumbHeight, storyboardColumns, storyboardRows),
+		"-q:v", "5",
+		filepath.Join(outDir, "sprite_%03d.jpg"),
+	}
+	cmd := exec.CommandContext(ctx, s.ffmpegPath, args...)
+	stderr := &tailBuffer{maxBytes: 32 << 10}
+	cmd.Stdout = io.Discard
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return nil, formatFFmpegError("ffmpeg storyboard", err, stderr.String())
+	}
+
+	vtt := buildStoryboardVTT(durationSec, intervalSec, thumbWidth, thumbHeight)
+	if err := os.WriteFile(filepath.Join(outDir, path.Base(storyboardVTTName)), []byte(vtt), 0o644); err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(outDir)
+	if err != nil {
+		return nil, err
+	}
+	prefix := path.Join("users", media.OwnerUserID, "media", media.ID, storyboardDir)
+	for _, entry := range entries {
+		localPath := filepath.Join(outDir, entry.Name())
+		contentType := "image/jpeg"
+		if strings.HasSuffix(entry.Name(), ".vtt") {
+			contentType = "text/vtt"
+		}
+		targetKey := path.Join(prefix, entry.Name())
+		if err := s.withRetry(ctx, "upload storyboard", media.ID, func() error {
+			return s.storage.UploadOutputFile(ctx, targetKey, contentType, localPath)
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	storyboardURL := s.storage.ObjectURL(path.Join(prefix, path.Base(storyboardVTTName)))
+	return &storyboardURL, nil
+}
+
+func buildStoryboardVTT(durationSec float64, intervalSec, thumbWidth, thumbHeight int) string {
+	perSprite := storyboardColumns * storyboardRows
+	count := int(math.Ceil(durationSec / float64(intervalSec)))
+
+	var b strings.Builder
+	b.WriteString("WEBVTT\n")
+	for i := 0; i < count; i++ {
+		start := float64(i * intervalSec)
+		end := math.Min(float64((i+1)*intervalSec), durationSec)
+		tile := i % perSprite
+		x := (tile % storyboardColumns) * thumbWidth
+		y := (tile / storyboardColumns) * thumbHeight
+		// ffmpeg numbers image sequences from 1.
+		fmt.Fprintf(&b, "\n%s --> %s\nsprite_%03d.jpg#xywh=%d,%d,%d,%d\n",
+			formatVTTTimestamp(start), formatVTTTimestamp(end), i/perSprite+1, x, y, thumbWidth, thumbHeight)
+	}
+	return b.String()
+}
+
+func formatVTTTimestamp(sec float64) string {
+	d := time.Duration(math.Round(sec*1000)) * time.Millisecond
+	hours := int(d / time.Hour)
+	minutes := int(d % time.Hour / time.Minute)
+	seconds := int(d % time.Minute / time.Second)
+	millis := int(d % time.Second / time.Millisecond)
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", hours, minutes, seconds, millis)
+}
@@ -0,0 +1,312 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"calixio/internal/repository"
+)
+
+var errInvalidSubtitleFile = errors.New("invalid subtitle file")
+
+const (
+	subtitleFormatSRT = "srt"
+	subtitleFormatVTT = "vtt"
+	subtitleFormatASS = "ass"
+
+	subtitleDir     = "subs"
+	subtitleGroupID = "subs"
+)
+
+var (
+	srtTimingPattern   = regexp.MustCompile(`^(\d{1,2}:\d{2}:\d{2}[,.]\d{1,3})\s*-->\s*(\d{1,2}:\d{2}:\d{2}[,.]\d{1,3})`)
+	assOverridePattern = regexp.MustCompile(`\{[^}]*\}`)
+	fontTagPattern     = regexp.MustCompile(`(?i)</?font[^>]*>`)
+	streamInfPattern   = regexp.MustCompile(`(?m)^#EXT-X-STREAM-INF:(.*)$`)
+)
+
+type subtitleCue struct {
+	start float64
+	end   float64
+	text  string
+}
+
+func subtitlePlaylistName(subtitleID string) string {
+	return path.Join(subtitleDir, subtitleID+".m3u8")
+}
+
+func subtitleVTTName(subtitleID string) string {
+	return path.Join(subtitleDir, subtitleID+".vtt")
+}
+
+// detectSubtitleFormat prefers the explicit format and falls back to the
+// file extension. SSA is parsed the same way as ASS.
+func detectSubtitleFormat(format, fileName string) (string, bool) {
+	candidate := strings.ToLower(strings.TrimSpace(format))
+	if candidate == "" {
+		candidate = strings.TrimPrefix(strings.ToLower(path.Ext(strings.TrimSpace(fileName))), ".")
+	}
+	switch candidate {
+	case subtitleFormatSRT, subtitleFormatVTT, subtitleFormatASS:
+		return candidate, true
+	case "ssa":
+		return subtitleFormatASS, true
+	case "webvtt":
+		return subtitleFormatVTT, true
+	default:
+		return "", false
+	}
+}
+
+// convertSubtitleToWebVTT returns the WebVTT document and the end time of
+// its last cue.
+func convertSubtitleToWebVTT(data []byte, format string) (string, float64, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	if !utf8.Valid(data) {
+		return "", 0, fmt.Errorf("%w: subtitles must be utf-8", errInvalidSubtitleFile)
+	}
+	text := strings.ReplaceAll(strings.ReplaceAll(string(data), "\r\n", "\n"), "\r", "\n")
+
+	var (
+		cues []subtitleCue
+		err  error
+	)
+	switch format {
+	case subtitleFormatSRT:
+		cues, err = parseSRT(text)
+	case subtitleFormatVTT:
+		return normalizeWebVTT(text)
+	case subtitleFormatASS:
+		cues, err = parseASS(text)
+	default:
+		return "", 0, fmt.Errorf("%w: unsupported format %q", errInvalidSubtitleFile, format)
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	if len(cues) == 0 {
+		return "", 0, fmt.Errorf("%w: no cues", errInvalidSubtitleFile)
+	}
+
+	var b strings.Builder
+	b.WriteString("WEBVTT\n")
+	lastEnd := 0.0
+	for _, cue := range cues {
+		fmt.Fprintf(&b, "\n%s --> %s\n%s\n", formatVTTTimestamp(cue.start), formatVTTTimestamp(cue.end), cue.text)
+		lastEnd = math.Max(lastEnd, cue.end)
+	}
+	return b.String(), lastEnd, nil
+}
+
+func parseSRT(text string) ([]subtitleCue, error) {
+	cues := make([]subtitleCue, 0)
+	for _, block := range strings.Split(text, "\n\n") {
+		lines := strings.Split(strings.Trim(block, "\n"), "\n")
+		for len(lines) > 0 && !srtTimingPattern.MatchString(strings.TrimSpace(lines[0])) {
+			lines = lines[1:]
+		}
+		if len(lines) == 0 {
+			continue
+		}
+
+		match := srtTimingPattern.FindStringSubmatch(strings.TrimSpace(lines[0]))
+		start, err := parseSubtitleTimestamp(match[1])
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseSubtitleTimestamp(match[2])
+		if err != nil {
+			return nil, err
+		}
+		body := strings.TrimSpace(strings.Join(lines[1:], "\n"))
+		body = assOverridePattern.ReplaceAllString(body, "")
+		body = fontTagPattern.ReplaceAllString(body, "")
+		if body == "" || end <= start {
+			continue
+		}
+		cues = append(cues, subtitleCue{start: start, end: end, text: strings.ReplaceAll(body, "-->", "->")})
+	}
+	return cues, nil
+}
+
+// parseASS reads the Dialogue lines of the [Events] section. Styling and
+// positioning are dropped; only the text survives.
+func parseASS(text string) ([]subtitleCue, error) {
+	cues := make([]subtitleCue, 0)
+	inEvents, sawEvents := false, false
+	startIdx, endIdx, textIdx, fieldCount := 1, 2, 9, 10
+	for _, rawLine := range strings.Split(text, "\n") {
+		line := strings.TrimSpace(rawLine)
+		if strings.HasPrefix(line, "[") {
+			inEvents = strings.EqualFold(line, "[Events]")
+			sawEvents = sawEvents || inEvents
+			continue
+		}
+		if !inEvents {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "format":
+			fields := strings.Split(value, ",")
+			fieldCount = len(fields)
+			for i, field := range fields {
+				switch strings.ToLower(strings.TrimSpace(field)) {
+				case "start":
+					startIdx = i
+				case "end":
+					endIdx = i
+				case "text":
+					textIdx = i
+				}
+			}
+		case "dialogue":
+			fields := strings.SplitN(value, ",", fieldCount)
+			if len(fields) != fieldCount || textIdx >= len(fields) {
+				continue
+			}
+			start, err := parseSubtitleTimestamp(strings.TrimSpace(fields[startIdx]))
+			if err != nil {
+				return nil, err
+			}
+			end, err := parseSubtitleTimestamp(strings.TrimSpace(fields[endIdx]))
+			if err != nil {
+				return nil, err
+			}
+			body := assOverridePattern.ReplaceAllString(fields[textIdx], "")
+			body = strings.NewReplacer(`\N`, "\n", `\n`, "\n", `\h`, " ").Replace(body)
+			body = escapeVTTText(strings.TrimSpace(body))
+			if body == "" || end <= start {
+				continue
+			}
+			cues = append(cues, subtitleCue{start: start, end: end, text: body})
+		}
+	}
+	if !sawEvents {
+		return nil, fmt.Errorf("%w: missing [Events] section", errInvalidSubtitleFile)
+	}
+	return cues, nil
+}
+
+// normalizeWebVTT checks the header and finds the last cue end so the
+// subtitle playlist gets a sensible duration.
+func normalizeWebVTT(text string) (string, float64, error) {
+	if !strings.HasPrefix(text, "WEBVTT") {
+		return "", 0, fmt.Errorf("%w: missing WEBVTT header", errInvalidSubtitleFile)
+	}
+	lastEnd := 0.0
+	for _, line := range strings.Split(text, "\n") {
+		start, end, ok := strings.Cut(line, "-->")
+		if !ok {
+			continue
+		}
+		endFields := strings.Fields(end)
+		if len(endFields) == 0 {
+			continue
+		}
+		if _, err := parseSubtitleTimestamp(strings.TrimSpace(start)); err != nil {
+			return "", 0, err
+		}
+		endSec, err := parseSubtitleTimestamp(endFields[0])
+		if err != nil {
+			return "", 0, err
+		}
+		lastEnd = math.Max(lastEnd, endSec)
+	}
+	if lastEnd == 0 {
+		return "", 0, fmt.Errorf("%w: no cues", errInvalidSubtitleFile)
+	}
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	return text, lastEnd, nil
+}
+
+// parseSubtitleTimestamp accepts SRT (00:00:01,500), WebVTT (00:01.500) and
+// ASS (0:00:01.50) timestamps.
+func parseSubtitleTimestamp(raw string) (float64, error) {
+	raw = strings.Replace(strings.TrimSpace(raw), ",", ".", 1)
+	parts := strings.Split(raw, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("%w: bad timestamp %q", errInvalidSubtitleFile, raw)
+	}
+	total := 0.0
+	for i, part := range parts {
+		value, err := strconv.ParseFloat(part, 64)
+		if err != nil || value < 0 || (i < len(parts)-1 && strings.Contains(part, ".")) {
+			return 0, fmt.Errorf("%w: bad timestamp %q", errInvalidSubtitleFile, raw)
+		}
+		total = total*60 + value
+	}
+	return total, nil
+}
+
+func escapeVTTText(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+}
+
+// buildSubtitlePlaylist wraps a single WebVTT file in the media playlist HLS
+// requires for a SUBTITLES rendition.
+func buildSubtitlePlaylist(vttName string, durationSec float64) string {
+	target := int(math.Ceil(durationSec))
+	if target < 1 {
+		target = 1
+	}
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	b.WriteString("#EXT-X-VERSION:3\n")
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", target)
+	b.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n")
+	b.WriteString("#EXT-X-PLAYLIST-TYPE:VOD\n")
+	fmt.Fprintf(&b, "#EXTINF:%.3f,\n", durationSec)
+	b.WriteString(vttName + "\n")
+	b.WriteString("#EXT-X-ENDLIST\n")
+	return b.String()
+}
+
+// withSubtitleRenditions adds one EXT-X-MEDIA TYPE=SUBTITLES entry per
+// subtitle and points every variant at the group. Subtitles live in the
+// database, so the stored master playlist never has to be rewritten.
+func withSubtitleRenditions(master string, subtitles []repository.MediaSubtitle) string {
+	if len(subtitles) == 0 || !strings.Contains(master, "#EXT-X-STREAM-INF:") {
+		return master
+	}
+
+	var media strings.Builder
+	for _, subtitle := range subtitles {
+		isDefault := "NO"
+		if subtitle.IsDefault {
+			isDefault = "YES"
+		}
+		fmt.Fprintf(&media, "#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID=\"%s\",NAME=\"%s\"", subtitleGroupID, hlsQuotedValue(subtitle.Label))
+		if language := trackLanguage(subtitle.Language); language != "" {
+			fmt.Fprintf(&media, ",LANGUAGE=\"%s\"", hlsQuotedValue(language))
+		}
+		fmt.Fprintf(&media, ",DEFAULT=%s,AUTOSELECT=YES,URI=\"%s\"\n", isDefault, subtitlePlaylistName(subtitle.ID))
+	}
+
+	first := streamInfPattern.FindStringIndex(master)
+	out := master[:first[0]] + media.String() + master[first[0]:]
+	return streamInfPattern.ReplaceAllStringFunc(out, func(line string) string {
+		if strings.Contains(line, "SUBTITLES=") {
+			return line
+		}
+		return line + `,SUBTITLES="` + subtitleGroupID + `"`
+	})
+}
+
+// hlsQuotedValue strips characters a quoted-string attribute cannot hold.
+func hlsQuotedValue(v string) string {
+	return strings.NewReplacer(`"`, "'", "\n", " ", "\r", " ").Replace(v)
+}
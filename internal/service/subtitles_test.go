@@ -0,0 +1,178 @@
+package service
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestConvertSubtitleToWebVTT(t *testing.T) {
+	tests := []struct {
+		name        string
+		format      string
+		data        string
+		want        string
+		wantLastEnd float64
+		wantErr     bool
+	}{
+		{
+			name:        "srt with bom and crlf",
+			format:      subtitleFormatSRT,
+			data:        "\xef\xbb\xbf1\r\n00:00:01,500 --> 00:00:03,000\r\nHello\r\n\r\n2\r\n00:00:04,000 --> 00:00:05,250\r\nWorld\r\nagain\r\n",
+			want:        "WEBVTT\n\n00:00:01.500 --> 00:00:03.000\nHello\n\n00:00:04.000 --> 00:00:05.250\nWorld\nagain\n",
+			wantLastEnd: 5.25,
+		},
+		{
+			name:        "srt strips font and override tags",
+			format:      subtitleFormatSRT,
+			data:        "1\n00:00:01,000 --> 00:00:02,000\n<font color=\"red\">{\\an8}Hi --> <i>there</i></font>\n",
+			want:        "WEBVTT\n\n00:00:01.000 --> 00:00:02.000\nHi -> <i>there</i>\n",
+			wantLastEnd: 2,
+		},
+		{
+			name:        "srt without cue numbers and short hours",
+			format:      subtitleFormatSRT,
+			data:        "0:00:01.000 --> 0:00:02.5\nfirst\n\n\n\n0:01:00,000-->0:01:01,000\nsecond\n",
+			want:        "WEBVTT\n\n00:00:01.000 --> 00:00:02.500\nfirst\n\n00:01:00.000 --> 00:01:01.000\nsecond\n",
+			wantLastEnd: 61,
+		},
+		{
+			name:        "srt skips empty and reversed cues",
+			format:      subtitleFormatSRT,
+			data:        "1\n00:00:01,000 --> 00:00:02,000\n\n2\n00:00:05,000 --> 00:00:04,000\nreversed\n\n3\n00:00:06,000 --> 00:00:07,000\nkept\n",
+			want:        "WEBVTT\n\n00:00:06.000 --> 00:00:07.000\nkept\n",
+			wantLastEnd: 7,
+		},
+		{
+			name:        "srt last end is the latest cue",
+			format:      subtitleFormatSRT,
+			data:        "00:00:01,000 --> 00:00:09,000\nlong\n\n00:00:02,000 --> 00:00:03,000\nshort\n",
+			want:        "WEBVTT\n\n00:00:01.000 --> 00:00:09.000\nlong\n\n00:00:02.000 --> 00:00:03.000\nshort\n",
+			wantLastEnd: 9,
+		},
+		{
+			name:    "srt without cues",
+			format:  subtitleFormatSRT,
+			data:    "just some text\n",
+			wantErr: true,
+		},
+		{
+			name:    "not utf-8",
+			format:  subtitleFormatSRT,
+			data:    "1\n00:00:01,000 --> 00:00:02,000\n\xff\xfe\n",
+			wantErr: true,
+		},
+		{
+			name:   "ass",
+			format: subtitleFormatASS,
+			data: "[Script Info]\nTitle: test\n\n[V4+ Styles]\nFormat: Name, Fontname\nStyle: Default,Arial\n\n" +
+				"[Events]\nFormat: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text\n" +
+				"Dialogue: 0,0:00:01.50,0:00:02.00,Default,,0,0,0,,{\\i1}Hello, world\\Nsecond & <line>\n" +
+				"Comment: 0,0:00:03.00,0:00:04.00,Default,,0,0,0,,ignored\n" +
+				"Dialogue: 0,0:00:05.00,0:00:04.00,Default,,0,0,0,,reversed\n" +
+				"Dialogue: 0,0:00:06.00,0:00:07.00,Default,,0,0,0,,{\\pos(1,2)}\n",
+			want:        "WEBVTT\n\n00:00:01.500 --> 00:00:02.000\nHello, world\nsecond &amp; &lt;line&gt;\n",
+			wantLastEnd: 2,
+		},
+		{
+			name:        "ass with a custom field order",
+			format:      subtitleFormatASS,
+			data:        "[events]\nFormat: Start, End, Text\nDialogue: 0:00:00.00,0:00:01.00,a, b\\hc\n",
+			want:        "WEBVTT\n\n00:00:00.000 --> 00:00:01.000\na, b c\n",
+			wantLastEnd: 1,
+		},
+		{
+			name:    "ass without events",
+			format:  subtitleFormatASS,
+			data:    "[Script Info]\nTitle: test\n",
+			wantErr: true,
+		},
+		{
+			name:    "ass without dialogue",
+			format:  subtitleFormatASS,
+			data:    "[Events]\nFormat: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text\n",
+			wantErr: true,
+		},
+		{
+			name:    "ass with a bad timestamp",
+			format:  subtitleFormatASS,
+			data:    "[Events]\nFormat: Start, End, Text\nDialogue: soon,0:00:01.00,text\n",
+			wantErr: true,
+		},
+		{
+			name:        "vtt is kept as is",
+			format:      subtitleFormatVTT,
+			data:        "WEBVTT\n\n00:01.000 --> 00:02.000 align:start\nHi\n\n00:00:03.000 --> 00:00:04.500\nThere",
+			want:        "WEBVTT\n\n00:01.000 --> 00:02.000 align:start\nHi\n\n00:00:03.000 --> 00:00:04.500\nThere\n",
+			wantLastEnd: 4.5,
+		},
+		{
+			name:    "vtt without header",
+			format:  subtitleFormatVTT,
+			data:    "00:01.000 --> 00:02.000\nHi\n",
+			wantErr: true,
+		},
+		{
+			name:    "vtt without cues",
+			format:  subtitleFormatVTT,
+			data:    "WEBVTT\n",
+			wantErr: true,
+		},
+		{
+			name:    "unsupported format",
+			format:  "sub",
+			data:    "{1}{2}Hi\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, lastEnd, err := convertSubtitleToWebVTT([]byte(tt.data), tt.format)
+			if tt.wantErr {
+				if !errors.Is(err, errInvalidSubtitleFile) {
+					t.Fatalf("error = %v, want errInvalidSubtitleFile", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("document = %q, want %q", got, tt.want)
+			}
+			if lastEnd != tt.wantLastEnd {
+				t.Fatalf("last end = %v, want %v", lastEnd, tt.wantLastEnd)
+			}
+		})
+	}
+}
+
+func TestParseSubtitleTimestamp(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    float64
+		wantErr bool
+	}{
+		{raw: "00:00:01,500", want: 1.5},
+		{raw: "01:02:03.250", want: 3723.25},
+		{raw: "00:01.500", want: 1.5},
+		{raw: "0:00:01.50", want: 1.5},
+		{raw: "1.5", wantErr: true},
+		{raw: "00:00.5:01", wantErr: true},
+		{raw: "00:-01:00", wantErr: true},
+		{raw: "1:2:3:4", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			got, err := parseSubtitleTimestamp(tt.raw)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseSubtitleTimestamp(%q) = %v, want error", tt.raw, got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("parseSubtitleTimestamp(%q) = %v, %v; want %v", tt.raw, got, err, tt.want)
+			}
+		})
+	}
+}
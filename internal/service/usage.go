@@ -0,0 +1,198 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"calixio/internal/repository"
+
+	"go.uber.org/zap"
+)
+
+var (
+	ErrStorageQuotaExceeded    = errors.New("storage quota exceeded")
+	ErrMediaCountQuotaExceeded = errors.New("media count quota exceeded")
+	ErrDurationQuotaExceeded   = errors.New("media duration quota exceeded")
+)
+
+// UsageService enforces per-user quotas and keeps the stored sizes on media
+// rows in line with the bucket. Limits come from the user's storage plan
+// unless the users row overrides them.
+type UsageService struct {
+	usageRepo  repository.UsageRepository
+	mediaRepo  repository.MediaRepository
+	storage    Storage
+	logger     *zap.Logger
+	runTimeout time.Duration
+}
+
+type NewUsageServiceInput struct {
+	UsageRepo  repository.UsageRepository
+	MediaRepo  repository.MediaRepository
+	Storage    Storage
+	Logger     *zap.Logger
+	RunTimeout time.Duration
+}
+
+func NewUsageService(in NewUsageServiceInput) *UsageService {
+	logger := in.Logger
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+
+	runTimeout := in.RunTimeout
+	if runTimeout <= 0 {
+		runTimeout = 30 * time.Minute
+	}
+
+	return &UsageService{
+		usageRepo:  in.UsageRepo,
+		mediaRepo:  in.MediaRepo,
+		storage:    in.Storage,
+		logger:     logger,
+		runTimeout: runTimeout,
+	}
+}
+
+type UsageReport struct {
+	Usage repository.UserUsage
+	Quota repository.UserQuota
+}
+
+func (s *UsageService) GetUsage(ctx context.Context, userID string) (UsageReport, error) {
+	if strings.TrimSpace(userID) == "" {
+		return UsageReport{}, ErrInvalidUploadInput
+	}
+	quota, err := s.usageRepo.GetQuota(ctx, userID)
+	if err != nil {
+		return UsageReport{}, err
+	}
+	usage, err := s.usageRepo.GetUsage(ctx, userID, "")
+	if err != nil {
+		return UsageReport{}, err
+	}
+	return UsageReport{Usage: usage, Quota: quota}, nil
+}
+
+// CheckQuota reports whether a media of sizeBytes fits into the library of
+// userID. excludeMediaID names the media being checked once it has a row,
+// so its reservation is not counted twice. Duration is only known after
+// transcoding, so that limit rejects new media once it is already reached.
+func (s *UsageService) CheckQuota(ctx context.Context, userID, excludeMediaID string, sizeBytes int64) error {
+	quota, err := s.usageRepo.GetQuota(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if quota.MaxStorageBytes == nil && quota.MaxMediaCount == nil && quota.MaxDurationSec == nil {
+		return nil
+	}
+
+	usage, err := s.usageRepo.GetUsage(ctx, userID, excludeMediaID)
+	if err != nil {
+		return err
+	}
+	if quota.MaxMediaCount != nil && usage.MediaCount >= *quota.MaxMediaCount {
+		return ErrMediaCountQuotaExceeded
+	}
+	if quota.MaxStorageBytes != nil && usage.StorageBytes+sizeBytes > *quota.MaxStorageBytes {
+		return ErrStorageQuotaExceeded
+	}
+	if quota.MaxDurationSec != nil && usage.DurationSec >= *quota.MaxDurationSec {
+		return ErrDurationQuotaExceeded
+	}
+	return nil
+}
+
+// Reconcile recomputes the original and output sizes of every media from a
+// storage listing and fixes the rows that drifted, e.g. after a poster or
+// subtitle upload or a transcoding attempt that failed halfway.
+func (s *UsageService) Reconcile(ctx context.Context) (scannedCount, updatedCount int, err error) {
+	sizes, err := storedMediaBytes(ctx, s.storage, "users/")
+	if err != nil {
+		return 0, 0, err
+	}
+
+	ids := make([]string, 0, len(sizes))
+	for id := range sizes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	scannedCount = len(ids)
+
+	for _, id := range ids {
+		stored := sizes[id]
+		changed, err := s.mediaRepo.UpdateStoredBytes(ctx, id, stored.original, stored.output)
+		if err != nil {
+			return scannedCount, updatedCount, err
+		}
+		if changed {
+			updatedCount++
+			s.logger.Info("media usage reconciled",
+				zap.String("media_id", id),
+				zap.Int64("original_bytes", stored.original),
+				zap.Int64("output_bytes", stored.output),
+			)
+		}
+	}
+
+	return scannedCount, updatedCount, nil
+}
+
+func (s *UsageService) RunDaily() {
+	go func() {
+		ticker := time.NewTicker(24 * time.Hour)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			ctx, cancel := context.WithTimeout(context.Background(), s.runTimeout)
+			scannedCount, updatedCount, err := s.Reconcile(ctx)
+			cancel()
+
+			if err != nil {
+				s.logger.Error("media usage reconcile failed", zap.Error(err))
+				continue
+			}
+
+			s.logger.Info(
+				"media usage reconcile finished",
+				zap.Int("scanned_media", scannedCount),
+				zap.Int("updated_media", updatedCount),
+			)
+		}
+	}()
+}
+
+type mediaStoredBytes struct {
+	original int64
+	output   int64
+}
+
+// storedMediaBytes sums object sizes under prefix per media ID, splitting the
+// uploaded original from everything derived from it.
+func storedMediaBytes(ctx context.Context, storage Storage, prefix string) (map[string]*mediaStoredBytes, error) {
+	sizes := make(map[string]*mediaStoredBytes)
+	err := storage.WalkObjects(ctx, prefix, func(key string, size int64) error {
+		mediaID, mediaPrefix, ok := extractMediaPrefix(key)
+		if !ok {
+			return nil
+		}
+		stored, exists := sizes[mediaID]
+		if !exists {
+			stored = &mediaStoredBytes{}
+			sizes[mediaID] = stored
+		}
+		if strings.HasPrefix(key, mediaPrefix+"original/") {
+			stored.original += size
+		} else {
+			stored.output += size
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sizes, nil
+}
@@ -0,0 +1,117 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"calixio/internal/repository"
+)
+
+type usageTestMedia struct {
+	id          string
+	bytes       int64
+	durationSec int64
+}
+
+// usageTestRepo sums media the way PostgresUsageRepository.GetUsage does.
+type usageTestRepo struct {
+	quota      repository.UserQuota
+	quotaErr   error
+	media      []usageTestMedia
+	usageCalls int
+}
+
+func (r *usageTestRepo) GetQuota(context.Context, string) (repository.UserQuota, error) {
+	return r.quota, r.quotaErr
+}
+
+func (r *usageTestRepo) GetUsage(_ context.Context, _, excludeMediaID string) (repository.UserUsage, error) {
+	r.usageCalls++
+	var out repository.UserUsage
+	for _, media := range r.media {
+		if media.id == excludeMediaID {
+			continue
+		}
+		out.StorageBytes += media.bytes
+		out.MediaCount++
+		out.DurationSec += media.durationSec
+	}
+	return out, nil
+}
+
+func TestCheckQuota(t *testing.T) {
+	limit64 := func(v int64) *int64 { return &v }
+	countLimit := func(v int) *int { return &v }
+	library := []usageTestMedia{
+		{id: "media-1", bytes: 600, durationSec: 1800},
+		{id: "media-2", bytes: 300, durationSec: 1200},
+	}
+	// media-3 is the upload being checked; its row already reserves its
+	// declared size.
+	withUpload := append(append([]usageTestMedia(nil), library...), usageTestMedia{id: "media-3", bytes: 100})
+
+	tests := []struct {
+		name        string
+		quota       repository.UserQuota
+		quotaErr    error
+		media       []usageTestMedia
+		excludeID   string
+		sizeBytes   int64
+		wantErr     error
+		wantNoUsage bool
+	}{
+		{name: "no limits", media: library, sizeBytes: 1 << 40, wantNoUsage: true},
+		{name: "storage fits exactly", quota: repository.UserQuota{MaxStorageBytes: limit64(1000)}, media: library, sizeBytes: 100},
+		{name: "storage exceeded", quota: repository.UserQuota{MaxStorageBytes: limit64(1000)}, media: library, sizeBytes: 101, wantErr: ErrStorageQuotaExceeded},
+		{
+			name:      "own reservation is not counted twice",
+			quota:     repository.UserQuota{MaxStorageBytes: limit64(1000)},
+			media:     withUpload,
+			excludeID: "media-3",
+			sizeBytes: 100,
+		},
+		{
+			name:      "own reservation counted without exclusion",
+			quota:     repository.UserQuota{MaxStorageBytes: limit64(1000)},
+			media:     withUpload,
+			sizeBytes: 100,
+			wantErr:   ErrStorageQuotaExceeded,
+		},
+		{name: "count below limit", quota: repository.UserQuota{MaxMediaCount: countLimit(3)}, media: library},
+		{name: "count reached", quota: repository.UserQuota{MaxMediaCount: countLimit(2)}, media: library, wantErr: ErrMediaCountQuotaExceeded},
+		{
+			name:      "count reached by the media itself",
+			quota:     repository.UserQuota{MaxMediaCount: countLimit(3)},
+			media:     withUpload,
+			excludeID: "media-3",
+		},
+		{name: "duration below limit", quota: repository.UserQuota{MaxDurationSec: limit64(3001)}, media: library},
+		{name: "duration reached", quota: repository.UserQuota{MaxDurationSec: limit64(3000)}, media: library, wantErr: ErrDurationQuotaExceeded},
+		{
+			name:    "count is checked first",
+			quota:   repository.UserQuota{MaxStorageBytes: limit64(1), MaxMediaCount: countLimit(1), MaxDurationSec: limit64(1)},
+			media:   library,
+			wantErr: ErrMediaCountQuotaExceeded,
+		},
+		{name: "unknown user", quotaErr: repository.ErrNotFound, wantErr: repository.ErrNotFound, wantNoUsage: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &usageTestRepo{quota: tt.quota, quotaErr: tt.quotaErr, media: tt.media}
+			svc := NewUsageService(NewUsageServiceInput{UsageRepo: repo})
+
+			err := svc.CheckQuota(context.Background(), "user-1", tt.excludeID, tt.sizeBytes)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("error = %v, want %v", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantNoUsage && repo.usageCalls != 0 {
+				t.Fatalf("usage read %d times, want none", repo.usageCalls)
+			}
+		})
+	}
+}
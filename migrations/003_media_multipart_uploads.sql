@@ -0,0 +1,10 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS media_uploads (
+  media_id TEXT PRIMARY KEY REFERENCES media(id) ON DELETE CASCADE,
+  upload_id TEXT NOT NULL,
+  part_size_bytes BIGINT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS media_uploads;
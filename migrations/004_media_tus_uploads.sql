@@ -0,0 +1,8 @@
+-- +goose Up
+ALTER TABLE media_uploads
+  ADD COLUMN IF NOT EXISTS protocol TEXT NOT NULL DEFAULT 'multipart',
+  ADD COLUMN IF NOT EXISTS offset_bytes BIGINT NOT NULL DEFAULT 0;
+
+-- +goose Down
+ALTER TABLE media_uploads DROP COLUMN IF EXISTS offset_bytes;
+ALTER TABLE media_uploads DROP COLUMN IF EXISTS protocol;
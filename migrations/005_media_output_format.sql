@@ -0,0 +1,6 @@
+-- +goose Up
+ALTER TABLE media
+  ADD COLUMN IF NOT EXISTS output_format TEXT NOT NULL DEFAULT 'hls_ts';
+
+-- +goose Down
+ALTER TABLE media DROP COLUMN IF EXISTS output_format;
@@ -0,0 +1,32 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS transcode_jobs (
+  id BIGSERIAL PRIMARY KEY,
+  media_id TEXT NOT NULL REFERENCES media(id) ON DELETE CASCADE,
+  status TEXT NOT NULL DEFAULT 'queued',
+  attempts INT NOT NULL DEFAULT 0,
+  max_attempts INT NOT NULL DEFAULT 3,
+  run_after TIMESTAMPTZ NOT NULL DEFAULT now(),
+  locked_by TEXT,
+  lease_expires_at TIMESTAMPTZ,
+  last_error TEXT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS transcode_jobs_active_media_idx
+  ON transcode_jobs(media_id) WHERE status IN ('queued', 'running');
+CREATE INDEX IF NOT EXISTS transcode_jobs_queued_idx
+  ON transcode_jobs(run_after, id) WHERE status = 'queued';
+CREATE INDEX IF NOT EXISTS transcode_jobs_lease_idx
+  ON transcode_jobs(lease_expires_at) WHERE status = 'running';
+
+-- Jobs held by the old in-memory queue are gone; pick their media up again.
+INSERT INTO transcode_jobs (media_id)
+SELECT id FROM media
+WHERE status = 'processing' AND deleted_at IS NULL;
+
+-- +goose Down
+DROP INDEX IF EXISTS transcode_jobs_lease_idx;
+DROP INDEX IF EXISTS transcode_jobs_queued_idx;
+DROP INDEX IF EXISTS transcode_jobs_active_media_idx;
+DROP TABLE IF EXISTS transcode_jobs;
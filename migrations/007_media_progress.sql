@@ -0,0 +1,13 @@
+-- +goose Up
+ALTER TABLE media
+  ADD COLUMN IF NOT EXISTS progress_stage TEXT,
+  ADD COLUMN IF NOT EXISTS progress_percent DOUBLE PRECISION,
+  ADD COLUMN IF NOT EXISTS progress_eta_sec INT,
+  ADD COLUMN IF NOT EXISTS progress_updated_at TIMESTAMPTZ;
+
+-- +goose Down
+ALTER TABLE media
+  DROP COLUMN IF EXISTS progress_updated_at,
+  DROP COLUMN IF EXISTS progress_eta_sec,
+  DROP COLUMN IF EXISTS progress_percent,
+  DROP COLUMN IF EXISTS progress_stage;
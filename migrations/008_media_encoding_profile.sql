@@ -0,0 +1,5 @@
+-- +goose Up
+ALTER TABLE media ADD COLUMN IF NOT EXISTS encoding_profile TEXT;
+
+-- +goose Down
+ALTER TABLE media DROP COLUMN IF EXISTS encoding_profile;
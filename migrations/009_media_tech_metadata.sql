@@ -0,0 +1,14 @@
+-- +goose Up
+ALTER TABLE media
+  ADD COLUMN IF NOT EXISTS width INT,
+  ADD COLUMN IF NOT EXISTS height INT,
+  ADD COLUMN IF NOT EXISTS tech_metadata JSONB;
+
+CREATE INDEX IF NOT EXISTS media_owner_height_idx ON media(owner_user_id, height) WHERE deleted_at IS NULL;
+
+-- +goose Down
+DROP INDEX IF EXISTS media_owner_height_idx;
+ALTER TABLE media
+  DROP COLUMN IF EXISTS tech_metadata,
+  DROP COLUMN IF EXISTS height,
+  DROP COLUMN IF EXISTS width;
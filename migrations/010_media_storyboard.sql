@@ -0,0 +1,5 @@
+-- +goose Up
+ALTER TABLE media ADD COLUMN IF NOT EXISTS storyboard_url TEXT;
+
+-- +goose Down
+ALTER TABLE media DROP COLUMN IF EXISTS storyboard_url;
@@ -0,0 +1,14 @@
+-- +goose Up
+ALTER TABLE media ADD COLUMN IF NOT EXISTS encrypted BOOLEAN NOT NULL DEFAULT false;
+
+CREATE TABLE IF NOT EXISTS media_keys (
+  media_id TEXT NOT NULL REFERENCES media(id) ON DELETE CASCADE,
+  key_index INTEGER NOT NULL,
+  key_bytes BYTEA NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (media_id, key_index)
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS media_keys;
+ALTER TABLE media DROP COLUMN IF EXISTS encrypted;
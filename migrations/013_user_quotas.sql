@@ -0,0 +1,26 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS storage_plans (
+  name TEXT PRIMARY KEY,
+  max_storage_bytes BIGINT,
+  max_media_count INTEGER,
+  max_duration_sec BIGINT
+);
+
+INSERT INTO storage_plans (name) VALUES ('default') ON CONFLICT (name) DO NOTHING;
+
+ALTER TABLE users
+  ADD COLUMN IF NOT EXISTS plan TEXT NOT NULL DEFAULT 'default' REFERENCES storage_plans(name),
+  ADD COLUMN IF NOT EXISTS max_storage_bytes BIGINT,
+  ADD COLUMN IF NOT EXISTS max_media_count INTEGER,
+  ADD COLUMN IF NOT EXISTS max_duration_sec BIGINT;
+
+ALTER TABLE media ADD COLUMN IF NOT EXISTS output_size_bytes BIGINT NOT NULL DEFAULT 0;
+
+-- +goose Down
+ALTER TABLE media DROP COLUMN IF EXISTS output_size_bytes;
+ALTER TABLE users
+  DROP COLUMN IF EXISTS max_duration_sec,
+  DROP COLUMN IF EXISTS max_media_count,
+  DROP COLUMN IF EXISTS max_storage_bytes,
+  DROP COLUMN IF EXISTS plan;
+DROP TABLE IF EXISTS storage_plans;
@@ -0,0 +1,5 @@
+-- +goose Up
+ALTER TABLE media ADD COLUMN IF NOT EXISTS reject_reason TEXT;
+
+-- +goose Down
+ALTER TABLE media DROP COLUMN IF EXISTS reject_reason;
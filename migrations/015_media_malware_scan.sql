@@ -0,0 +1,11 @@
+-- +goose Up
+ALTER TABLE media
+  ADD COLUMN IF NOT EXISTS scan_status TEXT,
+  ADD COLUMN IF NOT EXISTS scan_signature TEXT,
+  ADD COLUMN IF NOT EXISTS scanned_at TIMESTAMPTZ;
+
+-- +goose Down
+ALTER TABLE media
+  DROP COLUMN IF EXISTS scanned_at,
+  DROP COLUMN IF EXISTS scan_signature,
+  DROP COLUMN IF EXISTS scan_status;
@@ -0,0 +1,18 @@
+-- +goose Up
+ALTER TABLE media
+  ADD COLUMN IF NOT EXISTS content_sha256 TEXT,
+  ADD COLUMN IF NOT EXISTS output_media_id TEXT REFERENCES media(id),
+  ADD COLUMN IF NOT EXISTS output_owner_user_id UUID;
+
+CREATE INDEX IF NOT EXISTS media_content_sha256_idx ON media(content_sha256)
+  WHERE content_sha256 IS NOT NULL AND output_media_id IS NULL;
+CREATE INDEX IF NOT EXISTS media_output_media_id_idx ON media(output_media_id)
+  WHERE output_media_id IS NOT NULL;
+
+-- +goose Down
+DROP INDEX IF EXISTS media_output_media_id_idx;
+DROP INDEX IF EXISTS media_content_sha256_idx;
+ALTER TABLE media
+  DROP COLUMN IF EXISTS output_owner_user_id,
+  DROP COLUMN IF EXISTS output_media_id,
+  DROP COLUMN IF EXISTS content_sha256;
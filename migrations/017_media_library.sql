@@ -0,0 +1,58 @@
+-- +goose Up
+ALTER TABLE media ADD COLUMN IF NOT EXISTS description TEXT NOT NULL DEFAULT '';
+
+-- 'simple' neither stems nor drops stop words, so Russian and English titles
+-- are matched the same way.
+ALTER TABLE media ADD COLUMN IF NOT EXISTS search_vector tsvector
+  GENERATED ALWAYS AS (
+    setweight(to_tsvector('simple', title), 'A') ||
+    setweight(to_tsvector('simple', description), 'B')
+  ) STORED;
+
+CREATE INDEX IF NOT EXISTS media_search_vector_idx ON media USING GIN (search_vector);
+CREATE INDEX IF NOT EXISTS media_owner_created_idx ON media(owner_user_id, created_at DESC, id DESC)
+  WHERE deleted_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS tags (
+  id BIGSERIAL PRIMARY KEY,
+  owner_user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  name TEXT NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS tags_owner_name_idx ON tags(owner_user_id, name);
+
+CREATE TABLE IF NOT EXISTS media_tags (
+  media_id TEXT NOT NULL REFERENCES media(id) ON DELETE CASCADE,
+  tag_id BIGINT NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+  PRIMARY KEY (media_id, tag_id)
+);
+
+CREATE INDEX IF NOT EXISTS media_tags_tag_idx ON media_tags(tag_id);
+
+CREATE TABLE IF NOT EXISTS collections (
+  id TEXT PRIMARY KEY,
+  owner_user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  name TEXT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS collections_owner_name_idx ON collections(owner_user_id, name);
+
+CREATE TABLE IF NOT EXISTS media_collections (
+  media_id TEXT NOT NULL REFERENCES media(id) ON DELETE CASCADE,
+  collection_id TEXT NOT NULL REFERENCES collections(id) ON DELETE CASCADE,
+  added_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (media_id, collection_id)
+);
+
+CREATE INDEX IF NOT EXISTS media_collections_collection_idx ON media_collections(collection_id);
+
+-- +goose Down
+DROP TABLE IF EXISTS media_collections;
+DROP TABLE IF EXISTS collections;
+DROP TABLE IF EXISTS media_tags;
+DROP TABLE IF EXISTS tags;
+DROP INDEX IF EXISTS media_owner_created_idx;
+DROP INDEX IF EXISTS media_search_vector_idx;
+ALTER TABLE media DROP COLUMN IF EXISTS search_vector;
+ALTER TABLE media DROP COLUMN IF EXISTS description;